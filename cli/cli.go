@@ -0,0 +1,168 @@
+// Package cli implements the non-interactive sbfm command tree
+package cli
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"winder.website/sbfm/prompt"
+)
+
+// Exit codes returned by Run.
+const (
+	ExitOK    = 0
+	ExitError = 1
+	ExitUsage = 2
+)
+
+// errUsage marks errors caused by bad arguments rather than failed operations.
+var errUsage = errors.New("usage error")
+
+// command is a node of the command tree. Either run or subcommands is set.
+type command struct {
+	summary     string
+	usage       string
+	run         func(dbConnection *sql.DB, args []string) error
+	subcommands map[string]*command
+}
+
+// commands is the root of the command tree.
+var commands = map[string]*command{
+	"interactive": {
+		summary: "start the numbered interactive menu (default)",
+		run:     runInteractive,
+	},
+	"user":      userCommand,
+	"inbound":   inboundCommand,
+	"transport": transportCommand,
+	"tls":       tlsCommand,
+	"reality":   realityCommand,
+	"handshake": handshakeCommand,
+	"outbound":  outboundCommand,
+	"route":     routeCommand,
+	"dns":       dnsCommand,
+	"log":       logCommand,
+	"generate":  generateCommand,
+	"migrate":   migrateCommand,
+	"serve":     serveCommand,
+	"expire":    expireCommand,
+	"traffic":   trafficCommand,
+	"settings":  settingsCommand,
+	"validate":  validateCommand,
+	"rollback":  rollbackCommand,
+	"reload":    reloadCommand,
+	"import":    importCommand,
+}
+
+// Run executes the command described by args and returns the process exit code.
+func Run(dbConnection *sql.DB, args []string) int {
+	if len(args) == 0 {
+		args = []string{"interactive"}
+	}
+
+	err := dispatch(dbConnection, "sbfm", commands, args)
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return ExitOK
+	case errors.Is(err, errUsage):
+		fmt.Fprintln(os.Stderr, "sbfm:", err)
+		return ExitUsage
+	default:
+		fmt.Fprintln(os.Stderr, "sbfm:", err)
+		return ExitError
+	}
+}
+
+// PrintUsage prints the top level command list.
+func PrintUsage() {
+	printCommands("sbfm", commands)
+}
+
+// dispatch walks the command tree until it reaches a runnable command.
+func dispatch(dbConnection *sql.DB, path string, tree map[string]*command, args []string) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printCommands(path, tree)
+		if len(args) == 0 {
+			return fmt.Errorf("%w: %s needs a subcommand", errUsage, path)
+		}
+		return nil
+	}
+
+	cmd, ok := tree[args[0]]
+	if !ok {
+		printCommands(path, tree)
+		return fmt.Errorf("%w: unknown command %q", errUsage, strings.TrimSpace(path+" "+args[0]))
+	}
+
+	if cmd.subcommands != nil {
+		return dispatch(dbConnection, path+" "+args[0], cmd.subcommands, args[1:])
+	}
+	return cmd.run(dbConnection, args[1:])
+}
+
+// printCommands lists the commands available below path.
+func printCommands(path string, tree map[string]*command) {
+	names := make([]string, 0, len(tree))
+	for name := range tree {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", path)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, tree[name].summary)
+	}
+}
+
+// newFlagSet creates a flag set that reports errors instead of exiting.
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sbfm %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args and rejects stray positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("%w: unexpected argument %q", errUsage, fs.Arg(0))
+	}
+	return nil
+}
+
+// requireFlag returns a usage error when a mandatory flag was left empty.
+func requireFlag(fs *flag.FlagSet, name string, missing bool) error {
+	if missing {
+		fs.Usage()
+		return fmt.Errorf("%w: --%s is required", errUsage, name)
+	}
+	return nil
+}
+
+func runInteractive(dbConnection *sql.DB, args []string) error {
+	if err := parseFlags(newFlagSet("interactive", ""), args); err != nil {
+		return err
+	}
+
+	// Create a scanner for reading input
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Main application loop
+	prompt.HandleMenu(scanner, dbConnection)
+	return nil
+}
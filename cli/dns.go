@@ -0,0 +1,245 @@
+package cli
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"winder.website/sbfm/db"
+)
+
+var dnsCommand = &command{
+	summary: "manage DNS servers, DNS rules and the global DNS settings",
+	subcommands: map[string]*command{
+		"server": {
+			summary: "manage DNS servers",
+			subcommands: map[string]*command{
+				"add": {
+					summary: "add a DNS server",
+					run:     runDNSServerAdd,
+				},
+				"list": {
+					summary: "list all DNS servers",
+					run:     listRunner("dns server list", db.PrintDNSServers),
+				},
+				"edit": {
+					summary: "change fields of a DNS server by ID",
+					run:     runDNSServerEdit,
+				},
+				"delete": {
+					summary: "delete a DNS server by ID",
+					run:     deleteRunner("dns server delete", "DNS server", db.DeleteDNSServer),
+				},
+			},
+		},
+		"rule": {
+			summary: "manage DNS rules",
+			subcommands: map[string]*command{
+				"add": {
+					summary: "add a DNS rule or a sub-rule of a logical rule",
+					run:     runDNSRuleAdd,
+				},
+				"list": {
+					summary: "list the DNS rules in the order they apply",
+					run:     listRunner("dns rule list", db.PrintDNSRules),
+				},
+				"edit": {
+					summary: "change fields of a DNS rule by ID",
+					run:     runDNSRuleEdit,
+				},
+				"delete": {
+					summary: "delete a DNS rule and its sub-rules by ID",
+					run:     deleteRunner("dns rule delete", "DNS rule", db.DeleteDNSRule),
+				},
+			},
+		},
+		"set": {
+			summary: "set the final server, strategy, cache and fakeip settings",
+			run:     runDNSSet,
+		},
+	},
+}
+
+// dnsServerFlags registers the flags of a DNS server. The returned function
+// copies the flags in set, or all of them if set is nil, into record.
+func dnsServerFlags(fs *flag.FlagSet) func(record *db.DNSServerRecord, set map[string]bool) {
+	tag := fs.String("tag", "", "unique DNS server tag")
+	address := fs.String("address", "", "server address, e.g. 1.1.1.1, tls://1.1.1.1, https://dns.google/dns-query, local or fakeip")
+	addressResolver := fs.String("address-resolver", "", "tag of the DNS server that resolves a domain name in the address")
+	strategy := fs.String("strategy", "", "domain strategy (prefer_ipv4, prefer_ipv6, ipv4_only, ipv6_only)")
+	detour := fs.String("detour", "", "tag of the outbound queries are sent through")
+
+	return func(record *db.DNSServerRecord, set map[string]bool) {
+		apply := func(name string) bool {
+			return set == nil || set[name]
+		}
+		if apply("tag") {
+			record.Tag = *tag
+		}
+		if apply("address") {
+			record.Address = *address
+		}
+		if apply("address-resolver") {
+			record.AddressResolver = *addressResolver
+		}
+		if apply("strategy") {
+			record.Strategy = *strategy
+		}
+		if apply("detour") {
+			record.Detour = *detour
+		}
+	}
+}
+
+func runDNSServerAdd(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("dns server add", "--tag TAG --address ADDRESS [--address-resolver TAG] [--detour TAG]")
+	apply := dnsServerFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var record db.DNSServerRecord
+	apply(&record, nil)
+	if err := db.ValidateDNSServer(record); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	return db.AddDNSServer(dbConnection, record)
+}
+
+func runDNSServerEdit(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("dns server edit", "--id ID [--address ADDRESS] [--detour TAG] ...")
+	id := fs.Int("id", 0, "ID of the DNS server")
+	apply := dnsServerFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	record, err := db.GetDNSServer(dbConnection, *id)
+	if err != nil {
+		return err
+	}
+	apply(&record, setFlags(fs))
+	if err := db.ValidateDNSServer(record); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	if err := db.UpdateDNSServer(dbConnection, record); err != nil {
+		return err
+	}
+	fmt.Println("DNS server updated successfully.")
+	return nil
+}
+
+// dnsRuleFlags registers the flags of a DNS rule, see ruleFlags
+func dnsRuleFlags(fs *flag.FlagSet) func(record *db.DNSRuleRecord, set map[string]bool) error {
+	apply := ruleFlags(fs, "server", "tag of the DNS server for matching queries")
+	return func(record *db.DNSRuleRecord, set map[string]bool) error {
+		return apply(&record.RuleRecord, &record.Server, set)
+	}
+}
+
+func runDNSRuleAdd(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("dns rule add", "--server TAG [--domain-suffix LIST] [--rule-set LIST] ... | --parent ID ...")
+	apply := dnsRuleFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var record db.DNSRuleRecord
+	if err := apply(&record, nil); err != nil {
+		return err
+	}
+	if err := db.ValidateDNSRule(record); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	return db.AddDNSRule(dbConnection, record)
+}
+
+func runDNSRuleEdit(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("dns rule edit", "--id ID [--priority N] [--server TAG] ...")
+	id := fs.Int("id", 0, "ID of the DNS rule")
+	apply := dnsRuleFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	record, err := db.GetDNSRule(dbConnection, *id)
+	if err != nil {
+		return err
+	}
+	if err := apply(&record, setFlags(fs)); err != nil {
+		return err
+	}
+	if err := db.ValidateDNSRule(record); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	if err := db.UpdateDNSRule(dbConnection, record); err != nil {
+		return err
+	}
+	fmt.Println("DNS rule updated successfully.")
+	return nil
+}
+
+func runDNSSet(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("dns set", "[--final TAG] [--strategy S] [--disable-cache=true|false] [--fakeip-inet4-range CIDR] ...")
+	final := fs.String("final", "", "tag of the DNS server for unmatched queries, empty for the first server")
+	strategy := fs.String("strategy", "", "default domain strategy (prefer_ipv4, prefer_ipv6, ipv4_only, ipv6_only), empty for none")
+	disableCache := fs.Bool("disable-cache", false, "turn the DNS cache off")
+	independentCache := fs.Bool("independent-cache", false, "keep a separate cache per DNS server")
+	inet4Range := fs.String("fakeip-inet4-range", "", "IPv4 range of fake IPs, e.g. 198.18.0.0/15, empty to disable")
+	inet6Range := fs.String("fakeip-inet6-range", "", "IPv6 range of fake IPs, e.g. fc00::/18, empty to disable")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	set := setFlags(fs)
+	if len(set) == 0 {
+		fs.Usage()
+		return fmt.Errorf("%w: nothing to set", errUsage)
+	}
+
+	// Check every value before storing any of them
+	values := []struct {
+		flag, key, value string
+	}{
+		{"strategy", db.SettingDNSStrategy, *strategy},
+		{"disable-cache", db.SettingDNSDisableCache, strconv.FormatBool(*disableCache)},
+		{"independent-cache", db.SettingDNSIndependentCache, strconv.FormatBool(*independentCache)},
+		{"fakeip-inet4-range", db.SettingDNSFakeIPInet4Range, *inet4Range},
+		{"fakeip-inet6-range", db.SettingDNSFakeIPInet6Range, *inet6Range},
+	}
+	for _, v := range values {
+		if set[v.flag] {
+			if err := db.ValidateSetting(v.key, v.value); err != nil {
+				return fmt.Errorf("%w: --%s: %v", errUsage, v.flag, err)
+			}
+		}
+	}
+
+	if set["final"] {
+		if err := db.SetDNSFinal(dbConnection, *final); err != nil {
+			return err
+		}
+		fmt.Printf("%s set to %q.\n", db.SettingDNSFinal, *final)
+	}
+	for _, v := range values {
+		if !set[v.flag] {
+			continue
+		}
+		if err := db.SetSetting(dbConnection, v.key, v.value); err != nil {
+			return err
+		}
+		fmt.Printf("%s set to %q.\n", v.key, v.value)
+	}
+	return nil
+}
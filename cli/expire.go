@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+
+	"winder.website/sbfm/db"
+)
+
+var expireCommand = &command{
+	summary: "deactivate expired users and regenerate the affected files",
+	run:     runExpire,
+}
+
+func runExpire(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("expire", "[--template ./template.json] [--no-reload]")
+	templateFilePath := fs.String("template", "./template.json", "client template file for template mode")
+	noReload := fs.Bool("no-reload", false, "do not run the reload hooks after writing config.json")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	expired, err := db.SweepExpiredUsers(dbConnection, *templateFilePath, *noReload)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d user(s) expired.\n", len(expired))
+	return nil
+}
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// idFlag is an optional foreign key flag; it stays nil unless set.
+type idFlag struct {
+	id *int
+}
+
+func (f *idFlag) String() string {
+	if f == nil || f.id == nil {
+		return ""
+	}
+	return strconv.Itoa(*f.id)
+}
+
+func (f *idFlag) Set(value string) error {
+	id, err := strconv.Atoi(value)
+	if err != nil || id <= 0 {
+		return fmt.Errorf("invalid ID %q", value)
+	}
+	f.id = &id
+	return nil
+}
+
+// sizeUnits are the units parseSize accepts, longest suffix first
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseSize parses a byte count such as 50GiB, 500MB or 1048576
+func parseSize(value string) (int64, error) {
+	number, multiplier := strings.TrimSpace(value), int64(1)
+	for _, unit := range sizeUnits {
+		if rest, ok := strings.CutSuffix(number, unit.suffix); ok {
+			number, multiplier = strings.TrimSpace(rest), unit.multiplier
+			break
+		}
+	}
+
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("%w: invalid size %q, expected e.g. 50GiB", errUsage, value)
+	}
+	return int64(size * float64(multiplier)), nil
+}
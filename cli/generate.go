@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+
+	"winder.website/sbfm/db"
+	"winder.website/sbfm/jsonhandler"
+)
+
+var generateCommand = &command{
+	summary: "generate sing-box and subscription files",
+	subcommands: map[string]*command{
+		"all": {
+			summary: "generate config.json and every per-user output as one generation",
+			run:     runGenerateAll,
+		},
+		"config": {
+			summary: "generate ./sing-box/config.json",
+			run:     runGenerateConfig,
+		},
+		"clients": {
+			summary: "generate the per-user sing-box client profiles",
+			run:     runGenerateClients,
+		},
+		"clash": {
+			summary: "generate the per-user Clash/Mihomo YAML profiles",
+			run:     runGenerateClash,
+		},
+		"links": {
+			summary: "generate the per-user base64 share link bundles",
+			run:     runGenerateLinks,
+		},
+		"subs": {
+			summary: "generate the per-user nginx subscription snippets",
+			run:     runGenerateSubs,
+		},
+	},
+}
+
+// dryRunFlag registers --dry-run, which makes a generator print what would
+// change and exit 1 if anything would, without writing files
+func dryRunFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("dry-run", false, "print what would change without writing, exit 1 if anything would")
+}
+
+func runGenerateAll(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("generate all", "[--template ./template.json] [--dry-run] [--no-reload]")
+	dryRun := dryRunFlag(fs)
+	noReload := fs.Bool("no-reload", false, "do not run the reload hooks after writing config.json")
+	templateFilePath := fs.String("template", "./template.json", "client template file for template mode")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return db.GenerateAll(dbConnection, *templateFilePath, *dryRun, *noReload)
+}
+
+func runGenerateConfig(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("generate config", "[--force] [--dry-run] [--no-reload]")
+	force := fs.Bool("force", false, "write the config even if validation reports errors")
+	dryRun := dryRunFlag(fs)
+	noReload := fs.Bool("no-reload", false, "do not run the reload hooks after writing")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return db.GenerateServerConfig(
+		dbConnection,
+		jsonhandler.GenerateOptions{Force: *force, DryRun: *dryRun},
+		*noReload,
+	)
+}
+
+func runGenerateClients(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("generate clients", "[--mode generated|template] [--template ./template.json] [--dry-run]")
+	dryRun := dryRunFlag(fs)
+	mode := fs.String("mode", "", "generated or template (default: the client_profile_mode setting)")
+	templateFilePath := fs.String("template", "./template.json", "client template file for template mode")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	switch *mode {
+	case "":
+		return db.GenerateUserClientFiles(dbConnection, *templateFilePath, *dryRun)
+	case db.ClientProfileGenerated:
+		return db.GenerateUserClientProfiles(dbConnection, *dryRun)
+	case db.ClientProfileTemplate:
+		return db.GenerateUserJSONFiles(dbConnection, *templateFilePath, *dryRun)
+	default:
+		return fmt.Errorf("%w: invalid mode %q", errUsage, *mode)
+	}
+}
+
+func runGenerateSubs(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("generate subs", "[--dry-run]")
+	dryRun := dryRunFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return db.GenerateUserConfigFiles(dbConnection, *dryRun)
+}
+
+func runGenerateLinks(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("generate links", "[--dry-run]")
+	dryRun := dryRunFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return db.GenerateUserShareLinks(dbConnection, *dryRun)
+}
+
+func runGenerateClash(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("generate clash", "[--dry-run]")
+	dryRun := dryRunFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return db.GenerateUserClashProfiles(dbConnection, *dryRun)
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"database/sql"
+
+	"winder.website/sbfm/db"
+)
+
+var importCommand = &command{
+	summary: "import inbounds and users from other tools",
+	subcommands: map[string]*command{
+		"config": {
+			summary: "import a sing-box config.json",
+			run:     runImportConfig,
+		},
+		"xui": {
+			summary: "import the VLESS inbounds and clients of an x-ui or 3x-ui database",
+			run:     runImportXUI,
+		},
+	},
+}
+
+func runImportConfig(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("import config", "--file config.json")
+	filename := fs.String("file", "", "sing-box config file to import")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "file", *filename == ""); err != nil {
+		return err
+	}
+
+	report, err := db.ImportConfigFile(dbConnection, *filename)
+	if err != nil {
+		return err
+	}
+	report.Print()
+	return nil
+}
+
+func runImportXUI(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("import xui", "--file x-ui.db")
+	filename := fs.String("file", "", "x-ui or 3x-ui database to import")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "file", *filename == ""); err != nil {
+		return err
+	}
+
+	report, err := db.ImportXUIDatabase(dbConnection, *filename)
+	if err != nil {
+		return err
+	}
+	report.Print()
+	return nil
+}
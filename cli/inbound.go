@@ -0,0 +1,659 @@
+package cli
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+
+	"winder.website/sbfm/db"
+	"winder.website/sbfm/jsonhandler"
+)
+
+var inboundCommand = &command{
+	summary: "manage inbounds",
+	subcommands: map[string]*command{
+		"add": {
+			summary: "add an inbound",
+			run:     runInboundAdd,
+		},
+		"list": {
+			summary: "list all inbounds",
+			run:     listRunner("inbound list", db.PrintInbounds),
+		},
+		"edit": {
+			summary: "change fields of an inbound by ID",
+			run:     runInboundEdit,
+		},
+		"delete": {
+			summary: "delete an inbound by ID",
+			run:     deleteRunner("inbound delete", "Inbound", db.DeleteInbound),
+		},
+	},
+}
+
+var transportCommand = &command{
+	summary: "manage transports",
+	subcommands: map[string]*command{
+		"add": {
+			summary: "add a transport",
+			run:     runTransportAdd,
+		},
+		"list": {
+			summary: "list all transports",
+			run:     listRunner("transport list", db.PrintTransports),
+		},
+		"edit": {
+			summary: "change fields of a transport by ID",
+			run:     runTransportEdit,
+		},
+		"delete": {
+			summary: "delete a transport by ID",
+			run:     deleteRunner("transport delete", "Transport", db.DeleteTransport),
+		},
+	},
+}
+
+var tlsCommand = &command{
+	summary: "manage TLS configurations",
+	subcommands: map[string]*command{
+		"add": {
+			summary: "add a TLS configuration",
+			run:     runTLSAdd,
+		},
+		"list": {
+			summary: "list all TLS configurations",
+			run:     listRunner("tls list", db.PrintTLS),
+		},
+		"edit": {
+			summary: "change fields of a TLS configuration by ID",
+			run:     runTLSEdit,
+		},
+		"delete": {
+			summary: "delete a TLS configuration by ID",
+			run:     deleteRunner("tls delete", "TLS configuration", db.DeleteTLS),
+		},
+	},
+}
+
+var realityCommand = &command{
+	summary: "manage Reality configurations",
+	subcommands: map[string]*command{
+		"add": {
+			summary: "add a Reality configuration",
+			run:     runRealityAdd,
+		},
+		"list": {
+			summary: "list all Reality configurations",
+			run:     listRunner("reality list", db.PrintReality),
+		},
+		"edit": {
+			summary: "change fields of a Reality configuration by ID",
+			run:     runRealityEdit,
+		},
+		"keygen": {
+			summary: "print a new x25519 key pair without storing it",
+			run:     runRealityKeygen,
+		},
+		"derive-keys": {
+			summary: "derive missing public keys from the stored private keys",
+			run:     runRealityDeriveKeys,
+		},
+		"rotate-short-id": {
+			summary: "issue a new per-user short_id, revoking the old one",
+			run:     runRealityRotateShortID,
+		},
+		"delete": {
+			summary: "delete a Reality configuration by ID",
+			run:     deleteRunner("reality delete", "Reality configuration", db.DeleteReality),
+		},
+	},
+}
+
+var handshakeCommand = &command{
+	summary: "manage Handshake configurations",
+	subcommands: map[string]*command{
+		"add": {
+			summary: "add a Handshake configuration",
+			run:     runHandshakeAdd,
+		},
+		"list": {
+			summary: "list all Handshake configurations",
+			run:     listRunner("handshake list", db.PrintHandshake),
+		},
+		"edit": {
+			summary: "change fields of a Handshake configuration by ID",
+			run:     runHandshakeEdit,
+		},
+		"delete": {
+			summary: "delete a Handshake configuration by ID",
+			run:     deleteRunner("handshake delete", "Handshake configuration", db.DeleteHandshake),
+		},
+	},
+}
+
+// listRunner wraps one of the db Print* functions as a command.
+func listRunner(name string, print func(*sql.DB) error) func(*sql.DB, []string) error {
+	return func(dbConnection *sql.DB, args []string) error {
+		if err := parseFlags(newFlagSet(name, ""), args); err != nil {
+			return err
+		}
+		return print(dbConnection)
+	}
+}
+
+// deleteRunner wraps one of the db Delete* functions as a command.
+func deleteRunner(name, what string, remove func(*sql.DB, int) error) func(*sql.DB, []string) error {
+	return func(dbConnection *sql.DB, args []string) error {
+		fs := newFlagSet(name, "--id ID")
+		id := fs.Int("id", 0, "ID of the row to delete")
+		if err := parseFlags(fs, args); err != nil {
+			return err
+		}
+		if err := requireFlag(fs, "id", *id <= 0); err != nil {
+			return err
+		}
+
+		if err := remove(dbConnection, *id); err != nil {
+			return fmt.Errorf("error deleting %s: %v", what, err)
+		}
+
+		fmt.Printf("%s deleted successfully.\n", what)
+		return nil
+	}
+}
+
+func runInboundAdd(dbConnection *sql.DB, args []string) error {
+	var transportID, tlsID, realityID, handshakeID idFlag
+
+	fs := newFlagSet("inbound add", "--type vless --tag vless-ws --port 443 [--tls ID] [--method METHOD] ...")
+	inboundType := fs.String("type", "vless", "inbound type (vless, vmess, trojan, shadowsocks, hysteria2, tuic)")
+	tag := fs.String("tag", "vless-ws", "unique inbound tag")
+	listen := fs.String("listen", "::", "listen address")
+	listenPort := fs.Int("port", 8080, "listen port")
+	sniff := fs.Bool("sniff", true, "enable sniffing")
+	sniffOverrideDestination := fs.Bool("sniff-override-destination", false, "override the destination with the sniffed domain")
+	sniffTimeout := fs.String("sniff-timeout", "300ms", "sniff timeout")
+	detour := fs.String("detour", "", "tag of the inbound or outbound to forward connections to")
+	method := fs.String("method", "", "shadowsocks method, e.g. 2022-blake3-aes-128-gcm")
+	password := fs.String("password", "", "shadowsocks 2022 server key (default: generate one)")
+	flow := fs.String("flow", "", "vless flow of every user, xtls-rprx-vision for Reality or TLS over TCP")
+	fs.Var(&transportID, "transport", "ID of the transport to use")
+	fs.Var(&tlsID, "tls", "ID of the TLS configuration to use")
+	fs.Var(&realityID, "reality", "ID of the Reality configuration to use")
+	fs.Var(&handshakeID, "handshake", "ID of the Handshake configuration to use")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *listenPort <= 0 || *listenPort > 65535 {
+		return fmt.Errorf("%w: invalid port %d", errUsage, *listenPort)
+	}
+
+	return db.AddInbound(dbConnection, db.InboundRecord{
+		Type:                     *inboundType,
+		Tag:                      *tag,
+		Listen:                   *listen,
+		ListenPort:               *listenPort,
+		Sniff:                    *sniff,
+		SniffOverrideDestination: *sniffOverrideDestination,
+		SniffTimeout:             *sniffTimeout,
+		Detour:                   *detour,
+		Method:                   *method,
+		Password:                 *password,
+		Flow:                     *flow,
+		TransportID:              transportID.id,
+		TLSID:                    tlsID.id,
+		RealityID:                realityID.id,
+		HandshakeID:              handshakeID.id,
+	})
+}
+
+func runTransportAdd(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("transport add", "--type ws --path /ws")
+	transportType := fs.String("type", "ws", "transport type (ws, http, grpc, ...)")
+	transportPath := fs.String("path", "", "transport path")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := db.AddTransport(dbConnection, *transportType, *transportPath); err != nil {
+		return err
+	}
+
+	fmt.Println("Transport configuration saved.")
+	return nil
+}
+
+func runTLSAdd(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("tls add", "--server-name example.com [--certificate-path PATH --key-path PATH]")
+	enabled := fs.Bool("enabled", true, "enable TLS")
+	serverName := fs.String("server-name", "www.yahoo.com", "TLS server name")
+	minVersion := fs.String("min-version", "", "minimum TLS version")
+	maxVersion := fs.String("max-version", "", "maximum TLS version")
+	certificatePath := fs.String("certificate-path", "", "path to the certificate")
+	keyPath := fs.String("key-path", "", "path to the key")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	return db.AddTLS(
+		dbConnection,
+		*enabled,
+		*serverName,
+		*minVersion,
+		*maxVersion,
+		*certificatePath,
+		*keyPath,
+	)
+}
+
+// realityShortIDFlags registers the flags that set the shared short_ids of
+// a Reality configuration
+func realityShortIDFlags(fs *flag.FlagSet) func() ([]string, error) {
+	shortIDs := fs.String("short-ids", "", "comma separated hex short IDs")
+	generate := fs.Int("generate-short-ids", 0, "number of random short IDs to add")
+	length := fs.Int("short-id-length", 8, "length of generated short IDs in hex digits")
+	return func() ([]string, error) {
+		list := jsonhandler.SplitList(*shortIDs)
+		for _, shortID := range list {
+			if err := db.ValidateShortID(shortID); err != nil {
+				return nil, fmt.Errorf("%w: %v", errUsage, err)
+			}
+		}
+		if *generate < 0 {
+			return nil, fmt.Errorf("%w: invalid short ID count %d", errUsage, *generate)
+		}
+		generated, err := db.GenerateShortIDs(*generate, *length)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errUsage, err)
+		}
+		return append(list, generated...), nil
+	}
+}
+
+func runRealityAdd(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet(
+		"reality add",
+		"[--private-key KEY] [--short-ids HEX,...] [--generate-short-ids N] [--server-names NAME,...]",
+	)
+	enabled := fs.Bool("enabled", true, "enable Reality")
+	privateKey := fs.String("private-key", "", "Reality private key (default: generate a new key pair)")
+	shortIDs := realityShortIDFlags(fs)
+	serverNames := fs.String("server-names", "", "comma separated server names clients may use")
+	perUserShortIDs := fs.Bool("per-user-short-ids", false, "issue every user a short ID of their own")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	shortIDList, err := shortIDs()
+	if err != nil {
+		return err
+	}
+
+	if *privateKey == "" {
+		generated, _, err := db.GenerateRealityKeyPair()
+		if err != nil {
+			return err
+		}
+		*privateKey = generated
+	}
+
+	return db.AddReality(
+		dbConnection,
+		*enabled,
+		*privateKey,
+		shortIDList,
+		jsonhandler.SplitList(*serverNames),
+		*perUserShortIDs,
+	)
+}
+
+func runRealityKeygen(dbConnection *sql.DB, args []string) error {
+	if err := parseFlags(newFlagSet("reality keygen", ""), args); err != nil {
+		return err
+	}
+
+	privateKey, publicKey, err := db.GenerateRealityKeyPair()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("PrivateKey: %s\nPublicKey: %s\n", privateKey, publicKey)
+	return nil
+}
+
+func runRealityDeriveKeys(dbConnection *sql.DB, args []string) error {
+	if err := parseFlags(newFlagSet("reality derive-keys", ""), args); err != nil {
+		return err
+	}
+
+	derived, err := db.DeriveRealityPublicKeys(dbConnection)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Derived %d Reality public key(s).\n", derived)
+	return nil
+}
+
+func runRealityRotateShortID(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("reality rotate-short-id", "--id ID --user USER_ID")
+	id := fs.Int("id", 0, "ID of the Reality configuration")
+	userID := fs.Int("user", 0, "ID of the user")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "user", *userID <= 0); err != nil {
+		return err
+	}
+
+	if err := db.RotateUserShortID(dbConnection, *id, *userID); err != nil {
+		return err
+	}
+	fmt.Println("Short ID rotated successfully.")
+	return nil
+}
+
+func runHandshakeAdd(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("handshake add", "--server www.yahoo.com --port 443")
+	server := fs.String("server", "www.yahoo.com", "handshake server address")
+	serverPort := fs.Int("port", 443, "handshake server port")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *serverPort <= 0 || *serverPort > 65535 {
+		return fmt.Errorf("%w: invalid port %d", errUsage, *serverPort)
+	}
+
+	return db.AddHandshake(dbConnection, *server, *serverPort)
+}
+
+// setFlags returns the names of the flags given on the command line.
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+func runInboundEdit(dbConnection *sql.DB, args []string) error {
+	var transportID, tlsID, realityID, handshakeID idFlag
+
+	fs := newFlagSet("inbound edit", "--id ID [--tag TAG] [--port PORT] [--tls ID] ...")
+	id := fs.Int("id", 0, "ID of the inbound")
+	inboundType := fs.String("type", "", "inbound type (vless, vmess, trojan, shadowsocks, hysteria2, tuic)")
+	tag := fs.String("tag", "", "unique inbound tag")
+	listen := fs.String("listen", "", "listen address")
+	listenPort := fs.Int("port", 0, "listen port")
+	sniff := fs.Bool("sniff", true, "enable sniffing")
+	sniffOverrideDestination := fs.Bool("sniff-override-destination", false, "override the destination with the sniffed domain")
+	sniffTimeout := fs.String("sniff-timeout", "", "sniff timeout")
+	detour := fs.String("detour", "", "tag of the inbound or outbound to forward connections to, empty for none")
+	method := fs.String("method", "", "shadowsocks method, empty for other types")
+	password := fs.String("password", "", "shadowsocks 2022 server key, empty to generate one")
+	flow := fs.String("flow", "", "vless flow of every user, empty for none")
+	fs.Var(&transportID, "transport", "ID of the transport to use")
+	fs.Var(&tlsID, "tls", "ID of the TLS configuration to use")
+	fs.Var(&realityID, "reality", "ID of the Reality configuration to use")
+	fs.Var(&handshakeID, "handshake", "ID of the Handshake configuration to use")
+	noTransport := fs.Bool("no-transport", false, "unlink the transport")
+	noTLS := fs.Bool("no-tls", false, "unlink the TLS configuration")
+	noReality := fs.Bool("no-reality", false, "unlink the Reality configuration")
+	noHandshake := fs.Bool("no-handshake", false, "unlink the Handshake configuration")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	record, err := db.GetInbound(dbConnection, *id)
+	if err != nil {
+		return err
+	}
+
+	set := setFlags(fs)
+	if set["type"] {
+		record.Type = *inboundType
+	}
+	if set["tag"] {
+		record.Tag = *tag
+	}
+	if set["listen"] {
+		record.Listen = *listen
+	}
+	if set["port"] {
+		if *listenPort <= 0 || *listenPort > 65535 {
+			return fmt.Errorf("%w: invalid port %d", errUsage, *listenPort)
+		}
+		record.ListenPort = *listenPort
+	}
+	if set["sniff"] {
+		record.Sniff = *sniff
+	}
+	if set["sniff-override-destination"] {
+		record.SniffOverrideDestination = *sniffOverrideDestination
+	}
+	if set["sniff-timeout"] {
+		record.SniffTimeout = *sniffTimeout
+	}
+	if set["detour"] {
+		record.Detour = *detour
+	}
+	// A new method needs a server key of its own length
+	if set["method"] && *method != record.Method && !set["password"] {
+		record.Password = ""
+	}
+	if set["method"] {
+		record.Method = *method
+	}
+	if set["password"] {
+		record.Password = *password
+	}
+	if set["flow"] {
+		record.Flow = *flow
+	}
+	if set["transport"] {
+		record.TransportID = transportID.id
+	}
+	if set["tls"] {
+		record.TLSID = tlsID.id
+	}
+	if set["reality"] {
+		record.RealityID = realityID.id
+	}
+	if set["handshake"] {
+		record.HandshakeID = handshakeID.id
+	}
+	if *noTransport {
+		record.TransportID = nil
+	}
+	if *noTLS {
+		record.TLSID = nil
+	}
+	if *noReality {
+		record.RealityID = nil
+	}
+	if *noHandshake {
+		record.HandshakeID = nil
+	}
+
+	if err := db.UpdateInbound(dbConnection, record); err != nil {
+		return err
+	}
+	fmt.Println("Inbound updated successfully.")
+	return nil
+}
+
+func runTransportEdit(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("transport edit", "--id ID [--type ws] [--path /ws]")
+	id := fs.Int("id", 0, "ID of the transport")
+	transportType := fs.String("type", "", "transport type (ws, http, grpc, ...)")
+	transportPath := fs.String("path", "", "transport path")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	record, err := db.GetTransport(dbConnection, *id)
+	if err != nil {
+		return err
+	}
+
+	set := setFlags(fs)
+	if set["type"] {
+		record.Type = *transportType
+	}
+	if set["path"] {
+		record.Path = *transportPath
+	}
+
+	if err := db.UpdateTransport(dbConnection, record); err != nil {
+		return err
+	}
+	fmt.Println("Transport updated successfully.")
+	return nil
+}
+
+func runTLSEdit(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("tls edit", "--id ID [--server-name example.com] ...")
+	id := fs.Int("id", 0, "ID of the TLS configuration")
+	enabled := fs.Bool("enabled", true, "enable TLS")
+	serverName := fs.String("server-name", "", "TLS server name")
+	minVersion := fs.String("min-version", "", "minimum TLS version")
+	maxVersion := fs.String("max-version", "", "maximum TLS version")
+	certificatePath := fs.String("certificate-path", "", "path to the certificate")
+	keyPath := fs.String("key-path", "", "path to the key")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	record, err := db.GetTLS(dbConnection, *id)
+	if err != nil {
+		return err
+	}
+
+	set := setFlags(fs)
+	if set["enabled"] {
+		record.Enabled = *enabled
+	}
+	if set["server-name"] {
+		record.ServerName = *serverName
+	}
+	if set["min-version"] {
+		record.MinVersion = *minVersion
+	}
+	if set["max-version"] {
+		record.MaxVersion = *maxVersion
+	}
+	if set["certificate-path"] {
+		record.CertificatePath = *certificatePath
+	}
+	if set["key-path"] {
+		record.KeyPath = *keyPath
+	}
+
+	if err := db.UpdateTLS(dbConnection, record); err != nil {
+		return err
+	}
+	fmt.Println("TLS configuration updated successfully.")
+	return nil
+}
+
+func runRealityEdit(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet(
+		"reality edit",
+		"--id ID [--private-key KEY] [--short-ids HEX,...] [--generate-short-ids N] [--server-names NAME,...]",
+	)
+	id := fs.Int("id", 0, "ID of the Reality configuration")
+	enabled := fs.Bool("enabled", true, "enable Reality")
+	privateKey := fs.String("private-key", "", "Reality private key")
+	shortIDs := realityShortIDFlags(fs)
+	serverNames := fs.String("server-names", "", "comma separated server names clients may use")
+	perUserShortIDs := fs.Bool("per-user-short-ids", false, "issue every user a short ID of their own")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	record, err := db.GetReality(dbConnection, *id)
+	if err != nil {
+		return err
+	}
+
+	set := setFlags(fs)
+	if set["enabled"] {
+		record.Enabled = *enabled
+	}
+	if set["private-key"] {
+		record.PrivateKey = *privateKey
+	}
+	// --generate-short-ids adds to the existing list unless --short-ids
+	// replaces it
+	if set["short-ids"] || set["generate-short-ids"] {
+		shortIDList, err := shortIDs()
+		if err != nil {
+			return err
+		}
+		if set["short-ids"] {
+			record.ShortIDs = shortIDList
+		} else {
+			record.ShortIDs = append(record.ShortIDs, shortIDList...)
+		}
+	}
+	if set["server-names"] {
+		record.ServerNames = jsonhandler.SplitList(*serverNames)
+	}
+	if set["per-user-short-ids"] {
+		record.PerUserShortIDs = *perUserShortIDs
+	}
+
+	if err := db.UpdateReality(dbConnection, record); err != nil {
+		return err
+	}
+	fmt.Println("Reality configuration updated successfully.")
+	return nil
+}
+
+func runHandshakeEdit(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("handshake edit", "--id ID [--server HOST] [--port PORT]")
+	id := fs.Int("id", 0, "ID of the Handshake configuration")
+	server := fs.String("server", "", "handshake server address")
+	serverPort := fs.Int("port", 0, "handshake server port")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	record, err := db.GetHandshake(dbConnection, *id)
+	if err != nil {
+		return err
+	}
+
+	set := setFlags(fs)
+	if set["server"] {
+		record.Server = *server
+	}
+	if set["port"] {
+		if *serverPort <= 0 || *serverPort > 65535 {
+			return fmt.Errorf("%w: invalid port %d", errUsage, *serverPort)
+		}
+		record.ServerPort = *serverPort
+	}
+
+	if err := db.UpdateHandshake(dbConnection, record); err != nil {
+		return err
+	}
+	fmt.Println("Handshake configuration updated successfully.")
+	return nil
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"winder.website/sbfm/db"
+)
+
+var logCommand = &command{
+	summary: "manage the log block",
+	subcommands: map[string]*command{
+		"show": {
+			summary: "print the log settings",
+			run:     listRunner("log show", db.PrintLogSettings),
+		},
+		"set": {
+			summary: "change the log settings, creating them if they are unset",
+			run:     runLogSet,
+		},
+		"unset": {
+			summary: "remove the log settings, leaving the log block out of config.json",
+			run:     runLogUnset,
+		},
+	},
+}
+
+func runLogSet(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("log set", "[--level info] [--output FILE] [--disabled=true|false] [--timestamp=true|false]")
+	disabled := fs.Bool("disabled", false, "disable logging")
+	level := fs.String("level", db.DefaultLogSettings.Level, "log level ("+strings.Join(db.LogLevels, ", ")+")")
+	output := fs.String("output", "", "log output file, empty for the console")
+	timestamp := fs.Bool("timestamp", db.DefaultLogSettings.Timestamp, "add timestamps to log lines")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	set := setFlags(fs)
+	if len(set) == 0 {
+		fs.Usage()
+		return fmt.Errorf("%w: nothing to set", errUsage)
+	}
+
+	// Unset settings start from the defaults
+	settings, _, err := db.GetLogSettings(dbConnection)
+	if err != nil {
+		return err
+	}
+	if set["disabled"] {
+		settings.Disabled = *disabled
+	}
+	if set["level"] {
+		settings.Level = *level
+	}
+	if set["output"] {
+		settings.Output = *output
+	}
+	if set["timestamp"] {
+		settings.Timestamp = *timestamp
+	}
+	if err := db.ValidateLogSettings(settings); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	if err := db.SetLogSettings(dbConnection, settings); err != nil {
+		return err
+	}
+	fmt.Println("Log settings saved.")
+	return nil
+}
+
+func runLogUnset(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("log unset", "")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := db.UnsetLogSettings(dbConnection); err != nil {
+		return err
+	}
+	fmt.Println("Log settings removed, config.json will have no log block.")
+	return nil
+}
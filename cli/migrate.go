@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+
+	"winder.website/sbfm/db"
+)
+
+var migrateCommand = &command{
+	summary: "inspect and apply database schema migrations",
+	subcommands: map[string]*command{
+		"status": {
+			summary: "show the schema version and pending migrations",
+			run:     runMigrateStatus,
+		},
+		"up": {
+			summary: "apply all pending migrations",
+			run:     runMigrateUp,
+		},
+	},
+}
+
+// SkipsAutoMigrate reports whether args run a command that must see the
+// database schema as it is, before the automatic migration on startup.
+func SkipsAutoMigrate(args []string) bool {
+	return len(args) > 0 && args[0] == "migrate"
+}
+
+func runMigrateStatus(dbConnection *sql.DB, args []string) error {
+	if err := parseFlags(newFlagSet("migrate status", ""), args); err != nil {
+		return err
+	}
+	return db.PrintMigrationStatus(dbConnection)
+}
+
+func runMigrateUp(dbConnection *sql.DB, args []string) error {
+	if err := parseFlags(newFlagSet("migrate up", ""), args); err != nil {
+		return err
+	}
+
+	applied, err := db.Migrate(dbConnection)
+	if err != nil {
+		return err
+	}
+	if applied == 0 {
+		fmt.Printf("Database is up to date at schema version %d.\n", db.LatestSchemaVersion())
+	}
+	return nil
+}
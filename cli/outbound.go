@@ -0,0 +1,206 @@
+package cli
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"strings"
+
+	"winder.website/sbfm/db"
+	"winder.website/sbfm/jsonhandler"
+)
+
+var outboundCommand = &command{
+	summary: "manage outbounds",
+	subcommands: map[string]*command{
+		"add": {
+			summary: "add an outbound",
+			run:     runOutboundAdd,
+		},
+		"list": {
+			summary: "list all outbounds",
+			run:     listRunner("outbound list", db.PrintOutbounds),
+		},
+		"edit": {
+			summary: "change fields of an outbound by ID",
+			run:     runOutboundEdit,
+		},
+		"delete": {
+			summary: "delete an outbound by ID",
+			run:     deleteRunner("outbound delete", "Outbound", db.DeleteOutbound),
+		},
+	},
+}
+
+// outboundFlags registers a flag for every outbound field. The returned
+// function copies the flags in set, or all of them if set is nil, into
+// record.
+func outboundFlags(fs *flag.FlagSet) func(record *db.OutboundRecord, set map[string]bool) {
+	var transportID idFlag
+
+	outboundType := fs.String("type", "direct", "outbound type ("+strings.Join(db.OutboundTypes, ", ")+")")
+	tag := fs.String("tag", "", "unique outbound tag")
+	server := fs.String("server", "", "server address")
+	serverPort := fs.Int("port", 0, "server port")
+	version := fs.String("version", "", "socks version (4, 4a, 5)")
+	username := fs.String("username", "", "socks or http username")
+	password := fs.String("password", "", "socks, http or shadowsocks password")
+	method := fs.String("method", "", "shadowsocks method")
+	outboundUUID := fs.String("uuid", "", "vless uuid")
+	flow := fs.String("flow", "", "vless flow")
+	tlsEnabled := fs.Bool("tls", false, "enable TLS")
+	tlsServerName := fs.String("tls-server-name", "", "TLS server name")
+	tlsInsecure := fs.Bool("tls-insecure", false, "accept any server certificate")
+	utlsFingerprint := fs.String("utls", "", "uTLS fingerprint, e.g. chrome")
+	realityPublicKey := fs.String("reality-public-key", "", "Reality public key")
+	realityShortID := fs.String("reality-short-id", "", "Reality short ID")
+	fs.Var(&transportID, "transport", "ID of the transport to use")
+	noTransport := fs.Bool("no-transport", false, "unlink the transport")
+	localAddress := fs.String("local-address", "", "comma separated wireguard interface addresses")
+	privateKey := fs.String("private-key", "", "wireguard private key")
+	peerPublicKey := fs.String("peer-public-key", "", "wireguard peer public key")
+	preSharedKey := fs.String("pre-shared-key", "", "wireguard pre-shared key")
+	reserved := fs.String("reserved", "", "wireguard reserved bytes, e.g. 0,0,0")
+	mtu := fs.Int("mtu", 0, "wireguard MTU")
+	detour := fs.String("detour", "", "tag of the outbound to dial through")
+	bindInterface := fs.String("bind-interface", "", "network interface to bind to")
+	routingMark := fs.Int("routing-mark", 0, "netfilter routing mark")
+	domainStrategy := fs.String("domain-strategy", "", "prefer_ipv4, prefer_ipv6, ipv4_only or ipv6_only")
+
+	return func(record *db.OutboundRecord, set map[string]bool) {
+		apply := func(name string) bool {
+			return set == nil || set[name]
+		}
+		if apply("type") {
+			record.Type = *outboundType
+		}
+		if apply("tag") {
+			record.Tag = *tag
+		}
+		if apply("server") {
+			record.Server = *server
+		}
+		if apply("port") {
+			record.ServerPort = *serverPort
+		}
+		if apply("version") {
+			record.Version = *version
+		}
+		if apply("username") {
+			record.Username = *username
+		}
+		if apply("password") {
+			record.Password = *password
+		}
+		if apply("method") {
+			record.Method = *method
+		}
+		if apply("uuid") {
+			record.UUID = *outboundUUID
+		}
+		if apply("flow") {
+			record.Flow = *flow
+		}
+		if apply("tls") {
+			record.TLSEnabled = *tlsEnabled
+		}
+		if apply("tls-server-name") {
+			record.TLSServerName = *tlsServerName
+		}
+		if apply("tls-insecure") {
+			record.TLSInsecure = *tlsInsecure
+		}
+		if apply("utls") {
+			record.UTLSFingerprint = *utlsFingerprint
+		}
+		if apply("reality-public-key") {
+			record.RealityPublicKey = *realityPublicKey
+		}
+		if apply("reality-short-id") {
+			record.RealityShortID = *realityShortID
+		}
+		if apply("transport") {
+			record.TransportID = transportID.id
+		}
+		if *noTransport {
+			record.TransportID = nil
+		}
+		if apply("local-address") {
+			record.LocalAddress = jsonhandler.SplitList(*localAddress)
+		}
+		if apply("private-key") {
+			record.PrivateKey = *privateKey
+		}
+		if apply("peer-public-key") {
+			record.PeerPublicKey = *peerPublicKey
+		}
+		if apply("pre-shared-key") {
+			record.PreSharedKey = *preSharedKey
+		}
+		if apply("reserved") {
+			record.Reserved = *reserved
+		}
+		if apply("mtu") {
+			record.MTU = *mtu
+		}
+		if apply("detour") {
+			record.Detour = *detour
+		}
+		if apply("bind-interface") {
+			record.BindInterface = *bindInterface
+		}
+		if apply("routing-mark") {
+			record.RoutingMark = *routingMark
+		}
+		if apply("domain-strategy") {
+			record.DomainStrategy = *domainStrategy
+		}
+	}
+}
+
+func runOutboundAdd(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("outbound add", "--type TYPE --tag TAG [--server HOST --port PORT] ...")
+	apply := outboundFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var record db.OutboundRecord
+	apply(&record, nil)
+	if record.Tag == "" {
+		record.Tag = record.Type
+	}
+	if err := db.ValidateOutbound(record); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	return db.AddOutbound(dbConnection, record)
+}
+
+func runOutboundEdit(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("outbound edit", "--id ID [--tag TAG] [--server HOST] [--detour TAG] ...")
+	id := fs.Int("id", 0, "ID of the outbound")
+	apply := outboundFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	record, err := db.GetOutbound(dbConnection, *id)
+	if err != nil {
+		return err
+	}
+
+	apply(&record, setFlags(fs))
+	if err := db.ValidateOutbound(record); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	if err := db.UpdateOutbound(dbConnection, record); err != nil {
+		return err
+	}
+	fmt.Println("Outbound updated successfully.")
+	return nil
+}
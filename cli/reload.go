@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"winder.website/sbfm/db"
+	"winder.website/sbfm/jsonhandler"
+)
+
+var reloadCommand = &command{
+	summary: "check the current config.json and run the reload hooks",
+	run:     runReload,
+}
+
+func runReload(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("reload", "[--check-only]")
+	checkOnly := fs.Bool("check-only", false, "only run sing-box check")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	hooks, err := db.ReloadHooks(dbConnection)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(jsonhandler.ConfigFilePath); err != nil {
+		return fmt.Errorf("error reading config: %v", err)
+	}
+	if err := hooks.Check(jsonhandler.ConfigFilePath); err != nil {
+		return err
+	}
+	if *checkOnly {
+		return nil
+	}
+	return hooks.Reload()
+}
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"winder.website/sbfm/staging"
+)
+
+var rollbackCommand = &command{
+	summary: "restore the files replaced by the last generation",
+	run:     runRollback,
+}
+
+func runRollback(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("rollback", "[--list] [--backup NAME]")
+	list := fs.Bool("list", false, "list the backups instead of restoring one")
+	backup := fs.String("backup", "", "backup to restore (default: the newest)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *list {
+		return printBackups()
+	}
+
+	restored, err := staging.Rollback(*backup)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored backup %s.\n", restored)
+	return nil
+}
+
+func printBackups() error {
+	backups, err := staging.Backups()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Backups (newest first):")
+	for _, backup := range backups {
+		contents, err := staging.BackupContents(backup)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%s\n", backup, strings.Join(contents, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package cli
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"winder.website/sbfm/db"
+	"winder.website/sbfm/jsonhandler"
+)
+
+var routeCommand = &command{
+	summary: "manage route rules, rule sets and the final outbound",
+	subcommands: map[string]*command{
+		"rule": {
+			summary: "manage route rules",
+			subcommands: map[string]*command{
+				"add": {
+					summary: "add a route rule or a sub-rule of a logical rule",
+					run:     runRouteRuleAdd,
+				},
+				"list": {
+					summary: "list the route rules in the order they apply",
+					run:     listRunner("route rule list", db.PrintRouteRules),
+				},
+				"edit": {
+					summary: "change fields of a route rule by ID",
+					run:     runRouteRuleEdit,
+				},
+				"delete": {
+					summary: "delete a route rule and its sub-rules by ID",
+					run:     deleteRunner("route rule delete", "Route rule", db.DeleteRouteRule),
+				},
+			},
+		},
+		"rule-set": {
+			summary: "manage rule sets",
+			subcommands: map[string]*command{
+				"add": {
+					summary: "add a local or remote rule set",
+					run:     runRuleSetAdd,
+				},
+				"list": {
+					summary: "list all rule sets",
+					run:     listRunner("route rule-set list", db.PrintRuleSets),
+				},
+				"edit": {
+					summary: "change fields of a rule set by ID",
+					run:     runRuleSetEdit,
+				},
+				"delete": {
+					summary: "delete a rule set by ID",
+					run:     deleteRunner("route rule-set delete", "Rule set", db.DeleteRuleSet),
+				},
+			},
+		},
+		"set": {
+			summary: "set the final outbound and auto_detect_interface",
+			run:     runRouteSet,
+		},
+	},
+}
+
+// ruleMatcherFlags registers the flags of the rule matchers. The returned
+// function copies the flags in set, or all of them if set is nil, into
+// matchers.
+func ruleMatcherFlags(fs *flag.FlagSet) func(matchers *db.RuleMatchers, set map[string]bool) error {
+	inbound := fs.String("inbound", "", "comma separated inbound tags")
+	protocol := fs.String("protocol", "", "comma separated sniffed protocols (http, tls, quic, ...)")
+	domain := fs.String("domain", "", "comma separated full domains")
+	domainSuffix := fs.String("domain-suffix", "", "comma separated domain suffixes")
+	ipCIDR := fs.String("ip-cidr", "", "comma separated IP ranges")
+	port := fs.String("port", "", "comma separated destination ports")
+	ruleSet := fs.String("rule-set", "", "comma separated rule set tags")
+
+	return func(matchers *db.RuleMatchers, set map[string]bool) error {
+		apply := func(name string) bool {
+			return set == nil || set[name]
+		}
+		if apply("inbound") {
+			matchers.Inbound = jsonhandler.SplitList(*inbound)
+		}
+		if apply("protocol") {
+			matchers.Protocol = jsonhandler.SplitList(*protocol)
+		}
+		if apply("domain") {
+			matchers.Domain = jsonhandler.SplitList(*domain)
+		}
+		if apply("domain-suffix") {
+			matchers.DomainSuffix = jsonhandler.SplitList(*domainSuffix)
+		}
+		if apply("ip-cidr") {
+			matchers.IPCIDR = jsonhandler.SplitList(*ipCIDR)
+		}
+		if apply("port") {
+			ports, err := db.ParsePorts(*port)
+			if err != nil {
+				return fmt.Errorf("%w: %v", errUsage, err)
+			}
+			matchers.Port = ports
+		}
+		if apply("rule-set") {
+			matchers.RuleSet = jsonhandler.SplitList(*ruleSet)
+		}
+		return nil
+	}
+}
+
+// ruleFlags registers the flags shared by route and DNS rules, with
+// targetFlag for the tag of the outbound or DNS server matching rules lead
+// to. The returned function copies the flags in set, or all of them if set
+// is nil, into rule and target.
+func ruleFlags(fs *flag.FlagSet, targetFlag, targetUsage string) func(rule *db.RuleRecord, target *string, set map[string]bool) error {
+	var parentID idFlag
+
+	fs.Var(&parentID, "parent", "ID of the logical rule this is a sub-rule of")
+	noParent := fs.Bool("no-parent", false, "make the rule a top level rule")
+	priority := fs.Int("priority", 0, "order among the rules with the same parent, lowest first (default: after the others)")
+	ruleType := fs.String("type", db.RuleTypeDefault, "rule type (default, logical)")
+	mode := fs.String("mode", "", "and or or, for logical rules")
+	invert := fs.Bool("invert", false, "invert the match")
+	targetTag := fs.String(targetFlag, "", targetUsage)
+	applyMatchers := ruleMatcherFlags(fs)
+
+	return func(rule *db.RuleRecord, target *string, set map[string]bool) error {
+		apply := func(name string) bool {
+			return set == nil || set[name]
+		}
+		if apply("parent") {
+			rule.ParentID = parentID.id
+		}
+		if *noParent {
+			rule.ParentID = nil
+		}
+		if apply("priority") {
+			rule.Priority = *priority
+		}
+		if apply("type") {
+			rule.Type = *ruleType
+		}
+		if apply("mode") {
+			rule.Mode = *mode
+		}
+		if apply("invert") {
+			rule.Invert = *invert
+		}
+		if apply(targetFlag) {
+			*target = *targetTag
+		}
+		return applyMatchers(&rule.RuleMatchers, set)
+	}
+}
+
+// routeRuleFlags registers the flags of a route rule, see ruleFlags
+func routeRuleFlags(fs *flag.FlagSet) func(record *db.RouteRuleRecord, set map[string]bool) error {
+	apply := ruleFlags(fs, "outbound", "tag of the outbound for matching connections")
+	return func(record *db.RouteRuleRecord, set map[string]bool) error {
+		return apply(&record.RuleRecord, &record.Outbound, set)
+	}
+}
+
+func runRouteRuleAdd(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("route rule add", "--outbound TAG [--domain-suffix LIST] [--rule-set LIST] ... | --parent ID ...")
+	apply := routeRuleFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var record db.RouteRuleRecord
+	if err := apply(&record, nil); err != nil {
+		return err
+	}
+	if err := db.ValidateRouteRule(record); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	return db.AddRouteRule(dbConnection, record)
+}
+
+func runRouteRuleEdit(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("route rule edit", "--id ID [--priority N] [--outbound TAG] ...")
+	id := fs.Int("id", 0, "ID of the route rule")
+	apply := routeRuleFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	record, err := db.GetRouteRule(dbConnection, *id)
+	if err != nil {
+		return err
+	}
+	if err := apply(&record, setFlags(fs)); err != nil {
+		return err
+	}
+	if err := db.ValidateRouteRule(record); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	if err := db.UpdateRouteRule(dbConnection, record); err != nil {
+		return err
+	}
+	fmt.Println("Route rule updated successfully.")
+	return nil
+}
+
+// ruleSetFlags registers the flags of a rule set. The returned function
+// copies the flags in set, or all of them if set is nil, into record.
+func ruleSetFlags(fs *flag.FlagSet) func(record *db.RuleSetRecord, set map[string]bool) {
+	tag := fs.String("tag", "", "unique rule set tag, e.g. geosite-cn")
+	ruleSetType := fs.String("type", "remote", "rule set type (local, remote)")
+	format := fs.String("format", "binary", "rule set format (source, binary)")
+	path := fs.String("path", "", "file of a local rule set")
+	url := fs.String("url", "", "download URL of a remote rule set")
+	downloadDetour := fs.String("download-detour", "", "tag of the outbound to download through")
+	updateInterval := fs.String("update-interval", "", "how often to update a remote rule set, e.g. 1d")
+
+	return func(record *db.RuleSetRecord, set map[string]bool) {
+		apply := func(name string) bool {
+			return set == nil || set[name]
+		}
+		if apply("tag") {
+			record.Tag = *tag
+		}
+		if apply("type") {
+			record.Type = *ruleSetType
+		}
+		if apply("format") {
+			record.Format = *format
+		}
+		if apply("path") {
+			record.Path = *path
+		}
+		if apply("url") {
+			record.URL = *url
+		}
+		if apply("download-detour") {
+			record.DownloadDetour = *downloadDetour
+		}
+		if apply("update-interval") {
+			record.UpdateInterval = *updateInterval
+		}
+	}
+}
+
+func runRuleSetAdd(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("route rule-set add", "--tag TAG (--url URL | --type local --path PATH) [--format binary]")
+	apply := ruleSetFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var record db.RuleSetRecord
+	apply(&record, nil)
+	if err := db.ValidateRuleSet(record); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	return db.AddRuleSet(dbConnection, record)
+}
+
+func runRuleSetEdit(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("route rule-set edit", "--id ID [--url URL] [--download-detour TAG] ...")
+	id := fs.Int("id", 0, "ID of the rule set")
+	apply := ruleSetFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	record, err := db.GetRuleSet(dbConnection, *id)
+	if err != nil {
+		return err
+	}
+	apply(&record, setFlags(fs))
+	if err := db.ValidateRuleSet(record); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	if err := db.UpdateRuleSet(dbConnection, record); err != nil {
+		return err
+	}
+	fmt.Println("Rule set updated successfully.")
+	return nil
+}
+
+func runRouteSet(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("route set", "[--final TAG] [--auto-detect-interface=true|false]")
+	final := fs.String("final", "", "tag of the outbound for unmatched connections, empty for the first outbound")
+	autoDetect := fs.Bool("auto-detect-interface", false, "bind outbound connections to the default interface")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	set := setFlags(fs)
+	if len(set) == 0 {
+		fs.Usage()
+		return fmt.Errorf("%w: nothing to set", errUsage)
+	}
+	if set["final"] {
+		if err := db.SetRouteFinal(dbConnection, *final); err != nil {
+			return err
+		}
+		fmt.Printf("route.final set to %q.\n", *final)
+	}
+	if set["auto-detect-interface"] {
+		err := db.SetSetting(dbConnection, db.SettingRouteAutoDetectInterface, strconv.FormatBool(*autoDetect))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("route.auto_detect_interface set to %t.\n", *autoDetect)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"winder.website/sbfm/db"
+	"winder.website/sbfm/subscription"
+)
+
+var serveCommand = &command{
+	summary: "serve user subscriptions over HTTP",
+	run:     runServe,
+}
+
+func runServe(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("serve", "[--listen :8080] [--prefix /sub/] [--template ./template.json] [--expire-interval 1h] [--collect-interval 1m]")
+	listen := fs.String("listen", ":8080", "address to listen on")
+	prefix := fs.String("prefix", "/sub/", "URL path prefix in front of the sub token")
+	templateFilePath := fs.String("template", "./template.json", "client template file for template mode")
+	expireInterval := fs.Duration("expire-interval", time.Hour, "how often to deactivate expired users, 0 to never")
+	collectInterval := fs.Duration("collect-interval", time.Minute, "how often to collect traffic and enforce quotas when the stats API is on, 0 to never")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *expireInterval > 0 {
+		go expireLoop(ctx, dbConnection, *templateFilePath, *expireInterval)
+	}
+
+	if *collectInterval > 0 {
+		listen, err := db.GetSetting(dbConnection, db.SettingStatsAPIListen)
+		if err != nil {
+			return err
+		}
+		if listen != "" {
+			client, err := db.DialStatsAPI(dbConnection)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			go collectLoop(ctx, dbConnection, client, *collectInterval, *templateFilePath, false)
+		}
+	}
+
+	return subscription.Serve(ctx, dbConnection, subscription.Options{
+		Listen:           *listen,
+		Prefix:           *prefix,
+		TemplateFilePath: *templateFilePath,
+	})
+}
+
+// expireLoop runs the expiry sweep right away and then every interval until
+// ctx is cancelled. Errors are logged, the next sweep tries again.
+func expireLoop(ctx context.Context, dbConnection *sql.DB, templateFilePath string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := db.SweepExpiredUsers(dbConnection, templateFilePath, false); err != nil {
+			log.Printf("Error expiring users: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+
+	"winder.website/sbfm/db"
+)
+
+var settingsCommand = &command{
+	summary: "show and change sbfm settings",
+	subcommands: map[string]*command{
+		"list": {
+			summary: "print all settings",
+			run:     listRunner("settings list", db.PrintSettings),
+		},
+		"get": {
+			summary: "print one setting",
+			run:     runSettingsGet,
+		},
+		"set": {
+			summary: "change one setting",
+			run:     runSettingsSet,
+		},
+	},
+}
+
+func runSettingsGet(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("settings get", "--key KEY")
+	key := fs.String("key", "", "name of the setting")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "key", *key == ""); err != nil {
+		return err
+	}
+	if !db.KnownSetting(*key) {
+		return fmt.Errorf("%w: unknown setting %q", errUsage, *key)
+	}
+
+	value, err := db.GetSetting(dbConnection, *key)
+	if err != nil {
+		return err
+	}
+	fmt.Println(value)
+	return nil
+}
+
+func runSettingsSet(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("settings set", "--key KEY --value VALUE")
+	key := fs.String("key", "", "name of the setting")
+	value := fs.String("value", "", "new value")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "key", *key == ""); err != nil {
+		return err
+	}
+
+	if err := db.ValidateSetting(*key, *value); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if err := db.SetSetting(dbConnection, *key, *value); err != nil {
+		return err
+	}
+	fmt.Printf("%s set to %q.\n", *key, *value)
+	return nil
+}
@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"winder.website/sbfm/db"
+	"winder.website/sbfm/stats"
+)
+
+var trafficCommand = &command{
+	summary: "collect and report per-user traffic and enforce data quotas",
+	subcommands: map[string]*command{
+		"collect": {
+			summary: "read the traffic counters of sing-box into the database",
+			run:     runTrafficCollect,
+		},
+		"report": {
+			summary: "print the traffic of all users, or of one user per day",
+			run:     runTrafficReport,
+		},
+		"quotas": {
+			summary: "print the usage of the users that have a quota",
+			run: listRunner("traffic quotas", func(dbConnection *sql.DB) error {
+				return db.PrintQuotas(dbConnection, time.Now())
+			}),
+		},
+		"enforce": {
+			summary: "deactivate the users over quota and regenerate the affected files",
+			run:     runTrafficEnforce,
+		},
+	},
+}
+
+// collectTimeout bounds a single query of the stats API
+const collectTimeout = 10 * time.Second
+
+func runTrafficCollect(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("traffic collect", "[--interval 1m] [--once] [--template ./template.json] [--no-reload]")
+	interval := fs.Duration("interval", time.Minute, "how often to read the counters")
+	once := fs.Bool("once", false, "read the counters once and exit")
+	templateFilePath := fs.String("template", "./template.json", "client template file for template mode")
+	noReload := fs.Bool("no-reload", false, "do not run the reload hooks after dropping users over quota")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if !*once && *interval <= 0 {
+		return fmt.Errorf("%w: --interval must be positive", errUsage)
+	}
+
+	client, err := db.DialStatsAPI(dbConnection)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if *once {
+		counted, err := collectTraffic(context.Background(), dbConnection, client)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Traffic of %d user(s) collected.\n", counted)
+		overQuota, err := db.SweepOverQuotaUsers(dbConnection, *templateFilePath, *noReload)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d user(s) over quota.\n", len(overQuota))
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	collectLoop(ctx, dbConnection, client, *interval, *templateFilePath, *noReload)
+	return nil
+}
+
+// collectTraffic runs one collection with collectTimeout
+func collectTraffic(ctx context.Context, dbConnection *sql.DB, querier stats.Querier) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, collectTimeout)
+	defer cancel()
+	return db.CollectTraffic(ctx, dbConnection, querier, time.Now())
+}
+
+// collectLoop collects traffic and drops the users over quota right away
+// and then every interval until ctx is cancelled. Errors are logged,
+// sing-box may just be restarting.
+func collectLoop(ctx context.Context, dbConnection *sql.DB, querier stats.Querier, interval time.Duration, templateFilePath string, noReload bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := collectTraffic(ctx, dbConnection, querier); err != nil {
+			log.Printf("Error collecting traffic: %v", err)
+		} else if _, err := db.SweepOverQuotaUsers(dbConnection, templateFilePath, noReload); err != nil {
+			log.Printf("Error enforcing quotas: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runTrafficEnforce(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("traffic enforce", "[--template ./template.json] [--no-reload]")
+	templateFilePath := fs.String("template", "./template.json", "client template file for template mode")
+	noReload := fs.Bool("no-reload", false, "do not run the reload hooks after writing config.json")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	overQuota, err := db.SweepOverQuotaUsers(dbConnection, *templateFilePath, *noReload)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d user(s) over quota.\n", len(overQuota))
+	return nil
+}
+
+func runTrafficReport(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("traffic report", "[--days 30 | --from YYYY-MM-DD [--to YYYY-MM-DD]] [--id ID]")
+	days := fs.Int("days", 30, "number of days up to today to report")
+	fromDate := fs.String("from", "", "first day to report, instead of --days")
+	toDate := fs.String("to", "", "last day to report, default today")
+	id := fs.Int("id", 0, "ID of a user to report per day")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	to := time.Now()
+	if *toDate != "" {
+		day, err := parseDate(*toDate)
+		if err != nil {
+			return err
+		}
+		to = day
+	}
+
+	var from time.Time
+	switch {
+	case *fromDate != "":
+		day, err := parseDate(*fromDate)
+		if err != nil {
+			return err
+		}
+		from = day
+	case *days > 0:
+		from = to.AddDate(0, 0, 1-*days)
+	default:
+		return fmt.Errorf("%w: --days must be positive", errUsage)
+	}
+	if from.After(to) {
+		return fmt.Errorf("%w: --from is after --to", errUsage)
+	}
+
+	if *id > 0 {
+		return db.PrintUserTraffic(dbConnection, *id, from, to)
+	}
+	return db.PrintTrafficUsage(dbConnection, from, to)
+}
+
+// parseDate parses a YYYY-MM-DD day in local time
+func parseDate(date string) (time.Time, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid date %q, expected YYYY-MM-DD", errUsage, date)
+	}
+	return day, nil
+}
@@ -0,0 +1,426 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"winder.website/sbfm/db"
+	"winder.website/sbfm/jsonhandler"
+)
+
+var userCommand = &command{
+	summary: "manage users",
+	subcommands: map[string]*command{
+		"add": {
+			summary: "add a user with a generated uuid and sub token",
+			run:     runUserAdd,
+		},
+		"import": {
+			summary: "add users from a JSON file",
+			run:     runUserImport,
+		},
+		"list": {
+			summary: "print all users",
+			run:     runUserList,
+		},
+		"delete": {
+			summary: "delete a user by ID",
+			run:     runUserDelete,
+		},
+		"activate": {
+			summary: "activate a user by ID",
+			run: func(dbConnection *sql.DB, args []string) error {
+				return runUserSetActive(dbConnection, args, true)
+			},
+		},
+		"deactivate": {
+			summary: "deactivate a user by ID",
+			run: func(dbConnection *sql.DB, args []string) error {
+				return runUserSetActive(dbConnection, args, false)
+			},
+		},
+		"grant": {
+			summary: "give a user access to an inbound",
+			run: func(dbConnection *sql.DB, args []string) error {
+				return runUserInbound(dbConnection, args, true)
+			},
+		},
+		"revoke": {
+			summary: "remove a user's access to an inbound",
+			run: func(dbConnection *sql.DB, args []string) error {
+				return runUserInbound(dbConnection, args, false)
+			},
+		},
+		"inbounds": {
+			summary: "list the inbounds a user has been granted",
+			run:     runUserInbounds,
+		},
+		"links": {
+			summary: "print the share links of a user",
+			run:     runUserLinks,
+		},
+		"extend": {
+			summary: "extend a user by a number of days and activate it",
+			run:     runUserExtend,
+		},
+		"expiry": {
+			summary: "set or clear the expiry date of a user",
+			run:     runUserExpiry,
+		},
+		"quota": {
+			summary: "set or remove the data quota of a user",
+			run:     runUserQuota,
+		},
+		"reset-usage": {
+			summary: "start the quota usage of a user over and activate it",
+			run:     runUserResetUsage,
+		},
+		"default-access": {
+			summary: "show or set the inbounds new users are granted (all or none)",
+			run:     runUserDefaultAccess,
+		},
+	},
+}
+
+func runUserAdd(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("user add", "--name NAME [--days N | --expires YYYY-MM-DD]")
+	name := fs.String("name", "", "name of the user")
+	days := fs.Int("days", 0, "days until the user expires (default: never)")
+	expires := fs.String("expires", "", "last day the user is valid, YYYY-MM-DD")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "name", *name == ""); err != nil {
+		return err
+	}
+
+	var expiresAt *time.Time
+	switch {
+	case *days != 0 && *expires != "":
+		return fmt.Errorf("%w: --days and --expires cannot be combined", errUsage)
+	case *days < 0:
+		return fmt.Errorf("%w: invalid --days %d", errUsage, *days)
+	case *days > 0:
+		expiry := time.Now().AddDate(0, 0, *days)
+		expiresAt = &expiry
+	case *expires != "":
+		expiry, err := parseExpiryDate(*expires)
+		if err != nil {
+			return err
+		}
+		expiresAt = &expiry
+	}
+
+	user, err := db.AddUser(dbConnection, *name, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("User added successfully. UUID: %s, password: %s\n", user.UUID, user.Password)
+	return nil
+}
+
+// parseExpiryDate parses the last day a user is valid. The user expires
+// when the following day starts.
+func parseExpiryDate(date string) (time.Time, error) {
+	day, err := parseDate(date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.AddDate(0, 0, 1), nil
+}
+
+func runUserExtend(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("user extend", "--id ID --days N")
+	id := fs.Int("id", 0, "ID of the user")
+	days := fs.Int("days", 0, "days to add, counted from now if the user has expired")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "days", *days <= 0); err != nil {
+		return err
+	}
+
+	expiresAt, err := db.ExtendUser(dbConnection, *id, *days, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("User with ID %d is active until %s\n", *id, expiresAt.Format("2006-01-02 15:04"))
+	return nil
+}
+
+func runUserExpiry(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("user expiry", "--id ID (--expires YYYY-MM-DD | --never)")
+	id := fs.Int("id", 0, "ID of the user")
+	expires := fs.String("expires", "", "last day the user is valid, YYYY-MM-DD")
+	never := fs.Bool("never", false, "the user never expires")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+	if (*expires == "") == !*never {
+		fs.Usage()
+		return fmt.Errorf("%w: set exactly one of --expires and --never", errUsage)
+	}
+
+	var expiresAt *time.Time
+	if *expires != "" {
+		expiry, err := parseExpiryDate(*expires)
+		if err != nil {
+			return err
+		}
+		expiresAt = &expiry
+	}
+
+	if err := db.SetUserExpiry(dbConnection, *id, expiresAt); err != nil {
+		return err
+	}
+	if expiresAt == nil {
+		fmt.Printf("User with ID %d never expires\n", *id)
+	} else {
+		fmt.Printf("User with ID %d expires at %s\n", *id, expiresAt.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+func runUserQuota(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("user quota", "--id ID (--limit SIZE [--period monthly|rolling] [--anchor YYYY-MM-DD] | --unlimited)")
+	id := fs.Int("id", 0, "ID of the user")
+	limit := fs.String("limit", "", "traffic per period, e.g. 50GiB or 500MB")
+	period := fs.String("period", db.QuotaMonthly, "when the quota starts over: monthly on the 1st, or rolling from the anchor")
+	anchor := fs.String("anchor", "", "first day of a rolling quota, YYYY-MM-DD (default today)")
+	unlimited := fs.Bool("unlimited", false, "remove the quota")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+	if (*limit == "") == !*unlimited {
+		fs.Usage()
+		return fmt.Errorf("%w: set exactly one of --limit and --unlimited", errUsage)
+	}
+
+	if *unlimited {
+		if err := db.SetUserQuota(dbConnection, *id, nil); err != nil {
+			return err
+		}
+		fmt.Printf("User with ID %d has no quota\n", *id)
+		return nil
+	}
+
+	bytes, err := parseSize(*limit)
+	if err != nil {
+		return err
+	}
+	quota := db.Quota{Bytes: bytes, Period: *period}
+	if *period == db.QuotaRolling {
+		quota.Anchor = time.Now()
+		if *anchor != "" {
+			if quota.Anchor, err = parseDate(*anchor); err != nil {
+				return err
+			}
+		}
+	} else if *anchor != "" {
+		return fmt.Errorf("%w: --anchor only applies to rolling quotas", errUsage)
+	}
+	if err := db.ValidateQuota(quota); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	if err := db.SetUserQuota(dbConnection, *id, &quota); err != nil {
+		return err
+	}
+	fmt.Printf("User with ID %d has a %s quota of %s\n", *id, quota.Period, *limit)
+	return nil
+}
+
+func runUserResetUsage(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("user reset-usage", "--id ID")
+	id := fs.Int("id", 0, "ID of the user")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	if err := db.ResetUserUsage(dbConnection, *id, time.Now()); err != nil {
+		return err
+	}
+	fmt.Printf("Usage of user with ID %d reset, the user is active\n", *id)
+	return nil
+}
+
+func runUserImport(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("user import", "--file users.json")
+	file := fs.String("file", "", "JSON file with a list of users")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "file", *file == ""); err != nil {
+		return err
+	}
+
+	return db.ImportUsersFromJSON(dbConnection, *file)
+}
+
+func runUserList(dbConnection *sql.DB, args []string) error {
+	if err := parseFlags(newFlagSet("user list", ""), args); err != nil {
+		return err
+	}
+	return db.PrintAllUsers(dbConnection)
+}
+
+func runUserDelete(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("user delete", "--id ID")
+	id := fs.Int("id", 0, "ID of the user")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	if err := db.DeleteUser(dbConnection, *id); err != nil {
+		return err
+	}
+
+	fmt.Printf("User with ID %d deleted successfully\n", *id)
+	return nil
+}
+
+func runUserSetActive(dbConnection *sql.DB, args []string, active bool) error {
+	name := "user activate"
+	if !active {
+		name = "user deactivate"
+	}
+
+	fs := newFlagSet(name, "--id ID")
+	id := fs.Int("id", 0, "ID of the user")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	if err := db.SetUserActive(dbConnection, *id, active); err != nil {
+		return err
+	}
+
+	status := "activated"
+	if !active {
+		status = "deactivated"
+	}
+	fmt.Printf("User with ID %d has been %s successfully\n", *id, status)
+	return nil
+}
+
+func runUserInbound(dbConnection *sql.DB, args []string, grant bool) error {
+	name := "user grant"
+	if !grant {
+		name = "user revoke"
+	}
+
+	fs := newFlagSet(name, "--id ID --inbound TAG")
+	id := fs.Int("id", 0, "ID of the user")
+	tag := fs.String("inbound", "", "tag of the inbound")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "inbound", *tag == ""); err != nil {
+		return err
+	}
+
+	if grant {
+		if err := db.GrantUserInbound(dbConnection, *id, *tag); err != nil {
+			return err
+		}
+		fmt.Printf("User %d can now use inbound %s.\n", *id, *tag)
+		return nil
+	}
+
+	if err := db.RevokeUserInbound(dbConnection, *id, *tag); err != nil {
+		return err
+	}
+	fmt.Printf("User %d can no longer use inbound %s.\n", *id, *tag)
+	return nil
+}
+
+func runUserInbounds(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("user inbounds", "--id ID")
+	id := fs.Int("id", 0, "ID of the user")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	return db.PrintUserInbounds(dbConnection, *id)
+}
+
+func runUserDefaultAccess(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("user default-access", "[--set all|none]")
+	mode := fs.String("set", "", "inbounds granted to new users: all or none")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *mode != "" {
+		if err := db.ValidateSetting(db.SettingNewUserInbounds, *mode); err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+		if err := db.SetNewUserInbounds(dbConnection, *mode); err != nil {
+			return err
+		}
+	}
+
+	current, err := db.GetSetting(dbConnection, db.SettingNewUserInbounds)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("New users are granted %s inbounds.\n", current)
+	return nil
+}
+
+func runUserLinks(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("user links", "--id ID [--base64]")
+	id := fs.Int("id", 0, "ID of the user")
+	bundle := fs.Bool("base64", false, "print the base64 subscription bundle instead")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlag(fs, "id", *id <= 0); err != nil {
+		return err
+	}
+
+	user, err := db.GetUser(dbConnection, *id)
+	if err != nil {
+		return err
+	}
+
+	links, err := db.UserShareLinks(dbConnection, user)
+	if err != nil {
+		return err
+	}
+
+	if *bundle {
+		fmt.Println(jsonhandler.ShareLinkBundle(links))
+		return nil
+	}
+	for _, link := range links {
+		fmt.Println(link)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"winder.website/sbfm/db"
+	"winder.website/sbfm/jsonhandler"
+)
+
+var validateCommand = &command{
+	summary: "check the config generated from the database without writing it",
+	run:     runValidate,
+}
+
+func runValidate(dbConnection *sql.DB, args []string) error {
+	fs := newFlagSet("validate", "[--strict]")
+	strict := fs.Bool("strict", false, "fail on warnings as well as errors")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	settings, err := db.ConfigSettings(dbConnection)
+	if err != nil {
+		return err
+	}
+	config := jsonhandler.Config{}
+	if err := jsonhandler.PopulateConfig(dbConnection, settings, &config); err != nil {
+		return fmt.Errorf("error populating config: %v", err)
+	}
+
+	report := jsonhandler.ValidateConfig(config)
+	report.Print(os.Stdout)
+
+	if report.ErrorCount() > 0 || (*strict && report.WarningCount() > 0) {
+		return fmt.Errorf("config is not valid")
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"winder.website/sbfm/jsonhandler"
+	"winder.website/sbfm/staging"
+)
+
+// UserClashProfile returns the Clash/Mihomo YAML profile of the user with one
+// proxy per inbound the user can use. Inbounds Clash cannot express are
+// logged and skipped, a Reality inbound without a public key is an error.
+func UserClashProfile(dbConnection *sql.DB, user jsonhandler.User) ([]byte, error) {
+	clients, err := readClientInbounds(dbConnection)
+	if err != nil {
+		return nil, err
+	}
+	return clients.userClashProfile(user)
+}
+
+func (clients clientInbounds) userClashProfile(user jsonhandler.User) ([]byte, error) {
+	endpoints, err := clients.userEndpoints(user)
+	if err != nil {
+		return nil, err
+	}
+
+	var proxies []jsonhandler.ClashProxy
+	for _, e := range endpoints {
+		proxy, err := jsonhandler.NewClashProxy(e.inbound, user, e.host)
+		if err != nil {
+			if errors.Is(err, jsonhandler.ErrNoRealityPublicKey) {
+				return nil, err
+			}
+			log.Printf("skipping inbound %s for user %s: %v", e.inbound.Tag, user.Name, err)
+			continue
+		}
+		proxies = append(proxies, proxy)
+	}
+
+	return jsonhandler.ClashProfile(proxies), nil
+}
+
+// GenerateUserClashProfiles writes the Clash profile of every active user to
+// ./sing-box/users/<name>.yaml, next to the client JSON files
+func GenerateUserClashProfiles(dbConnection *sql.DB, dryRun bool) error {
+	clients, err := readClientInbounds(dbConnection)
+	if err != nil {
+		return err
+	}
+	return generateUserClashProfiles(dbConnection, clients, dryRun)
+}
+
+func generateUserClashProfiles(dbConnection *sql.DB, clients clientInbounds, dryRun bool) error {
+	users, err := activeUsers(dbConnection)
+	if err != nil {
+		return err
+	}
+
+	// Stage a new users directory without the previous profiles
+	usersDir, err := staging.NewDir("./sing-box/users", ".yaml")
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		profile, err := clients.userClashProfile(user)
+		if err != nil {
+			return fmt.Errorf("error building clash profile for user %s: %v", user.Name, err)
+		}
+
+		fileName := fmt.Sprintf("%s.yaml", user.Name)
+		if err := usersDir.WriteFile(fileName, profile); err != nil {
+			log.Printf("error writing clash profile for user %s: %v", user.Name, err)
+			continue // Continue processing other users even if one fails
+		}
+		if !dryRun {
+			log.Printf("Generated clash profile: %s", fileName)
+		}
+	}
+
+	return usersDir.Finish(dryRun)
+}
@@ -6,13 +6,13 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 
 	"winder.website/sbfm/jsonhandler"
+	"winder.website/sbfm/staging"
 )
 
 // GenerateUserJSONFiles generates JSON files for each user based on a template
-func GenerateUserJSONFiles(dbConnection *sql.DB, templateFilePath string) error {
+func GenerateUserJSONFiles(dbConnection *sql.DB, templateFilePath string, dryRun bool) error {
 	// Step 1: Query all users from the database
 	rows, err := dbConnection.Query(`SELECT uuid, name FROM users WHERE active = TRUE`)
 	if err != nil {
@@ -29,68 +29,64 @@ func GenerateUserJSONFiles(dbConnection *sql.DB, templateFilePath string) error
 		users = append(users, user)
 	}
 
+	flows, err := vlessFlows(dbConnection)
+	if err != nil {
+		return err
+	}
+
 	// Step 2: Read the JSON template file
 	templateData, err := os.ReadFile(templateFilePath)
 	if err != nil {
 		return fmt.Errorf("error reading template file: %v", err)
 	}
 
-	// Step 3: Create the users directory and clear existing files
-	usersDir := "./sing-box/users"
-	if err := os.MkdirAll(usersDir, os.ModePerm); err != nil {
-		return fmt.Errorf("error creating users directory: %v", err)
-	}
-
-	// Clear the contents of the users directory
-	if err := clearDirectory(usersDir); err != nil {
+	// Step 3: Stage a new users directory without the previous client JSON files
+	usersDir, err := staging.NewDir("./sing-box/users", ".json")
+	if err != nil {
 		return err
 	}
 
 	// Step 4: Generate JSON files for each user
 	for _, user := range users {
-		// Unmarshal the template JSON into a generic structure
-		var jsonData interface{}
-		if err := json.Unmarshal(templateData, &jsonData); err != nil {
-			log.Printf("error unmarshalling template JSON: %v", err)
-			continue
-		}
-
-		// Step 5: Replace UUIDs in the JSON structure
-		replaceUUID(jsonData, user.UUID)
-
-		// Step 6: Marshal the modified structure back to JSON
-		modifiedJSON, err := json.MarshalIndent(jsonData, "", "  ")
+		modifiedJSON, err := BuildUserClientJSON(templateData, user, flows)
 		if err != nil {
-			log.Printf("error marshalling modified JSON for user %s: %v", user.Name, err)
+			log.Printf("error building JSON for user %s: %v", user.Name, err)
 			continue
 		}
 
-		// Step 7: Write the modified JSON to a new file in the users directory
-		fileName := filepath.Join(usersDir, fmt.Sprintf("%s.json", user.Name))
-		if err := os.WriteFile(fileName, modifiedJSON, 0o644); err != nil {
+		// Step 5: Write the modified JSON to a new file in the users directory
+		fileName := fmt.Sprintf("%s.json", user.Name)
+		if err := usersDir.WriteFile(fileName, modifiedJSON); err != nil {
 			log.Printf("error writing JSON file for user %s: %v", user.Name, err)
 			continue // Continue processing other users even if one fails
 		}
-		log.Printf("Generated JSON file: %s", fileName)
+		if !dryRun {
+			log.Printf("Generated JSON file: %s", fileName)
+		}
 	}
 
-	return nil
+	return usersDir.Finish(dryRun)
 }
 
-// clearDirectory removes all files in the specified directory
-func clearDirectory(dir string) error {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		return fmt.Errorf("error reading directory %s: %v", dir, err)
+// BuildUserClientJSON fills the template with the user's UUID and the vless
+// flows of the inbounds and returns the client JSON
+func BuildUserClientJSON(templateData []byte, user jsonhandler.User, flows map[string]string) ([]byte, error) {
+	// Unmarshal the template JSON into a generic structure
+	var jsonData interface{}
+	if err := json.Unmarshal(templateData, &jsonData); err != nil {
+		return nil, fmt.Errorf("error unmarshalling template JSON: %v", err)
 	}
 
-	for _, file := range files {
-		if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
-			log.Printf("error deleting file %s: %v", file.Name(), err)
-		}
-	}
+	// Replace UUIDs in the JSON structure
+	replaceUUID(jsonData, user.UUID)
+	setFlows(jsonData, flows)
 
-	return nil
+	// Marshal the modified structure back to JSON
+	modifiedJSON, err := json.MarshalIndent(jsonData, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling modified JSON: %v", err)
+	}
+	return modifiedJSON, nil
 }
 
 // replaceUUID recursively replaces UUID placeholders in the JSON structure with the actual UUID
@@ -110,3 +106,55 @@ func replaceUUID(data interface{}, newUUID string) {
 		}
 	}
 }
+
+// vlessFlows returns the flow of every vless inbound by tag
+func vlessFlows(dbConnection *sql.DB) (map[string]string, error) {
+	rows, err := dbConnection.Query(`SELECT tag, flow FROM inbounds WHERE type = 'vless'`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying inbounds table: %v", err)
+	}
+	defer rows.Close()
+
+	flows := make(map[string]string)
+	for rows.Next() {
+		var tag, flow string
+		if err := rows.Scan(&tag, &flow); err != nil {
+			return nil, fmt.Errorf("error scanning inbound row: %v", err)
+		}
+		flows[tag] = flow
+	}
+	return flows, rows.Err()
+}
+
+// setFlows recursively sets the flow of the vless outbounds in the JSON
+// structure to the flow of the vless inbound with the same tag. When there
+// is a single vless inbound its flow is used whatever the tag; outbounds
+// matching no inbound are left as they are.
+func setFlows(data interface{}, flows map[string]string) {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		if v["type"] == "vless" {
+			tag, _ := v["tag"].(string)
+			flow, ok := flows[tag]
+			if !ok && len(flows) == 1 {
+				for _, only := range flows {
+					flow, ok = only, true
+				}
+			}
+			switch {
+			case !ok:
+			case flow == "":
+				delete(v, "flow")
+			default:
+				v["flow"] = flow
+			}
+		}
+		for _, value := range v {
+			setFlows(value, flows)
+		}
+	case []interface{}:
+		for _, item := range v {
+			setFlows(item, flows)
+		}
+	}
+}
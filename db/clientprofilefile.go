@@ -0,0 +1,171 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"winder.website/sbfm/jsonhandler"
+	"winder.website/sbfm/staging"
+)
+
+// UserClientProfile builds the sing-box client profile of the user from the
+// inbounds, tls, reality and transports tables, with one outbound per inbound
+// the user can use wrapped in the group chosen by the client_group setting
+func UserClientProfile(dbConnection *sql.DB, user jsonhandler.User) ([]byte, error) {
+	group, err := GetSetting(dbConnection, SettingClientGroup)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := readClientInbounds(dbConnection)
+	if err != nil {
+		return nil, err
+	}
+	return clients.userClientProfile(user, group)
+}
+
+func (clients clientInbounds) userClientProfile(user jsonhandler.User, group string) ([]byte, error) {
+	endpoints, err := clients.userEndpoints(user)
+	if err != nil {
+		return nil, err
+	}
+
+	var outbounds []jsonhandler.Outbound
+	for _, e := range endpoints {
+		outbound, err := jsonhandler.NewClientOutbound(e.inbound, user, e.host)
+		if err != nil {
+			if errors.Is(err, jsonhandler.ErrNoRealityPublicKey) {
+				return nil, err
+			}
+			log.Printf("skipping inbound %s for user %s: %v", e.inbound.Tag, user.Name, err)
+			continue
+		}
+		outbounds = append(outbounds, outbound)
+	}
+
+	profile, err := jsonhandler.ClientProfile(outbounds, group)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonData, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	return jsonData, nil
+}
+
+// UserClientJSON returns the sing-box client JSON of the user, generated or
+// filled in from the template depending on the client_profile_mode setting
+func UserClientJSON(dbConnection *sql.DB, user jsonhandler.User, templateFilePath string) ([]byte, error) {
+	mode, err := GetSetting(dbConnection, SettingClientProfileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == ClientProfileTemplate {
+		templateData, err := os.ReadFile(templateFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading template file: %v", err)
+		}
+		flows, err := vlessFlows(dbConnection)
+		if err != nil {
+			return nil, err
+		}
+		return BuildUserClientJSON(templateData, user, flows)
+	}
+	return UserClientProfile(dbConnection, user)
+}
+
+// GenerateUserClientProfiles writes the generated client profile of every
+// active user to ./sing-box/users/<name>.json
+func GenerateUserClientProfiles(dbConnection *sql.DB, dryRun bool) error {
+	clients, err := readClientInbounds(dbConnection)
+	if err != nil {
+		return err
+	}
+	return generateUserClientProfiles(dbConnection, clients, dryRun)
+}
+
+func generateUserClientProfiles(dbConnection *sql.DB, clients clientInbounds, dryRun bool) error {
+	users, err := activeUsers(dbConnection)
+	if err != nil {
+		return err
+	}
+	group, err := GetSetting(dbConnection, SettingClientGroup)
+	if err != nil {
+		return err
+	}
+
+	// Stage a new users directory without the previous client JSON files
+	usersDir, err := staging.NewDir("./sing-box/users", ".json")
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		profile, err := clients.userClientProfile(user, group)
+		if err != nil {
+			return fmt.Errorf("error building client profile for user %s: %v", user.Name, err)
+		}
+
+		fileName := fmt.Sprintf("%s.json", user.Name)
+		if err := usersDir.WriteFile(fileName, profile); err != nil {
+			log.Printf("error writing JSON file for user %s: %v", user.Name, err)
+			continue // Continue processing other users even if one fails
+		}
+		if !dryRun {
+			log.Printf("Generated JSON file: %s", fileName)
+		}
+	}
+
+	return usersDir.Finish(dryRun)
+}
+
+// GenerateUserClientFiles writes the client JSON of every active user in the
+// mode selected by the client_profile_mode setting. A dry run writes nothing
+// and prints the files that would change instead.
+func GenerateUserClientFiles(dbConnection *sql.DB, templateFilePath string, dryRun bool) error {
+	clients, err := readClientInbounds(dbConnection)
+	if err != nil {
+		return err
+	}
+	return generateUserClientFiles(dbConnection, clients, templateFilePath, dryRun)
+}
+
+func generateUserClientFiles(dbConnection *sql.DB, clients clientInbounds, templateFilePath string, dryRun bool) error {
+	mode, err := GetSetting(dbConnection, SettingClientProfileMode)
+	if err != nil {
+		return err
+	}
+
+	if mode == ClientProfileTemplate {
+		return GenerateUserJSONFiles(dbConnection, templateFilePath, dryRun)
+	}
+	return generateUserClientProfiles(dbConnection, clients, dryRun)
+}
+
+// keepTemplateProfiles sets client_profile_mode to template on databases
+// that already have users, whose client JSON used to be filled in from the
+// template. New databases generate client profiles.
+func keepTemplateProfiles(tx *sql.Tx) error {
+	var users int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users").Scan(&users); err != nil {
+		return fmt.Errorf("error counting users: %v", err)
+	}
+	if users == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(
+		"INSERT OR IGNORE INTO settings (key, value) VALUES (?, ?)",
+		SettingClientProfileMode, ClientProfileTemplate,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving setting %s: %v", SettingClientProfileMode, err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+)
+
+// remigrate runs the client profile migration again on a database that
+// has gone through every migration, as if it had stopped just before it
+func remigrate(t *testing.T, dbConnection *sql.DB) {
+	t.Helper()
+	if _, err := dbConnection.Exec("DELETE FROM schema_version WHERE version = 16"); err != nil {
+		t.Fatalf("error resetting schema version: %v", err)
+	}
+	if _, err := Migrate(dbConnection); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+}
+
+func clientProfileMode(t *testing.T, dbConnection *sql.DB) string {
+	t.Helper()
+	mode, err := GetSetting(dbConnection, SettingClientProfileMode)
+	if err != nil {
+		t.Fatalf("GetSetting: %v", err)
+	}
+	return mode
+}
+
+func TestNewDatabaseGeneratesClientProfiles(t *testing.T) {
+	dbConnection := openTestDB(t)
+	if mode := clientProfileMode(t, dbConnection); mode != ClientProfileGenerated {
+		t.Errorf("client_profile_mode = %s, want %s", mode, ClientProfileGenerated)
+	}
+}
+
+func TestExistingUsersKeepTemplateProfiles(t *testing.T) {
+	dbConnection := openTestDB(t)
+	_, err := dbConnection.Exec(
+		"INSERT INTO users (name, uuid, sub) VALUES ('alice', '0b6ea4a8-3c42-4c54-9d5c-1d2f8d5a6a01', 'sub-alice')",
+	)
+	if err != nil {
+		t.Fatalf("error adding user: %v", err)
+	}
+
+	remigrate(t, dbConnection)
+	if mode := clientProfileMode(t, dbConnection); mode != ClientProfileTemplate {
+		t.Errorf("client_profile_mode = %s, want %s", mode, ClientProfileTemplate)
+	}
+}
+
+func TestChosenClientProfileModeIsKept(t *testing.T) {
+	dbConnection := openTestDB(t)
+	_, err := dbConnection.Exec(
+		"INSERT INTO users (name, uuid, sub) VALUES ('alice', '0b6ea4a8-3c42-4c54-9d5c-1d2f8d5a6a01', 'sub-alice')",
+	)
+	if err != nil {
+		t.Fatalf("error adding user: %v", err)
+	}
+	if err := SetSetting(dbConnection, SettingClientProfileMode, ClientProfileGenerated); err != nil {
+		t.Fatalf("SetSetting: %v", err)
+	}
+
+	remigrate(t, dbConnection)
+	if mode := clientProfileMode(t, dbConnection); mode != ClientProfileGenerated {
+		t.Errorf("client_profile_mode = %s, want %s", mode, ClientProfileGenerated)
+	}
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"winder.website/sbfm/jsonhandler"
+)
+
+// userPasswordLength is the length in hex digits of the generated password
+// users send to trojan, hysteria2, tuic and older shadowsocks inbounds
+const userPasswordLength = 32
+
+// userKeyLength is the length in bytes of the generated shadowsocks 2022
+// key of a user. It is the longest key a 2022 method takes, methods with
+// shorter keys use its first bytes.
+const userKeyLength = 32
+
+// newShadowsocksKey returns length random bytes in base64, the key format
+// of the shadowsocks 2022 methods
+func newShadowsocksKey(length int) (string, error) {
+	key := make([]byte, length)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("error generating shadowsocks key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// newUserCredentials generates the password and shadowsocks key of a user
+func newUserCredentials() (password, ssKey string, err error) {
+	password, err = generateRandomString(userPasswordLength)
+	if err != nil {
+		return "", "", fmt.Errorf("error generating password: %v", err)
+	}
+	ssKey, err = newShadowsocksKey(userKeyLength)
+	if err != nil {
+		return "", "", err
+	}
+	return password, ssKey, nil
+}
+
+// ShadowsocksMethodNames returns the shadowsocks methods sbfm supports in
+// order
+func ShadowsocksMethodNames() []string {
+	methods := make([]string, 0, len(jsonhandler.ShadowsocksMethods))
+	for method := range jsonhandler.ShadowsocksMethods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// inboundPassword checks the method and server password of an inbound and
+// returns the password to store. Only shadowsocks inbounds have them. The
+// 2022 methods take a base64 key of the length of the method, which is
+// generated when password is empty; the older methods take none.
+func inboundPassword(inboundType, method, password string) (string, error) {
+	if inboundType != "shadowsocks" {
+		if method != "" || password != "" {
+			return "", fmt.Errorf("method and password are only used by shadowsocks inbounds")
+		}
+		return "", nil
+	}
+
+	length, ok := jsonhandler.ShadowsocksMethods[method]
+	switch {
+	case !ok:
+		return "", fmt.Errorf(
+			"invalid shadowsocks method %q, expected one of %s",
+			method, strings.Join(ShadowsocksMethodNames(), ", "),
+		)
+	case length == 0 && password != "":
+		return "", fmt.Errorf("%s takes no server password, users have their own", method)
+	case length == 0:
+		return "", nil
+	case password == "":
+		return newShadowsocksKey(length)
+	case !jsonhandler.ValidShadowsocksKey(password, length):
+		return "", fmt.Errorf("the server password of %s must be a base64 %d byte key", method, length)
+	}
+	return password, nil
+}
+
+func addCredentials(tx *sql.Tx) error {
+	for _, column := range []string{
+		"users ADD COLUMN password TEXT NOT NULL DEFAULT ''",
+		// base64, userKeyLength bytes
+		"users ADD COLUMN ss_key TEXT NOT NULL DEFAULT ''",
+		// Shadowsocks only; password is the server key of the 2022 methods
+		"inbounds ADD COLUMN method TEXT NOT NULL DEFAULT ''",
+		"inbounds ADD COLUMN password TEXT NOT NULL DEFAULT ''",
+	} {
+		if _, err := tx.Exec("ALTER TABLE " + column); err != nil {
+			return fmt.Errorf("error adding credential columns: %v", err)
+		}
+	}
+
+	// Existing users get their credentials right away
+	rows, err := tx.Query("SELECT id FROM users")
+	if err != nil {
+		return fmt.Errorf("error querying users table: %v", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning user row: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading users: %v", err)
+	}
+
+	for _, id := range ids {
+		password, ssKey, err := newUserCredentials()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE users SET password = ?, ss_key = ? WHERE id = ?", password, ssKey, id); err != nil {
+			return fmt.Errorf("error setting user credentials: %v", err)
+		}
+	}
+	return nil
+}
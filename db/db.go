@@ -9,8 +9,9 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// CreateTables function is responsible for creating the tables in the database.
-func CreateTables(db *sql.DB) error {
+// createInitialSchema creates the tables of schema version 1. Databases created
+// before the schema_version table existed match this schema exactly.
+func createInitialSchema(db *sql.Tx) error {
 	// Create log table
 	_, err := db.Exec(`
 		CREATE TABLE IF NOT EXISTS log (
@@ -125,17 +126,3 @@ func CreateTables(db *sql.DB) error {
 
 	return nil
 }
-
-// AddLogData inserts a new log entry into the log table.
-func AddLogData(db *sql.DB, disabled bool, level, output string, timestamp bool) error {
-	query := `
-        INSERT INTO log (disabled, level, output, timestamp) 
-        VALUES (?, ?, ?, ?)
-    `
-	_, err := db.Exec(query, disabled, level, output, timestamp)
-	if err != nil {
-		return fmt.Errorf("error inserting log data: %v", err)
-	}
-	fmt.Println("Log data added successfully.")
-	return nil
-}
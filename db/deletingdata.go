@@ -8,9 +8,13 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// DeleteInbound deletes an inbound by ID
+// DeleteInbound deletes an inbound by ID along with its user assignments
 func DeleteInbound(dbConnection *sql.DB, inboundID int) error {
 	_, err := dbConnection.Exec("DELETE FROM inbounds WHERE id = ?", inboundID)
+	if err != nil {
+		return err
+	}
+	_, err = dbConnection.Exec("DELETE FROM user_inbounds WHERE inbound_id = ?", inboundID)
 	return err
 }
 
@@ -29,6 +33,14 @@ func DeleteTLS(dbConnection *sql.DB, tlsID int) error {
 // DeleteReality deletes a Reality configuration by ID
 func DeleteReality(dbConnection *sql.DB, realityID int) error {
 	_, err := dbConnection.Exec("DELETE FROM reality WHERE id = ?", realityID)
+	if err != nil {
+		return err
+	}
+	_, err = dbConnection.Exec("DELETE FROM reality_short_ids WHERE reality_id = ?", realityID)
+	if err != nil {
+		return err
+	}
+	_, err = dbConnection.Exec("DELETE FROM reality_server_names WHERE reality_id = ?", realityID)
 	return err
 }
 
@@ -37,3 +49,10 @@ func DeleteHandshake(dbConnection *sql.DB, handshakeID int) error {
 	_, err := dbConnection.Exec("DELETE FROM handshake WHERE id = ?", handshakeID)
 	return err
 }
+
+// DeleteOutbound deletes an outbound by ID. Detours through it are left in
+// place and reported by validation.
+func DeleteOutbound(dbConnection *sql.DB, outboundID int) error {
+	_, err := dbConnection.Exec("DELETE FROM outbounds WHERE id = ?", outboundID)
+	return err
+}
@@ -12,7 +12,7 @@ import (
 // PrintInbounds prints all the data in the inbounds table
 func PrintInbounds(dbConnection *sql.DB) error {
 	rows, err := dbConnection.Query(
-		`SELECT id, type, tag, listen, listen_port, sniff, sniff_override_destination, sniff_timeout, transport_id, tls_id, reality_id, handshake_id FROM inbounds`,
+		`SELECT id, type, tag, listen, listen_port, sniff, sniff_override_destination, sniff_timeout, COALESCE(detour, ''), method, flow, transport_id, tls_id, reality_id, handshake_id FROM inbounds`,
 	)
 	if err != nil {
 		return fmt.Errorf("error querying inbounds table: %v", err)
@@ -21,19 +21,57 @@ func PrintInbounds(dbConnection *sql.DB) error {
 
 	fmt.Println("Available Inbounds:")
 	fmt.Println(
-		"ID,\tType,\tTag,\tListen,\tListenPort,\tSniff,\tSniffOverrideDestination,\tSniffTimeout,\tTransportID,\tTLSID,\tRealityID,\tHandshakeID",
+		"ID,\tType,\tTag,\tListen,\tListenPort,\tSniff,\tSniffOverrideDestination,\tSniffTimeout,\tDetour,\tMethod,\tFlow,\tTransportID,\tTLSID,\tRealityID,\tHandshakeID",
 	)
 	for rows.Next() {
-		var id int
-		var inboundType, port, settings string
-		if err := rows.Scan(&id, &inboundType, &port, &settings); err != nil {
+		var id, listenPort int
+		var inboundType, tag, listen, sniffTimeout, detour, method, flow string
+		var sniff, sniffOverrideDestination bool
+		var transportID, tlsID, realityID, handshakeID sql.NullInt64
+		if err := rows.Scan(
+			&id, &inboundType, &tag, &listen, &listenPort, &sniff, &sniffOverrideDestination, &sniffTimeout, &detour, &method, &flow,
+			&transportID, &tlsID, &realityID, &handshakeID,
+		); err != nil {
 			return fmt.Errorf("error scanning inbound row: %v", err)
 		}
-		fmt.Printf("%d\t%s\t%s\t%s\n", id, inboundType, port, settings)
+		fmt.Printf(
+			"%d\t%s\t%s\t%s\t%d\t%t\t%t\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			id,
+			inboundType,
+			tag,
+			listen,
+			listenPort,
+			sniff,
+			sniffOverrideDestination,
+			sniffTimeout,
+			formatOptional(detour),
+			formatOptional(method),
+			formatOptional(flow),
+			formatNullID(transportID),
+			formatNullID(tlsID),
+			formatNullID(realityID),
+			formatNullID(handshakeID),
+		)
 	}
 	return nil
 }
 
+// formatNullID formats an optional foreign key for the listings
+func formatNullID(id sql.NullInt64) string {
+	if !id.Valid {
+		return "-"
+	}
+	return fmt.Sprintf("%d", id.Int64)
+}
+
+// formatOptional formats an optional text column for the listings
+func formatOptional(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
 // PrintTransports prints all the data in the trasport table
 func PrintTransports(dbConnection *sql.DB) error {
 	rows, err := dbConnection.Query(`SELECT id, type, path FROM transports`)
@@ -90,8 +128,12 @@ func PrintTLS(dbConnection *sql.DB) error {
 
 // PrintReality prints all the data in the reality table
 func PrintReality(dbConnection *sql.DB) error {
-	rows, err := dbConnection.Query(
-		`SELECT id, enabled, private_key, short_id FROM reality`,
+	rows, err := dbConnection.Query(`
+		SELECT r.id, r.enabled, r.private_key, COALESCE(r.public_key, ''), r.per_user_short_ids,
+			COALESCE((SELECT GROUP_CONCAT(short_id, ',' ORDER BY id) FROM reality_short_ids WHERE reality_id = r.id AND user_id IS NULL), ''),
+			(SELECT COUNT(*) FROM reality_short_ids WHERE reality_id = r.id AND user_id IS NOT NULL),
+			COALESCE((SELECT GROUP_CONCAT(server_name, ',' ORDER BY id) FROM reality_server_names WHERE reality_id = r.id), '')
+		FROM reality r`,
 	)
 	if err != nil {
 		return fmt.Errorf("error querying reality table: %v", err)
@@ -99,20 +141,30 @@ func PrintReality(dbConnection *sql.DB) error {
 	defer rows.Close()
 
 	fmt.Println("Available Reality:")
-	fmt.Println("ID\tEnabled\tPrivetKey\tShortID")
+	fmt.Println("ID\tEnabled\tPrivetKey\tPublicKey\tShortIDs\tPerUserShortIDs\tServerNames")
 	for rows.Next() {
-		var id int
-		var enabled bool
-		var privateKey, shortID string
-		if err := rows.Scan(&id, &enabled, &privateKey, &shortID); err != nil {
+		var id, userShortIDs int
+		var enabled, perUserShortIDs bool
+		var privateKey, publicKey, shortIDs, serverNames string
+		if err := rows.Scan(&id, &enabled, &privateKey, &publicKey, &perUserShortIDs, &shortIDs, &userShortIDs, &serverNames); err != nil {
 			return fmt.Errorf("error scanning reality row: %v", err)
 		}
+		if publicKey == "" {
+			publicKey = "-"
+		}
+		perUser := "off"
+		if perUserShortIDs {
+			perUser = fmt.Sprintf("on (%d)", userShortIDs)
+		}
 		fmt.Printf(
-			"%d\t%t\t%s\n%s\n",
+			"%d\t%t\t%s\t%s\t%s\t%s\t%s\n",
 			id,
 			enabled,
 			privateKey,
-			shortID,
+			publicKey,
+			shortIDs,
+			perUser,
+			serverNames,
 		)
 	}
 	return nil
@@ -145,3 +197,52 @@ func PrintHandshake(dbConnection *sql.DB) error {
 	}
 	return nil
 }
+
+// PrintOutbounds prints the outbounds table with the server and dial fields
+// of each outbound
+func PrintOutbounds(dbConnection *sql.DB) error {
+	rows, err := dbConnection.Query(
+		`SELECT id, type, tag, server, server_port, tls_enabled, transport_id, detour, bind_interface, routing_mark FROM outbounds ORDER BY id`,
+	)
+	if err != nil {
+		return fmt.Errorf("error querying outbounds table: %v", err)
+	}
+	defer rows.Close()
+
+	fmt.Println("Available Outbounds:")
+	fmt.Println("ID\tType\tTag\tServer\tTLS\tTransportID\tDetour\tBindInterface\tRoutingMark")
+	for rows.Next() {
+		var id, serverPort, routingMark int
+		var outboundType, tag, server, detour, bindInterface string
+		var tlsEnabled bool
+		var transportID sql.NullInt64
+		if err := rows.Scan(
+			&id, &outboundType, &tag, &server, &serverPort, &tlsEnabled, &transportID,
+			&detour, &bindInterface, &routingMark,
+		); err != nil {
+			return fmt.Errorf("error scanning outbound row: %v", err)
+		}
+
+		address := "-"
+		if server != "" {
+			address = fmt.Sprintf("%s:%d", server, serverPort)
+		}
+		mark := "-"
+		if routingMark != 0 {
+			mark = fmt.Sprintf("%d", routingMark)
+		}
+		fmt.Printf(
+			"%d\t%s\t%s\t%s\t%t\t%s\t%s\t%s\t%s\n",
+			id,
+			outboundType,
+			tag,
+			address,
+			tlsEnabled,
+			formatNullID(transportID),
+			formatOptional(detour),
+			formatOptional(bindInterface),
+			mark,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"winder.website/sbfm/jsonhandler"
+)
+
+// Keys of the DNS settings, stored in the settings table.
+const (
+	// SettingDNSFinal is the tag of the DNS server that gets the queries no
+	// DNS rule matched. Empty means the first server.
+	SettingDNSFinal = "dns_final"
+	// SettingDNSStrategy is the default domain strategy of the DNS servers,
+	// one of DNSStrategies or empty.
+	SettingDNSStrategy = "dns_strategy"
+	// SettingDNSDisableCache turns the DNS cache off: true or false.
+	SettingDNSDisableCache = "dns_disable_cache"
+	// SettingDNSIndependentCache keeps a separate cache per DNS server: true
+	// or false.
+	SettingDNSIndependentCache = "dns_independent_cache"
+	// SettingDNSFakeIPInet4Range and SettingDNSFakeIPInet6Range are the
+	// ranges fake IPs are handed out from. FakeIP is enabled when either is
+	// set.
+	SettingDNSFakeIPInet4Range = "dns_fakeip_inet4_range"
+	SettingDNSFakeIPInet6Range = "dns_fakeip_inet6_range"
+)
+
+// DNSStrategies are the domain strategies of DNS servers
+var DNSStrategies = []string{"prefer_ipv4", "prefer_ipv6", "ipv4_only", "ipv6_only"}
+
+// dnsAddressSchemes are the schemes of DNS server addresses sing-box accepts
+var dnsAddressSchemes = []string{"tcp", "udp", "tls", "https", "quic", "h3", "rcode", "dhcp"}
+
+// validDNSStrategy accepts a domain strategy or an empty one
+func validDNSStrategy(strategy string) error {
+	if strategy == "" {
+		return nil
+	}
+	return oneOf(DNSStrategies...)(strategy)
+}
+
+// fakeIPRange returns a validator for a fakeip range of the IPv4 or the
+// IPv6 family. An empty range is accepted.
+func fakeIPRange(ipv4 bool) func(string) error {
+	return func(value string) error {
+		if value == "" {
+			return nil
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return fmt.Errorf("invalid range %q, expected a CIDR such as 198.18.0.0/15", value)
+		}
+		if prefix.Addr().Is4() != ipv4 {
+			return fmt.Errorf("range %q is of the wrong address family", value)
+		}
+		return nil
+	}
+}
+
+// DNSServerRecord is a row of the dns_servers table
+type DNSServerRecord struct {
+	ID  int
+	Tag string
+	// Address is a plain IP, a URL such as tls://1.1.1.1 or
+	// https://dns.google/dns-query, local or fakeip
+	Address string
+	// AddressResolver is the tag of the DNS server that resolves the host of
+	// Address when it is a domain name
+	AddressResolver string
+	Strategy        string
+	// Detour is the tag of the outbound queries are sent through
+	Detour string
+}
+
+// ValidateDNSServer checks a DNS server on its own, without looking at the
+// database
+func ValidateDNSServer(record DNSServerRecord) error {
+	if record.Tag == "" {
+		return fmt.Errorf("DNS server has no tag")
+	}
+	if record.Address == "" {
+		return fmt.Errorf("DNS server has no address")
+	}
+	if scheme, _, ok := strings.Cut(record.Address, "://"); ok {
+		if err := oneOf(dnsAddressSchemes...)(scheme); err != nil {
+			return fmt.Errorf("invalid address scheme: %v", err)
+		}
+	}
+	if record.AddressResolver == record.Tag {
+		return fmt.Errorf("DNS server %s cannot resolve its own address", record.Tag)
+	}
+	if host := jsonhandler.DNSAddressHost(record.Address); host != "" && record.AddressResolver == "" {
+		return fmt.Errorf("an address_resolver is needed to look up %s", host)
+	}
+	if err := validDNSStrategy(record.Strategy); err != nil {
+		return fmt.Errorf("invalid strategy: %v", err)
+	}
+	return nil
+}
+
+// checkDNSServer validates record and the tags it refers to
+func checkDNSServer(dbConnection *sql.DB, record DNSServerRecord) error {
+	if err := ValidateDNSServer(record); err != nil {
+		return err
+	}
+	if err := checkReference(dbConnection, "address_resolver", record.AddressResolver, "dns_servers"); err != nil {
+		return err
+	}
+	return checkReference(dbConnection, "detour", record.Detour, "outbounds")
+}
+
+// AddDNSServer Function to add a DNS server
+func AddDNSServer(dbConnection *sql.DB, record DNSServerRecord) error {
+	if err := checkDNSServer(dbConnection, record); err != nil {
+		return err
+	}
+
+	_, err := dbConnection.Exec(
+		`INSERT INTO dns_servers (tag, address, address_resolver, strategy, detour) VALUES (?, ?, ?, ?, ?)`,
+		record.Tag, record.Address, record.AddressResolver, record.Strategy, record.Detour,
+	)
+	if err != nil {
+		return fmt.Errorf("error adding DNS server: %v", err)
+	}
+
+	fmt.Println("DNS server added successfully.")
+	return nil
+}
+
+// GetDNSServer fetches a DNS server by ID
+func GetDNSServer(dbConnection *sql.DB, serverID int) (DNSServerRecord, error) {
+	record := DNSServerRecord{ID: serverID}
+	err := dbConnection.QueryRow(
+		`SELECT tag, address, address_resolver, strategy, detour FROM dns_servers WHERE id = ?`,
+		serverID,
+	).Scan(&record.Tag, &record.Address, &record.AddressResolver, &record.Strategy, &record.Detour)
+	if err != nil {
+		return DNSServerRecord{}, notFound(err, "DNS server", serverID)
+	}
+	return record, nil
+}
+
+// UpdateDNSServer overwrites the DNS server with record.ID
+func UpdateDNSServer(dbConnection *sql.DB, record DNSServerRecord) error {
+	if err := checkDNSServer(dbConnection, record); err != nil {
+		return err
+	}
+
+	result, err := dbConnection.Exec(
+		`UPDATE dns_servers SET tag = ?, address = ?, address_resolver = ?, strategy = ?, detour = ? WHERE id = ?`,
+		record.Tag, record.Address, record.AddressResolver, record.Strategy, record.Detour, record.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating DNS server: %v", err)
+	}
+	return checkUpdated(result, "DNS server", record.ID)
+}
+
+// DeleteDNSServer deletes a DNS server by ID. Rules and servers that use it
+// are reported by validation.
+func DeleteDNSServer(dbConnection *sql.DB, serverID int) error {
+	_, err := dbConnection.Exec("DELETE FROM dns_servers WHERE id = ?", serverID)
+	return err
+}
+
+// PrintDNSServers prints all the data in the dns_servers table
+func PrintDNSServers(dbConnection *sql.DB) error {
+	rows, err := dbConnection.Query(
+		`SELECT id, tag, address, address_resolver, strategy, detour FROM dns_servers ORDER BY id`,
+	)
+	if err != nil {
+		return fmt.Errorf("error querying dns_servers table: %v", err)
+	}
+	defer rows.Close()
+
+	fmt.Println("Available DNS Servers:")
+	fmt.Println("ID\tTag\tAddress\tAddressResolver\tStrategy\tDetour")
+	for rows.Next() {
+		var record DNSServerRecord
+		if err := rows.Scan(
+			&record.ID, &record.Tag, &record.Address, &record.AddressResolver, &record.Strategy, &record.Detour,
+		); err != nil {
+			return fmt.Errorf("error scanning DNS server row: %v", err)
+		}
+
+		fmt.Printf(
+			"%d\t%s\t%s\t%s\t%s\t%s\n",
+			record.ID,
+			record.Tag,
+			record.Address,
+			formatOptional(record.AddressResolver),
+			formatOptional(record.Strategy),
+			formatOptional(record.Detour),
+		)
+	}
+	return nil
+}
+
+// DNSRuleRecord is a row of the dns_rules table. Only top level rules have
+// a server.
+type DNSRuleRecord struct {
+	RuleRecord
+	Server string
+}
+
+var dnsRules = ruleTable{table: "dns_rules", target: "server", name: "DNS rule"}
+
+// ValidateDNSRule checks a DNS rule on its own, without looking at the
+// database
+func ValidateDNSRule(record DNSRuleRecord) error {
+	return dnsRules.validateRule(record.RuleRecord, record.Server)
+}
+
+// checkDNSRule validates record and the rows it refers to
+func checkDNSRule(dbConnection *sql.DB, record DNSRuleRecord) error {
+	if err := ValidateDNSRule(record); err != nil {
+		return err
+	}
+	if err := checkReference(dbConnection, "server", record.Server, "dns_servers"); err != nil {
+		return err
+	}
+	return dnsRules.checkRule(dbConnection, record.RuleRecord)
+}
+
+// AddDNSRule adds a DNS rule. A zero priority puts the rule after the other
+// rules with the same parent.
+func AddDNSRule(dbConnection *sql.DB, record DNSRuleRecord) error {
+	if err := checkDNSRule(dbConnection, record); err != nil {
+		return err
+	}
+
+	priority, err := dnsRules.insert(dbConnection, record.RuleRecord, record.Server)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("DNS rule added with priority %d.\n", priority)
+	return nil
+}
+
+// GetDNSRule fetches a DNS rule by ID
+func GetDNSRule(dbConnection *sql.DB, ruleID int) (DNSRuleRecord, error) {
+	rule, server, err := dnsRules.get(dbConnection, ruleID)
+	return DNSRuleRecord{RuleRecord: rule, Server: server}, err
+}
+
+// UpdateDNSRule overwrites the DNS rule with record.ID
+func UpdateDNSRule(dbConnection *sql.DB, record DNSRuleRecord) error {
+	if err := checkDNSRule(dbConnection, record); err != nil {
+		return err
+	}
+	return dnsRules.update(dbConnection, record.RuleRecord, record.Server)
+}
+
+// DeleteDNSRule deletes a DNS rule by ID along with its sub-rules
+func DeleteDNSRule(dbConnection *sql.DB, ruleID int) error {
+	return dnsRules.delete(dbConnection, ruleID)
+}
+
+// PrintDNSRules prints the DNS rules in the order they are applied, with
+// sub-rules indented below their logical rule
+func PrintDNSRules(dbConnection *sql.DB) error {
+	return dnsRules.print(dbConnection, "DNS Rules:", "Server")
+}
+
+// SetDNSFinal sets the DNS server that gets the queries no DNS rule
+// matched. An empty tag falls back to the first server.
+func SetDNSFinal(dbConnection *sql.DB, tag string) error {
+	if err := checkReference(dbConnection, "final", tag, "dns_servers"); err != nil {
+		return err
+	}
+	return SetSetting(dbConnection, SettingDNSFinal, tag)
+}
+
+func createDNS(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE dns_servers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			tag TEXT UNIQUE NOT NULL,
+			address TEXT NOT NULL,
+			address_resolver TEXT NOT NULL DEFAULT '',
+			strategy TEXT NOT NULL DEFAULT '',
+			detour TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating dns_servers table: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE dns_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			parent_id INTEGER,
+			priority INTEGER NOT NULL,
+			type TEXT NOT NULL DEFAULT 'default',
+			mode TEXT NOT NULL DEFAULT '',
+			inbound TEXT NOT NULL DEFAULT '',
+			protocol TEXT NOT NULL DEFAULT '',
+			domain TEXT NOT NULL DEFAULT '',
+			domain_suffix TEXT NOT NULL DEFAULT '',
+			ip_cidr TEXT NOT NULL DEFAULT '',
+			port TEXT NOT NULL DEFAULT '',
+			rule_set TEXT NOT NULL DEFAULT '',
+			invert BOOLEAN NOT NULL DEFAULT FALSE,
+			server TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY (parent_id) REFERENCES dns_rules(id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating dns_rules table: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"winder.website/sbfm/jsonhandler"
+	"winder.website/sbfm/staging"
+)
+
+// SetUserExpiry sets when the user with the given ID expires. A nil
+// expiresAt means the user never expires.
+func SetUserExpiry(db *sql.DB, id int, expiresAt *time.Time) error {
+	var value interface{}
+	if expiresAt != nil {
+		value = expiresAt.Unix()
+	}
+
+	result, err := db.Exec("UPDATE users SET expires_at = ? WHERE id = ?", value, id)
+	if err != nil {
+		return fmt.Errorf("error updating user expiry: %v", err)
+	}
+	return checkUpdated(result, "user", id)
+}
+
+// ExtendUser moves the expiry of the user with the given ID days into the
+// future, counting from now if the user has expired or never expired, and
+// activates the user. It returns the new expiry.
+func ExtendUser(db *sql.DB, id, days int, now time.Time) (time.Time, error) {
+	if days <= 0 {
+		return time.Time{}, fmt.Errorf("invalid number of days %d", days)
+	}
+
+	var expiresAt sql.NullInt64
+	err := db.QueryRow("SELECT expires_at FROM users WHERE id = ?", id).Scan(&expiresAt)
+	if err != nil {
+		return time.Time{}, notFound(err, "user", id)
+	}
+
+	from := now
+	if expiresAt.Valid && expiresAt.Int64 > now.Unix() {
+		from = time.Unix(expiresAt.Int64, 0)
+	}
+	extended := from.AddDate(0, 0, days)
+
+	_, err = db.Exec("UPDATE users SET expires_at = ?, active = TRUE WHERE id = ?", extended.Unix(), id)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error extending user: %v", err)
+	}
+	return extended, nil
+}
+
+// daysLeft describes how long a user has until expiresAt for the listings
+func daysLeft(expiresAt sql.NullInt64, now time.Time) string {
+	if !expiresAt.Valid {
+		return "never"
+	}
+	remaining := time.Unix(expiresAt.Int64, 0).Sub(now)
+	if remaining <= 0 {
+		return "expired"
+	}
+	// Round up, a user with an hour left has one day left
+	return fmt.Sprintf("%d", int((remaining+24*time.Hour-1)/(24*time.Hour)))
+}
+
+// ExpireUsers deactivates every active user whose expiry has passed and
+// returns their names. They are left pending until dropUsers has removed
+// them from the generated files.
+func ExpireUsers(db *sql.DB, now time.Time) ([]string, error) {
+	rows, err := db.Query(
+		"SELECT id, name FROM users WHERE active = TRUE AND expires_at IS NOT NULL AND expires_at <= ?",
+		now.Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying users table: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	var names []string
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, fmt.Errorf("error scanning user row: %v", err)
+		}
+		ids = append(ids, id)
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading users: %v", err)
+	}
+
+	for _, id := range ids {
+		if err := deactivatePending(db, id); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// deactivatePending deactivates the user with the given ID and marks them
+// pending until dropUsers has regenerated the files without them
+func deactivatePending(db *sql.DB, id int) error {
+	result, err := db.Exec("UPDATE users SET active = FALSE, drop_pending = TRUE WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("error deactivating user: %v", err)
+	}
+	return checkUpdated(result, "user", id)
+}
+
+// userOutput is a per-user output that only holds files of active users
+type userOutput struct {
+	// path returns the file of the named user
+	path     func(name string) string
+	generate func(dbConnection *sql.DB, templateFilePath string) error
+}
+
+var userOutputs = []userOutput{
+	{
+		path: func(name string) string { return filepath.Join("sing-box", "users", name+".json") },
+		generate: func(dbConnection *sql.DB, templateFilePath string) error {
+			return GenerateUserClientFiles(dbConnection, templateFilePath, false)
+		},
+	},
+	{
+		path: func(name string) string { return filepath.Join("sing-box", "users", name+".yaml") },
+		generate: func(dbConnection *sql.DB, _ string) error {
+			return GenerateUserClashProfiles(dbConnection, false)
+		},
+	},
+	{
+		path: func(name string) string { return filepath.Join("sing-box", "users", name+".txt") },
+		generate: func(dbConnection *sql.DB, _ string) error {
+			return GenerateUserShareLinks(dbConnection, false)
+		},
+	},
+	{
+		path: func(name string) string { return filepath.Join("sing-box", "sub", name) },
+		generate: func(dbConnection *sql.DB, _ string) error {
+			return GenerateUserConfigFiles(dbConnection, false)
+		},
+	},
+}
+
+// sweeps serializes the sweeps, which regenerate the same files
+var sweeps sync.Mutex
+
+// SweepExpiredUsers deactivates the users whose expiry has passed, then
+// regenerates config.json, reloading sing-box unless noReload is set, and
+// every per-user output that still has a file of one of them. Users an
+// earlier sweep could not drop are retried. It returns the names of the
+// deactivated users.
+func SweepExpiredUsers(dbConnection *sql.DB, templateFilePath string, noReload bool) ([]string, error) {
+	sweeps.Lock()
+	defer sweeps.Unlock()
+
+	expired, err := ExpireUsers(dbConnection, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range expired {
+		log.Printf("User %s has expired and was deactivated", name)
+	}
+	return expired, dropUsers(dbConnection, templateFilePath, noReload)
+}
+
+// dropUsers regenerates config.json, reloading sing-box unless noReload is
+// set, and every per-user output that still has a file of one of the users
+// pending a drop. They stop being pending only once that succeeded, so a
+// failed check or reload is retried by the next sweep. The files it
+// replaces form one generation.
+func dropUsers(dbConnection *sql.DB, templateFilePath string, noReload bool) error {
+	ids, names, err := pendingDrops(dbConnection)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	if err := staging.Begin(); err != nil {
+		return err
+	}
+	err = regenerateWithout(dbConnection, names, templateFilePath, noReload)
+	if endErr := staging.End(); err == nil {
+		err = endErr
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if _, err := dbConnection.Exec("UPDATE users SET drop_pending = FALSE WHERE id = ?", id); err != nil {
+			return fmt.Errorf("error updating user: %v", err)
+		}
+	}
+	return nil
+}
+
+// pendingDrops returns the IDs and names of the users pending a drop
+func pendingDrops(dbConnection *sql.DB) ([]int, []string, error) {
+	rows, err := dbConnection.Query("SELECT id, name FROM users WHERE drop_pending = TRUE ORDER BY id")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying users table: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	var names []string
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, nil, fmt.Errorf("error scanning user row: %v", err)
+		}
+		ids = append(ids, id)
+		names = append(names, name)
+	}
+	return ids, names, rows.Err()
+}
+
+// regenerateWithout is dropUsers within its generation
+func regenerateWithout(dbConnection *sql.DB, names []string, templateFilePath string, noReload bool) error {
+	if err := GenerateServerConfig(dbConnection, jsonhandler.GenerateOptions{}, noReload); err != nil {
+		return err
+	}
+	for _, output := range userOutputs {
+		for _, name := range names {
+			if _, err := os.Stat(output.path(name)); err == nil {
+				if err := output.generate(dbConnection, templateFilePath); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func addDropPending(tx *sql.Tx) error {
+	// Set on users deactivated by a sweep until the files no longer have them
+	if _, err := tx.Exec("ALTER TABLE users ADD COLUMN drop_pending BOOLEAN NOT NULL DEFAULT FALSE"); err != nil {
+		return fmt.Errorf("error adding drop_pending column: %v", err)
+	}
+	return nil
+}
+
+func addUserExpiry(tx *sql.Tx) error {
+	// Unix seconds, NULL for users that never expire
+	if _, err := tx.Exec("ALTER TABLE users ADD COLUMN expires_at INTEGER"); err != nil {
+		return fmt.Errorf("error adding expires_at column: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"winder.website/sbfm/jsonhandler"
+	"winder.website/sbfm/staging"
+)
+
+// GenerateAll writes config.json, reloading sing-box unless noReload is
+// set, and every per-user output as a single generation, which one
+// rollback restores as a whole. A dry run goes through every output and
+// reports all the pending changes.
+func GenerateAll(dbConnection *sql.DB, templateFilePath string, dryRun, noReload bool) error {
+	if err := staging.Begin(); err != nil {
+		return err
+	}
+	err := generateAll(dbConnection, templateFilePath, dryRun, noReload)
+	if endErr := staging.End(); err == nil {
+		err = endErr
+	}
+	return err
+}
+
+func generateAll(dbConnection *sql.DB, templateFilePath string, dryRun, noReload bool) error {
+	// The client exports of every user are built from one read of the inbounds
+	clients, err := readClientInbounds(dbConnection)
+	if err != nil {
+		return err
+	}
+
+	generators := []func() error{
+		func() error {
+			return GenerateServerConfig(dbConnection, jsonhandler.GenerateOptions{DryRun: dryRun}, noReload)
+		},
+		func() error { return generateUserClientFiles(dbConnection, clients, templateFilePath, dryRun) },
+		func() error { return generateUserClashProfiles(dbConnection, clients, dryRun) },
+		func() error { return generateUserShareLinks(dbConnection, clients, dryRun) },
+		func() error { return GenerateUserConfigFiles(dbConnection, dryRun) },
+	}
+
+	pending := 0
+	for _, generate := range generators {
+		err := generate()
+		if dryRun && errors.Is(err, staging.ErrPendingChanges) {
+			pending++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d output(s)", staging.ErrPendingChanges, pending)
+	}
+	return nil
+}
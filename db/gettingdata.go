@@ -0,0 +1,213 @@
+// Package db handles the database
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	//go-sqlite3 is the sql driver for sqlite in go
+	_ "github.com/mattn/go-sqlite3"
+
+	"winder.website/sbfm/jsonhandler"
+)
+
+// InboundRecord is a row of the inbounds table
+type InboundRecord struct {
+	ID                       int
+	Type                     string
+	Tag                      string
+	Listen                   string
+	ListenPort               int
+	Sniff                    bool
+	SniffOverrideDestination bool
+	SniffTimeout             string
+	Detour                   string
+	Method                   string
+	Password                 string
+	Flow                     string
+	TransportID              *int
+	TLSID                    *int
+	RealityID                *int
+	HandshakeID              *int
+}
+
+// TransportRecord is a row of the transports table
+type TransportRecord struct {
+	ID   int
+	Type string
+	Path string
+}
+
+// TLSRecord is a row of the tls table
+type TLSRecord struct {
+	ID              int
+	Enabled         bool
+	ServerName      string
+	MinVersion      string
+	MaxVersion      string
+	CertificatePath string
+	KeyPath         string
+}
+
+// RealityRecord is a row of the reality table with its shared short_ids and
+// server names
+type RealityRecord struct {
+	ID              int
+	Enabled         bool
+	PrivateKey      string
+	PublicKey       string
+	ShortIDs        []string
+	ServerNames     []string
+	PerUserShortIDs bool
+}
+
+// HandshakeRecord is a row of the handshake table
+type HandshakeRecord struct {
+	ID         int
+	Server     string
+	ServerPort int
+}
+
+// notFound turns sql.ErrNoRows into a readable error
+func notFound(err error, what string, id int) error {
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no %s found with ID %d", what, id)
+	}
+	return fmt.Errorf("error querying %s: %v", what, err)
+}
+
+// nullIDPointer converts an optional foreign key into a *int
+func nullIDPointer(id sql.NullInt64) *int {
+	if !id.Valid {
+		return nil
+	}
+	value := int(id.Int64)
+	return &value
+}
+
+// GetInbound fetches an inbound by ID
+func GetInbound(dbConnection *sql.DB, inboundID int) (InboundRecord, error) {
+	record := InboundRecord{ID: inboundID}
+	var transportID, tlsID, realityID, handshakeID sql.NullInt64
+	err := dbConnection.QueryRow(
+		`SELECT type, tag, listen, listen_port, sniff, sniff_override_destination, sniff_timeout, COALESCE(detour, ''), method, password, flow, transport_id, tls_id, reality_id, handshake_id FROM inbounds WHERE id = ?`,
+		inboundID,
+	).Scan(
+		&record.Type, &record.Tag, &record.Listen, &record.ListenPort,
+		&record.Sniff, &record.SniffOverrideDestination, &record.SniffTimeout, &record.Detour,
+		&record.Method, &record.Password, &record.Flow,
+		&transportID, &tlsID, &realityID, &handshakeID,
+	)
+	if err != nil {
+		return InboundRecord{}, notFound(err, "inbound", inboundID)
+	}
+
+	record.TransportID = nullIDPointer(transportID)
+	record.TLSID = nullIDPointer(tlsID)
+	record.RealityID = nullIDPointer(realityID)
+	record.HandshakeID = nullIDPointer(handshakeID)
+	return record, nil
+}
+
+// GetTransport fetches a transport by ID
+func GetTransport(dbConnection *sql.DB, transportID int) (TransportRecord, error) {
+	record := TransportRecord{ID: transportID}
+	err := dbConnection.QueryRow(
+		`SELECT type, path FROM transports WHERE id = ?`,
+		transportID,
+	).Scan(&record.Type, &record.Path)
+	if err != nil {
+		return TransportRecord{}, notFound(err, "transport", transportID)
+	}
+	return record, nil
+}
+
+// GetTLS fetches a TLS configuration by ID
+func GetTLS(dbConnection *sql.DB, tlsID int) (TLSRecord, error) {
+	record := TLSRecord{ID: tlsID}
+	err := dbConnection.QueryRow(
+		`SELECT enabled, server_name, COALESCE(min_version, ''), COALESCE(max_version, ''), COALESCE(certificate_path, ''), COALESCE(key_path, '') FROM tls WHERE id = ?`,
+		tlsID,
+	).Scan(
+		&record.Enabled, &record.ServerName, &record.MinVersion, &record.MaxVersion,
+		&record.CertificatePath, &record.KeyPath,
+	)
+	if err != nil {
+		return TLSRecord{}, notFound(err, "TLS configuration", tlsID)
+	}
+	return record, nil
+}
+
+// GetReality fetches a Reality configuration by ID
+func GetReality(dbConnection *sql.DB, realityID int) (RealityRecord, error) {
+	record := RealityRecord{ID: realityID}
+	err := dbConnection.QueryRow(
+		`SELECT enabled, private_key, COALESCE(public_key, ''), per_user_short_ids FROM reality WHERE id = ?`,
+		realityID,
+	).Scan(&record.Enabled, &record.PrivateKey, &record.PublicKey, &record.PerUserShortIDs)
+	if err != nil {
+		return RealityRecord{}, notFound(err, "Reality configuration", realityID)
+	}
+
+	record.ShortIDs, err = realityList(
+		dbConnection,
+		`SELECT short_id FROM reality_short_ids WHERE reality_id = ? AND user_id IS NULL ORDER BY id`,
+		realityID,
+	)
+	if err != nil {
+		return RealityRecord{}, err
+	}
+
+	record.ServerNames, err = realityList(
+		dbConnection,
+		`SELECT server_name FROM reality_server_names WHERE reality_id = ? ORDER BY id`,
+		realityID,
+	)
+	if err != nil {
+		return RealityRecord{}, err
+	}
+	return record, nil
+}
+
+// GetHandshake fetches a Handshake configuration by ID
+func GetHandshake(dbConnection *sql.DB, handshakeID int) (HandshakeRecord, error) {
+	record := HandshakeRecord{ID: handshakeID}
+	err := dbConnection.QueryRow(
+		`SELECT server, COALESCE(server_port, 0) FROM handshake WHERE id = ?`,
+		handshakeID,
+	).Scan(&record.Server, &record.ServerPort)
+	if err != nil {
+		return HandshakeRecord{}, notFound(err, "Handshake configuration", handshakeID)
+	}
+	return record, nil
+}
+
+// GetOutbound fetches an outbound by ID
+func GetOutbound(dbConnection *sql.DB, outboundID int) (OutboundRecord, error) {
+	record := OutboundRecord{ID: outboundID}
+	var transportID sql.NullInt64
+	var localAddress string
+	err := dbConnection.QueryRow(
+		`
+	SELECT type, tag, server, server_port, version, username, password, method, uuid, flow,
+		tls_enabled, tls_server_name, tls_insecure, utls_fingerprint, reality_public_key, reality_short_id, transport_id,
+		local_address, private_key, peer_public_key, pre_shared_key, reserved, mtu,
+		detour, bind_interface, routing_mark, domain_strategy
+	FROM outbounds WHERE id = ?`,
+		outboundID,
+	).Scan(
+		&record.Type, &record.Tag, &record.Server, &record.ServerPort,
+		&record.Version, &record.Username, &record.Password, &record.Method, &record.UUID, &record.Flow,
+		&record.TLSEnabled, &record.TLSServerName, &record.TLSInsecure, &record.UTLSFingerprint,
+		&record.RealityPublicKey, &record.RealityShortID, &transportID,
+		&localAddress, &record.PrivateKey, &record.PeerPublicKey, &record.PreSharedKey, &record.Reserved, &record.MTU,
+		&record.Detour, &record.BindInterface, &record.RoutingMark, &record.DomainStrategy,
+	)
+	if err != nil {
+		return OutboundRecord{}, notFound(err, "outbound", outboundID)
+	}
+
+	record.TransportID = nullIDPointer(transportID)
+	record.LocalAddress = jsonhandler.SplitList(localAddress)
+	return record, nil
+}
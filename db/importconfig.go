@@ -0,0 +1,799 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"winder.website/sbfm/jsonhandler"
+)
+
+// ImportReport counts what an import added and lists what it could not
+// represent in the database.
+type ImportReport struct {
+	Inbounds   int
+	Outbounds  int
+	Users      int
+	Grants     int
+	TLS        int
+	Transports int
+	Reality    int
+	Handshakes int
+	// Reused counts TLS, transport, Reality and handshake blocks that
+	// matched a row already in the database.
+	Reused  int
+	Skipped []string
+}
+
+func (report *ImportReport) skip(format string, args ...interface{}) {
+	report.Skipped = append(report.Skipped, fmt.Sprintf(format, args...))
+}
+
+// Print writes the counts and every skipped item
+func (report ImportReport) Print() {
+	fmt.Printf(
+		"Imported %d inbound(s), %d outbound(s), %d user(s), %d grant(s), %d TLS, %d transport(s), %d Reality and %d handshake block(s); reused %d existing block(s).\n",
+		report.Inbounds, report.Outbounds, report.Users, report.Grants, report.TLS, report.Transports,
+		report.Reality, report.Handshakes, report.Reused,
+	)
+	if len(report.Skipped) == 0 {
+		return
+	}
+	fmt.Printf("Could not import %d item(s):\n", len(report.Skipped))
+	for _, skipped := range report.Skipped {
+		fmt.Println("  -", skipped)
+	}
+}
+
+// configImport is the state of one ImportConfigFile run
+type configImport struct {
+	tx     *sql.Tx
+	report *ImportReport
+	// userIDs maps the credential that identifies a user, e.g.
+	// "uuid:<uuid>", to its users row, so users are merged across inbounds
+	userIDs map[string]int64
+	// addedUsers maps the names of the users added by this import to their
+	// rows and the credentials they brought, so that a user listed on
+	// inbounds of different types becomes one user
+	addedUsers map[string]*addedUser
+	// expiresAt maps uuids to the expiry of new users, in Unix seconds
+	expiresAt map[string]int64
+	// quotaBytes maps uuids to the monthly quota of new users
+	quotaBytes map[string]int64
+}
+
+// ImportConfigFile reads a sing-box config.json and adds its log block,
+// inbounds and their TLS, Reality, handshake and transport blocks and users,
+// and outbounds to the database. Identical blocks share a row, users are
+// merged by uuid, or by password on the types without one, and everything
+// that has no place in the database is listed in the report.
+// Nothing is imported if any statement fails.
+func ImportConfigFile(dbConnection *sql.DB, filename string) (ImportReport, error) {
+	var report ImportReport
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return report, fmt.Errorf("error reading file: %v", err)
+	}
+
+	var config jsonhandler.Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return report, fmt.Errorf("error parsing config: %v", err)
+	}
+	reportUnknownFields(&report, data)
+
+	err = runImport(dbConnection, &report, func(imp *configImport) error {
+		if err := imp.log(config.Log); err != nil {
+			return err
+		}
+		for _, inbound := range config.Inbounds {
+			// sing-box configs only list the users that may connect
+			for i := range inbound.Users {
+				inbound.Users[i].Active = true
+			}
+			if err := imp.inbound(inbound); err != nil {
+				return err
+			}
+		}
+		for _, outbound := range config.Outbounds {
+			if err := imp.outbound(outbound); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return report, err
+}
+
+// runImport runs fn in a transaction that is only committed if fn succeeds
+func runImport(dbConnection *sql.DB, report *ImportReport, fn func(imp *configImport) error) error {
+	tx, err := dbConnection.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting import: %v", err)
+	}
+	defer tx.Rollback()
+
+	imp := &configImport{
+		tx:         tx,
+		report:     report,
+		userIDs:    make(map[string]int64),
+		addedUsers: make(map[string]*addedUser),
+		expiresAt:  make(map[string]int64),
+		quotaBytes: make(map[string]int64),
+	}
+	if err := fn(imp); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing import: %v", err)
+	}
+
+	// Per-user short_ids for the Reality profiles that use them
+	_, err = AssignUserShortIDs(dbConnection)
+	return err
+}
+
+// log stores the log block, replacing the current log settings
+func (imp *configImport) log(block *jsonhandler.Log) error {
+	if block == nil {
+		return nil
+	}
+
+	settings := LogSettings{
+		Disabled:  block.Disabled,
+		Level:     block.Level,
+		Output:    block.Output,
+		Timestamp: block.Timestamp,
+	}
+	// sing-box logs at info when the level is left out
+	if settings.Level == "" {
+		settings.Level = DefaultLogSettings.Level
+	}
+	if err := ValidateLogSettings(settings); err != nil {
+		return fmt.Errorf("error importing log block: %v", err)
+	}
+	return upsertLogSettings(imp.tx, settings)
+}
+
+// inbound stores one inbound with its blocks and users
+func (imp *configImport) inbound(inbound jsonhandler.Inbound) error {
+	tag := inbound.Tag
+	if tag == "" {
+		imp.report.skip("%s inbound on port %d: no tag", inbound.Type, inbound.ListenPort)
+		return nil
+	}
+
+	var exists int
+	err := imp.tx.QueryRow("SELECT COUNT(*) FROM inbounds WHERE tag = ?", tag).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error looking up inbound %s: %v", tag, err)
+	}
+	if exists > 0 {
+		imp.report.skip("inbound %s: an inbound with this tag already exists", tag)
+		return nil
+	}
+
+	transportID, err := imp.transport(inbound.Transport)
+	if err != nil {
+		return err
+	}
+	tlsID, err := imp.tls(inbound.TLS)
+	if err != nil {
+		return err
+	}
+	realityID, handshakeID, err := imp.reality(tag, inbound.TLS.Reality)
+	if err != nil {
+		return err
+	}
+
+	listen := inbound.Listen
+	if listen == "" {
+		listen = "::"
+	}
+
+	// Single-user shadowsocks inbounds keep their password in the inbound
+	// block, which only the 2022 methods use for the server key
+	password := inbound.Password
+	if inbound.Type == "shadowsocks" && !jsonhandler.IsShadowsocks2022(inbound.Method) && password != "" {
+		imp.report.skip("inbound %s: single-user %s password", tag, inbound.Method)
+		password = ""
+	}
+	password, err = inboundPassword(inbound.Type, inbound.Method, password)
+	if err != nil {
+		imp.report.skip("inbound %s: %v", tag, err)
+		return nil
+	}
+	flow := imp.flow(inbound)
+
+	result, err := imp.tx.Exec(
+		`
+	INSERT INTO inbounds (
+		type, tag, listen, listen_port, tcp_fast_open, tcp_multi_path, udp_fragment,
+		udp_timeout, detour, sniff, sniff_override_destination, sniff_timeout,
+		domain_strategy, udp_disable_domain_unmapping, method, password, flow,
+		transport_id, tls_id, reality_id, handshake_id
+	)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		inbound.Type, tag, listen, inbound.ListenPort,
+		inbound.TCPFastOpen, inbound.TCPMultiPath, inbound.UDPFragment,
+		inbound.UDPTimeout, inbound.Detour,
+		inbound.Sniff, inbound.SniffOverrideDestination, inbound.SniffTimeout,
+		inbound.DomainStrategy, inbound.UDPDisableDomainUnmapping, inbound.Method, password, flow,
+		transportID, tlsID, realityID, handshakeID,
+	)
+	if err != nil {
+		return fmt.Errorf("error importing inbound %s: %v", tag, err)
+	}
+	inboundID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error importing inbound %s: %v", tag, err)
+	}
+	imp.report.Inbounds++
+
+	for _, user := range inbound.Users {
+		if err := imp.user(inbound, inboundID, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flow returns the flow of an inbound, which sing-box keeps on each user.
+// sbfm gives every user of an inbound the same flow: the one of the first
+// user that has one.
+func (imp *configImport) flow(inbound jsonhandler.Inbound) string {
+	var flow string
+	for _, user := range inbound.Users {
+		if flow == "" {
+			flow = user.Flow
+		}
+		if user.Flow != flow {
+			imp.report.skip("inbound %s: user %s: flow %q, using %q", inbound.Tag, user.Name, user.Flow, flow)
+		}
+	}
+	if err := checkFlow(inbound.Type, flow, inbound.Transport.Type); err != nil {
+		imp.report.skip("inbound %s: %v", inbound.Tag, err)
+		return ""
+	}
+	return flow
+}
+
+// outbound stores one outbound. Outbounds are imported after the inbounds,
+// so an inbound may detour through an outbound that is not stored yet.
+func (imp *configImport) outbound(outbound jsonhandler.Outbound) error {
+	tag := outbound.Tag
+	if tag == "" {
+		tag = outbound.Type
+	}
+	if oneOf(OutboundTypes...)(outbound.Type) != nil {
+		imp.report.skip("outbound %s: %s outbounds are not supported", tag, outbound.Type)
+		return nil
+	}
+
+	var exists int
+	err := imp.tx.QueryRow("SELECT COUNT(*) FROM outbounds WHERE tag = ?", tag).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error looking up outbound %s: %v", tag, err)
+	}
+	if exists > 0 {
+		imp.report.skip("outbound %s: an outbound with this tag already exists", tag)
+		return nil
+	}
+
+	record := OutboundRecord{
+		Type:           outbound.Type,
+		Tag:            tag,
+		Server:         outbound.Server,
+		ServerPort:     outbound.ServerPort,
+		Version:        outbound.Version,
+		Username:       outbound.Username,
+		Password:       outbound.Password,
+		Method:         outbound.Method,
+		UUID:           outbound.UUID,
+		Flow:           outbound.Flow,
+		LocalAddress:   outbound.LocalAddress,
+		PrivateKey:     outbound.PrivateKey,
+		PeerPublicKey:  outbound.PeerPublicKey,
+		PreSharedKey:   outbound.PreSharedKey,
+		MTU:            outbound.MTU,
+		Detour:         outbound.Detour,
+		BindInterface:  outbound.BindInterface,
+		RoutingMark:    outbound.RoutingMark,
+		DomainStrategy: outbound.DomainStrategy,
+	}
+	if len(outbound.Reserved) > 0 {
+		reserved := make([]string, len(outbound.Reserved))
+		for i, value := range outbound.Reserved {
+			reserved[i] = fmt.Sprint(value)
+		}
+		record.Reserved = strings.Join(reserved, ",")
+	}
+	if tls := outbound.TLS; tls != nil {
+		record.TLSEnabled = tls.Enabled
+		record.TLSServerName = tls.ServerName
+		record.TLSInsecure = tls.Insecure
+		if tls.UTLS != nil && tls.UTLS.Enabled {
+			record.UTLSFingerprint = tls.UTLS.Fingerprint
+		}
+		if tls.Reality != nil && tls.Reality.Enabled {
+			record.RealityPublicKey = tls.Reality.PublicKey
+			record.RealityShortID = tls.Reality.ShortID
+		}
+	}
+	if outbound.Transport != nil {
+		record.TransportID, err = imp.transport(*outbound.Transport)
+		if err != nil {
+			return err
+		}
+	}
+	if err := ValidateOutbound(record); err != nil {
+		imp.report.skip("outbound %s: %v", tag, err)
+		return nil
+	}
+
+	_, err = imp.tx.Exec(
+		`
+	INSERT INTO outbounds (
+		type, tag, server, server_port, version, username, password, method, uuid, flow,
+		tls_enabled, tls_server_name, tls_insecure, utls_fingerprint, reality_public_key, reality_short_id, transport_id,
+		local_address, private_key, peer_public_key, pre_shared_key, reserved, mtu,
+		detour, bind_interface, routing_mark, domain_strategy
+	)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		outboundValues(record)...,
+	)
+	if err != nil {
+		return fmt.Errorf("error importing outbound %s: %v", tag, err)
+	}
+	imp.report.Outbounds++
+	return nil
+}
+
+// userCredentials are the credentials an imported user brings; the
+// missing ones are generated
+type userCredentials struct {
+	uuid     string
+	password string
+	ssKey    string
+}
+
+// importedCredentials returns the credentials user has on inbound and the
+// users column and value that identify it: the uuid on the types that have
+// one, otherwise the password, or the key with the shadowsocks 2022 methods
+func importedCredentials(inbound jsonhandler.Inbound, user jsonhandler.User) (userCredentials, string, string) {
+	switch {
+	case inbound.Type == "shadowsocks" && jsonhandler.IsShadowsocks2022(inbound.Method):
+		return userCredentials{ssKey: user.Password}, "ss_key", user.Password
+	case inbound.Type == "shadowsocks", inbound.Type == "trojan", inbound.Type == "hysteria2":
+		return userCredentials{password: user.Password}, "password", user.Password
+	case inbound.Type == "tuic":
+		return userCredentials{uuid: user.UUID, password: user.Password}, "uuid", user.UUID
+	}
+	return userCredentials{uuid: user.UUID}, "uuid", user.UUID
+}
+
+// user stores a user, or finds the one with the same credentials, and
+// grants it the inbound
+func (imp *configImport) user(inbound jsonhandler.Inbound, inboundID int64, user jsonhandler.User) error {
+	tag := inbound.Tag
+	credentials, column, value := importedCredentials(inbound, user)
+	if column == "uuid" {
+		if _, err := uuid.Parse(value); err != nil {
+			imp.report.skip("inbound %s: user %q has no valid uuid", tag, user.Name)
+			return nil
+		}
+	} else if value == "" {
+		imp.report.skip("inbound %s: user %q has no password", tag, user.Name)
+		return nil
+	}
+
+	key := column + ":" + value
+	userID, ok := imp.userIDs[key]
+	if !ok {
+		err := imp.tx.QueryRow("SELECT id FROM users WHERE "+column+" = ?", value).Scan(&userID)
+		if err == sql.ErrNoRows {
+			if added := imp.addedUsers[user.Name]; user.Name != "" && added != nil && added.merge(credentials) {
+				userID = added.id
+				err = imp.setCredentials(userID, credentials)
+			} else {
+				userID, err = imp.addUser(user, credentials)
+			}
+		} else if err == nil {
+			// tuic users bring the password their clients use
+			err = imp.setCredentials(userID, credentials)
+		}
+		if err != nil {
+			return fmt.Errorf("error importing user %q: %v", user.Name, err)
+		}
+		imp.userIDs[key] = userID
+	}
+
+	_, err := imp.tx.Exec(
+		"INSERT OR IGNORE INTO user_inbounds (user_id, inbound_id) VALUES (?, ?)",
+		userID, inboundID,
+	)
+	if err != nil {
+		return fmt.Errorf("error granting inbound %s: %v", tag, err)
+	}
+	imp.report.Grants++
+	return nil
+}
+
+// addedUser is a user added by the import with the credentials it brought
+type addedUser struct {
+	id          int64
+	credentials userCredentials
+}
+
+// merge adds credentials to the ones user brought and reports whether they
+// belong to the same user, that is whether none of them differ
+func (user *addedUser) merge(credentials userCredentials) bool {
+	merged := user.credentials
+	for _, pair := range []struct{ have, brought *string }{
+		{&merged.uuid, &credentials.uuid},
+		{&merged.password, &credentials.password},
+		{&merged.ssKey, &credentials.ssKey},
+	} {
+		if *pair.brought == "" {
+			continue
+		}
+		if *pair.have != "" && *pair.have != *pair.brought {
+			return false
+		}
+		*pair.have = *pair.brought
+	}
+	user.credentials = merged
+	return true
+}
+
+// setCredentials stores the credentials a user brought
+func (imp *configImport) setCredentials(userID int64, credentials userCredentials) error {
+	for _, column := range []struct{ name, value string }{
+		{"uuid", credentials.uuid},
+		{"password", credentials.password},
+		{"ss_key", credentials.ssKey},
+	} {
+		if column.value == "" {
+			continue
+		}
+		if _, err := imp.tx.Exec("UPDATE users SET "+column.name+" = ? WHERE id = ?", column.value, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addUser inserts a user with a fresh sub token and generates the
+// credentials it did not bring
+func (imp *configImport) addUser(user jsonhandler.User, credentials userCredentials) (int64, error) {
+	generated := credentials
+	password, ssKey, err := newUserCredentials()
+	if err != nil {
+		return 0, err
+	}
+	if generated.uuid == "" {
+		generated.uuid = uuid.New().String()
+	}
+	if generated.password == "" {
+		generated.password = password
+	}
+	if generated.ssKey == "" {
+		generated.ssKey = ssKey
+	}
+
+	name, err := imp.freeName(user.Name, generated.uuid)
+	if err != nil {
+		return 0, err
+	}
+
+	sub, err := generateRandomString(50)
+	if err != nil {
+		return 0, fmt.Errorf("error generating sub token: %v", err)
+	}
+
+	var expiresAt interface{}
+	if expiry, ok := imp.expiresAt[user.UUID]; ok {
+		expiresAt = expiry
+	}
+
+	// Imported quotas start over every month from the day of the import
+	var quotaBytes interface{}
+	quotaPeriod, quotaAnchor := QuotaMonthly, ""
+	if quota, ok := imp.quotaBytes[user.UUID]; ok {
+		quotaBytes = quota
+		quotaPeriod, quotaAnchor = QuotaRolling, time.Now().Format(trafficDayFormat)
+	}
+
+	result, err := imp.tx.Exec(
+		`INSERT INTO users (name, uuid, password, ss_key, sub, active, expires_at, quota_bytes, quota_period, quota_anchor)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		name, generated.uuid, generated.password, generated.ssKey, sub, user.Active,
+		expiresAt, quotaBytes, quotaPeriod, quotaAnchor,
+	)
+	if err != nil {
+		return 0, err
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	imp.report.Users++
+	if user.Name != "" {
+		imp.addedUsers[user.Name] = &addedUser{id: userID, credentials: credentials}
+	}
+	return userID, nil
+}
+
+// freeName returns the name a new user gets: its own if no user has it yet,
+// otherwise the name with the start of its uuid appended. Users without a
+// name or with one that cannot name their files are named after their uuid.
+func (imp *configImport) freeName(name, uuid string) (string, error) {
+	if name == "" {
+		return "user-" + uuid[:8], nil
+	}
+	if err := validUserName(name); err != nil {
+		imp.report.skip("user %s: %v, imported as user-%s", name, err, uuid[:8])
+		return "user-" + uuid[:8], nil
+	}
+	taken, err := userNameTaken(imp.tx, name)
+	if err != nil || !taken {
+		return name, err
+	}
+
+	free := name + "-" + uuid[:8]
+	taken, err = userNameTaken(imp.tx, free)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", fmt.Errorf("users named %s and %s already exist", name, free)
+	}
+	imp.report.skip("user %s: the name is taken, imported as %s", name, free)
+	return free, nil
+}
+
+// findOrInsert returns the id of the row matching query, inserting it with
+// insert if there is none. counter is bumped for new rows.
+func (imp *configImport) findOrInsert(counter *int, query, insert string, args ...interface{}) (*int, error) {
+	var id int
+	err := imp.tx.QueryRow(query, args...).Scan(&id)
+	if err == nil {
+		imp.report.Reused++
+		return &id, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	result, err := imp.tx.Exec(insert, args...)
+	if err != nil {
+		return nil, err
+	}
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	*counter++
+	id = int(newID)
+	return &id, nil
+}
+
+// transport stores a transport block. gRPC keeps its service name in the
+// path column.
+func (imp *configImport) transport(transport jsonhandler.Transport) (*int, error) {
+	if transport.Type == "" {
+		return nil, nil
+	}
+
+	path := transport.Path
+	if transport.Type == "grpc" {
+		path = transport.ServiceName
+	}
+
+	id, err := imp.findOrInsert(
+		&imp.report.Transports,
+		"SELECT id FROM transports WHERE type = ? AND path = ? LIMIT 1",
+		"INSERT INTO transports (type, path) VALUES (?, ?)",
+		transport.Type, path,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error importing transport: %v", err)
+	}
+	return id, nil
+}
+
+// tls stores a TLS block. Reality inbounds use it for enabled and server_name.
+func (imp *configImport) tls(tls jsonhandler.TLS) (*int, error) {
+	if !tls.Enabled && !tls.Reality.Enabled {
+		return nil, nil
+	}
+
+	id, err := imp.findOrInsert(
+		&imp.report.TLS,
+		`SELECT id FROM tls WHERE enabled = ? AND server_name = ?
+		AND COALESCE(min_version, '') = ? AND COALESCE(max_version, '') = ?
+		AND COALESCE(certificate_path, '') = ? AND COALESCE(key_path, '') = ? LIMIT 1`,
+		`INSERT INTO tls (enabled, server_name, min_version, max_version, certificate_path, key_path)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		tls.Enabled, tls.ServerName, tls.MinVersion, tls.MaxVersion, tls.CertificatePath, tls.KeyPath,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error importing tls: %v", err)
+	}
+	return id, nil
+}
+
+// reality stores a Reality block and its handshake. Reality blocks with the
+// same private key are the same profile, so their short_ids are merged.
+func (imp *configImport) reality(tag string, reality jsonhandler.Reality) (*int, *int, error) {
+	if !reality.Enabled && reality.PrivateKey == "" {
+		return nil, nil, nil
+	}
+
+	var handshakeID *int
+	if reality.Handshake.Server != "" {
+		var err error
+		handshakeID, err = imp.findOrInsert(
+			&imp.report.Handshakes,
+			"SELECT id FROM handshake WHERE server = ? AND CAST(server_port AS INTEGER) = ? LIMIT 1",
+			"INSERT INTO handshake (server, server_port) VALUES (?, ?)",
+			reality.Handshake.Server, reality.Handshake.ServerPort,
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error importing handshake: %v", err)
+		}
+	}
+
+	publicKey, err := RealityPublicKey(reality.PrivateKey)
+	if err != nil {
+		imp.report.skip("inbound %s: reality block: %v", tag, err)
+		return nil, handshakeID, nil
+	}
+
+	var realityID int
+	err = imp.tx.QueryRow("SELECT id FROM reality WHERE private_key = ? LIMIT 1", reality.PrivateKey).Scan(&realityID)
+	switch {
+	case err == nil:
+		imp.report.Reused++
+	case err == sql.ErrNoRows:
+		result, err := imp.tx.Exec(
+			"INSERT INTO reality (enabled, private_key, public_key) VALUES (?, ?, ?)",
+			reality.Enabled, reality.PrivateKey, publicKey,
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error importing reality: %v", err)
+		}
+		newID, err := result.LastInsertId()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error importing reality: %v", err)
+		}
+		realityID = int(newID)
+		imp.report.Reality++
+	default:
+		return nil, nil, fmt.Errorf("error importing reality: %v", err)
+	}
+
+	if reality.Enabled && len(reality.ShortID) == 0 {
+		imp.report.skip("inbound %s: reality has no short_id, add one before generating", tag)
+	}
+	for _, shortID := range reality.ShortID {
+		if err := ValidateShortID(shortID); err != nil {
+			imp.report.skip("inbound %s: %v", tag, err)
+			continue
+		}
+		_, err := imp.tx.Exec(
+			"INSERT OR IGNORE INTO reality_short_ids (reality_id, short_id) VALUES (?, ?)",
+			realityID, strings.ToLower(shortID),
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error importing short_id %s: %v", shortID, err)
+		}
+	}
+	for _, serverName := range reality.ServerNames {
+		_, err := imp.tx.Exec(
+			"INSERT OR IGNORE INTO reality_server_names (reality_id, server_name) VALUES (?, ?)",
+			realityID, serverName,
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error importing server name %s: %v", serverName, err)
+		}
+	}
+	return &realityID, handshakeID, nil
+}
+
+// importedSections are the top level blocks ImportConfigFile reads
+var importedSections = map[string]bool{"log": true, "inbounds": true, "outbounds": true}
+
+// reportUnknownFields lists every top level block and inbound and outbound
+// field of the raw config that has no counterpart in the jsonhandler structs
+func reportUnknownFields(report *ImportReport, data []byte) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return
+	}
+
+	for _, section := range sortedRawKeys(raw) {
+		if !importedSections[section] {
+			report.skip("%s block", section)
+		}
+	}
+
+	blocks := map[string]reflect.Type{
+		"inbound":  reflect.TypeOf(jsonhandler.Inbound{}),
+		"outbound": reflect.TypeOf(jsonhandler.Outbound{}),
+	}
+	for _, kind := range []string{"inbound", "outbound"} {
+		var items []map[string]json.RawMessage
+		if err := json.Unmarshal(raw[kind+"s"], &items); err != nil {
+			continue
+		}
+		for _, item := range items {
+			var tag string
+			json.Unmarshal(item["tag"], &tag)
+			unknownFields(report, kind+" "+tag, "", item, blocks[kind])
+		}
+	}
+}
+
+// unknownFields reports the keys of raw that are not json fields of t,
+// descending into nested objects. name is the block the keys belong to,
+// e.g. "inbound vless-in".
+func unknownFields(report *ImportReport, name, prefix string, raw map[string]json.RawMessage, t reflect.Type) {
+	fields := jsonFields(t)
+	for _, key := range sortedRawKeys(raw) {
+		field, ok := fields[key]
+		if !ok {
+			report.skip("%s: field %s%s", name, prefix, key)
+			continue
+		}
+		if field.Kind() == reflect.Ptr {
+			field = field.Elem()
+		}
+
+		if field.Kind() == reflect.Slice && field.Elem().Kind() == reflect.Struct {
+			var items []map[string]json.RawMessage
+			if json.Unmarshal(raw[key], &items) == nil {
+				for _, item := range items {
+					unknownFields(report, name, prefix+key+"[].", item, field.Elem())
+				}
+			}
+		}
+		if field.Kind() == reflect.Struct {
+			var nested map[string]json.RawMessage
+			if json.Unmarshal(raw[key], &nested) == nil {
+				unknownFields(report, name, prefix+key+".", nested, field)
+			}
+		}
+	}
+}
+
+// jsonFields maps the json names of the fields of t to their types. Fields
+// tagged "-" are not part of the config.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func sortedRawKeys(raw map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
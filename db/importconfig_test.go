@@ -0,0 +1,91 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportConfigFile(t *testing.T) {
+	dbConnection := openTestDB(t)
+	report, err := ImportConfigFile(dbConnection, filepath.Join("testdata", "config.json"))
+	if err != nil {
+		t.Fatalf("ImportConfigFile: %v", err)
+	}
+
+	// The three inbounds share one TLS block and the two ws inbounds one
+	// transport, so two TLS blocks and one transport are reused
+	counts := report
+	counts.Skipped = nil
+	wantCounts := ImportReport{Inbounds: 3, Outbounds: 1, Users: 3, Grants: 5, TLS: 1, Transports: 1, Reused: 3}
+	if !reflect.DeepEqual(counts, wantCounts) {
+		t.Errorf("report = %+v, want %+v", counts, wantCounts)
+	}
+	wantSkipped := []string{
+		"route block",
+		"inbound vless-ws: field multiplex",
+		"inbound vless-ws: field tls.acme",
+		"inbound vmess-ws: field users[].alterId",
+		"user bob: the name is taken, imported as bob-7f1c2d3e",
+		"outbound select: selector outbounds are not supported",
+	}
+	if !reflect.DeepEqual(report.Skipped, wantSkipped) {
+		t.Errorf("skipped =\n%s\nwant\n%s", strings.Join(report.Skipped, "\n"), strings.Join(wantSkipped, "\n"))
+	}
+
+	var blocks []string
+	rows, err := dbConnection.Query("SELECT tag, COALESCE(tls_id, 0), COALESCE(transport_id, 0) FROM inbounds ORDER BY id")
+	if err != nil {
+		t.Fatalf("error reading inbounds: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag string
+		var tlsID, transportID int
+		if err := rows.Scan(&tag, &tlsID, &transportID); err != nil {
+			t.Fatalf("error scanning inbound: %v", err)
+		}
+		blocks = append(blocks, fmt.Sprintf("%s %d %d", tag, tlsID, transportID))
+	}
+	if want := []string{"vless-ws 1 1", "vmess-ws 1 1", "trojan-in 1 0"}; !reflect.DeepEqual(blocks, want) {
+		t.Errorf("inbounds with tls and transport ids = %q, want %q", blocks, want)
+	}
+
+	// alice is one user across the uuid inbounds and the trojan inbound,
+	// where she brings her password
+	alice, err := GetUser(dbConnection, 1)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if alice.Name != "alice" || alice.UUID != "0b6ea4a8-3c42-4c54-9d5c-1d2f8d5a6a01" || alice.Password != "alice-password" {
+		t.Errorf("alice = %+v, want her uuid and trojan password", alice)
+	}
+
+	grants := make(map[string][]string)
+	rows, err = dbConnection.Query(`
+	SELECT users.name, inbounds.tag FROM user_inbounds
+	JOIN users ON users.id = user_inbounds.user_id
+	JOIN inbounds ON inbounds.id = user_inbounds.inbound_id
+	ORDER BY users.id, inbounds.id`)
+	if err != nil {
+		t.Fatalf("error reading grants: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, tag string
+		if err := rows.Scan(&name, &tag); err != nil {
+			t.Fatalf("error scanning grant: %v", err)
+		}
+		grants[name] = append(grants[name], tag)
+	}
+	wantGrants := map[string][]string{
+		"alice":        {"vless-ws", "vmess-ws", "trojan-in"},
+		"bob":          {"vless-ws"},
+		"bob-7f1c2d3e": {"vmess-ws"},
+	}
+	if !reflect.DeepEqual(grants, wantGrants) {
+		t.Errorf("grants = %v, want %v", grants, wantGrants)
+	}
+}
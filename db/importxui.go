@@ -0,0 +1,334 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"winder.website/sbfm/jsonhandler"
+)
+
+// xuiInbound is a row of the inbounds table of an x-ui or 3x-ui database
+type xuiInbound struct {
+	remark         string
+	enable         bool
+	listen         string
+	port           int
+	protocol       string
+	settings       string
+	streamSettings string
+	tag            string
+	sniffing       string
+}
+
+// xuiSettings is the settings column of a VLESS inbound
+type xuiSettings struct {
+	Clients []xuiClient `json:"clients"`
+}
+
+// xuiClient is a VLESS client of an x-ui inbound. The original x-ui has no
+// enable key, its clients are all enabled.
+type xuiClient struct {
+	ID         string `json:"id"`
+	Email      string `json:"email"`
+	Enable     *bool  `json:"enable"`
+	Flow       string `json:"flow"`
+	ExpiryTime int64  `json:"expiryTime"`
+	TotalGB    int64  `json:"totalGB"`
+}
+
+// xuiStreamSettings is the stream_settings column of an inbound
+type xuiStreamSettings struct {
+	Network     string `json:"network"`
+	Security    string `json:"security"`
+	TLSSettings struct {
+		ServerName   string `json:"serverName"`
+		MinVersion   string `json:"minVersion"`
+		MaxVersion   string `json:"maxVersion"`
+		Certificates []struct {
+			CertificateFile string   `json:"certificateFile"`
+			KeyFile         string   `json:"keyFile"`
+			Certificate     []string `json:"certificate"`
+		} `json:"certificates"`
+	} `json:"tlsSettings"`
+	RealitySettings struct {
+		Dest        string   `json:"dest"`
+		ServerNames []string `json:"serverNames"`
+		PrivateKey  string   `json:"privateKey"`
+		ShortIDs    []string `json:"shortIds"`
+	} `json:"realitySettings"`
+	WSSettings struct {
+		Path string `json:"path"`
+	} `json:"wsSettings"`
+	GRPCSettings struct {
+		ServiceName string `json:"serviceName"`
+	} `json:"grpcSettings"`
+	HTTPUpgradeSettings struct {
+		Path string `json:"path"`
+	} `json:"httpupgradeSettings"`
+}
+
+// xuiSniffing is the sniffing column of an inbound
+type xuiSniffing struct {
+	Enabled bool `json:"enabled"`
+}
+
+// ImportXUIDatabase reads the inbounds and clients of an x-ui or 3x-ui
+// database and adds them the way ImportConfigFile adds a sing-box config.
+// VLESS inbounds with tcp, ws, grpc or httpupgrade streams and tls or
+// reality security are mapped, everything else is listed in the report.
+// New users keep the expiry and traffic limit of their x-ui client, the
+// limit as a quota that starts over every month from the import.
+func ImportXUIDatabase(dbConnection *sql.DB, filename string) (ImportReport, error) {
+	var report ImportReport
+
+	if _, err := os.Stat(filename); err != nil {
+		return report, fmt.Errorf("error opening x-ui database: %v", err)
+	}
+	xuiDB, err := sql.Open("sqlite3", "file:"+filename+"?mode=ro")
+	if err != nil {
+		return report, fmt.Errorf("error opening x-ui database: %v", err)
+	}
+	defer xuiDB.Close()
+
+	inbounds, err := readXUIInbounds(xuiDB)
+	if err != nil {
+		return report, err
+	}
+	disabledClients, err := readXUIDisabledClients(xuiDB)
+	if err != nil {
+		return report, err
+	}
+
+	err = runImport(dbConnection, &report, func(imp *configImport) error {
+		for _, row := range inbounds {
+			inbound, ok := mapXUIInbound(&report, row, disabledClients, imp.expiresAt, imp.quotaBytes)
+			if !ok {
+				continue
+			}
+			if err := imp.inbound(inbound); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return report, err
+}
+
+// readXUIInbounds reads every row of the x-ui inbounds table
+func readXUIInbounds(xuiDB *sql.DB) ([]xuiInbound, error) {
+	rows, err := xuiDB.Query(`
+		SELECT COALESCE(remark, ''), enable, COALESCE(listen, ''), port, protocol,
+			COALESCE(settings, ''), COALESCE(stream_settings, ''), COALESCE(tag, ''),
+			COALESCE(sniffing, '')
+		FROM inbounds ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying x-ui inbounds: %v", err)
+	}
+	defer rows.Close()
+
+	var inbounds []xuiInbound
+	for rows.Next() {
+		var row xuiInbound
+		err := rows.Scan(
+			&row.remark, &row.enable, &row.listen, &row.port, &row.protocol,
+			&row.settings, &row.streamSettings, &row.tag, &row.sniffing,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning x-ui inbound: %v", err)
+		}
+		inbounds = append(inbounds, row)
+	}
+	return inbounds, rows.Err()
+}
+
+// readXUIDisabledClients returns the emails of the clients that x-ui
+// disabled in client_traffics, e.g. because they ran out of traffic.
+// Databases without that table have none.
+func readXUIDisabledClients(xuiDB *sql.DB) (map[string]bool, error) {
+	disabled := make(map[string]bool)
+
+	var tables int
+	err := xuiDB.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'client_traffics'",
+	).Scan(&tables)
+	if err != nil || tables == 0 {
+		return disabled, err
+	}
+
+	rows, err := xuiDB.Query("SELECT email FROM client_traffics WHERE enable = FALSE")
+	if err != nil {
+		return nil, fmt.Errorf("error querying x-ui client_traffics: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, fmt.Errorf("error scanning x-ui client traffic: %v", err)
+		}
+		disabled[email] = true
+	}
+	return disabled, rows.Err()
+}
+
+// mapXUIInbound turns an x-ui inbound into a sing-box inbound. It reports
+// and returns false for inbounds that cannot be mapped at all. The expiry and
+// the traffic limit of each client with one are added to expiresAt and
+// quotaBytes by uuid.
+func mapXUIInbound(report *ImportReport, row xuiInbound, disabledClients map[string]bool, expiresAt, quotaBytes map[string]int64) (jsonhandler.Inbound, bool) {
+	tag := row.tag
+	if tag == "" {
+		tag = fmt.Sprintf("inbound-%d", row.port)
+	}
+	name := tag
+	if row.remark != "" {
+		name = fmt.Sprintf("%s (%s)", tag, row.remark)
+	}
+
+	if row.protocol != "vless" {
+		report.skip("inbound %s: %s inbounds are not supported", name, row.protocol)
+		return jsonhandler.Inbound{}, false
+	}
+
+	var settings xuiSettings
+	var stream xuiStreamSettings
+	if err := json.Unmarshal([]byte(row.settings), &settings); err != nil {
+		report.skip("inbound %s: invalid settings: %v", name, err)
+		return jsonhandler.Inbound{}, false
+	}
+	if row.streamSettings != "" {
+		if err := json.Unmarshal([]byte(row.streamSettings), &stream); err != nil {
+			report.skip("inbound %s: invalid stream_settings: %v", name, err)
+			return jsonhandler.Inbound{}, false
+		}
+	}
+
+	inbound := jsonhandler.Inbound{
+		Type:       "vless",
+		Tag:        tag,
+		Listen:     row.listen,
+		ListenPort: row.port,
+	}
+	if !row.enable {
+		report.skip("inbound %s: disabled in x-ui, imported as enabled", name)
+	}
+
+	var sniffing xuiSniffing
+	if row.sniffing != "" && json.Unmarshal([]byte(row.sniffing), &sniffing) == nil {
+		inbound.Sniff = sniffing.Enabled
+	}
+
+	switch stream.Network {
+	case "", "tcp":
+	case "ws":
+		inbound.Transport = jsonhandler.Transport{Type: "ws", Path: stream.WSSettings.Path}
+	case "grpc":
+		inbound.Transport = jsonhandler.Transport{Type: "grpc", ServiceName: stream.GRPCSettings.ServiceName}
+	case "httpupgrade":
+		inbound.Transport = jsonhandler.Transport{Type: "httpupgrade", Path: stream.HTTPUpgradeSettings.Path}
+	default:
+		report.skip("inbound %s: %s streams are not supported", name, stream.Network)
+		return jsonhandler.Inbound{}, false
+	}
+
+	switch stream.Security {
+	case "", "none":
+	case "tls":
+		tls := stream.TLSSettings
+		inbound.TLS = jsonhandler.TLS{
+			Enabled:    true,
+			ServerName: tls.ServerName,
+			MinVersion: tls.MinVersion,
+			MaxVersion: tls.MaxVersion,
+		}
+		if len(tls.Certificates) > 0 {
+			inbound.TLS.CertificatePath = tls.Certificates[0].CertificateFile
+			inbound.TLS.KeyPath = tls.Certificates[0].KeyFile
+			if inbound.TLS.CertificatePath == "" && len(tls.Certificates[0].Certificate) > 0 {
+				report.skip("inbound %s: inline TLS certificates, only certificate files are supported", name)
+			}
+		}
+		if len(tls.Certificates) > 1 {
+			report.skip("inbound %s: %d extra TLS certificates", name, len(tls.Certificates)-1)
+		}
+	case "reality":
+		reality := stream.RealitySettings
+		inbound.TLS = jsonhandler.TLS{
+			Enabled: true,
+			Reality: jsonhandler.Reality{
+				Enabled:     true,
+				PrivateKey:  reality.PrivateKey,
+				ShortID:     reality.ShortIDs,
+				ServerNames: reality.ServerNames,
+			},
+		}
+		if len(reality.ServerNames) > 0 {
+			inbound.TLS.ServerName = reality.ServerNames[0]
+		}
+		handshake, err := parseXUIDest(reality.Dest)
+		if err != nil {
+			report.skip("inbound %s: reality dest %q: %v", name, reality.Dest, err)
+		}
+		inbound.TLS.Reality.Handshake = handshake
+	default:
+		report.skip("inbound %s: %s security is not supported", name, stream.Security)
+		return jsonhandler.Inbound{}, false
+	}
+
+	for _, client := range settings.Clients {
+		user := jsonhandler.User{
+			Name:   client.Email,
+			UUID:   client.ID,
+			Flow:   client.Flow,
+			Active: (client.Enable == nil || *client.Enable) && !disabledClients[client.Email],
+		}
+		if expiry, ok := xuiExpiry(client.ExpiryTime, time.Now()); ok {
+			expiresAt[client.ID] = expiry.Unix()
+		}
+		// Despite its name totalGB is in bytes
+		if client.TotalGB > 0 {
+			quotaBytes[client.ID] = client.TotalGB
+		}
+		inbound.Users = append(inbound.Users, user)
+	}
+
+	return inbound, true
+}
+
+// xuiExpiry converts the expiryTime of an x-ui client, in Unix
+// milliseconds. Zero means the client never expires. 3x-ui stores a negative
+// duration for clients whose time starts on first use; their time starts at
+// the import instead.
+func xuiExpiry(expiryTime int64, now time.Time) (time.Time, bool) {
+	switch {
+	case expiryTime > 0:
+		return time.UnixMilli(expiryTime), true
+	case expiryTime < 0:
+		return now.Add(time.Duration(-expiryTime) * time.Millisecond), true
+	}
+	return time.Time{}, false
+}
+
+// parseXUIDest splits a reality dest such as "www.yahoo.com:443". A bare
+// port means the handshake goes to localhost.
+func parseXUIDest(dest string) (jsonhandler.Handshake, error) {
+	if !strings.Contains(dest, ":") {
+		dest = "127.0.0.1:" + dest
+	}
+
+	host, port, err := net.SplitHostPort(dest)
+	if err != nil {
+		return jsonhandler.Handshake{}, err
+	}
+	serverPort, err := strconv.Atoi(port)
+	if err != nil {
+		return jsonhandler.Handshake{}, fmt.Errorf("invalid port %q", port)
+	}
+	return jsonhandler.Handshake{Server: host, ServerPort: serverPort}, nil
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// xuiFixture builds an x-ui database from testdata/3x-ui.sql and returns
+// its path
+func xuiFixture(t *testing.T) string {
+	t.Helper()
+	dump, err := os.ReadFile(filepath.Join("testdata", "3x-ui.sql"))
+	if err != nil {
+		t.Fatalf("error reading fixture: %v", err)
+	}
+	filename := filepath.Join(t.TempDir(), "x-ui.db")
+	xuiDB, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatalf("error opening x-ui database: %v", err)
+	}
+	defer xuiDB.Close()
+	if _, err := xuiDB.Exec(string(dump)); err != nil {
+		t.Fatalf("error loading fixture: %v", err)
+	}
+	return filename
+}
+
+func TestImportXUIDatabase(t *testing.T) {
+	dbConnection := openTestDB(t)
+	report, err := ImportXUIDatabase(dbConnection, xuiFixture(t))
+	if err != nil {
+		t.Fatalf("ImportXUIDatabase: %v", err)
+	}
+
+	if report.Inbounds != 2 || report.Users != 4 || report.Reality != 1 || report.TLS != 2 {
+		t.Errorf("report = %+v, want 2 inbounds, 4 users, 1 reality and 2 TLS", report)
+	}
+	var vmessSkipped bool
+	for _, skipped := range report.Skipped {
+		if strings.Contains(skipped, "inbound-10086 (vmess): vmess inbounds are not supported") {
+			vmessSkipped = true
+		}
+	}
+	if !vmessSkipped {
+		t.Errorf("skipped = %q, want the vmess inbound", report.Skipped)
+	}
+
+	want := map[string]bool{"alice": true, "bob": false, "carol": false, "dave": true}
+	for name, active := range want {
+		var got bool
+		if err := dbConnection.QueryRow("SELECT active FROM users WHERE name = ?", name).Scan(&got); err != nil {
+			t.Fatalf("error reading user %s: %v", name, err)
+		}
+		if got != active {
+			t.Errorf("user %s: active = %v, want %v", name, got, active)
+		}
+	}
+
+	var expiresAt, quotaBytes sql.NullInt64
+	err = dbConnection.QueryRow("SELECT expires_at, quota_bytes FROM users WHERE name = 'alice'").Scan(&expiresAt, &quotaBytes)
+	if err != nil {
+		t.Fatalf("error reading user alice: %v", err)
+	}
+	if expiresAt.Int64 != 4102444800 || quotaBytes.Int64 != 10737418240 {
+		t.Errorf("alice: expires_at = %v, quota_bytes = %v, want 4102444800 and 10737418240", expiresAt, quotaBytes)
+	}
+
+	var flow string
+	var realityID int
+	err = dbConnection.QueryRow("SELECT flow, reality_id FROM inbounds WHERE tag = 'inbound-443'").Scan(&flow, &realityID)
+	if err != nil {
+		t.Fatalf("error reading inbound-443: %v", err)
+	}
+	if flow != "xtls-rprx-vision" {
+		t.Errorf("inbound-443: flow = %q, want xtls-rprx-vision", flow)
+	}
+	reality, err := GetReality(dbConnection, realityID)
+	if err != nil {
+		t.Fatalf("GetReality: %v", err)
+	}
+	if !reflect.DeepEqual(reality.ShortIDs, []string{"", "6ba85179"}) {
+		t.Errorf("short_ids = %q, want the empty short_id and 6ba85179", reality.ShortIDs)
+	}
+}
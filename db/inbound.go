@@ -4,43 +4,59 @@ package db
 import (
 	"database/sql"
 	"fmt"
-	"log"
 
 	//go-sqlite3 is the sql driver for sqlite in go
 	_ "github.com/mattn/go-sqlite3"
+	"winder.website/sbfm/jsonhandler"
 )
 
-// AddInbound Function to add an inbound
-func AddInbound(
-	db *sql.DB,
-	inboundType, tag, listen, sniffTimeout string,
-	listenPort int,
-	transportID, tlsID, realityID, handshakeID *int,
-	sniff, sniffOverrideDestination bool,
-) {
+// AddInbound Function to add an inbound; record.ID is ignored. Method and
+// Password are only used by shadowsocks inbounds; the server password of the
+// 2022 methods is generated when it is empty. Flow is the vless flow given
+// to every user.
+func AddInbound(db *sql.DB, record InboundRecord) error {
+	if err := checkReference(db, "detour", record.Detour, "inbounds", "outbounds"); err != nil {
+		return err
+	}
+	password, err := inboundPassword(record.Type, record.Method, record.Password)
+	if err != nil {
+		return err
+	}
+	transportType, err := inboundTransportType(db, record.TransportID)
+	if err != nil {
+		return err
+	}
+	if err := checkFlow(record.Type, record.Flow, transportType); err != nil {
+		return err
+	}
+
 	// Insert the inbound and associate it with the transport ID
-	_, err := db.Exec(
+	_, err = db.Exec(
 		`
-	INSERT INTO inbounds (type, tag, listen, listen_port, sniff, sniff_override_destination, sniff_timeout, transport_id, tls_id, reality_id, handshake_id)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		inboundType,
-		tag,
-		listen,
-		listenPort,
-		sniff,
-		sniffOverrideDestination,
-		sniffTimeout,
-		transportID,
-		tlsID,
-		realityID,
-		handshakeID,
+	INSERT INTO inbounds (type, tag, listen, listen_port, sniff, sniff_override_destination, sniff_timeout, detour, method, password, flow, transport_id, tls_id, reality_id, handshake_id)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.Type,
+		record.Tag,
+		record.Listen,
+		record.ListenPort,
+		record.Sniff,
+		record.SniffOverrideDestination,
+		record.SniffTimeout,
+		record.Detour,
+		record.Method,
+		password,
+		record.Flow,
+		record.TransportID,
+		record.TLSID,
+		record.RealityID,
+		record.HandshakeID,
 	)
 	if err != nil {
-		log.Printf("Error adding inbound: %v", err)
-		return
+		return fmt.Errorf("error adding inbound: %v", err)
 	}
 
 	fmt.Println("Inbound added successfully.")
+	return nil
 }
 
 // AddTLS Function to add a tls
@@ -48,7 +64,7 @@ func AddTLS(
 	db *sql.DB,
 	enabled bool,
 	serverName, minVersion, maxVersion, certificatePath, keyPath string,
-) {
+) error {
 	// Insert the inbound and associate it with the transport ID
 	_, err := db.Exec(
 		`
@@ -62,34 +78,64 @@ func AddTLS(
 		keyPath,
 	)
 	if err != nil {
-		log.Printf("Error adding tls: %v", err)
-		return
+		return fmt.Errorf("error adding tls: %v", err)
 	}
 
 	fmt.Println("tls added successfully.")
+	return nil
 }
 
-// AddReality Function to add a reality
+// AddReality Function to add a reality. The public key is derived from the
+// private key and stored next to it.
 func AddReality(
 	db *sql.DB,
 	enabled bool,
-	privateKey, shortID string,
-) {
-	// Insert the inbound and associate it with the transport ID
-	_, err := db.Exec(
+	privateKey string,
+	shortIDs, serverNames []string,
+	perUserShortIDs bool,
+) error {
+	publicKey, err := RealityPublicKey(privateKey)
+	if err != nil {
+		return err
+	}
+	if err := checkRealityShortIDs(enabled, perUserShortIDs, shortIDs); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error adding reality: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Insert the reality and its short_ids and server names
+	result, err := tx.Exec(
 		`
-	INSERT INTO reality (enabled, private_key, short_id)
-	VALUES (?, ?, ?)`,
+	INSERT INTO reality (enabled, private_key, public_key, per_user_short_ids)
+	VALUES (?, ?, ?, ?)`,
 		enabled,
 		privateKey,
-		shortID,
+		publicKey,
+		perUserShortIDs,
 	)
 	if err != nil {
-		log.Printf("Error adding reality: %v", err)
-		return
+		return fmt.Errorf("error adding reality: %v", err)
+	}
+
+	realityID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error adding reality: %v", err)
+	}
+	if err := replaceRealityLists(tx, realityID, shortIDs, serverNames); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error adding reality: %v", err)
 	}
 
-	fmt.Println("reality added successfully.")
+	fmt.Printf("reality added successfully. Public key: %s\n", publicKey)
+	return nil
 }
 
 // AddHandshake Function to add a handshake
@@ -97,7 +143,7 @@ func AddHandshake(
 	db *sql.DB,
 	server string,
 	serverPort int,
-) {
+) error {
 	// Insert the inbound and associate it with the transport ID
 	_, err := db.Exec(
 		`
@@ -107,11 +153,11 @@ func AddHandshake(
 		serverPort,
 	)
 	if err != nil {
-		log.Printf("Error adding handshake: %v", err)
-		return
+		return fmt.Errorf("error adding handshake: %v", err)
 	}
 
 	fmt.Println("handshake added successfully.")
+	return nil
 }
 
 // AddTransport inserts a new transport entry into the database.
@@ -127,3 +173,43 @@ func AddTransport(db *sql.DB, transportType, transportPath string) error {
 
 	return nil
 }
+
+// checkFlow checks the vless flow of an inbound. Only vless inbounds have
+// one, xtls-rprx-vision is the only flow sing-box supports, and it does not
+// work over a transport. transportType is empty for inbounds without one.
+func checkFlow(inboundType, flow, transportType string) error {
+	if flow == "" {
+		return nil
+	}
+	if inboundType != "vless" {
+		return fmt.Errorf("flow is only used by vless inbounds")
+	}
+	if err := oneOf(jsonhandler.FlowVision)(flow); err != nil {
+		return fmt.Errorf("invalid flow: %v", err)
+	}
+	if transportType != "" {
+		return fmt.Errorf("flow %s does not work over the %s transport", flow, transportType)
+	}
+	return nil
+}
+
+// inboundTransportType returns the type of the transport with transportID,
+// or an empty string if transportID is nil
+func inboundTransportType(db *sql.DB, transportID *int) (string, error) {
+	if transportID == nil {
+		return "", nil
+	}
+	var transportType string
+	err := db.QueryRow("SELECT type FROM transports WHERE id = ?", *transportID).Scan(&transportType)
+	if err != nil {
+		return "", notFound(err, "transport", *transportID)
+	}
+	return transportType, nil
+}
+
+func addInboundFlow(tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE inbounds ADD COLUMN flow TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("error adding flow column: %v", err)
+	}
+	return nil
+}
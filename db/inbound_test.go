@@ -0,0 +1,51 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"winder.website/sbfm/jsonhandler"
+)
+
+func TestVisionFlowRefusesTransports(t *testing.T) {
+	dbConnection := openTestDB(t)
+	if err := AddTransport(dbConnection, "ws", "/ws"); err != nil {
+		t.Fatalf("AddTransport: %v", err)
+	}
+	if err := AddTransport(dbConnection, "grpc", ""); err != nil {
+		t.Fatalf("AddTransport: %v", err)
+	}
+	ws, grpc := 1, 2
+
+	err := AddInbound(dbConnection, InboundRecord{
+		Type: "vless", Tag: "vless-ws", Listen: "::", ListenPort: 443, Flow: jsonhandler.FlowVision, TransportID: &ws,
+	})
+	if err == nil || !strings.Contains(err.Error(), "ws transport") {
+		t.Errorf("AddInbound with vision over ws = %v, want an error", err)
+	}
+
+	err = AddInbound(dbConnection, InboundRecord{
+		Type: "vless", Tag: "vless-tcp", Listen: "::", ListenPort: 443, Flow: jsonhandler.FlowVision,
+	})
+	if err != nil {
+		t.Fatalf("AddInbound with vision over tcp: %v", err)
+	}
+	record, err := GetInbound(dbConnection, 1)
+	if err != nil {
+		t.Fatalf("GetInbound: %v", err)
+	}
+	record.TransportID = &grpc
+	if err := UpdateInbound(dbConnection, record); err == nil || !strings.Contains(err.Error(), "grpc transport") {
+		t.Errorf("UpdateInbound with vision over grpc = %v, want an error", err)
+	}
+
+	// Attach ws to the vision inbound directly, then switch that transport
+	// underneath it through UpdateTransport
+	if _, err := dbConnection.Exec("UPDATE inbounds SET transport_id = ? WHERE id = 1", ws); err != nil {
+		t.Fatalf("error setting transport: %v", err)
+	}
+	err = UpdateTransport(dbConnection, TransportRecord{ID: ws, Type: "httpupgrade", Path: "/up"})
+	if err == nil || !strings.Contains(err.Error(), "inbound vless-tcp") {
+		t.Errorf("UpdateTransport under a vision inbound = %v, want an error", err)
+	}
+}
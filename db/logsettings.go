@@ -0,0 +1,138 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// LogLevels are the log levels sing-box accepts, from the most verbose
+var LogLevels = []string{"trace", "debug", "info", "warn", "error", "fatal", "panic"}
+
+// LogSettings is the single row of the log table
+type LogSettings struct {
+	Disabled bool
+	Level    string
+	// Output is the file sing-box logs to, empty for the console
+	Output    string
+	Timestamp bool
+}
+
+// DefaultLogSettings are the log settings a new database starts with
+var DefaultLogSettings = LogSettings{Level: "info", Timestamp: true}
+
+// ValidateLogSettings checks the log level
+func ValidateLogSettings(settings LogSettings) error {
+	if err := oneOf(LogLevels...)(settings.Level); err != nil {
+		return fmt.Errorf("invalid log level: %v", err)
+	}
+	return nil
+}
+
+// GetLogSettings returns the log settings and whether they are set. The
+// log block is left out of config.json when they are not.
+func GetLogSettings(dbConnection *sql.DB) (LogSettings, bool, error) {
+	var settings LogSettings
+	err := dbConnection.QueryRow("SELECT disabled, level, output, timestamp FROM log WHERE id = 1").Scan(
+		&settings.Disabled, &settings.Level, &settings.Output, &settings.Timestamp,
+	)
+	if err == sql.ErrNoRows {
+		return DefaultLogSettings, false, nil
+	}
+	if err != nil {
+		return LogSettings{}, false, fmt.Errorf("error querying log table: %v", err)
+	}
+	return settings, true, nil
+}
+
+// SetLogSettings validates and stores the log settings, replacing the
+// current ones
+func SetLogSettings(dbConnection *sql.DB, settings LogSettings) error {
+	if err := ValidateLogSettings(settings); err != nil {
+		return err
+	}
+	return upsertLogSettings(dbConnection, settings)
+}
+
+// upsertLogSettings writes the single row of the log table
+func upsertLogSettings(db querier, settings LogSettings) error {
+	_, err := db.Exec(
+		`INSERT INTO log (id, disabled, level, output, timestamp) VALUES (1, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			disabled = excluded.disabled, level = excluded.level,
+			output = excluded.output, timestamp = excluded.timestamp`,
+		settings.Disabled, settings.Level, settings.Output, settings.Timestamp,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving log settings: %v", err)
+	}
+	return nil
+}
+
+// UnsetLogSettings removes the log settings, so that the log block is left
+// out of config.json and sing-box uses its own defaults
+func UnsetLogSettings(dbConnection *sql.DB) error {
+	_, err := dbConnection.Exec("DELETE FROM log")
+	if err != nil {
+		return fmt.Errorf("error removing log settings: %v", err)
+	}
+	return nil
+}
+
+// PrintLogSettings prints the log settings
+func PrintLogSettings(dbConnection *sql.DB) error {
+	settings, ok, err := GetLogSettings(dbConnection)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		fmt.Println("Log settings are unset, config.json has no log block.")
+		return nil
+	}
+
+	fmt.Println("Key\tValue")
+	fmt.Printf("disabled\t%t\n", settings.Disabled)
+	fmt.Printf("level\t%s\n", settings.Level)
+	fmt.Printf("output\t%s\n", formatOptional(settings.Output))
+	fmt.Printf("timestamp\t%t\n", settings.Timestamp)
+	return nil
+}
+
+// createLogSingleton rebuilds the log table with a single row. Of the rows
+// older versions piled up, the last one added is kept; a database without
+// one gets DefaultLogSettings.
+func createLogSingleton(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE log_singleton (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			disabled BOOLEAN NOT NULL DEFAULT FALSE,
+			level TEXT NOT NULL DEFAULT 'info',
+			output TEXT NOT NULL DEFAULT '',
+			timestamp BOOLEAN NOT NULL DEFAULT TRUE
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating log table: %v", err)
+	}
+
+	var settings LogSettings
+	err = tx.QueryRow(
+		`SELECT COALESCE(disabled, FALSE), COALESCE(level, ''), COALESCE(output, ''), COALESCE(timestamp, FALSE)
+		FROM log ORDER BY rowid DESC LIMIT 1`,
+	).Scan(&settings.Disabled, &settings.Level, &settings.Output, &settings.Timestamp)
+	switch {
+	case err == sql.ErrNoRows:
+		settings = DefaultLogSettings
+	case err != nil:
+		return fmt.Errorf("error reading log table: %v", err)
+	case ValidateLogSettings(settings) != nil:
+		settings.Level = DefaultLogSettings.Level
+	}
+
+	if _, err := tx.Exec("DROP TABLE log"); err != nil {
+		return fmt.Errorf("error dropping old log table: %v", err)
+	}
+	if _, err := tx.Exec("ALTER TABLE log_singleton RENAME TO log"); err != nil {
+		return fmt.Errorf("error renaming log table: %v", err)
+	}
+	return upsertLogSettings(tx, settings)
+}
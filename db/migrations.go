@@ -0,0 +1,194 @@
+// Package db handles the database
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	// go-sqlite3 is the sql driver for sqlite in go
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// migration is a single forward step of the database schema.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// migrations lists every schema change in the order it has to be applied.
+// Append new migrations at the end and never edit one that has shipped.
+var migrations = []migration{
+	{version: 1, description: "initial schema", up: createInitialSchema},
+	{version: 2, description: "per-user inbound assignment and settings", up: createUserInbounds},
+	{version: 3, description: "reality public keys", up: addRealityPublicKey},
+	{version: 4, description: "multiple reality short_ids and server names", up: createRealityLists},
+	{version: 5, description: "outbounds", up: createOutbounds},
+	{version: 6, description: "route rules and rule sets", up: createRouteRules},
+	{version: 7, description: "DNS servers and rules", up: createDNS},
+	{version: 8, description: "single row log settings", up: createLogSingleton},
+	{version: 9, description: "user expiry dates", up: addUserExpiry},
+	{version: 10, description: "per-user daily traffic", up: createUserTraffic},
+	{version: 11, description: "user data quotas", up: addUserQuotas},
+	{version: 12, description: "user passwords and shadowsocks keys", up: addCredentials},
+	{version: 13, description: "vless flow of inbounds", up: addInboundFlow},
+	{version: 14, description: "users pending a drop from the generated files", up: addDropPending},
+	{version: 15, description: "unique user names", up: uniqueUserNames},
+	{version: 16, description: "template client profiles for existing users", up: keepTemplateProfiles},
+}
+
+// LatestSchemaVersion returns the version the database is migrated to by Migrate.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the current schema version of the database without
+// changing it. Databases created before versioning are reported as version 1.
+func SchemaVersion(db *sql.DB) (int, error) {
+	versioned, err := tableExists(db, "schema_version")
+	if err != nil {
+		return 0, err
+	}
+	if versioned {
+		var version sql.NullInt64
+		if err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+			return 0, fmt.Errorf("error querying schema_version table: %v", err)
+		}
+		if version.Valid {
+			return int(version.Int64), nil
+		}
+	}
+
+	legacy, err := tableExists(db, "inbounds")
+	if err != nil {
+		return 0, err
+	}
+	if legacy {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Migrate applies every pending migration, each in its own transaction, and
+// returns the number of migrations applied.
+func Migrate(db *sql.DB) (int, error) {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("error creating schema_version table: %v", err)
+	}
+
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+
+	// A database created by the old CreateTables has no version rows yet but
+	// already has the initial schema, so record it instead of applying it.
+	var recorded int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&recorded); err != nil {
+		return 0, fmt.Errorf("error querying schema_version table: %v", err)
+	}
+	if recorded == 0 && current == 1 {
+		_, err := db.Exec(
+			"INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)",
+			1, migrations[0].description+" (detected)", time.Now().UTC(),
+		)
+		if err != nil {
+			return 0, fmt.Errorf("error recording schema version 1: %v", err)
+		}
+		fmt.Println("Detected existing database at schema version 1.")
+	}
+
+	if current > LatestSchemaVersion() {
+		return 0, fmt.Errorf(
+			"database schema version %d is newer than this sbfm supports (%d)",
+			current, LatestSchemaVersion(),
+		)
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return applied, err
+		}
+		fmt.Printf("Applied migration %d: %s\n", m.version, m.description)
+		applied++
+	}
+
+	return applied, nil
+}
+
+// applyMigration runs one migration and records it atomically.
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting migration %d: %v", m.version, err)
+	}
+
+	if err := m.up(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error applying migration %d (%s): %v", m.version, m.description, err)
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)",
+		m.version, m.description, time.Now().UTC(),
+	)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error recording migration %d: %v", m.version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing migration %d: %v", m.version, err)
+	}
+	return nil
+}
+
+// PrintMigrationStatus prints the current schema version and every migration
+// with whether it has been applied.
+func PrintMigrationStatus(db *sql.DB) error {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Schema version: %d (latest: %d)\n", current, LatestSchemaVersion())
+	fmt.Println("Version\tStatus\tDescription")
+	pending := 0
+	for _, m := range migrations {
+		status := "applied"
+		if m.version > current {
+			status = "pending"
+			pending++
+		}
+		fmt.Printf("%d\t%s\t%s\n", m.version, status, m.description)
+	}
+
+	if pending > 0 {
+		fmt.Printf("%d migration(s) pending, run `sbfm migrate up` to apply them.\n", pending)
+	}
+	return nil
+}
+
+// tableExists reports whether a table with the given name exists.
+func tableExists(db *sql.DB, name string) (bool, error) {
+	var count int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name,
+	).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking for table %s: %v", name, err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,259 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"winder.website/sbfm/jsonhandler"
+)
+
+// OutboundTypes are the outbound types sbfm can store
+var OutboundTypes = []string{"direct", "block", "dns", "socks", "http", "shadowsocks", "vless", "wireguard"}
+
+// outboundDomainStrategies are the values sing-box accepts for domain_strategy
+var outboundDomainStrategies = []string{"", "prefer_ipv4", "prefer_ipv6", "ipv4_only", "ipv6_only"}
+
+// OutboundRecord is a row of the outbounds table. Which fields are used
+// depends on Type; the others are left out of the generated config.
+type OutboundRecord struct {
+	ID         int
+	Type       string
+	Tag        string
+	Server     string
+	ServerPort int
+	// Version is the SOCKS version: 4, 4a or 5
+	Version  string
+	Username string
+	Password string
+	// Method is the shadowsocks cipher
+	Method string
+	UUID   string
+	Flow   string
+
+	TLSEnabled       bool
+	TLSServerName    string
+	TLSInsecure      bool
+	UTLSFingerprint  string
+	RealityPublicKey string
+	RealityShortID   string
+	TransportID      *int
+
+	// LocalAddress lists the WireGuard interface addresses in CIDR form
+	LocalAddress  []string
+	PrivateKey    string
+	PeerPublicKey string
+	PreSharedKey  string
+	// Reserved holds the three WireGuard reserved bytes as "0,0,0"
+	Reserved string
+	MTU      int
+
+	// Dial fields
+	Detour         string
+	BindInterface  string
+	RoutingMark    int
+	DomainStrategy string
+}
+
+// dialsServer reports whether outbounds of type connect to a server
+func dialsServer(outboundType string) bool {
+	switch outboundType {
+	case "socks", "http", "shadowsocks", "vless", "wireguard":
+		return true
+	}
+	return false
+}
+
+// ValidateOutbound checks that record has the fields its type needs
+func ValidateOutbound(record OutboundRecord) error {
+	if err := oneOf(OutboundTypes...)(record.Type); err != nil {
+		return fmt.Errorf("invalid outbound type: %v", err)
+	}
+	if record.Tag == "" {
+		return fmt.Errorf("outbound has no tag")
+	}
+	if record.Detour != "" && record.Detour == record.Tag {
+		return fmt.Errorf("outbound %s cannot detour through itself", record.Tag)
+	}
+	if err := oneOf(outboundDomainStrategies...)(record.DomainStrategy); err != nil {
+		return fmt.Errorf("invalid domain_strategy: %v", err)
+	}
+	if record.RoutingMark < 0 {
+		return fmt.Errorf("invalid routing_mark %d", record.RoutingMark)
+	}
+
+	if dialsServer(record.Type) {
+		if record.Server == "" {
+			return fmt.Errorf("%s outbound needs a server", record.Type)
+		}
+		if record.ServerPort <= 0 || record.ServerPort > 65535 {
+			return fmt.Errorf("invalid server port %d", record.ServerPort)
+		}
+	}
+
+	switch record.Type {
+	case "socks":
+		if err := oneOf("", "4", "4a", "5")(record.Version); err != nil {
+			return fmt.Errorf("invalid socks version: %v", err)
+		}
+	case "shadowsocks":
+		if record.Method == "" || record.Password == "" {
+			return fmt.Errorf("shadowsocks outbound needs a method and a password")
+		}
+	case "vless":
+		if _, err := uuid.Parse(record.UUID); err != nil {
+			return fmt.Errorf("invalid vless uuid %q: %v", record.UUID, err)
+		}
+	case "wireguard":
+		if len(record.LocalAddress) == 0 {
+			return fmt.Errorf("wireguard outbound needs a local address")
+		}
+		if record.PrivateKey == "" || record.PeerPublicKey == "" {
+			return fmt.Errorf("wireguard outbound needs a private key and a peer public key")
+		}
+		if _, err := ParseReserved(record.Reserved); err != nil {
+			return err
+		}
+	}
+
+	if record.RealityPublicKey != "" && !record.TLSEnabled {
+		return fmt.Errorf("reality needs tls to be enabled")
+	}
+	if record.RealityShortID != "" {
+		if err := ValidateShortID(record.RealityShortID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseReserved parses the WireGuard reserved bytes. An empty string is no
+// reserved bytes.
+func ParseReserved(reserved string) ([]int, error) {
+	list := jsonhandler.SplitList(reserved)
+	if len(list) == 0 {
+		return nil, nil
+	}
+	if len(list) != 3 {
+		return nil, fmt.Errorf("invalid reserved %q: expected three bytes", reserved)
+	}
+
+	bytes := make([]int, 0, len(list))
+	for _, item := range list {
+		value, err := strconv.Atoi(item)
+		if err != nil || value < 0 || value > 255 {
+			return nil, fmt.Errorf("invalid reserved byte %q", item)
+		}
+		bytes = append(bytes, value)
+	}
+	return bytes, nil
+}
+
+// checkReference makes sure tag names an existing row in one of tables.
+// field is the name of the referencing field, used in the error.
+func checkReference(dbConnection *sql.DB, field, tag string, tables ...string) error {
+	if tag == "" {
+		return nil
+	}
+
+	for _, table := range tables {
+		var count int
+		err := dbConnection.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE tag = ?", tag).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("error looking up %s %s: %v", field, tag, err)
+		}
+		if count > 0 {
+			return nil
+		}
+	}
+	kinds := make([]string, len(tables))
+	for i, table := range tables {
+		kinds[i] = strings.ReplaceAll(strings.TrimSuffix(table, "s"), "_", " ")
+	}
+	return fmt.Errorf("%s %q matches no %s tag", field, tag, strings.Join(kinds, " or "))
+}
+
+// AddOutbound Function to add an outbound
+func AddOutbound(dbConnection *sql.DB, record OutboundRecord) error {
+	if err := ValidateOutbound(record); err != nil {
+		return err
+	}
+	if err := checkReference(dbConnection, "detour", record.Detour, "outbounds"); err != nil {
+		return err
+	}
+
+	_, err := dbConnection.Exec(
+		`
+	INSERT INTO outbounds (
+		type, tag, server, server_port, version, username, password, method, uuid, flow,
+		tls_enabled, tls_server_name, tls_insecure, utls_fingerprint, reality_public_key, reality_short_id, transport_id,
+		local_address, private_key, peer_public_key, pre_shared_key, reserved, mtu,
+		detour, bind_interface, routing_mark, domain_strategy
+	)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		outboundValues(record)...,
+	)
+	if err != nil {
+		return fmt.Errorf("error adding outbound: %v", err)
+	}
+
+	fmt.Println("Outbound added successfully.")
+	return nil
+}
+
+// outboundValues lists the columns of record in the order AddOutbound and
+// UpdateOutbound use
+func outboundValues(record OutboundRecord) []interface{} {
+	return []interface{}{
+		record.Type, record.Tag, record.Server, record.ServerPort,
+		record.Version, record.Username, record.Password, record.Method, record.UUID, record.Flow,
+		record.TLSEnabled, record.TLSServerName, record.TLSInsecure, record.UTLSFingerprint,
+		record.RealityPublicKey, strings.ToLower(record.RealityShortID), record.TransportID,
+		strings.Join(record.LocalAddress, ","), record.PrivateKey, record.PeerPublicKey,
+		record.PreSharedKey, record.Reserved, record.MTU,
+		record.Detour, record.BindInterface, record.RoutingMark, record.DomainStrategy,
+	}
+}
+
+func createOutbounds(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE outbounds (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			tag TEXT UNIQUE NOT NULL,
+			server TEXT NOT NULL DEFAULT '',
+			server_port INTEGER NOT NULL DEFAULT 0,
+			version TEXT NOT NULL DEFAULT '',
+			username TEXT NOT NULL DEFAULT '',
+			password TEXT NOT NULL DEFAULT '',
+			method TEXT NOT NULL DEFAULT '',
+			uuid TEXT NOT NULL DEFAULT '',
+			flow TEXT NOT NULL DEFAULT '',
+			tls_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			tls_server_name TEXT NOT NULL DEFAULT '',
+			tls_insecure BOOLEAN NOT NULL DEFAULT FALSE,
+			utls_fingerprint TEXT NOT NULL DEFAULT '',
+			reality_public_key TEXT NOT NULL DEFAULT '',
+			reality_short_id TEXT NOT NULL DEFAULT '',
+			transport_id INTEGER,
+			local_address TEXT NOT NULL DEFAULT '',
+			private_key TEXT NOT NULL DEFAULT '',
+			peer_public_key TEXT NOT NULL DEFAULT '',
+			pre_shared_key TEXT NOT NULL DEFAULT '',
+			reserved TEXT NOT NULL DEFAULT '',
+			mtu INTEGER NOT NULL DEFAULT 0,
+			detour TEXT NOT NULL DEFAULT '',
+			bind_interface TEXT NOT NULL DEFAULT '',
+			routing_mark INTEGER NOT NULL DEFAULT 0,
+			domain_strategy TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY (transport_id) REFERENCES transports(id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating outbounds table: %v", err)
+	}
+	return nil
+}
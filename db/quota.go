@@ -0,0 +1,286 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Reset periods of a quota.
+const (
+	// QuotaMonthly quotas start over on the first day of every month.
+	QuotaMonthly = "monthly"
+	// QuotaRolling quotas start over every month on the day of the month of
+	// their anchor, e.g. the day the user signed up.
+	QuotaRolling = "rolling"
+)
+
+// QuotaPeriods are the reset periods of a quota
+var QuotaPeriods = []string{QuotaMonthly, QuotaRolling}
+
+// Quota limits the traffic of a user per period
+type Quota struct {
+	// Bytes is the upload and download the user may use per period
+	Bytes  int64
+	Period string
+	// Anchor is the first day of a rolling quota
+	Anchor time.Time
+}
+
+// ValidateQuota checks the size, period and anchor of a quota
+func ValidateQuota(quota Quota) error {
+	if quota.Bytes <= 0 {
+		return fmt.Errorf("quota must be positive")
+	}
+	if err := oneOf(QuotaPeriods...)(quota.Period); err != nil {
+		return fmt.Errorf("invalid quota period: %v", err)
+	}
+	if quota.Period == QuotaRolling && quota.Anchor.IsZero() {
+		return fmt.Errorf("a rolling quota needs an anchor date")
+	}
+	return nil
+}
+
+// periodStart returns the first day of the period of quota that now falls
+// in. Anchors past the end of a short month start its period on its last
+// day.
+func (quota Quota) periodStart(now time.Time) time.Time {
+	day := 1
+	if quota.Period == QuotaRolling {
+		day = quota.Anchor.Day()
+	}
+
+	start := monthDay(now.Year(), now.Month(), day)
+	if start.After(now) {
+		start = monthDay(now.Year(), now.Month()-1, day)
+	}
+	// Traffic before the anchor is not counted
+	if quota.Anchor.After(start) {
+		start = quota.Anchor
+	}
+	return start
+}
+
+// monthDay returns the given day of the month, or its last day if it is
+// shorter
+func monthDay(year int, month time.Month, day int) time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.Local).Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
+
+// SetUserQuota sets the quota of the user with the given ID. A nil quota
+// means the user is unlimited.
+func SetUserQuota(dbConnection *sql.DB, id int, quota *Quota) error {
+	var bytes interface{}
+	period, anchor := QuotaMonthly, ""
+	if quota != nil {
+		if err := ValidateQuota(*quota); err != nil {
+			return err
+		}
+		bytes = quota.Bytes
+		period = quota.Period
+		if quota.Period == QuotaRolling {
+			anchor = quota.Anchor.Format(trafficDayFormat)
+		}
+	}
+
+	result, err := dbConnection.Exec(
+		"UPDATE users SET quota_bytes = ?, quota_period = ?, quota_anchor = ? WHERE id = ?",
+		bytes, period, anchor, id,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating user quota: %v", err)
+	}
+	return checkUpdated(result, "user", id)
+}
+
+// ResetUserUsage starts the usage of the user with the given ID over from
+// now, until the next period starts, and activates the user
+func ResetUserUsage(dbConnection *sql.DB, id int, now time.Time) error {
+	today := now.Format(trafficDayFormat)
+
+	// Traffic is stored per day, so what the user used earlier today is
+	// kept aside and subtracted later
+	var usedToday int64
+	err := dbConnection.QueryRow(
+		"SELECT COALESCE(SUM(upload + download), 0) FROM user_traffic WHERE user_id = ? AND day = ?",
+		id, today,
+	).Scan(&usedToday)
+	if err != nil {
+		return fmt.Errorf("error querying user_traffic table: %v", err)
+	}
+
+	result, err := dbConnection.Exec(
+		"UPDATE users SET usage_reset_day = ?, usage_reset_bytes = ?, active = TRUE WHERE id = ?",
+		today, usedToday, id,
+	)
+	if err != nil {
+		return fmt.Errorf("error resetting user usage: %v", err)
+	}
+	return checkUpdated(result, "user", id)
+}
+
+// userQuota is a user with a quota
+type userQuota struct {
+	id     int
+	name   string
+	active bool
+	quota  Quota
+	// resetDay and resetBytes record the last ResetUserUsage: its day and
+	// the traffic of that day before it
+	resetDay   string
+	resetBytes int64
+}
+
+// readUserQuotas returns the users that have a quota
+func readUserQuotas(dbConnection *sql.DB) ([]userQuota, error) {
+	rows, err := dbConnection.Query(`
+		SELECT id, name, active, quota_bytes, quota_period, quota_anchor, usage_reset_day, usage_reset_bytes
+		FROM users WHERE quota_bytes IS NOT NULL ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying users table: %v", err)
+	}
+	defer rows.Close()
+
+	var users []userQuota
+	for rows.Next() {
+		var user userQuota
+		var anchor string
+		err := rows.Scan(
+			&user.id, &user.name, &user.active, &user.quota.Bytes, &user.quota.Period, &anchor,
+			&user.resetDay, &user.resetBytes,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning user row: %v", err)
+		}
+		if anchor != "" {
+			user.quota.Anchor, err = time.ParseInLocation(trafficDayFormat, anchor, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quota anchor %q of user %s", anchor, user.name)
+			}
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading users: %v", err)
+	}
+	return users, nil
+}
+
+// usage returns the traffic of the user in the current period, or since
+// the last reset if that was later, and when it is counted from
+func (user userQuota) usage(dbConnection *sql.DB, now time.Time) (int64, time.Time, error) {
+	since := user.quota.periodStart(now)
+	from := since.Format(trafficDayFormat)
+	var offset int64
+	if user.resetDay >= from {
+		from = user.resetDay
+		offset = user.resetBytes
+		since, _ = time.ParseInLocation(trafficDayFormat, from, time.Local)
+	}
+
+	var used int64
+	err := dbConnection.QueryRow(
+		"SELECT COALESCE(SUM(upload + download), 0) FROM user_traffic WHERE user_id = ? AND day >= ?",
+		user.id, from,
+	).Scan(&used)
+	if err != nil {
+		return 0, since, fmt.Errorf("error querying user_traffic table: %v", err)
+	}
+	return used - offset, since, nil
+}
+
+// EnforceQuotas deactivates every active user who used up their quota and
+// returns their names. They stay inactive until their usage is reset, and
+// pending until dropUsers has removed them from the generated files.
+func EnforceQuotas(dbConnection *sql.DB, now time.Time) ([]string, error) {
+	users, err := readUserQuotas(dbConnection)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, user := range users {
+		if !user.active {
+			continue
+		}
+		used, _, err := user.usage(dbConnection, now)
+		if err != nil {
+			return nil, err
+		}
+		if used < user.quota.Bytes {
+			continue
+		}
+		if err := deactivatePending(dbConnection, user.id); err != nil {
+			return nil, err
+		}
+		names = append(names, user.name)
+	}
+	return names, nil
+}
+
+// SweepOverQuotaUsers deactivates the users who used up their quota and
+// drops them from config.json and the per-user outputs, like
+// SweepExpiredUsers. It returns the names of the deactivated users.
+func SweepOverQuotaUsers(dbConnection *sql.DB, templateFilePath string, noReload bool) ([]string, error) {
+	sweeps.Lock()
+	defer sweeps.Unlock()
+
+	overQuota, err := EnforceQuotas(dbConnection, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range overQuota {
+		log.Printf("User %s has used up their quota and was deactivated", name)
+	}
+	return overQuota, dropUsers(dbConnection, templateFilePath, noReload)
+}
+
+// PrintQuotas prints the users that have a quota with their usage in the
+// current period
+func PrintQuotas(dbConnection *sql.DB, now time.Time) error {
+	users, err := readUserQuotas(dbConnection)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("ID\tName\tActive\tPeriod\tSince\tUsed\tQuota")
+	for _, user := range users {
+		used, since, err := user.usage(dbConnection, now)
+		if err != nil {
+			return err
+		}
+		fmt.Printf(
+			"%d\t%s\t%v\t%s\t%s\t%s\t%s\n",
+			user.id,
+			user.name,
+			user.active,
+			user.quota.Period,
+			since.Format(trafficDayFormat),
+			formatBytes(used),
+			formatBytes(user.quota.Bytes),
+		)
+	}
+	return nil
+}
+
+func addUserQuotas(tx *sql.Tx) error {
+	for _, column := range []string{
+		// Bytes per period, NULL for unlimited users
+		"quota_bytes INTEGER",
+		"quota_period TEXT NOT NULL DEFAULT 'monthly'",
+		// YYYY-MM-DD, empty unless the period is rolling
+		"quota_anchor TEXT NOT NULL DEFAULT ''",
+		"usage_reset_day TEXT NOT NULL DEFAULT ''",
+		"usage_reset_bytes INTEGER NOT NULL DEFAULT 0",
+	} {
+		if _, err := tx.Exec("ALTER TABLE users ADD COLUMN " + column); err != nil {
+			return fmt.Errorf("error adding user quota columns: %v", err)
+		}
+	}
+	return nil
+}
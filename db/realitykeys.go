@@ -0,0 +1,103 @@
+// Package db handles the database
+package db
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+
+	// go-sqlite3 is the sql driver for sqlite in go
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// GenerateRealityKeyPair generates a new x25519 key pair in the base64
+// encoding sing-box uses for Reality keys
+func GenerateRealityKeyPair() (privateKey, publicKey string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("error generating x25519 key: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(key.Bytes()),
+		base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+		nil
+}
+
+// RealityPublicKey derives the public key clients need from a Reality private key
+func RealityPublicKey(privateKey string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid reality private key: %v", err)
+	}
+
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid reality private key: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// deriveMissingPublicKeys fills in the public key of every reality row that
+// has none. Rows with an unusable private key are logged and left alone.
+func deriveMissingPublicKeys(db querier) (int, error) {
+	rows, err := db.Query(`SELECT id, private_key FROM reality WHERE public_key IS NULL OR public_key = ''`)
+	if err != nil {
+		return 0, fmt.Errorf("error querying reality table: %v", err)
+	}
+
+	type pending struct {
+		id         int
+		privateKey string
+	}
+	var missing []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.privateKey); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning reality row: %v", err)
+		}
+		missing = append(missing, p)
+	}
+	rows.Close()
+
+	derived := 0
+	for _, p := range missing {
+		publicKey, err := RealityPublicKey(p.privateKey)
+		if err != nil {
+			log.Printf("skipping reality %d: %v", p.id, err)
+			continue
+		}
+		if _, err := db.Exec(`UPDATE reality SET public_key = ? WHERE id = ?`, publicKey, p.id); err != nil {
+			return derived, fmt.Errorf("error updating reality %d: %v", p.id, err)
+		}
+		derived++
+	}
+	return derived, nil
+}
+
+// DeriveRealityPublicKeys derives and stores the public key of every Reality
+// configuration that does not have one yet
+func DeriveRealityPublicKeys(db *sql.DB) (int, error) {
+	return deriveMissingPublicKeys(db)
+}
+
+// addRealityPublicKey adds the public_key column and derives it for the rows
+// already in the database
+func addRealityPublicKey(tx *sql.Tx) error {
+	if _, err := tx.Exec(`ALTER TABLE reality ADD COLUMN public_key TEXT`); err != nil {
+		return fmt.Errorf("error adding public_key column: %v", err)
+	}
+
+	_, err := deriveMissingPublicKeys(tx)
+	return err
+}
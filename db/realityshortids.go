@@ -0,0 +1,243 @@
+// Package db handles the database
+package db
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	// go-sqlite3 is the sql driver for sqlite in go
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// MaxShortIDLength is the longest Reality short_id, 8 bytes in hex.
+const MaxShortIDLength = 16
+
+// ValidateShortID checks that shortID is a hex string of even length up to
+// MaxShortIDLength. The empty short_id is valid and accepts any client.
+func ValidateShortID(shortID string) error {
+	if len(shortID) > MaxShortIDLength || len(shortID)%2 != 0 {
+		return fmt.Errorf("invalid short_id %q: length must be even and at most %d", shortID, MaxShortIDLength)
+	}
+	if _, err := hex.DecodeString(shortID); err != nil {
+		return fmt.Errorf("invalid short_id %q: not a hex string", shortID)
+	}
+	return nil
+}
+
+// GenerateShortID returns a random hex short_id of the given length
+func GenerateShortID(length int) (string, error) {
+	if length <= 0 || length > MaxShortIDLength || length%2 != 0 {
+		return "", fmt.Errorf("invalid short_id length %d: must be even and between 2 and %d", length, MaxShortIDLength)
+	}
+	return generateRandomString(length)
+}
+
+// GenerateShortIDs returns count random hex short_ids of the given length
+func GenerateShortIDs(count, length int) ([]string, error) {
+	shortIDs := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		shortID, err := GenerateShortID(length)
+		if err != nil {
+			return nil, err
+		}
+		shortIDs = append(shortIDs, shortID)
+	}
+	return shortIDs, nil
+}
+
+// checkRealityShortIDs refuses an enabled reality profile clients cannot
+// connect to because it has no short_id. Profiles handing out per-user
+// short_ids need no shared one.
+func checkRealityShortIDs(enabled, perUserShortIDs bool, shortIDs []string) error {
+	if enabled && !perUserShortIDs && len(shortIDs) == 0 {
+		return fmt.Errorf("an enabled reality needs at least one short_id or per-user short_ids")
+	}
+	return nil
+}
+
+// replaceRealityLists replaces the shared short_ids and the server names of
+// a reality profile. Per-user short_ids are kept.
+func replaceRealityLists(tx *sql.Tx, realityID int64, shortIDs, serverNames []string) error {
+	for _, shortID := range shortIDs {
+		if err := ValidateShortID(shortID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM reality_short_ids WHERE reality_id = ? AND user_id IS NULL`, realityID); err != nil {
+		return fmt.Errorf("error clearing short_ids: %v", err)
+	}
+	for _, shortID := range shortIDs {
+		_, err := tx.Exec(
+			`INSERT INTO reality_short_ids (reality_id, short_id) VALUES (?, ?)`,
+			realityID, strings.ToLower(shortID),
+		)
+		if err != nil {
+			return fmt.Errorf("error adding short_id %s: %v", shortID, err)
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM reality_server_names WHERE reality_id = ?`, realityID); err != nil {
+		return fmt.Errorf("error clearing server names: %v", err)
+	}
+	for _, serverName := range serverNames {
+		_, err := tx.Exec(
+			`INSERT INTO reality_server_names (reality_id, server_name) VALUES (?, ?)`,
+			realityID, serverName,
+		)
+		if err != nil {
+			return fmt.Errorf("error adding server name %s: %v", serverName, err)
+		}
+	}
+	return nil
+}
+
+// realityList fetches one column of a reality child table in insertion order
+func realityList(dbConnection *sql.DB, query string, realityID int) ([]string, error) {
+	rows, err := dbConnection.Query(query, realityID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying reality lists: %v", err)
+	}
+	defer rows.Close()
+
+	var items []string
+	for rows.Next() {
+		var item string
+		if err := rows.Scan(&item); err != nil {
+			return nil, fmt.Errorf("error scanning reality list row: %v", err)
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// AssignUserShortIDs gives every user that can use an inbound of a reality
+// profile with per-user short_ids enabled a short_id of their own, and
+// returns how many were created
+func AssignUserShortIDs(dbConnection *sql.DB) (int, error) {
+	rows, err := dbConnection.Query(`
+		SELECT DISTINCT r.id, ui.user_id
+		FROM reality r
+		JOIN inbounds i ON i.reality_id = r.id
+		JOIN user_inbounds ui ON ui.inbound_id = i.id
+		WHERE r.per_user_short_ids = TRUE
+		AND NOT EXISTS (
+			SELECT 1 FROM reality_short_ids rs WHERE rs.reality_id = r.id AND rs.user_id = ui.user_id
+		)`)
+	if err != nil {
+		return 0, fmt.Errorf("error querying users without short_id: %v", err)
+	}
+
+	type missing struct {
+		realityID, userID int
+	}
+	var pending []missing
+	for rows.Next() {
+		var m missing
+		if err := rows.Scan(&m.realityID, &m.userID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning user without short_id: %v", err)
+		}
+		pending = append(pending, m)
+	}
+	rows.Close()
+
+	for i, m := range pending {
+		if err := addUserShortID(dbConnection, m.realityID, m.userID); err != nil {
+			return i, err
+		}
+	}
+	return len(pending), nil
+}
+
+// addUserShortID stores a new random short_id for the user on the reality profile
+func addUserShortID(dbConnection *sql.DB, realityID, userID int) error {
+	shortID, err := GenerateShortID(MaxShortIDLength)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbConnection.Exec(
+		`INSERT INTO reality_short_ids (reality_id, short_id, user_id) VALUES (?, ?, ?)`,
+		realityID, shortID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("error adding short_id for user %d: %v", userID, err)
+	}
+	return nil
+}
+
+// RotateUserShortID replaces the user's short_id on the reality profile,
+// revoking every client config that still carries the old one
+func RotateUserShortID(dbConnection *sql.DB, realityID, userID int) error {
+	if err := userExists(dbConnection, userID); err != nil {
+		return err
+	}
+	if _, err := GetReality(dbConnection, realityID); err != nil {
+		return err
+	}
+
+	_, err := dbConnection.Exec(
+		`DELETE FROM reality_short_ids WHERE reality_id = ? AND user_id = ?`,
+		realityID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("error deleting short_id of user %d: %v", userID, err)
+	}
+	return addUserShortID(dbConnection, realityID, userID)
+}
+
+// createRealityLists moves short_id into the reality_short_ids child table
+// and adds reality_server_names and the per-user short_id switch
+func createRealityLists(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE reality_short_ids (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reality_id INTEGER NOT NULL,
+			short_id TEXT NOT NULL,
+			user_id INTEGER,
+			UNIQUE (reality_id, short_id),
+			FOREIGN KEY (reality_id) REFERENCES reality(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating reality_short_ids table: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE reality_server_names (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			reality_id INTEGER NOT NULL,
+			server_name TEXT NOT NULL,
+			UNIQUE (reality_id, server_name),
+			FOREIGN KEY (reality_id) REFERENCES reality(id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating reality_server_names table: %v", err)
+	}
+
+	// Short IDs that looked numeric were stored as integers and may have lost
+	// leading zeros; they are carried over as they are.
+	_, err = tx.Exec(`
+		INSERT INTO reality_short_ids (reality_id, short_id)
+		SELECT id, LOWER(CAST(short_id AS TEXT)) FROM reality
+		WHERE short_id IS NOT NULL AND CAST(short_id AS TEXT) != ''
+	`)
+	if err != nil {
+		return fmt.Errorf("error copying short_ids: %v", err)
+	}
+
+	if _, err := tx.Exec(`ALTER TABLE reality DROP COLUMN short_id`); err != nil {
+		return fmt.Errorf("error dropping short_id column: %v", err)
+	}
+
+	_, err = tx.Exec(`ALTER TABLE reality ADD COLUMN per_user_short_ids BOOLEAN NOT NULL DEFAULT FALSE`)
+	if err != nil {
+		return fmt.Errorf("error adding per_user_short_ids column: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"database/sql"
+
+	"winder.website/sbfm/jsonhandler"
+	"winder.website/sbfm/reload"
+)
+
+// ReloadHooks reads the reload hooks from the settings table
+func ReloadHooks(dbConnection *sql.DB) (reload.Hooks, error) {
+	var hooks reload.Hooks
+	fields := []struct {
+		key   string
+		value *string
+	}{
+		{SettingReloadCheck, &hooks.CheckMode},
+		{SettingSingBoxBinary, &hooks.SingBox},
+		{SettingReloadPidFile, &hooks.PidFile},
+		{SettingReloadCommand, &hooks.Command},
+	}
+
+	for _, field := range fields {
+		value, err := GetSetting(dbConnection, field.key)
+		if err != nil {
+			return reload.Hooks{}, err
+		}
+		*field.value = value
+	}
+	return hooks, nil
+}
+
+// GenerateServerConfig writes config.json after sing-box has checked it,
+// then reloads sing-box unless noReload is set. A dry run does neither.
+func GenerateServerConfig(dbConnection *sql.DB, options jsonhandler.GenerateOptions, noReload bool) error {
+	hooks, err := ReloadHooks(dbConnection)
+	if err != nil {
+		return err
+	}
+
+	settings, err := ConfigSettings(dbConnection)
+	if err != nil {
+		return err
+	}
+
+	options.Check = hooks.Check
+	if err := jsonhandler.GenerateConfigFile(dbConnection, settings, options); err != nil {
+		return err
+	}
+	if options.DryRun || noReload {
+		return nil
+	}
+	return hooks.Reload()
+}
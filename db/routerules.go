@@ -0,0 +1,689 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"winder.website/sbfm/jsonhandler"
+)
+
+// Keys of the route settings, stored in the settings table.
+const (
+	// SettingRouteFinal is the tag of the outbound that gets connections no
+	// route rule matched. Empty means the first outbound.
+	SettingRouteFinal = "route_final"
+	// SettingRouteAutoDetectInterface binds outbound connections to the
+	// default interface to avoid routing loops: true or false.
+	SettingRouteAutoDetectInterface = "route_auto_detect_interface"
+)
+
+// Values of RouteRuleRecord.Type
+const (
+	RuleTypeDefault = "default"
+	RuleTypeLogical = "logical"
+)
+
+// sniffedProtocols are the protocols sing-box can sniff and match on
+var sniffedProtocols = []string{"http", "tls", "quic", "stun", "dns", "bittorrent", "dtls", "ssh", "rdp"}
+
+// RuleMatchers are the conditions a rule matches connections on. They
+// combine the way sing-box combines the fields of a rule.
+type RuleMatchers struct {
+	Inbound      []string
+	Protocol     []string
+	Domain       []string
+	DomainSuffix []string
+	IPCIDR       []string
+	Port         []int
+	RuleSet      []string
+}
+
+// Empty reports whether no matcher is set
+func (matchers RuleMatchers) Empty() bool {
+	return len(matchers.Inbound) == 0 && len(matchers.Protocol) == 0 &&
+		len(matchers.Domain) == 0 && len(matchers.DomainSuffix) == 0 &&
+		len(matchers.IPCIDR) == 0 && len(matchers.Port) == 0 && len(matchers.RuleSet) == 0
+}
+
+// matcherColumns are the columns RuleMatchers are stored in, in the order of
+// matcherValues and matcherScan
+const matcherColumns = "inbound, protocol, domain, domain_suffix, ip_cidr, port, rule_set"
+
+// matcherValues lists the matchers in the order of matcherColumns
+func matcherValues(matchers RuleMatchers) []interface{} {
+	ports := make([]string, len(matchers.Port))
+	for i, port := range matchers.Port {
+		ports[i] = strconv.Itoa(port)
+	}
+	return []interface{}{
+		strings.Join(matchers.Inbound, ","),
+		strings.Join(matchers.Protocol, ","),
+		strings.Join(matchers.Domain, ","),
+		strings.Join(matchers.DomainSuffix, ","),
+		strings.Join(matchers.IPCIDR, ","),
+		strings.Join(ports, ","),
+		strings.Join(matchers.RuleSet, ","),
+	}
+}
+
+// matcherScan holds the raw matcher columns of a row being scanned
+type matcherScan [7]string
+
+// targets returns the scan destinations in the order of matcherColumns
+func (scan *matcherScan) targets() []interface{} {
+	return []interface{}{&scan[0], &scan[1], &scan[2], &scan[3], &scan[4], &scan[5], &scan[6]}
+}
+
+// matchers parses the scanned columns
+func (scan *matcherScan) matchers() (RuleMatchers, error) {
+	ports, err := ParsePorts(scan[5])
+	if err != nil {
+		return RuleMatchers{}, err
+	}
+	return RuleMatchers{
+		Inbound:      jsonhandler.SplitList(scan[0]),
+		Protocol:     jsonhandler.SplitList(scan[1]),
+		Domain:       jsonhandler.SplitList(scan[2]),
+		DomainSuffix: jsonhandler.SplitList(scan[3]),
+		IPCIDR:       jsonhandler.SplitList(scan[4]),
+		Port:         ports,
+		RuleSet:      jsonhandler.SplitList(scan[6]),
+	}, nil
+}
+
+// ParsePorts parses a comma separated list of ports
+func ParsePorts(list string) ([]int, error) {
+	var ports []int
+	for _, item := range jsonhandler.SplitList(list) {
+		port, err := strconv.Atoi(item)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q", item)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// validateMatchers checks the values of the matchers
+func validateMatchers(matchers RuleMatchers) error {
+	for _, protocol := range matchers.Protocol {
+		if err := oneOf(sniffedProtocols...)(protocol); err != nil {
+			return fmt.Errorf("invalid protocol: %v", err)
+		}
+	}
+	for _, cidr := range matchers.IPCIDR {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			if _, err := netip.ParseAddr(cidr); err != nil {
+				return fmt.Errorf("invalid ip_cidr %q", cidr)
+			}
+		}
+	}
+	for _, port := range matchers.Port {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+	}
+	return nil
+}
+
+// checkMatcherReferences makes sure the inbounds and rule sets the matchers
+// name exist
+func checkMatcherReferences(dbConnection *sql.DB, matchers RuleMatchers) error {
+	for _, inbound := range matchers.Inbound {
+		if err := checkReference(dbConnection, "inbound", inbound, "inbounds"); err != nil {
+			return err
+		}
+	}
+	for _, ruleSet := range matchers.RuleSet {
+		if err := checkReference(dbConnection, "rule_set", ruleSet, "rule_sets"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RuleRecord holds the fields shared by the rows of the route_rules and
+// dns_rules tables. Logical rules combine the rules whose ParentID points at
+// them; rules with the same parent are applied by ascending Priority.
+type RuleRecord struct {
+	ID       int
+	ParentID *int
+	Priority int
+	Type     string
+	// Mode is "and" or "or" for logical rules
+	Mode string
+	RuleMatchers
+	Invert bool
+}
+
+// RouteRuleRecord is a row of the route_rules table. Only top level rules
+// have an outbound.
+type RouteRuleRecord struct {
+	RuleRecord
+	Outbound string
+}
+
+// ruleTable describes a table of rules and the column naming what matching
+// rules lead to
+type ruleTable struct {
+	table string
+	// target is the column holding the tag of an outbound or DNS server
+	target string
+	// name is what the rules are called in messages
+	name string
+}
+
+var routeRules = ruleTable{table: "route_rules", target: "outbound", name: "route rule"}
+
+// validateRule checks a rule on its own, without looking at the database.
+// target is the value of the target column of the rule.
+func (rules ruleTable) validateRule(rule RuleRecord, target string) error {
+	switch rule.Type {
+	case RuleTypeDefault:
+		if rule.Mode != "" {
+			return fmt.Errorf("only logical rules have a mode")
+		}
+		if rule.RuleMatchers.Empty() {
+			return fmt.Errorf("rule matches nothing, set at least one matcher")
+		}
+	case RuleTypeLogical:
+		if err := oneOf("and", "or")(rule.Mode); err != nil {
+			return fmt.Errorf("invalid mode: %v", err)
+		}
+		if !rule.RuleMatchers.Empty() {
+			return fmt.Errorf("logical rules match through their sub-rules, not matchers")
+		}
+	default:
+		return fmt.Errorf("invalid rule type %q, expected %s or %s", rule.Type, RuleTypeDefault, RuleTypeLogical)
+	}
+
+	if rule.ParentID == nil && target == "" {
+		return fmt.Errorf("top level rules need a %s", rules.target)
+	}
+	if rule.ParentID != nil && target != "" {
+		return fmt.Errorf("sub-rules of a logical rule have no %s", rules.target)
+	}
+	return validateMatchers(rule.RuleMatchers)
+}
+
+// checkRule checks the inbounds, rule sets and parent rule that rule refers to
+func (rules ruleTable) checkRule(dbConnection *sql.DB, rule RuleRecord) error {
+	if err := checkMatcherReferences(dbConnection, rule.RuleMatchers); err != nil {
+		return err
+	}
+	if rule.ParentID == nil {
+		return nil
+	}
+
+	var parentType string
+	err := dbConnection.QueryRow("SELECT type FROM "+rules.table+" WHERE id = ?", *rule.ParentID).Scan(&parentType)
+	if err != nil {
+		return notFound(err, rules.name, *rule.ParentID)
+	}
+	if parentType != RuleTypeLogical {
+		return fmt.Errorf("rule %d is not a logical rule", *rule.ParentID)
+	}
+	return rules.checkAncestors(dbConnection, rule.ID, *rule.ParentID)
+}
+
+// checkAncestors makes sure that the rule ruleID is not parentID or one of
+// its ancestors, which would make the rules nest in a loop
+func (rules ruleTable) checkAncestors(dbConnection *sql.DB, ruleID, parentID int) error {
+	for id := parentID; ; {
+		if id == ruleID {
+			return fmt.Errorf("rule %d cannot be nested inside itself", ruleID)
+		}
+		var next sql.NullInt64
+		err := dbConnection.QueryRow("SELECT parent_id FROM "+rules.table+" WHERE id = ?", id).Scan(&next)
+		if err != nil {
+			return fmt.Errorf("error looking up rule %d: %v", id, err)
+		}
+		if !next.Valid {
+			return nil
+		}
+		id = int(next.Int64)
+	}
+}
+
+// nextPriority returns a priority after every rule with the same parent
+func (rules ruleTable) nextPriority(dbConnection *sql.DB, parentID *int) (int, error) {
+	var priority int
+	err := dbConnection.QueryRow(
+		"SELECT COALESCE(MAX(priority), 0) + 10 FROM "+rules.table+" WHERE parent_id IS ?",
+		parentID,
+	).Scan(&priority)
+	if err != nil {
+		return 0, fmt.Errorf("error finding the next priority: %v", err)
+	}
+	return priority, nil
+}
+
+// insert adds a rule and returns its priority. A zero priority puts the rule
+// after the other rules with the same parent.
+func (rules ruleTable) insert(dbConnection *sql.DB, rule RuleRecord, target string) (int, error) {
+	if rule.Priority == 0 {
+		priority, err := rules.nextPriority(dbConnection, rule.ParentID)
+		if err != nil {
+			return 0, err
+		}
+		rule.Priority = priority
+	}
+
+	args := []interface{}{rule.ParentID, rule.Priority, rule.Type, rule.Mode}
+	args = append(args, matcherValues(rule.RuleMatchers)...)
+	args = append(args, rule.Invert, target)
+	_, err := dbConnection.Exec(
+		`INSERT INTO `+rules.table+` (parent_id, priority, type, mode, `+matcherColumns+`, invert, `+rules.target+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		args...,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error adding %s: %v", rules.name, err)
+	}
+	return rule.Priority, nil
+}
+
+// get fetches a rule and the value of its target column by ID
+func (rules ruleTable) get(dbConnection *sql.DB, ruleID int) (RuleRecord, string, error) {
+	rule := RuleRecord{ID: ruleID}
+	var parentID sql.NullInt64
+	var scan matcherScan
+	var target string
+
+	targets := []interface{}{&parentID, &rule.Priority, &rule.Type, &rule.Mode}
+	targets = append(targets, scan.targets()...)
+	targets = append(targets, &rule.Invert, &target)
+	err := dbConnection.QueryRow(
+		`SELECT parent_id, priority, type, mode, `+matcherColumns+`, invert, `+rules.target+` FROM `+rules.table+` WHERE id = ?`,
+		ruleID,
+	).Scan(targets...)
+	if err != nil {
+		return RuleRecord{}, "", notFound(err, rules.name, ruleID)
+	}
+
+	rule.ParentID = nullIDPointer(parentID)
+	rule.RuleMatchers, err = scan.matchers()
+	return rule, target, err
+}
+
+// update overwrites the rule with rule.ID
+func (rules ruleTable) update(dbConnection *sql.DB, rule RuleRecord, target string) error {
+	args := []interface{}{rule.ParentID, rule.Priority, rule.Type, rule.Mode}
+	args = append(args, matcherValues(rule.RuleMatchers)...)
+	args = append(args, rule.Invert, target, rule.ID)
+	result, err := dbConnection.Exec(
+		`
+	UPDATE `+rules.table+` SET parent_id = ?, priority = ?, type = ?, mode = ?,
+		inbound = ?, protocol = ?, domain = ?, domain_suffix = ?, ip_cidr = ?, port = ?, rule_set = ?,
+		invert = ?, `+rules.target+` = ?
+	WHERE id = ?`,
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating %s: %v", rules.name, err)
+	}
+	return checkUpdated(result, rules.name, rule.ID)
+}
+
+// delete deletes a rule by ID along with its sub-rules
+func (rules ruleTable) delete(dbConnection *sql.DB, ruleID int) error {
+	_, err := dbConnection.Exec(`
+		WITH RECURSIVE doomed(id) AS (
+			SELECT id FROM `+rules.table+` WHERE id = ?
+			UNION ALL
+			SELECT r.id FROM `+rules.table+` r JOIN doomed d ON r.parent_id = d.id
+		)
+		DELETE FROM `+rules.table+` WHERE id IN (SELECT id FROM doomed)`,
+		ruleID,
+	)
+	return err
+}
+
+// print prints the rules in the order they are applied, with sub-rules
+// indented below their logical rule
+func (rules ruleTable) print(dbConnection *sql.DB, title, targetHeader string) error {
+	rows, err := dbConnection.Query(
+		`SELECT id, parent_id, priority, type, mode, ` + matcherColumns + `, invert, ` + rules.target + `
+		FROM ` + rules.table + ` ORDER BY priority, id`,
+	)
+	if err != nil {
+		return fmt.Errorf("error querying %s table: %v", rules.table, err)
+	}
+	defer rows.Close()
+
+	type listedRule struct {
+		RuleRecord
+		target string
+	}
+	children := make(map[int][]listedRule)
+	for rows.Next() {
+		var rule listedRule
+		var parentID sql.NullInt64
+		var scan matcherScan
+		targets := []interface{}{&rule.ID, &parentID, &rule.Priority, &rule.Type, &rule.Mode}
+		targets = append(targets, scan.targets()...)
+		targets = append(targets, &rule.Invert, &rule.target)
+		if err := rows.Scan(targets...); err != nil {
+			return fmt.Errorf("error scanning %s row: %v", rules.name, err)
+		}
+		if rule.RuleMatchers, err = scan.matchers(); err != nil {
+			return fmt.Errorf("%s %d: %v", rules.name, rule.ID, err)
+		}
+
+		parent := 0
+		if parentID.Valid {
+			parent = int(parentID.Int64)
+		}
+		children[parent] = append(children[parent], rule)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %v", rules.table, err)
+	}
+
+	fmt.Println(title)
+	fmt.Printf("ID\tPriority\tMatch\t%s\n", targetHeader)
+	var printRules func(parent int, indent string)
+	printRules = func(parent int, indent string) {
+		for _, rule := range children[parent] {
+			fmt.Printf(
+				"%s%d\t%d\t%s\t%s\n",
+				indent,
+				rule.ID,
+				rule.Priority,
+				describeRule(rule.RuleRecord),
+				formatOptional(rule.target),
+			)
+			printRules(rule.ID, indent+"  ")
+		}
+	}
+	printRules(0, "")
+	return nil
+}
+
+// describeRule summarizes the matchers of a rule for the listings
+func describeRule(rule RuleRecord) string {
+	var parts []string
+	if rule.Type == RuleTypeLogical {
+		parts = append(parts, "logical "+rule.Mode)
+	}
+	list := func(name string, values []string) {
+		if len(values) > 0 {
+			parts = append(parts, name+"="+strings.Join(values, ","))
+		}
+	}
+	list("inbound", rule.Inbound)
+	list("protocol", rule.Protocol)
+	list("domain", rule.Domain)
+	list("domain_suffix", rule.DomainSuffix)
+	list("ip_cidr", rule.IPCIDR)
+	ports := make([]string, len(rule.Port))
+	for i, port := range rule.Port {
+		ports[i] = strconv.Itoa(port)
+	}
+	list("port", ports)
+	list("rule_set", rule.RuleSet)
+	if rule.Invert {
+		parts = append(parts, "(inverted)")
+	}
+	return strings.Join(parts, " ")
+}
+
+// ValidateRouteRule checks a route rule on its own, without looking at the
+// database
+func ValidateRouteRule(record RouteRuleRecord) error {
+	return routeRules.validateRule(record.RuleRecord, record.Outbound)
+}
+
+// checkRouteRule validates record and the rows it refers to
+func checkRouteRule(dbConnection *sql.DB, record RouteRuleRecord) error {
+	if err := ValidateRouteRule(record); err != nil {
+		return err
+	}
+	if err := checkReference(dbConnection, "outbound", record.Outbound, "outbounds"); err != nil {
+		return err
+	}
+	return routeRules.checkRule(dbConnection, record.RuleRecord)
+}
+
+// AddRouteRule adds a route rule. A zero priority puts the rule after the
+// other rules with the same parent.
+func AddRouteRule(dbConnection *sql.DB, record RouteRuleRecord) error {
+	if err := checkRouteRule(dbConnection, record); err != nil {
+		return err
+	}
+
+	priority, err := routeRules.insert(dbConnection, record.RuleRecord, record.Outbound)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Route rule added with priority %d.\n", priority)
+	return nil
+}
+
+// GetRouteRule fetches a route rule by ID
+func GetRouteRule(dbConnection *sql.DB, ruleID int) (RouteRuleRecord, error) {
+	rule, outbound, err := routeRules.get(dbConnection, ruleID)
+	return RouteRuleRecord{RuleRecord: rule, Outbound: outbound}, err
+}
+
+// UpdateRouteRule overwrites the route rule with record.ID
+func UpdateRouteRule(dbConnection *sql.DB, record RouteRuleRecord) error {
+	if err := checkRouteRule(dbConnection, record); err != nil {
+		return err
+	}
+	return routeRules.update(dbConnection, record.RuleRecord, record.Outbound)
+}
+
+// DeleteRouteRule deletes a route rule by ID along with its sub-rules
+func DeleteRouteRule(dbConnection *sql.DB, ruleID int) error {
+	return routeRules.delete(dbConnection, ruleID)
+}
+
+// PrintRouteRules prints the route rules in the order they are applied,
+// with sub-rules indented below their logical rule
+func PrintRouteRules(dbConnection *sql.DB) error {
+	return routeRules.print(dbConnection, "Route Rules:", "Outbound")
+}
+
+// SetRouteFinal sets the outbound that gets the connections no route rule
+// matched. An empty tag falls back to the first outbound.
+func SetRouteFinal(dbConnection *sql.DB, tag string) error {
+	if err := checkReference(dbConnection, "final", tag, "outbounds"); err != nil {
+		return err
+	}
+	return SetSetting(dbConnection, SettingRouteFinal, tag)
+}
+
+// RuleSetRecord is a row of the rule_sets table
+type RuleSetRecord struct {
+	ID  int
+	Tag string
+	// Type is local or remote
+	Type string
+	// Format is source or binary
+	Format string
+	// Path is the file of a local rule set
+	Path string
+	// URL, DownloadDetour and UpdateInterval are used by remote rule sets
+	URL            string
+	DownloadDetour string
+	UpdateInterval string
+}
+
+// ValidateRuleSet checks a rule set on its own, without looking at the
+// database
+func ValidateRuleSet(record RuleSetRecord) error {
+	if record.Tag == "" {
+		return fmt.Errorf("rule set has no tag")
+	}
+	if err := oneOf("source", "binary")(record.Format); err != nil {
+		return fmt.Errorf("invalid format: %v", err)
+	}
+
+	switch record.Type {
+	case "local":
+		if record.Path == "" {
+			return fmt.Errorf("local rule sets need a path")
+		}
+	case "remote":
+		if !strings.HasPrefix(record.URL, "http://") && !strings.HasPrefix(record.URL, "https://") {
+			return fmt.Errorf("remote rule sets need an http or https url")
+		}
+	default:
+		return fmt.Errorf("invalid rule set type %q, expected local or remote", record.Type)
+	}
+	return nil
+}
+
+// AddRuleSet Function to add a rule set
+func AddRuleSet(dbConnection *sql.DB, record RuleSetRecord) error {
+	if err := ValidateRuleSet(record); err != nil {
+		return err
+	}
+	if err := checkReference(dbConnection, "download_detour", record.DownloadDetour, "outbounds"); err != nil {
+		return err
+	}
+
+	_, err := dbConnection.Exec(
+		`INSERT INTO rule_sets (tag, type, format, path, url, download_detour, update_interval)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		record.Tag, record.Type, record.Format, record.Path,
+		record.URL, record.DownloadDetour, record.UpdateInterval,
+	)
+	if err != nil {
+		return fmt.Errorf("error adding rule set: %v", err)
+	}
+
+	fmt.Println("Rule set added successfully.")
+	return nil
+}
+
+// GetRuleSet fetches a rule set by ID
+func GetRuleSet(dbConnection *sql.DB, ruleSetID int) (RuleSetRecord, error) {
+	record := RuleSetRecord{ID: ruleSetID}
+	err := dbConnection.QueryRow(
+		`SELECT tag, type, format, path, url, download_detour, update_interval FROM rule_sets WHERE id = ?`,
+		ruleSetID,
+	).Scan(
+		&record.Tag, &record.Type, &record.Format, &record.Path,
+		&record.URL, &record.DownloadDetour, &record.UpdateInterval,
+	)
+	if err != nil {
+		return RuleSetRecord{}, notFound(err, "rule set", ruleSetID)
+	}
+	return record, nil
+}
+
+// UpdateRuleSet overwrites the rule set with record.ID
+func UpdateRuleSet(dbConnection *sql.DB, record RuleSetRecord) error {
+	if err := ValidateRuleSet(record); err != nil {
+		return err
+	}
+	if err := checkReference(dbConnection, "download_detour", record.DownloadDetour, "outbounds"); err != nil {
+		return err
+	}
+
+	result, err := dbConnection.Exec(
+		`
+	UPDATE rule_sets SET tag = ?, type = ?, format = ?, path = ?, url = ?, download_detour = ?, update_interval = ?
+	WHERE id = ?`,
+		record.Tag, record.Type, record.Format, record.Path,
+		record.URL, record.DownloadDetour, record.UpdateInterval, record.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating rule set: %v", err)
+	}
+	return checkUpdated(result, "rule set", record.ID)
+}
+
+// DeleteRuleSet deletes a rule set by ID. Rules that use it are reported
+// by validation.
+func DeleteRuleSet(dbConnection *sql.DB, ruleSetID int) error {
+	_, err := dbConnection.Exec("DELETE FROM rule_sets WHERE id = ?", ruleSetID)
+	return err
+}
+
+// PrintRuleSets prints all the data in the rule_sets table
+func PrintRuleSets(dbConnection *sql.DB) error {
+	rows, err := dbConnection.Query(
+		`SELECT id, tag, type, format, path, url, download_detour, update_interval FROM rule_sets ORDER BY id`,
+	)
+	if err != nil {
+		return fmt.Errorf("error querying rule_sets table: %v", err)
+	}
+	defer rows.Close()
+
+	fmt.Println("Available Rule Sets:")
+	fmt.Println("ID\tTag\tType\tFormat\tSource\tDownloadDetour\tUpdateInterval")
+	for rows.Next() {
+		var record RuleSetRecord
+		if err := rows.Scan(
+			&record.ID, &record.Tag, &record.Type, &record.Format, &record.Path,
+			&record.URL, &record.DownloadDetour, &record.UpdateInterval,
+		); err != nil {
+			return fmt.Errorf("error scanning rule set row: %v", err)
+		}
+
+		source := record.Path
+		if record.Type == "remote" {
+			source = record.URL
+		}
+		fmt.Printf(
+			"%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			record.ID,
+			record.Tag,
+			record.Type,
+			record.Format,
+			source,
+			formatOptional(record.DownloadDetour),
+			formatOptional(record.UpdateInterval),
+		)
+	}
+	return nil
+}
+
+func createRouteRules(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE route_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			parent_id INTEGER,
+			priority INTEGER NOT NULL,
+			type TEXT NOT NULL DEFAULT 'default',
+			mode TEXT NOT NULL DEFAULT '',
+			inbound TEXT NOT NULL DEFAULT '',
+			protocol TEXT NOT NULL DEFAULT '',
+			domain TEXT NOT NULL DEFAULT '',
+			domain_suffix TEXT NOT NULL DEFAULT '',
+			ip_cidr TEXT NOT NULL DEFAULT '',
+			port TEXT NOT NULL DEFAULT '',
+			rule_set TEXT NOT NULL DEFAULT '',
+			invert BOOLEAN NOT NULL DEFAULT FALSE,
+			outbound TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY (parent_id) REFERENCES route_rules(id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating route_rules table: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE rule_sets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			tag TEXT UNIQUE NOT NULL,
+			type TEXT NOT NULL,
+			format TEXT NOT NULL,
+			path TEXT NOT NULL DEFAULT '',
+			url TEXT NOT NULL DEFAULT '',
+			download_detour TEXT NOT NULL DEFAULT '',
+			update_interval TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating rule_sets table: %v", err)
+	}
+	return nil
+}
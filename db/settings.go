@@ -0,0 +1,214 @@
+// Package db handles the database
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	// go-sqlite3 is the sql driver for sqlite in go
+	_ "github.com/mattn/go-sqlite3"
+	"winder.website/sbfm/jsonhandler"
+	"winder.website/sbfm/reload"
+)
+
+// Keys of the settings table.
+const (
+	// SettingNewUserInbounds controls which inbounds new users are granted:
+	// NewUserInboundsAll or NewUserInboundsNone.
+	SettingNewUserInbounds = "new_user_inbounds"
+	// SettingPublicHost is the address clients connect to, used in share links
+	// and other client exports.
+	SettingPublicHost = "public_host"
+	// SettingClientProfileMode selects how sing-box client profiles are built:
+	// ClientProfileGenerated or ClientProfileTemplate. Databases that had
+	// users before profiles were generated are migrated to the template.
+	SettingClientProfileMode = "client_profile_mode"
+	// SettingClientGroup is the outbound group wrapping the server outbounds of
+	// generated client profiles: selector or urltest.
+	SettingClientGroup = "client_group"
+	// SettingReloadCheck decides whether `sing-box check` vetoes new configs:
+	// reload.CheckAuto, reload.CheckOn or reload.CheckOff.
+	SettingReloadCheck = "reload_check"
+	// SettingSingBoxBinary is the sing-box binary used for checks.
+	SettingSingBoxBinary = "singbox_binary"
+	// SettingReloadPidFile is the pidfile of the sing-box process that gets
+	// SIGHUP after a new config is written.
+	SettingReloadPidFile = "reload_pidfile"
+	// SettingReloadCommand is run after a new config is written, e.g.
+	// "systemctl reload sing-box".
+	SettingReloadCommand = "reload_command"
+)
+
+// Values of SettingNewUserInbounds.
+const (
+	NewUserInboundsAll  = "all"
+	NewUserInboundsNone = "none"
+)
+
+// Values of SettingClientProfileMode.
+const (
+	ClientProfileGenerated = "generated"
+	ClientProfileTemplate  = "template"
+)
+
+// settingDefaults holds the value used for settings that were never set.
+var settingDefaults = map[string]string{
+	SettingNewUserInbounds:   NewUserInboundsAll,
+	SettingPublicHost:        "",
+	SettingClientProfileMode: ClientProfileGenerated,
+	SettingClientGroup:       jsonhandler.ClientGroupSelector,
+	SettingReloadCheck:       reload.CheckAuto,
+	SettingSingBoxBinary:     "sing-box",
+	SettingReloadPidFile:     "",
+	SettingReloadCommand:     "",
+
+	SettingRouteFinal:               "",
+	SettingRouteAutoDetectInterface: "false",
+
+	SettingDNSFinal:            "",
+	SettingDNSStrategy:         "",
+	SettingDNSDisableCache:     "false",
+	SettingDNSIndependentCache: "false",
+	SettingDNSFakeIPInet4Range: "",
+	SettingDNSFakeIPInet6Range: "",
+
+	SettingStatsAPIListen: "",
+}
+
+// settingValidators check values before they are stored.
+var settingValidators = map[string]func(string) error{
+	SettingNewUserInbounds:   oneOf(NewUserInboundsAll, NewUserInboundsNone),
+	SettingClientProfileMode: oneOf(ClientProfileGenerated, ClientProfileTemplate),
+	SettingClientGroup:       oneOf(jsonhandler.ClientGroupSelector, jsonhandler.ClientGroupURLTest),
+	SettingReloadCheck:       oneOf(reload.CheckAuto, reload.CheckOn, reload.CheckOff),
+
+	SettingRouteAutoDetectInterface: oneOf("true", "false"),
+
+	SettingDNSStrategy:         validDNSStrategy,
+	SettingDNSDisableCache:     oneOf("true", "false"),
+	SettingDNSIndependentCache: oneOf("true", "false"),
+	SettingDNSFakeIPInet4Range: fakeIPRange(true),
+	SettingDNSFakeIPInet6Range: fakeIPRange(false),
+
+	SettingStatsAPIListen: validStatsAPIListen,
+}
+
+// oneOf returns a validator accepting only the given values.
+func oneOf(allowed ...string) func(string) error {
+	return func(value string) error {
+		for _, a := range allowed {
+			if value == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid value %q, expected one of %s", value, strings.Join(allowed, ", "))
+	}
+}
+
+// SettingKeys returns the names of all known settings in sorted order.
+func SettingKeys() []string {
+	keys := make([]string, 0, len(settingDefaults))
+	for key := range settingDefaults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetSetting returns the value stored for key, or its default when unset.
+func GetSetting(db *sql.DB, key string) (string, error) {
+	var value string
+	err := db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return settingDefaults[key], nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error querying setting %s: %v", key, err)
+	}
+	return value, nil
+}
+
+// KnownSetting reports whether key names a setting.
+func KnownSetting(key string) bool {
+	_, ok := settingDefaults[key]
+	return ok
+}
+
+// ValidateSetting reports whether key is a known setting and value is acceptable for it.
+func ValidateSetting(key, value string) error {
+	if !KnownSetting(key) {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	if validate := settingValidators[key]; validate != nil {
+		return validate(value)
+	}
+	return nil
+}
+
+// SetSetting validates and stores value for key, replacing any previous value.
+func SetSetting(db *sql.DB, key, value string) error {
+	if err := ValidateSetting(key, value); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(
+		"INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value",
+		key, value,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving setting %s: %v", key, err)
+	}
+	return nil
+}
+
+// ConfigSettings reads the settings that go into config.json
+func ConfigSettings(db *sql.DB) (jsonhandler.Settings, error) {
+	var settings jsonhandler.Settings
+	var routeAutoDetectInterface, dnsDisableCache, dnsIndependentCache string
+	fields := []struct {
+		key   string
+		value *string
+	}{
+		{SettingRouteFinal, &settings.RouteFinal},
+		{SettingRouteAutoDetectInterface, &routeAutoDetectInterface},
+		{SettingDNSFinal, &settings.DNSFinal},
+		{SettingDNSStrategy, &settings.DNSStrategy},
+		{SettingDNSDisableCache, &dnsDisableCache},
+		{SettingDNSIndependentCache, &dnsIndependentCache},
+		{SettingDNSFakeIPInet4Range, &settings.DNSFakeIPInet4Range},
+		{SettingDNSFakeIPInet6Range, &settings.DNSFakeIPInet6Range},
+		{SettingStatsAPIListen, &settings.StatsAPIListen},
+	}
+
+	for _, field := range fields {
+		value, err := GetSetting(db, field.key)
+		if err != nil {
+			return jsonhandler.Settings{}, err
+		}
+		*field.value = value
+	}
+	settings.RouteAutoDetectInterface = routeAutoDetectInterface == "true"
+	settings.DNSDisableCache = dnsDisableCache == "true"
+	settings.DNSIndependentCache = dnsIndependentCache == "true"
+	return settings, nil
+}
+
+// SetNewUserInbounds sets which inbounds new users are granted by default.
+func SetNewUserInbounds(db *sql.DB, mode string) error {
+	return SetSetting(db, SettingNewUserInbounds, mode)
+}
+
+// PrintSettings prints every known setting with its current value
+func PrintSettings(db *sql.DB) error {
+	fmt.Println("Key\tValue")
+	for _, key := range SettingKeys() {
+		value, err := GetSetting(db, key)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%s\n", key, value)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"testing"
+
+	"winder.website/sbfm/jsonhandler"
+)
+
+func TestConfigSettings(t *testing.T) {
+	dbConnection := openTestDB(t)
+	settings, err := ConfigSettings(dbConnection)
+	if err != nil {
+		t.Fatalf("ConfigSettings: %v", err)
+	}
+	if settings != (jsonhandler.Settings{}) {
+		t.Errorf("ConfigSettings of a new database = %+v, want the zero defaults", settings)
+	}
+
+	for key, value := range map[string]string{
+		SettingRouteFinal:               "direct",
+		SettingRouteAutoDetectInterface: "true",
+		SettingDNSStrategy:              "prefer_ipv4",
+		SettingDNSIndependentCache:      "true",
+		SettingDNSFakeIPInet4Range:      "198.18.0.0/15",
+		SettingStatsAPIListen:           "127.0.0.1:10085",
+	} {
+		if err := SetSetting(dbConnection, key, value); err != nil {
+			t.Fatalf("SetSetting %s: %v", key, err)
+		}
+	}
+	settings, err = ConfigSettings(dbConnection)
+	if err != nil {
+		t.Fatalf("ConfigSettings: %v", err)
+	}
+	want := jsonhandler.Settings{
+		RouteFinal:               "direct",
+		RouteAutoDetectInterface: true,
+		DNSStrategy:              "prefer_ipv4",
+		DNSIndependentCache:      true,
+		DNSFakeIPInet4Range:      "198.18.0.0/15",
+		StatsAPIListen:           "127.0.0.1:10085",
+	}
+	if settings != want {
+		t.Errorf("ConfigSettings = %+v, want %+v", settings, want)
+	}
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"winder.website/sbfm/jsonhandler"
+	"winder.website/sbfm/staging"
+)
+
+// GetUser fetches a user by ID
+func GetUser(dbConnection *sql.DB, userID int) (jsonhandler.User, error) {
+	var user jsonhandler.User
+	err := dbConnection.QueryRow(
+		"SELECT name, uuid, password, ss_key, sub, active FROM users WHERE id = ?", userID,
+	).Scan(&user.Name, &user.UUID, &user.Password, &user.Key, &user.SUB, &user.Active)
+	if err != nil {
+		return jsonhandler.User{}, notFound(err, "user", userID)
+	}
+	return user, nil
+}
+
+// activeUsers fetches every active user
+func activeUsers(dbConnection *sql.DB) ([]jsonhandler.User, error) {
+	rows, err := dbConnection.Query(`SELECT uuid, name, password, ss_key, sub FROM users WHERE active = TRUE`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying users table: %v", err)
+	}
+	defer rows.Close()
+
+	var users []jsonhandler.User
+	for rows.Next() {
+		user := jsonhandler.User{Active: true}
+		if err := rows.Scan(&user.UUID, &user.Name, &user.Password, &user.Key, &user.SUB); err != nil {
+			return nil, fmt.Errorf("error scanning user row: %v", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// clientInbounds are the inbounds client exports are built from, in the
+// shape PopulateConfig puts them in config.json, and the public_host
+// setting. Generators read them once for all users.
+type clientInbounds struct {
+	inbounds   []jsonhandler.Inbound
+	publicHost string
+}
+
+// readClientInbounds reads the fully joined inbounds and the public host
+func readClientInbounds(dbConnection *sql.DB) (clientInbounds, error) {
+	publicHost, err := GetSetting(dbConnection, SettingPublicHost)
+	if err != nil {
+		return clientInbounds{}, err
+	}
+
+	var config jsonhandler.Config
+	if err := jsonhandler.PopulateInbounds(dbConnection, &config); err != nil {
+		return clientInbounds{}, err
+	}
+	return clientInbounds{inbounds: config.Inbounds, publicHost: publicHost}, nil
+}
+
+// UserInbounds returns the inbounds the user has been granted
+func UserInbounds(inbounds []jsonhandler.Inbound, user jsonhandler.User) []jsonhandler.Inbound {
+	var granted []jsonhandler.Inbound
+	for _, inbound := range inbounds {
+		for _, grantee := range inbound.Users {
+			if grantee.UUID == user.UUID {
+				granted = append(granted, inbound)
+				break
+			}
+		}
+	}
+	return granted
+}
+
+// clientHost returns the address clients use for inbound: the public_host
+// setting, or the TLS server name of plain TLS inbounds when it is unset
+func clientHost(publicHost string, inbound jsonhandler.Inbound) (string, error) {
+	if publicHost != "" {
+		return publicHost, nil
+	}
+	if inbound.TLS.Enabled && !inbound.TLS.Reality.Enabled && inbound.TLS.ServerName != "" {
+		return inbound.TLS.ServerName, nil
+	}
+	return "", fmt.Errorf("no public host for inbound %s, set the %s setting", inbound.Tag, SettingPublicHost)
+}
+
+// endpoint is an inbound as a client reaches it
+type endpoint struct {
+	inbound jsonhandler.Inbound
+	host    string
+}
+
+// userEndpoints returns the inbounds the user can use with the host clients
+// connect to for each
+func (clients clientInbounds) userEndpoints(user jsonhandler.User) ([]endpoint, error) {
+	inbounds := UserInbounds(clients.inbounds, user)
+	endpoints := make([]endpoint, 0, len(inbounds))
+	for _, inbound := range inbounds {
+		host, err := clientHost(clients.publicHost, inbound)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint{inbound: inbound, host: host})
+	}
+	return endpoints, nil
+}
+
+// UserShareLinks returns one share link per inbound the user can use.
+// Inbounds that cannot be expressed as a link are logged and skipped, a
+// Reality inbound without a public key is an error.
+func UserShareLinks(dbConnection *sql.DB, user jsonhandler.User) ([]string, error) {
+	clients, err := readClientInbounds(dbConnection)
+	if err != nil {
+		return nil, err
+	}
+	return clients.userShareLinks(user)
+}
+
+func (clients clientInbounds) userShareLinks(user jsonhandler.User) ([]string, error) {
+	endpoints, err := clients.userEndpoints(user)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []string
+	for _, e := range endpoints {
+		link, err := jsonhandler.ShareLink(e.inbound, user, e.host)
+		if err != nil {
+			if errors.Is(err, jsonhandler.ErrNoRealityPublicKey) {
+				return nil, err
+			}
+			log.Printf("skipping inbound %s for user %s: %v", e.inbound.Tag, user.Name, err)
+			continue
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// GenerateUserShareLinks writes the base64 share link bundle of every active
+// user to ./sing-box/users/<name>.txt, next to the client JSON files
+func GenerateUserShareLinks(dbConnection *sql.DB, dryRun bool) error {
+	clients, err := readClientInbounds(dbConnection)
+	if err != nil {
+		return err
+	}
+	return generateUserShareLinks(dbConnection, clients, dryRun)
+}
+
+func generateUserShareLinks(dbConnection *sql.DB, clients clientInbounds, dryRun bool) error {
+	users, err := activeUsers(dbConnection)
+	if err != nil {
+		return err
+	}
+
+	// Stage a new users directory without the previous bundles
+	usersDir, err := staging.NewDir("./sing-box/users", ".txt")
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		links, err := clients.userShareLinks(user)
+		if err != nil {
+			return fmt.Errorf("error building share links for user %s: %v", user.Name, err)
+		}
+
+		fileName := fmt.Sprintf("%s.txt", user.Name)
+		if err := usersDir.WriteFile(fileName, []byte(jsonhandler.ShareLinkBundle(links))); err != nil {
+			log.Printf("error writing share links for user %s: %v", user.Name, err)
+			continue // Continue processing other users even if one fails
+		}
+		if !dryRun {
+			log.Printf("Generated share links file: %s", fileName)
+		}
+	}
+
+	return usersDir.Finish(dryRun)
+}
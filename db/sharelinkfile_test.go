@@ -0,0 +1,51 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"winder.website/sbfm/jsonhandler"
+)
+
+func TestShareLinksNeedRealityPublicKey(t *testing.T) {
+	dbConnection := openTestDB(t)
+	importConfig(t, dbConnection, `{"inbounds": [{
+		"type": "vless", "tag": "reality-in", "listen": "::", "listen_port": 443,
+		"users": [{"name": "alice", "uuid": "0b6ea4a8-3c42-4c54-9d5c-1d2f8d5a6a01", "flow": "xtls-rprx-vision"}],
+		"tls": {"enabled": true, "server_name": "www.example.com", "reality": {
+			"enabled": true,
+			"handshake": {"server": "www.example.com", "server_port": 443},
+			"private_key": "PH7uqe8UEqAyafcSwrvl3SaCL-DVkHuheJmkKs8w1Dg",
+			"short_id": ["6ba85179"]
+		}}
+	}]}`)
+	if err := SetSetting(dbConnection, SettingPublicHost, "vpn.example.com"); err != nil {
+		t.Fatalf("SetSetting: %v", err)
+	}
+	user, err := GetUser(dbConnection, 1)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+
+	links, err := UserShareLinks(dbConnection, user)
+	if err != nil {
+		t.Fatalf("UserShareLinks: %v", err)
+	}
+	if len(links) != 1 || !strings.Contains(links[0], "pbk=") {
+		t.Errorf("links = %q, want one link with the public key", links)
+	}
+
+	if _, err := dbConnection.Exec("UPDATE reality SET public_key = ''"); err != nil {
+		t.Fatalf("error clearing public key: %v", err)
+	}
+	if _, err := UserShareLinks(dbConnection, user); !errors.Is(err, jsonhandler.ErrNoRealityPublicKey) {
+		t.Errorf("UserShareLinks = %v, want ErrNoRealityPublicKey", err)
+	}
+	if _, err := UserClashProfile(dbConnection, user); !errors.Is(err, jsonhandler.ErrNoRealityPublicKey) {
+		t.Errorf("UserClashProfile = %v, want ErrNoRealityPublicKey", err)
+	}
+	if _, err := UserClientProfile(dbConnection, user); !errors.Is(err, jsonhandler.ErrNoRealityPublicKey) {
+		t.Errorf("UserClientProfile = %v, want ErrNoRealityPublicKey", err)
+	}
+}
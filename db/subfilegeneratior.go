@@ -4,39 +4,20 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
 
 	"winder.website/sbfm/jsonhandler"
+	"winder.website/sbfm/staging"
 )
 
 // GenerateUserConfigFiles generates configuration files for each user based on their name and sub value
-func GenerateUserConfigFiles(dbConnection *sql.DB) error {
-	// Define the directory to store config files
-	configsDir := "./sing-box/sub"
-
-	// Step 1: Check if the configs directory exists
-	if _, err := os.Stat(configsDir); !os.IsNotExist(err) {
-		// Step 2: If it exists, remove all contents
-		files, err := os.ReadDir(configsDir)
-		if err != nil {
-			return fmt.Errorf("error reading configs directory: %v", err)
-		}
-
-		for _, file := range files {
-			err := os.Remove(filepath.Join(configsDir, file.Name()))
-			if err != nil {
-				log.Printf("error deleting file %s: %v", file.Name(), err)
-			}
-		}
-	}
-
-	// Step 3: Create the configs directory (if it doesn't exist)
-	if err := os.MkdirAll(configsDir, os.ModePerm); err != nil {
-		return fmt.Errorf("error creating configs directory: %v", err)
+func GenerateUserConfigFiles(dbConnection *sql.DB, dryRun bool) error {
+	// Step 1: Stage a new configs directory, replacing all previous contents
+	configsDir, err := staging.NewDir("./sing-box/sub", "")
+	if err != nil {
+		return err
 	}
 
-	// Step 4: Query all users from the database, including the sub field
+	// Step 2: Query all users from the database, including the sub field
 	rows, err := dbConnection.Query(`SELECT uuid, name, sub FROM users WHERE active = TRUE`)
 	if err != nil {
 		return fmt.Errorf("error querying users table: %v", err)
@@ -52,21 +33,23 @@ func GenerateUserConfigFiles(dbConnection *sql.DB) error {
 		users = append(users, user)
 	}
 
-	// Step 5: Generate config files for each user
+	// Step 3: Generate config files for each user
 	for _, user := range users {
 		// Define the content for the configuration file
 		content := fmt.Sprintf(`location /sub/%s {
     alias /etc/sing-box/users/%s.json;
 }`, user.SUB, user.Name)
 
-		// Step 6: Write the content to a new file in the configs directory
-		fileName := filepath.Join(configsDir, fmt.Sprintf("%s", user.Name))
-		if err := os.WriteFile(fileName, []byte(content), 0o644); err != nil {
+		// Step 4: Write the content to a new file in the staged configs directory
+		fileName := user.Name
+		if err := configsDir.WriteFile(fileName, []byte(content)); err != nil {
 			log.Printf("error writing config file for user %s: %v", user.Name, err)
 			continue // Continue processing other users even if one fails
 		}
-		log.Printf("Generated config file: %s", fileName)
+		if !dryRun {
+			log.Printf("Generated config file: %s", fileName)
+		}
 	}
 
-	return nil
+	return configsDir.Finish(dryRun)
 }
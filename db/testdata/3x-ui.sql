@@ -0,0 +1,24 @@
+-- A 3x-ui database with a reality inbound, an inbound written by the
+-- original x-ui, whose clients have no enable key, and a vmess inbound.
+CREATE TABLE `inbounds` (`id` integer,`user_id` integer,`up` integer,`down` integer,`total` integer,`remark` text,`enable` numeric,`expiry_time` integer,`listen` text,`port` integer,`protocol` text,`settings` text,`stream_settings` text,`tag` text,`sniffing` text,PRIMARY KEY (`id`),CONSTRAINT `uni_inbounds_port` UNIQUE (`port`),CONSTRAINT `uni_inbounds_tag` UNIQUE (`tag`));
+CREATE TABLE `client_traffics` (`id` integer,`inbound_id` integer,`enable` numeric,`email` text,`up` integer,`down` integer,`expiry_time` integer,`total` integer,PRIMARY KEY (`id`),CONSTRAINT `uni_client_traffics_email` UNIQUE (`email`));
+
+INSERT INTO inbounds VALUES (1, 1, 0, 0, 0, 'reality', 1, 0, '', 443, 'vless',
+'{"clients":[{"id":"0b6ea4a8-3c42-4c54-9d5c-1d2f8d5a6a01","flow":"xtls-rprx-vision","email":"alice","limitIp":0,"totalGB":10737418240,"expiryTime":4102444800000,"enable":true,"tgId":"","subId":"a1","reset":0},{"id":"0b6ea4a8-3c42-4c54-9d5c-1d2f8d5a6a02","flow":"xtls-rprx-vision","email":"bob","limitIp":0,"totalGB":0,"expiryTime":0,"enable":false,"tgId":"","subId":"b2","reset":0},{"id":"0b6ea4a8-3c42-4c54-9d5c-1d2f8d5a6a03","flow":"xtls-rprx-vision","email":"carol","limitIp":0,"totalGB":0,"expiryTime":0,"enable":true,"tgId":"","subId":"c3","reset":0}],"decryption":"none","fallbacks":[]}',
+'{"network":"tcp","security":"reality","externalProxy":[],"realitySettings":{"show":false,"xver":0,"dest":"www.example.com:443","serverNames":["www.example.com"],"privateKey":"PH7uqe8UEqAyafcSwrvl3SaCL-DVkHuheJmkKs8w1Dg","minClient":"","maxClient":"","maxTimediff":0,"shortIds":["","6ba85179"]},"tcpSettings":{"acceptProxyProtocol":false,"header":{"type":"none"}}}',
+'inbound-443', '{"enabled":true,"destOverride":["http","tls"]}');
+
+INSERT INTO inbounds VALUES (2, 1, 0, 0, 0, 'ws', 1, 0, '', 8443, 'vless',
+'{"clients":[{"id":"0b6ea4a8-3c42-4c54-9d5c-1d2f8d5a6a04","flow":"","email":"dave"}],"decryption":"none","fallbacks":[]}',
+'{"network":"ws","security":"tls","tlsSettings":{"serverName":"vpn.example.com","certificates":[{"certificateFile":"/etc/ssl/vpn.crt","keyFile":"/etc/ssl/vpn.key"}]},"wsSettings":{"path":"/ws","headers":{}}}',
+'inbound-8443', '{"enabled":false,"destOverride":["http","tls"]}');
+
+INSERT INTO inbounds VALUES (3, 1, 0, 0, 0, 'vmess', 1, 0, '', 10086, 'vmess',
+'{"clients":[{"id":"0b6ea4a8-3c42-4c54-9d5c-1d2f8d5a6a05","alterId":0,"email":"erin"}],"disableInsecureEncryption":false}',
+'{"network":"tcp","security":"none","tcpSettings":{"header":{"type":"none"}}}',
+'inbound-10086', '{"enabled":true,"destOverride":["http","tls"]}');
+
+INSERT INTO client_traffics VALUES (1, 1, 1, 'alice', 0, 0, 4102444800000, 10737418240);
+INSERT INTO client_traffics VALUES (2, 1, 0, 'bob', 0, 0, 0, 0);
+INSERT INTO client_traffics VALUES (3, 1, 0, 'carol', 0, 0, 0, 0);
+INSERT INTO client_traffics VALUES (4, 2, 1, 'dave', 0, 0, 0, 0);
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"winder.website/sbfm/stats"
+)
+
+// SettingStatsAPIListen is the address the V2Ray stats API of sing-box
+// listens on, e.g. 127.0.0.1:10085. Empty leaves the API and per-user
+// traffic accounting off. sing-box has to be built with the with_v2ray_api
+// tag to accept it.
+const SettingStatsAPIListen = "stats_api_listen"
+
+// trafficDayFormat is the format of the day column of user_traffic
+const trafficDayFormat = "2006-01-02"
+
+// validStatsAPIListen accepts a host:port address or an empty one
+func validStatsAPIListen(value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, err := stats.DialAddress(value); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DialStatsAPI connects to the stats API at the address of the
+// stats_api_listen setting
+func DialStatsAPI(dbConnection *sql.DB) (*stats.Client, error) {
+	listen, err := GetSetting(dbConnection, SettingStatsAPIListen)
+	if err != nil {
+		return nil, err
+	}
+	if listen == "" {
+		return nil, fmt.Errorf("the stats API is off, set %s first", SettingStatsAPIListen)
+	}
+	address, err := stats.DialAddress(listen)
+	if err != nil {
+		return nil, err
+	}
+	return stats.Dial(address)
+}
+
+// CollectTraffic reads and resets the traffic counters of querier and adds
+// them to the traffic of each user on the day of now. Resetting on every
+// read makes each read the traffic since the previous one, so a sing-box
+// restart, which starts the counters over, only loses what was not read
+// before it. Counters of names that are not in the users table are dropped.
+// It returns the number of users that had traffic.
+func CollectTraffic(ctx context.Context, dbConnection *sql.DB, querier stats.Querier, now time.Time) (int, error) {
+	traffic, err := querier.QueryUserTraffic(ctx, true)
+	if err != nil {
+		return 0, err
+	}
+
+	counted, err := addTraffic(dbConnection, traffic, now.Format(trafficDayFormat))
+	if err != nil {
+		// The counters are reset already, keep a trace of what is lost
+		for name, t := range traffic {
+			log.Printf("Traffic of %s not saved: %d bytes up, %d bytes down", name, t.Uplink, t.Downlink)
+		}
+		return 0, err
+	}
+	return counted, nil
+}
+
+// addTraffic adds traffic, by user name, to the user_traffic rows of day
+func addTraffic(dbConnection *sql.DB, traffic map[string]stats.Traffic, day string) (int, error) {
+	tx, err := dbConnection.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	counted := 0
+	for name, t := range traffic {
+		if t.Uplink == 0 && t.Downlink == 0 {
+			continue
+		}
+
+		var userID int
+		err := tx.QueryRow("SELECT id FROM users WHERE name = ?", name).Scan(&userID)
+		if err == sql.ErrNoRows {
+			log.Printf("Dropping traffic of unknown user %s", name)
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error querying users table: %v", err)
+		}
+
+		_, err = tx.Exec(
+			`INSERT INTO user_traffic (user_id, day, upload, download) VALUES (?, ?, ?, ?)
+			ON CONFLICT(user_id, day) DO UPDATE SET
+				upload = upload + excluded.upload, download = download + excluded.download`,
+			userID, day, t.Uplink, t.Downlink,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("error saving traffic of %s: %v", name, err)
+		}
+		counted++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing traffic: %v", err)
+	}
+	return counted, nil
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. 1.5 GiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	value := float64(n) / unit
+	suffixes := "KMGTPE"
+	i := 0
+	for value >= unit && i < len(suffixes)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, suffixes[i])
+}
+
+// PrintTrafficUsage prints the traffic of every user from the day of from
+// through the day of to
+func PrintTrafficUsage(dbConnection *sql.DB, from, to time.Time) error {
+	rows, err := dbConnection.Query(`
+		SELECT users.id, users.name, COALESCE(SUM(t.upload), 0), COALESCE(SUM(t.download), 0)
+		FROM users
+		LEFT JOIN user_traffic t ON t.user_id = users.id AND t.day BETWEEN ? AND ?
+		GROUP BY users.id
+		ORDER BY users.id`,
+		from.Format(trafficDayFormat), to.Format(trafficDayFormat),
+	)
+	if err != nil {
+		return fmt.Errorf("error querying user_traffic table: %v", err)
+	}
+	defer rows.Close()
+
+	fmt.Printf("Traffic from %s to %s:\n", from.Format(trafficDayFormat), to.Format(trafficDayFormat))
+	fmt.Println("ID\tName\tUpload\tDownload\tTotal")
+	for rows.Next() {
+		var id int
+		var name string
+		var upload, download int64
+		if err := rows.Scan(&id, &name, &upload, &download); err != nil {
+			return fmt.Errorf("error scanning traffic row: %v", err)
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\n", id, name, formatBytes(upload), formatBytes(download), formatBytes(upload+download))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading traffic: %v", err)
+	}
+	return nil
+}
+
+// PrintUserTraffic prints the traffic of the user with the given ID per day
+// from the day of from through the day of to
+func PrintUserTraffic(dbConnection *sql.DB, userID int, from, to time.Time) error {
+	var name string
+	if err := dbConnection.QueryRow("SELECT name FROM users WHERE id = ?", userID).Scan(&name); err != nil {
+		return notFound(err, "user", userID)
+	}
+
+	rows, err := dbConnection.Query(
+		`SELECT day, upload, download FROM user_traffic
+		WHERE user_id = ? AND day BETWEEN ? AND ?
+		ORDER BY day`,
+		userID, from.Format(trafficDayFormat), to.Format(trafficDayFormat),
+	)
+	if err != nil {
+		return fmt.Errorf("error querying user_traffic table: %v", err)
+	}
+	defer rows.Close()
+
+	fmt.Printf("Traffic of %s from %s to %s:\n", name, from.Format(trafficDayFormat), to.Format(trafficDayFormat))
+	fmt.Println("Day\tUpload\tDownload\tTotal")
+	var totalUpload, totalDownload int64
+	for rows.Next() {
+		var day string
+		var upload, download int64
+		if err := rows.Scan(&day, &upload, &download); err != nil {
+			return fmt.Errorf("error scanning traffic row: %v", err)
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", day, formatBytes(upload), formatBytes(download), formatBytes(upload+download))
+		totalUpload += upload
+		totalDownload += download
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading traffic: %v", err)
+	}
+	fmt.Printf("total\t%s\t%s\t%s\n", formatBytes(totalUpload), formatBytes(totalDownload), formatBytes(totalUpload+totalDownload))
+	return nil
+}
+
+func createUserTraffic(tx *sql.Tx) error {
+	// day is YYYY-MM-DD in local time, upload and download are bytes
+	_, err := tx.Exec(`
+		CREATE TABLE user_traffic (
+			user_id INTEGER NOT NULL,
+			day TEXT NOT NULL,
+			upload INTEGER NOT NULL DEFAULT 0,
+			download INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (user_id, day),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating user_traffic table: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"winder.website/sbfm/stats"
+)
+
+// openTestDB returns a migrated database in a temporary directory
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dbConnection, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "config.db"))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() { dbConnection.Close() })
+	if _, err := Migrate(dbConnection); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	return dbConnection
+}
+
+// fakeCounters keeps per-user counters the way sing-box does: they grow
+// with traffic, a query with reset sets them back to zero and a restart
+// drops them.
+type fakeCounters struct {
+	traffic map[string]stats.Traffic
+}
+
+func (fake *fakeCounters) add(user string, uplink, downlink int64) {
+	if fake.traffic == nil {
+		fake.traffic = make(map[string]stats.Traffic)
+	}
+	t := fake.traffic[user]
+	t.Uplink += uplink
+	t.Downlink += downlink
+	fake.traffic[user] = t
+}
+
+func (fake *fakeCounters) restart() {
+	fake.traffic = nil
+}
+
+func (fake *fakeCounters) QueryUserTraffic(_ context.Context, reset bool) (map[string]stats.Traffic, error) {
+	traffic := make(map[string]stats.Traffic)
+	for user, t := range fake.traffic {
+		traffic[user] = t
+		if reset {
+			fake.traffic[user] = stats.Traffic{}
+		}
+	}
+	return traffic, nil
+}
+
+// storedTraffic returns the traffic saved for the named user on day
+func storedTraffic(t *testing.T, dbConnection *sql.DB, name, day string) stats.Traffic {
+	t.Helper()
+	var traffic stats.Traffic
+	err := dbConnection.QueryRow(`
+		SELECT COALESCE(SUM(ut.upload), 0), COALESCE(SUM(ut.download), 0)
+		FROM user_traffic ut JOIN users u ON ut.user_id = u.id
+		WHERE u.name = ? AND ut.day = ?`,
+		name, day,
+	).Scan(&traffic.Uplink, &traffic.Downlink)
+	if err != nil {
+		t.Fatalf("error reading traffic of %s: %v", name, err)
+	}
+	return traffic
+}
+
+func collect(t *testing.T, dbConnection *sql.DB, querier stats.Querier, now time.Time) {
+	t.Helper()
+	if _, err := CollectTraffic(context.Background(), dbConnection, querier, now); err != nil {
+		t.Fatalf("CollectTraffic: %v", err)
+	}
+}
+
+func TestCollectTrafficAccumulatesDeltas(t *testing.T) {
+	dbConnection := openTestDB(t)
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := AddUser(dbConnection, name, nil); err != nil {
+			t.Fatalf("AddUser: %v", err)
+		}
+	}
+
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+	day := now.Format(trafficDayFormat)
+	counters := &fakeCounters{}
+
+	counters.add("alice", 100, 1000)
+	collect(t, dbConnection, counters, now)
+	counters.add("alice", 10, 20)
+	counters.add("bob", 1, 2)
+	collect(t, dbConnection, counters, now)
+	// Nothing new, nothing is counted twice
+	collect(t, dbConnection, counters, now)
+
+	if got, want := storedTraffic(t, dbConnection, "alice", day), (stats.Traffic{Uplink: 110, Downlink: 1020}); got != want {
+		t.Errorf("alice: %+v, want %+v", got, want)
+	}
+	if got, want := storedTraffic(t, dbConnection, "bob", day), (stats.Traffic{Uplink: 1, Downlink: 2}); got != want {
+		t.Errorf("bob: %+v, want %+v", got, want)
+	}
+
+	// The next day starts a new row
+	tomorrow := now.AddDate(0, 0, 1)
+	counters.add("alice", 5, 5)
+	collect(t, dbConnection, counters, tomorrow)
+	if got, want := storedTraffic(t, dbConnection, "alice", tomorrow.Format(trafficDayFormat)), (stats.Traffic{Uplink: 5, Downlink: 5}); got != want {
+		t.Errorf("alice tomorrow: %+v, want %+v", got, want)
+	}
+	if got, want := storedTraffic(t, dbConnection, "alice", day), (stats.Traffic{Uplink: 110, Downlink: 1020}); got != want {
+		t.Errorf("alice today after tomorrow: %+v, want %+v", got, want)
+	}
+}
+
+func TestCollectTrafficAfterRestart(t *testing.T) {
+	dbConnection := openTestDB(t)
+	if _, err := AddUser(dbConnection, "alice", nil); err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+	day := now.Format(trafficDayFormat)
+	counters := &fakeCounters{}
+
+	counters.add("alice", 100, 1000)
+	collect(t, dbConnection, counters, now)
+
+	// sing-box restarts: its counters start over below what was read before,
+	// which must neither be subtracted nor lose the stored traffic
+	counters.add("alice", 50, 50)
+	counters.restart()
+	counters.add("alice", 3, 4)
+	collect(t, dbConnection, counters, now)
+
+	if got, want := storedTraffic(t, dbConnection, "alice", day), (stats.Traffic{Uplink: 103, Downlink: 1004}); got != want {
+		t.Errorf("alice: %+v, want %+v", got, want)
+	}
+}
+
+func TestCollectTrafficDropsUnknownUsers(t *testing.T) {
+	dbConnection := openTestDB(t)
+	if _, err := AddUser(dbConnection, "alice", nil); err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+
+	counters := &fakeCounters{}
+	counters.add("alice", 1, 1)
+	counters.add("mallory", 9, 9)
+	counted, err := CollectTraffic(context.Background(), dbConnection, counters, time.Now())
+	if err != nil {
+		t.Fatalf("CollectTraffic: %v", err)
+	}
+	if counted != 1 {
+		t.Errorf("counted %d users, want 1", counted)
+	}
+
+	var rows int
+	if err := dbConnection.QueryRow("SELECT COUNT(*) FROM user_traffic").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Errorf("%d user_traffic rows, want 1", rows)
+	}
+}
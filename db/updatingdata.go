@@ -0,0 +1,201 @@
+// Package db handles the database
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	//go-sqlite3 is the sql driver for sqlite in go
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// checkUpdated returns an error when an UPDATE matched no row
+func checkUpdated(result sql.Result, what string, id int) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no %s found with ID %d", what, id)
+	}
+	return nil
+}
+
+// UpdateInbound overwrites the inbound with record.ID. An empty password
+// of a shadowsocks 2022 inbound is generated, as in AddInbound.
+func UpdateInbound(dbConnection *sql.DB, record InboundRecord) error {
+	if err := checkReference(dbConnection, "detour", record.Detour, "inbounds", "outbounds"); err != nil {
+		return err
+	}
+	password, err := inboundPassword(record.Type, record.Method, record.Password)
+	if err != nil {
+		return err
+	}
+	transportType, err := inboundTransportType(dbConnection, record.TransportID)
+	if err != nil {
+		return err
+	}
+	if err := checkFlow(record.Type, record.Flow, transportType); err != nil {
+		return err
+	}
+
+	result, err := dbConnection.Exec(
+		`
+	UPDATE inbounds SET type = ?, tag = ?, listen = ?, listen_port = ?, sniff = ?, sniff_override_destination = ?, sniff_timeout = ?, detour = ?, method = ?, password = ?, flow = ?, transport_id = ?, tls_id = ?, reality_id = ?, handshake_id = ?
+	WHERE id = ?`,
+		record.Type,
+		record.Tag,
+		record.Listen,
+		record.ListenPort,
+		record.Sniff,
+		record.SniffOverrideDestination,
+		record.SniffTimeout,
+		record.Detour,
+		record.Method,
+		password,
+		record.Flow,
+		record.TransportID,
+		record.TLSID,
+		record.RealityID,
+		record.HandshakeID,
+		record.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating inbound: %v", err)
+	}
+	if err := checkUpdated(result, "inbound", record.ID); err != nil {
+		return err
+	}
+
+	// A new Reality configuration may hand out per-user short_ids
+	_, err = AssignUserShortIDs(dbConnection)
+	return err
+}
+
+// UpdateTransport overwrites the transport with record.ID
+func UpdateTransport(dbConnection *sql.DB, record TransportRecord) error {
+	// The flow of an inbound using the transport has to keep working
+	var tag, flow string
+	err := dbConnection.QueryRow(
+		`SELECT tag, flow FROM inbounds WHERE transport_id = ? AND flow != '' LIMIT 1`, record.ID,
+	).Scan(&tag, &flow)
+	switch {
+	case err == nil:
+		if err := checkFlow("vless", flow, record.Type); err != nil {
+			return fmt.Errorf("inbound %s: %v", tag, err)
+		}
+	case err != sql.ErrNoRows:
+		return fmt.Errorf("error querying inbounds table: %v", err)
+	}
+
+	result, err := dbConnection.Exec(
+		`UPDATE transports SET type = ?, path = ? WHERE id = ?`,
+		record.Type, record.Path, record.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating transport: %v", err)
+	}
+	return checkUpdated(result, "transport", record.ID)
+}
+
+// UpdateTLS overwrites the TLS configuration with record.ID
+func UpdateTLS(dbConnection *sql.DB, record TLSRecord) error {
+	result, err := dbConnection.Exec(
+		`
+	UPDATE tls SET enabled = ?, server_name = ?, min_version = ?, max_version = ?, certificate_path = ?, key_path = ?
+	WHERE id = ?`,
+		record.Enabled,
+		record.ServerName,
+		record.MinVersion,
+		record.MaxVersion,
+		record.CertificatePath,
+		record.KeyPath,
+		record.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating tls: %v", err)
+	}
+	return checkUpdated(result, "TLS configuration", record.ID)
+}
+
+// UpdateReality overwrites the Reality configuration with record.ID, including
+// its shared short_ids and server names. The public key is always derived
+// again from the private key.
+func UpdateReality(dbConnection *sql.DB, record RealityRecord) error {
+	publicKey, err := RealityPublicKey(record.PrivateKey)
+	if err != nil {
+		return err
+	}
+	if err := checkRealityShortIDs(record.Enabled, record.PerUserShortIDs, record.ShortIDs); err != nil {
+		return err
+	}
+
+	tx, err := dbConnection.Begin()
+	if err != nil {
+		return fmt.Errorf("error updating reality: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`UPDATE reality SET enabled = ?, private_key = ?, public_key = ?, per_user_short_ids = ? WHERE id = ?`,
+		record.Enabled, record.PrivateKey, publicKey, record.PerUserShortIDs, record.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating reality: %v", err)
+	}
+	if err := checkUpdated(result, "Reality configuration", record.ID); err != nil {
+		return err
+	}
+
+	if err := replaceRealityLists(tx, int64(record.ID), record.ShortIDs, record.ServerNames); err != nil {
+		return err
+	}
+	if !record.PerUserShortIDs {
+		if _, err := tx.Exec(`DELETE FROM reality_short_ids WHERE reality_id = ? AND user_id IS NOT NULL`, record.ID); err != nil {
+			return fmt.Errorf("error deleting per-user short_ids: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error updating reality: %v", err)
+	}
+	_, err = AssignUserShortIDs(dbConnection)
+	return err
+}
+
+// UpdateHandshake overwrites the Handshake configuration with record.ID
+func UpdateHandshake(dbConnection *sql.DB, record HandshakeRecord) error {
+	result, err := dbConnection.Exec(
+		`UPDATE handshake SET server = ?, server_port = ? WHERE id = ?`,
+		record.Server, record.ServerPort, record.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating handshake: %v", err)
+	}
+	return checkUpdated(result, "Handshake configuration", record.ID)
+}
+
+// UpdateOutbound overwrites the outbound with record.ID
+func UpdateOutbound(dbConnection *sql.DB, record OutboundRecord) error {
+	if err := ValidateOutbound(record); err != nil {
+		return err
+	}
+	if err := checkReference(dbConnection, "detour", record.Detour, "outbounds"); err != nil {
+		return err
+	}
+
+	result, err := dbConnection.Exec(
+		`
+	UPDATE outbounds SET
+		type = ?, tag = ?, server = ?, server_port = ?, version = ?, username = ?, password = ?, method = ?, uuid = ?, flow = ?,
+		tls_enabled = ?, tls_server_name = ?, tls_insecure = ?, utls_fingerprint = ?, reality_public_key = ?, reality_short_id = ?, transport_id = ?,
+		local_address = ?, private_key = ?, peer_public_key = ?, pre_shared_key = ?, reserved = ?, mtu = ?,
+		detour = ?, bind_interface = ?, routing_mark = ?, domain_strategy = ?
+	WHERE id = ?`,
+		append(outboundValues(record), record.ID)...,
+	)
+	if err != nil {
+		return fmt.Errorf("error updating outbound: %v", err)
+	}
+	return checkUpdated(result, "outbound", record.ID)
+}
@@ -0,0 +1,171 @@
+// Package db handles the database
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	// go-sqlite3 is the sql driver for sqlite in go
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// inboundIDByTag looks up the ID of the inbound with the given tag.
+func inboundIDByTag(db *sql.DB, tag string) (int, error) {
+	var id int
+	err := db.QueryRow("SELECT id FROM inbounds WHERE tag = ?", tag).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no inbound found with tag %q", tag)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error querying inbounds table: %v", err)
+	}
+	return id, nil
+}
+
+// userExists returns an error when there is no user with the given ID.
+func userExists(db *sql.DB, userID int) error {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", userID).Scan(&count); err != nil {
+		return fmt.Errorf("error querying users table: %v", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("no user found with ID %d", userID)
+	}
+	return nil
+}
+
+// grantDefaultInbounds grants a freshly created user the inbounds selected by
+// the new_user_inbounds setting.
+func grantDefaultInbounds(db *sql.DB, userID int64) error {
+	mode, err := GetSetting(db, SettingNewUserInbounds)
+	if err != nil {
+		return err
+	}
+	if mode != NewUserInboundsAll {
+		return nil
+	}
+
+	_, err = db.Exec(
+		"INSERT OR IGNORE INTO user_inbounds (user_id, inbound_id) SELECT ?, id FROM inbounds",
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("error granting default inbounds: %v", err)
+	}
+	_, err = AssignUserShortIDs(db)
+	return err
+}
+
+// GrantUserInbound gives the user access to the inbound with the given tag
+func GrantUserInbound(db *sql.DB, userID int, tag string) error {
+	if err := userExists(db, userID); err != nil {
+		return err
+	}
+	inboundID, err := inboundIDByTag(db, tag)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		"INSERT OR IGNORE INTO user_inbounds (user_id, inbound_id) VALUES (?, ?)",
+		userID, inboundID,
+	)
+	if err != nil {
+		return fmt.Errorf("error granting inbound: %v", err)
+	}
+	_, err = AssignUserShortIDs(db)
+	return err
+}
+
+// RevokeUserInbound removes the user's access to the inbound with the given tag
+func RevokeUserInbound(db *sql.DB, userID int, tag string) error {
+	inboundID, err := inboundIDByTag(db, tag)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(
+		"DELETE FROM user_inbounds WHERE user_id = ? AND inbound_id = ?",
+		userID, inboundID,
+	)
+	if err != nil {
+		return fmt.Errorf("error revoking inbound: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("user %d has no access to inbound %q", userID, tag)
+	}
+	return nil
+}
+
+// PrintUserInbounds prints the inbounds the user has been granted
+func PrintUserInbounds(db *sql.DB, userID int) error {
+	if err := userExists(db, userID); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`
+		SELECT i.id, i.tag, i.type, i.listen_port
+		FROM user_inbounds ui
+		JOIN inbounds i ON i.id = ui.inbound_id
+		WHERE ui.user_id = ?
+		ORDER BY i.id`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("error querying user_inbounds table: %v", err)
+	}
+	defer rows.Close()
+
+	fmt.Printf("Inbounds of user %d:\n", userID)
+	fmt.Println("ID\tTag\tType\tListenPort")
+	for rows.Next() {
+		var id, listenPort int
+		var tag, inboundType string
+		if err := rows.Scan(&id, &tag, &inboundType, &listenPort); err != nil {
+			return fmt.Errorf("error scanning user inbound row: %v", err)
+		}
+		fmt.Printf("%d\t%s\t%s\t%d\n", id, tag, inboundType, listenPort)
+	}
+	return rows.Err()
+}
+
+// createUserInbounds adds the user_inbounds join table and the settings table.
+// Existing users keep access to every existing inbound, as before.
+func createUserInbounds(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE user_inbounds (
+			user_id INTEGER NOT NULL,
+			inbound_id INTEGER NOT NULL,
+			PRIMARY KEY (user_id, inbound_id),
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (inbound_id) REFERENCES inbounds(id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating user_inbounds table: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating settings table: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_inbounds (user_id, inbound_id)
+		SELECT u.id, i.id FROM users u CROSS JOIN inbounds i
+	`)
+	if err != nil {
+		return fmt.Errorf("error granting existing users their inbounds: %v", err)
+	}
+	return nil
+}
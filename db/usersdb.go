@@ -6,9 +6,12 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"winder.website/sbfm/jsonhandler"
@@ -28,100 +31,234 @@ func generateRandomString(length int) (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-// AddUserManually is responsible for adding a user to the database duh
-func AddUserManually(db *sql.DB) {
-	var name string
-	var active bool
-	fmt.Print("Enter user name: ")
-	fmt.Scanln(&name)
+// ErrUserNotFound is returned when no active user matches a lookup
+var ErrUserNotFound = errors.New("user not found")
 
-	uuid := uuid.New().String()
+// GetActiveUserBySub returns the active user owning the given sub token
+func GetActiveUserBySub(db *sql.DB, sub string) (jsonhandler.User, error) {
+	user := jsonhandler.User{SUB: sub}
+	err := db.QueryRow(
+		"SELECT name, uuid, password, ss_key, active FROM users WHERE sub = ? AND active = TRUE", sub,
+	).Scan(&user.Name, &user.UUID, &user.Password, &user.Key, &user.Active)
+	if err == sql.ErrNoRows {
+		return jsonhandler.User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return jsonhandler.User{}, fmt.Errorf("error querying users table: %v", err)
+	}
+	return user, nil
+}
+
+// AddUser inserts a new active user with a fresh uuid, sub token, password
+// and shadowsocks key and returns it.
+// A nil expiresAt means the user never expires.
+func AddUser(db *sql.DB, name string, expiresAt *time.Time) (jsonhandler.User, error) {
+	if err := checkUserName(db, name); err != nil {
+		return jsonhandler.User{}, err
+	}
 
 	sub, err := generateRandomString(50)
 	if err != nil {
-		fmt.Println("Error:", err)
+		return jsonhandler.User{}, fmt.Errorf("error generating sub token: %v", err)
+	}
+
+	password, ssKey, err := newUserCredentials()
+	if err != nil {
+		return jsonhandler.User{}, err
+	}
+
+	user := jsonhandler.User{
+		Name:     name,
+		UUID:     uuid.New().String(),
+		Password: password,
+		SUB:      sub,
+		Active:   true,
+		Key:      ssKey,
 	}
 
-	active = true
+	var expiry interface{}
+	if expiresAt != nil {
+		expiry = expiresAt.Unix()
+	}
 
-	_, err = db.Exec(
-		"INSERT INTO users (name, uuid, sub, active) VALUES (?, ?, ?, ?)",
-		name,
-		uuid,
-		sub,
-		active,
+	result, err := db.Exec(
+		"INSERT INTO users (name, uuid, password, ss_key, sub, active, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		user.Name,
+		user.UUID,
+		user.Password,
+		user.Key,
+		user.SUB,
+		user.Active,
+		expiry,
 	)
 	if err != nil {
-		log.Printf("Error adding user: %v", err)
-		return
+		return jsonhandler.User{}, fmt.Errorf("error adding user: %v", err)
+	}
+
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return jsonhandler.User{}, fmt.Errorf("error getting user ID: %v", err)
+	}
+	if err := grantDefaultInbounds(db, userID); err != nil {
+		return jsonhandler.User{}, err
 	}
 
-	fmt.Printf("User added successfully. UUID: %s\n", uuid)
+	return user, nil
 }
 
-// AddUsersFromJSON is responsible for adding multiple users from a json file
-func AddUsersFromJSON(db *sql.DB) {
-	fmt.Print("Enter JSON file name: ")
-	var filename string
-	fmt.Scanln(&filename)
+// AddUserManually is responsible for adding a user to the database duh
+func AddUserManually(db *sql.DB) {
+	var name string
+	var days int
+	fmt.Print("Enter user name: ")
+	fmt.Scanln(&name)
+	fmt.Print("Enter the number of days until the user expires (0 for never): ")
+	fmt.Scanln(&days)
 
-	data, err := os.ReadFile(filename)
+	var expiresAt *time.Time
+	if days > 0 {
+		expiry := time.Now().AddDate(0, 0, days)
+		expiresAt = &expiry
+	}
+
+	user, err := AddUser(db, name, expiresAt)
 	if err != nil {
-		log.Printf("Error reading file: %v", err)
+		log.Println(err)
 		return
 	}
 
+	fmt.Printf("User added successfully. UUID: %s, password: %s\n", user.UUID, user.Password)
+}
+
+// ImportUsersFromJSON adds every user listed in the given json file. Users
+// without a password get a generated one, and every user a fresh
+// shadowsocks key.
+func ImportUsersFromJSON(db *sql.DB, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("error reading file: %v", err)
+	}
+
 	var users []jsonhandler.User
 	err = json.Unmarshal(data, &users)
 	if err != nil {
-		log.Printf("Error parsing JSON: %v", err)
-		return
+		return fmt.Errorf("error parsing JSON: %v", err)
 	}
 
+	var failed int
 	for _, user := range users {
-		_, err := db.Exec(
-			"INSERT INTO users (name, uuid, sub, active) VALUES (?, ?, ?, ?)",
+		if err := checkUserName(db, user.Name); err != nil {
+			log.Printf("Error adding user %s: %v", user.Name, err)
+			failed++
+			continue
+		}
+		password, ssKey, err := newUserCredentials()
+		if err != nil {
+			return err
+		}
+		if user.Password != "" {
+			password = user.Password
+		}
+
+		result, err := db.Exec(
+			"INSERT INTO users (name, uuid, password, ss_key, sub, active) VALUES (?, ?, ?, ?, ?, ?)",
 			user.Name,
 			user.UUID,
+			password,
+			ssKey,
 			user.SUB,
 			user.Active,
 		)
+		if err == nil {
+			var userID int64
+			userID, err = result.LastInsertId()
+			if err == nil {
+				err = grantDefaultInbounds(db, userID)
+			}
+		}
 		if err != nil {
 			log.Printf("Error adding user %s: %v", user.Name, err)
+			failed++
 		} else {
 			fmt.Printf("User %s added successfully\n", user.Name)
 		}
 	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d users could not be added", failed, len(users))
+	}
+	return nil
+}
+
+// AddUsersFromJSON is responsible for adding multiple users from a json file
+func AddUsersFromJSON(db *sql.DB) {
+	fmt.Print("Enter JSON file name: ")
+	var filename string
+	fmt.Scanln(&filename)
+
+	if err := ImportUsersFromJSON(db, filename); err != nil {
+		log.Println(err)
+	}
 }
 
 // PrintAllUsers is responsible for fetching and printing all the users in the users table
-func PrintAllUsers(db *sql.DB) {
-	rows, err := db.Query("SELECT id, name, uuid, sub, active FROM users")
+func PrintAllUsers(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, name, uuid, sub, active, expires_at FROM users")
 	if err != nil {
-		log.Printf("Error querying users: %v", err)
-		return
+		return fmt.Errorf("error querying users: %v", err)
 	}
 	defer rows.Close()
 
 	fmt.Println("\nAll Users:")
-	fmt.Println("ID.Name.UUID.Sub.Active")
+	fmt.Println("ID.Name.UUID.Sub.Active.DaysLeft")
 	fmt.Println("--------------------")
 
+	now := time.Now()
 	for rows.Next() {
 		var id int
 		var name, uuid, sub string
 		var active bool
-		err := rows.Scan(&id, &name, &uuid, &sub, &active)
+		var expiresAt sql.NullInt64
+		err := rows.Scan(&id, &name, &uuid, &sub, &active, &expiresAt)
 		if err != nil {
 			log.Printf("Error scanning row: %v", err)
 			continue
 		}
-		fmt.Printf("%d.%s.%s.%s.%v\n", id, name, uuid, sub, active)
+		fmt.Printf("%d.%s.%s.%s.%v.%s\n", id, name, uuid, sub, active, daysLeft(expiresAt, now))
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Printf("Error iterating rows: %v", err)
+		return fmt.Errorf("error iterating rows: %v", err)
+	}
+	return nil
+}
+
+// DeleteUser deletes the user with the given ID
+func DeleteUser(db *sql.DB, id int) error {
+	result, err := db.Exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("error deleting user: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("no user found with ID %d", id)
 	}
+
+	if _, err := db.Exec("DELETE FROM user_inbounds WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("error deleting user inbounds: %v", err)
+	}
+	if _, err := db.Exec("DELETE FROM reality_short_ids WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("error deleting user short_ids: %v", err)
+	}
+	if _, err := db.Exec("DELETE FROM user_traffic WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("error deleting user traffic: %v", err)
+	}
+	return nil
 }
 
 // DeleteUserByID is responsible for what ever the name says idiot
@@ -134,23 +271,30 @@ func DeleteUserByID(db *sql.DB) {
 		return
 	}
 
-	result, err := db.Exec("DELETE FROM users WHERE id = ?", id)
-	if err != nil {
-		log.Printf("Error deleting user: %v", err)
+	if err := DeleteUser(db, id); err != nil {
+		log.Println(err)
 		return
 	}
 
+	fmt.Printf("User with ID %d deleted successfully\n", id)
+}
+
+// SetUserActive sets the active status of the user with the given ID
+func SetUserActive(db *sql.DB, id int, active bool) error {
+	result, err := db.Exec("UPDATE users SET active = ? WHERE id = ?", active, id)
+	if err != nil {
+		return fmt.Errorf("error updating user status: %v", err)
+	}
+
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		log.Printf("Error getting rows affected: %v", err)
-		return
+		return fmt.Errorf("error getting rows affected: %v", err)
 	}
 
 	if rowsAffected == 0 {
-		fmt.Printf("No user found with ID %d\n", id)
-	} else {
-		fmt.Printf("User with ID %d deleted successfully\n", id)
+		return fmt.Errorf("no user found with ID %d", id)
 	}
+	return nil
 }
 
 // ToggleUserActiveStatus toggles the active status of a user by their ID
@@ -173,9 +317,8 @@ func ToggleUserActiveStatus(db *sql.DB) {
 	}
 
 	// Update the active status in the database
-	_, err = db.Exec("UPDATE users SET active = ? WHERE id = ?", activate, id)
-	if err != nil {
-		log.Printf("Error updating user status: %v", err)
+	if err := SetUserActive(db, id, activate); err != nil {
+		log.Println(err)
 		return
 	}
 
@@ -185,3 +328,89 @@ func ToggleUserActiveStatus(db *sql.DB) {
 	}
 	fmt.Printf("User with ID %d has been %s successfully\n", id, status)
 }
+
+// checkUserName refuses empty user names and names another user has. sing-box
+// reports traffic by user name, so names have to be unique.
+func checkUserName(db *sql.DB, name string) error {
+	if err := validUserName(name); err != nil {
+		return err
+	}
+	taken, err := userNameTaken(db, name)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("a user named %s already exists", name)
+	}
+	return nil
+}
+
+// validUserName refuses names that cannot name the files generated for the
+// user, such as ./sing-box/users/<name>.json
+func validUserName(name string) error {
+	if name == "" {
+		return fmt.Errorf("user name must not be empty")
+	}
+	if strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("user name %q must not contain /, \\ or ..", name)
+	}
+	return nil
+}
+
+// userNameTaken reports whether a user has the given name
+func userNameTaken(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, name string) (bool, error) {
+	var count int
+	if err := q.QueryRow("SELECT COUNT(*) FROM users WHERE name = ?", name).Scan(&count); err != nil {
+		return false, fmt.Errorf("error querying users table: %v", err)
+	}
+	return count > 0, nil
+}
+
+func uniqueUserNames(tx *sql.Tx) error {
+	// Users sharing a name keep it on the oldest one, the others get their
+	// ID appended
+	rows, err := tx.Query(`
+		SELECT id, name FROM users u
+		WHERE EXISTS (SELECT 1 FROM users o WHERE o.name = u.name AND o.id < u.id)
+		ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("error querying users table: %v", err)
+	}
+	renamed := make(map[int]string)
+	var ids []int
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning user row: %v", err)
+		}
+		renamed[id] = fmt.Sprintf("%s-%d", name, id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading users: %v", err)
+	}
+
+	for _, id := range ids {
+		taken, err := userNameTaken(tx, renamed[id])
+		if err != nil {
+			return err
+		}
+		if taken {
+			return fmt.Errorf("cannot rename duplicate user %d to %s, the name is taken", id, renamed[id])
+		}
+		if _, err := tx.Exec("UPDATE users SET name = ? WHERE id = ?", renamed[id], id); err != nil {
+			return fmt.Errorf("error renaming user %d: %v", id, err)
+		}
+		fmt.Printf("Renamed user %d to %s, another user has its name.\n", id, renamed[id])
+	}
+
+	if _, err := tx.Exec("CREATE UNIQUE INDEX users_name ON users(name)"); err != nil {
+		return fmt.Errorf("error creating users_name index: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// importConfig imports a sing-box config file holding config
+func importConfig(t *testing.T, dbConnection *sql.DB, config string) ImportReport {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(filename, []byte(config), 0o644); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+	report, err := ImportConfigFile(dbConnection, filename)
+	if err != nil {
+		t.Fatalf("ImportConfigFile: %v", err)
+	}
+	return report
+}
+
+func TestAddUserRefusesNamesThatAreNoFileNames(t *testing.T) {
+	dbConnection := openTestDB(t)
+	for _, name := range []string{"", "../alice", "alice/bob", `alice\bob`, ".."} {
+		if _, err := AddUser(dbConnection, name, nil); err == nil {
+			t.Errorf("AddUser(%q) succeeded, want an error", name)
+		}
+	}
+	if _, err := AddUser(dbConnection, "alice.smith", nil); err != nil {
+		t.Errorf("AddUser(alice.smith): %v", err)
+	}
+}
+
+func TestImportRenamesNamesThatAreNoFileNames(t *testing.T) {
+	dbConnection := openTestDB(t)
+	report := importConfig(t, dbConnection, `{"inbounds": [{
+		"type": "vless", "tag": "vless-in", "listen": "::", "listen_port": 443,
+		"users": [{"name": "../../etc/passwd", "uuid": "0b6ea4a8-3c42-4c54-9d5c-1d2f8d5a6a01"}]
+	}]}`)
+
+	var name string
+	if err := dbConnection.QueryRow("SELECT name FROM users").Scan(&name); err != nil {
+		t.Fatalf("error reading user: %v", err)
+	}
+	if name != "user-0b6ea4a8" {
+		t.Errorf("imported user named %q, want user-0b6ea4a8", name)
+	}
+	if len(report.Skipped) != 1 || !strings.Contains(report.Skipped[0], "imported as user-0b6ea4a8") {
+		t.Errorf("skipped = %q, want the rename", report.Skipped)
+	}
+}
@@ -28,7 +28,7 @@
       default = pkgs.buildGoModule {
         name = "sbfm";
         src = self;
-        vendorHash = "sha256-eRl4Tglp/q3rTu9IHvbw0IyZO5KQrEc9lBE8G8FwVGw=";
+        vendorHash = "sha256-4BtdZGNWknRGvjWK/5zFZvkXPOfXNazMUkbg1KAAQ8A=";
         subPackages = ["."];
       };
     });
@@ -0,0 +1,186 @@
+package jsonhandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ClashProxy is one entry of the proxies list of a Clash/Mihomo profile.
+type ClashProxy struct {
+	Name              string
+	Type              string
+	Server            string
+	Port              int
+	UUID              string
+	Flow              string
+	Password          string
+	Cipher            string
+	Network           string
+	TLS               bool
+	ServerName        string
+	ClientFingerprint string
+	RealityPublicKey  string
+	RealityShortID    string
+	WSPath            string
+	GRPCServiceName   string
+}
+
+// NewClashProxy builds the Clash proxy a client needs to reach inbound as
+// user, with host as the public address of the server.
+func NewClashProxy(inbound Inbound, user User, host string) (ClashProxy, error) {
+	if err := checkRealityPublicKey(inbound); err != nil {
+		return ClashProxy{}, err
+	}
+
+	proxy := ClashProxy{
+		Name:   inbound.Tag,
+		Type:   inbound.Type,
+		Server: host,
+		Port:   inbound.ListenPort,
+	}
+
+	switch inbound.Type {
+	case "vless":
+		proxy.UUID = user.UUID
+		proxy.Flow = inbound.Flow
+		proxy.Network = "tcp"
+	case "trojan":
+		if !inbound.TLS.Enabled {
+			return ClashProxy{}, fmt.Errorf("clash trojan proxies need tls")
+		}
+		proxy.Password = user.Password
+		proxy.Network = "tcp"
+	case "hysteria2":
+		proxy.Password = user.Password
+	case "tuic":
+		proxy.UUID = user.UUID
+		proxy.Password = user.Password
+	case "shadowsocks":
+		proxy.Type = "ss"
+		proxy.Cipher = inbound.Method
+		proxy.Password = ClientShadowsocksPassword(inbound, user)
+		return proxy, nil
+	default:
+		return ClashProxy{}, fmt.Errorf("clash proxies are not supported for %s inbounds", inbound.Type)
+	}
+
+	if inbound.TLS.Enabled || inbound.TLS.Reality.Enabled {
+		proxy.TLS = true
+		proxy.ServerName = ClientServerName(inbound)
+	}
+	if inbound.TLS.Reality.Enabled {
+		proxy.ClientFingerprint = "chrome"
+		proxy.RealityPublicKey = inbound.TLS.Reality.PublicKey
+		proxy.RealityShortID = ClientShortID(inbound, user)
+	}
+
+	switch inbound.Transport.Type {
+	case "":
+	case "ws":
+		proxy.Network = "ws"
+		proxy.WSPath = inbound.Transport.Path
+	case "grpc":
+		proxy.Network = "grpc"
+		proxy.GRPCServiceName = inbound.Transport.ServiceName
+	default:
+		return ClashProxy{}, fmt.Errorf("clash proxies are not supported for the %s transport", inbound.Transport.Type)
+	}
+
+	return proxy, nil
+}
+
+// ClashProfile renders a complete Clash/Mihomo profile with the given
+// proxies, a selector group over them and a catch-all rule.
+func ClashProfile(proxies []ClashProxy) []byte {
+	var b strings.Builder
+
+	b.WriteString("mixed-port: 7890\n")
+	b.WriteString("allow-lan: false\n")
+	b.WriteString("mode: rule\n")
+	b.WriteString("log-level: info\n")
+
+	if len(proxies) == 0 {
+		b.WriteString("proxies: []\n")
+	} else {
+		b.WriteString("proxies:\n")
+	}
+	for _, proxy := range proxies {
+		writeClashProxy(&b, proxy)
+	}
+
+	b.WriteString("proxy-groups:\n")
+	b.WriteString("  - name: PROXY\n")
+	b.WriteString("    type: select\n")
+	b.WriteString("    proxies:\n")
+	for _, proxy := range proxies {
+		fmt.Fprintf(&b, "      - %s\n", yamlString(proxy.Name))
+	}
+	b.WriteString("      - DIRECT\n")
+
+	b.WriteString("rules:\n")
+	b.WriteString("  - GEOIP,LAN,DIRECT,no-resolve\n")
+	b.WriteString("  - MATCH,PROXY\n")
+
+	return []byte(b.String())
+}
+
+// writeClashProxy appends one proxies entry. vless proxies turn TLS on with
+// tls and name the server with servername, the other types name it with sni.
+func writeClashProxy(b *strings.Builder, proxy ClashProxy) {
+	fmt.Fprintf(b, "  - name: %s\n", yamlString(proxy.Name))
+	fmt.Fprintf(b, "    type: %s\n", proxy.Type)
+	fmt.Fprintf(b, "    server: %s\n", yamlString(proxy.Server))
+	fmt.Fprintf(b, "    port: %d\n", proxy.Port)
+	if proxy.UUID != "" {
+		fmt.Fprintf(b, "    uuid: %s\n", yamlString(proxy.UUID))
+	}
+	if proxy.Flow != "" {
+		fmt.Fprintf(b, "    flow: %s\n", proxy.Flow)
+	}
+	if proxy.Cipher != "" {
+		fmt.Fprintf(b, "    cipher: %s\n", proxy.Cipher)
+	}
+	if proxy.Password != "" {
+		fmt.Fprintf(b, "    password: %s\n", yamlString(proxy.Password))
+	}
+	if proxy.Network != "" {
+		fmt.Fprintf(b, "    network: %s\n", proxy.Network)
+	}
+	b.WriteString("    udp: true\n")
+	if proxy.Type == "vless" {
+		fmt.Fprintf(b, "    tls: %t\n", proxy.TLS)
+		if proxy.ServerName != "" {
+			fmt.Fprintf(b, "    servername: %s\n", yamlString(proxy.ServerName))
+		}
+	} else if proxy.ServerName != "" {
+		fmt.Fprintf(b, "    sni: %s\n", yamlString(proxy.ServerName))
+	}
+	if proxy.ClientFingerprint != "" {
+		fmt.Fprintf(b, "    client-fingerprint: %s\n", proxy.ClientFingerprint)
+	}
+	if proxy.RealityPublicKey != "" || proxy.RealityShortID != "" {
+		b.WriteString("    reality-opts:\n")
+		if proxy.RealityPublicKey != "" {
+			fmt.Fprintf(b, "      public-key: %s\n", yamlString(proxy.RealityPublicKey))
+		}
+		if proxy.RealityShortID != "" {
+			fmt.Fprintf(b, "      short-id: %s\n", yamlString(proxy.RealityShortID))
+		}
+	}
+	switch proxy.Network {
+	case "ws":
+		b.WriteString("    ws-opts:\n")
+		fmt.Fprintf(b, "      path: %s\n", yamlString(proxy.WSPath))
+	case "grpc":
+		b.WriteString("    grpc-opts:\n")
+		fmt.Fprintf(b, "      grpc-service-name: %s\n", yamlString(proxy.GRPCServiceName))
+	}
+}
+
+// yamlString quotes s as a YAML double-quoted scalar; JSON string syntax is
+// a subset of it, so short IDs like 0123 and names like "yes" stay strings.
+func yamlString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
@@ -0,0 +1,150 @@
+package jsonhandler
+
+import "fmt"
+
+// Outbound group types for client profiles.
+const (
+	ClientGroupSelector = "selector"
+	ClientGroupURLTest  = "urltest"
+)
+
+// ClientProxyTag is the tag of the group outbound wrapping the server outbounds.
+const ClientProxyTag = "proxy"
+
+// ClientConfig is the structure of a generated sing-box client profile.
+type ClientConfig struct {
+	Log       Log             `json:"log"`
+	Inbounds  []ClientInbound `json:"inbounds"`
+	Outbounds []Outbound      `json:"outbounds"`
+	Route     Route           `json:"route"`
+}
+
+// ClientInbound is the structure of the local inbounds of a client profile.
+type ClientInbound struct {
+	Type        string   `json:"type"`
+	Tag         string   `json:"tag"`
+	Listen      string   `json:"listen,omitempty"`
+	ListenPort  int      `json:"listen_port,omitempty"`
+	Address     []string `json:"address,omitempty"`
+	AutoRoute   bool     `json:"auto_route,omitempty"`
+	StrictRoute bool     `json:"strict_route,omitempty"`
+	Stack       string   `json:"stack,omitempty"`
+}
+
+// NewClientOutbound builds the outbound a sing-box client needs to reach
+// inbound as user, with host as the public address of the server.
+func NewClientOutbound(inbound Inbound, user User, host string) (Outbound, error) {
+	if err := checkRealityPublicKey(inbound); err != nil {
+		return Outbound{}, err
+	}
+
+	outbound := Outbound{
+		Type:       inbound.Type,
+		Tag:        inbound.Tag,
+		Server:     host,
+		ServerPort: inbound.ListenPort,
+	}
+
+	switch inbound.Type {
+	case "vless":
+		outbound.UUID = user.UUID
+		outbound.Flow = inbound.Flow
+	case "trojan", "hysteria2":
+		outbound.Password = user.Password
+	case "tuic":
+		outbound.UUID = user.UUID
+		outbound.Password = user.Password
+	case "shadowsocks":
+		outbound.Method = inbound.Method
+		outbound.Password = ClientShadowsocksPassword(inbound, user)
+	default:
+		return Outbound{}, fmt.Errorf("client outbounds are not supported for %s inbounds", inbound.Type)
+	}
+
+	if inbound.TLS.Enabled || inbound.TLS.Reality.Enabled {
+		outbound.TLS = &OutboundTLS{
+			Enabled:    true,
+			ServerName: ClientServerName(inbound),
+		}
+	}
+	if inbound.TLS.Reality.Enabled {
+		// Reality requires uTLS on the client
+		outbound.TLS.UTLS = &UTLS{Enabled: true, Fingerprint: "chrome"}
+		outbound.TLS.Reality = &OutboundReality{
+			Enabled:   true,
+			PublicKey: inbound.TLS.Reality.PublicKey,
+			ShortID:   ClientShortID(inbound, user),
+		}
+	}
+
+	switch inbound.Transport.Type {
+	case "":
+	default:
+		transport := inbound.Transport
+		outbound.Transport = &transport
+	}
+
+	return outbound, nil
+}
+
+// ClientProfile wraps the server outbounds in a selector or urltest group
+// and adds the local tun and mixed inbounds a client profile needs.
+func ClientProfile(outbounds []Outbound, group string) (ClientConfig, error) {
+	tags := make([]string, 0, len(outbounds))
+	for _, outbound := range outbounds {
+		tags = append(tags, outbound.Tag)
+	}
+
+	proxy := Outbound{
+		Tag:       ClientProxyTag,
+		Outbounds: tags,
+	}
+	switch group {
+	case ClientGroupSelector:
+		proxy.Type = "selector"
+		if len(tags) > 0 {
+			proxy.Default = tags[0]
+		}
+	case ClientGroupURLTest:
+		proxy.Type = "urltest"
+		proxy.URL = "https://www.gstatic.com/generate_204"
+		proxy.Interval = "3m"
+	default:
+		return ClientConfig{}, fmt.Errorf("unknown client group type %q", group)
+	}
+
+	config := ClientConfig{
+		Log: Log{Level: "info", Timestamp: true},
+		Inbounds: []ClientInbound{
+			{
+				Type:        "tun",
+				Tag:         "tun-in",
+				Address:     []string{"172.19.0.1/30"},
+				AutoRoute:   true,
+				StrictRoute: true,
+				Stack:       "system",
+			},
+			{
+				Type:       "mixed",
+				Tag:        "mixed-in",
+				Listen:     "127.0.0.1",
+				ListenPort: 2080,
+			},
+		},
+		Route: Route{
+			Final:               ClientProxyTag,
+			AutoDetectInterface: true,
+		},
+	}
+
+	// sing-box rejects empty groups, so users without inbounds get direct only
+	if len(tags) > 0 {
+		config.Outbounds = append(config.Outbounds, proxy)
+		config.Outbounds = append(config.Outbounds, outbounds...)
+	} else {
+		config.Route.Final = "direct"
+	}
+	config.Outbounds = append(config.Outbounds, Outbound{Type: "direct", Tag: "direct"})
+
+	return config, nil
+}
@@ -0,0 +1,109 @@
+package jsonhandler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// ShadowsocksMethods maps the shadowsocks methods sbfm supports to the
+// length in bytes of the base64 keys the 2022 ones take. The older AEAD
+// methods take any password and map to 0.
+var ShadowsocksMethods = map[string]int{
+	"2022-blake3-aes-128-gcm":       16,
+	"2022-blake3-aes-256-gcm":       32,
+	"2022-blake3-chacha20-poly1305": 32,
+	"aes-128-gcm":                   0,
+	"aes-256-gcm":                   0,
+	"chacha20-ietf-poly1305":        0,
+}
+
+// IsShadowsocks2022 reports whether method is one of the 2022 methods, which
+// need a server key on the inbound and a key of the same length per user
+func IsShadowsocks2022(method string) bool {
+	return ShadowsocksMethods[method] > 0
+}
+
+// MarshalJSON writes the inbound the way sing-box reads it: users carry only
+// the credentials the inbound type takes, and the tls and transport blocks
+// are left out when they are not used, since not every type accepts them.
+func (inbound Inbound) MarshalJSON() ([]byte, error) {
+	type plain Inbound
+	shaped := struct {
+		plain
+		Users     []User     `json:"users,omitempty"`
+		TLS       *TLS       `json:"tls,omitempty"`
+		Transport *Transport `json:"transport,omitempty"`
+	}{plain: plain(inbound), Users: ServerUsers(inbound)}
+	if inbound.TLS.Enabled {
+		shaped.TLS = &inbound.TLS
+	}
+	if inbound.Transport.Type != "" {
+		shaped.Transport = &inbound.Transport
+	}
+	return json.Marshal(shaped)
+}
+
+// ServerUsers returns the users of inbound as ServerUser shapes them
+func ServerUsers(inbound Inbound) []User {
+	users := make([]User, 0, len(inbound.Users))
+	for _, user := range inbound.Users {
+		users = append(users, ServerUser(inbound, user))
+	}
+	return users
+}
+
+// ServerUser returns user with only the credentials the type of inbound
+// takes: a uuid for vless, with the flow of the inbound, and vmess, a
+// password for trojan, hysteria2 and shadowsocks, and both for tuic.
+func ServerUser(inbound Inbound, user User) User {
+	shaped := User{Name: user.Name}
+	switch inbound.Type {
+	case "trojan", "hysteria2":
+		shaped.Password = user.Password
+	case "tuic":
+		shaped.UUID = user.UUID
+		shaped.Password = user.Password
+	case "shadowsocks":
+		shaped.Password = ShadowsocksUserPassword(inbound.Method, user)
+	case "vless":
+		shaped.UUID = user.UUID
+		shaped.Flow = inbound.Flow
+	default:
+		shaped.UUID = user.UUID
+	}
+	return shaped
+}
+
+// ShadowsocksUserPassword returns the password of user on a shadowsocks
+// inbound using method. The 2022 methods take the user key cut to the key
+// length of the method; users without a key fall back to their password,
+// which validation rejects unless it is such a key already.
+func ShadowsocksUserPassword(method string, user User) string {
+	length := ShadowsocksMethods[method]
+	if length == 0 || user.Key == "" {
+		return user.Password
+	}
+	key, err := base64.StdEncoding.DecodeString(user.Key)
+	if err != nil || len(key) < length {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(key[:length])
+}
+
+// ClientShadowsocksPassword returns the password a client sends to a
+// shadowsocks inbound as user. With the 2022 methods that is the server key
+// and the user key joined by a colon.
+func ClientShadowsocksPassword(inbound Inbound, user User) string {
+	password := ShadowsocksUserPassword(inbound.Method, user)
+	if IsShadowsocks2022(inbound.Method) {
+		return inbound.Password + ":" + password
+	}
+	return password
+}
+
+// ValidShadowsocksKey reports whether key is the base64 of exactly length
+// bytes
+func ValidShadowsocksKey(key string, length int) bool {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(decoded) == length
+}
@@ -0,0 +1,284 @@
+package jsonhandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadConfigFile reads a config.json back into a Config. A missing file
+// reads as an empty config.
+func ReadConfigFile(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Config{}, nil
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	config, err := parseConfig(data)
+	if err != nil {
+		return config, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// parseConfig parses a config.json. The flow of each inbound, which only
+// its users carry, is read back from the first user.
+func parseConfig(data []byte) (Config, error) {
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+	for i, inbound := range config.Inbounds {
+		if len(inbound.Users) > 0 {
+			config.Inbounds[i].Flow = inbound.Users[0].Flow
+		}
+	}
+	return config, nil
+}
+
+// writtenConfig returns config as ReadConfigFile reads it back once it is
+// written, with users shaped per inbound type and client-only fields gone
+func writtenConfig(config Config) (Config, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return Config{}, fmt.Errorf("error marshaling JSON: %v", err)
+	}
+	written, err := parseConfig(data)
+	if err != nil {
+		return written, fmt.Errorf("error parsing JSON: %v", err)
+	}
+	return written, nil
+}
+
+// DiffConfigs lists what changes when current is replaced by next: inbounds
+// and outbounds added and removed by tag, users added and removed per
+// inbound, changed inbound, TLS and log fields, changed outbounds, changed
+// route rules and rule sets, changed DNS servers, rules and settings, and
+// a changed v2ray_api block and stats users. Passwords and keys are listed
+// as changed without their values.
+func DiffConfigs(current, next Config) []string {
+	var changes []string
+	change := func(format string, args ...interface{}) {
+		changes = append(changes, fmt.Sprintf(format, args...))
+	}
+	field := func(name string, old, new interface{}) {
+		oldValue, newValue := fmt.Sprint(old), fmt.Sprint(new)
+		if oldValue != newValue {
+			change("~ %s: %s -> %s", name, oldValue, newValue)
+		}
+	}
+	// secret lists a changed password or key without its values, the diff
+	// ends up in terminals and logs
+	secret := func(name string, old, new string) {
+		if old != new {
+			change("~ %s: changed", name)
+		}
+	}
+
+	switch {
+	case current.Log == nil && next.Log != nil:
+		change("+ log (level %s)", next.Log.Level)
+	case current.Log != nil && next.Log == nil:
+		change("- log")
+	case current.Log != nil:
+		field("log.disabled", current.Log.Disabled, next.Log.Disabled)
+		field("log.level", current.Log.Level, next.Log.Level)
+		field("log.output", current.Log.Output, next.Log.Output)
+		field("log.timestamp", current.Log.Timestamp, next.Log.Timestamp)
+	}
+
+	currentInbounds := make(map[string]Inbound)
+	for _, inbound := range current.Inbounds {
+		currentInbounds[inbound.Tag] = inbound
+	}
+	nextInbounds := make(map[string]bool)
+
+	for _, inbound := range next.Inbounds {
+		nextInbounds[inbound.Tag] = true
+		old, ok := currentInbounds[inbound.Tag]
+		if !ok {
+			change("+ inbound %s (%s, port %d, %d user(s))", inbound.Tag, inbound.Type, inbound.ListenPort, len(inbound.Users))
+			continue
+		}
+
+		listed := len(changes)
+		prefix := "inbound " + inbound.Tag + "."
+		field(prefix+"type", old.Type, inbound.Type)
+		field(prefix+"listen", old.Listen, inbound.Listen)
+		field(prefix+"listen_port", old.ListenPort, inbound.ListenPort)
+		field(prefix+"tcp_fast_open", old.TCPFastOpen, inbound.TCPFastOpen)
+		field(prefix+"tcp_multi_path", old.TCPMultiPath, inbound.TCPMultiPath)
+		field(prefix+"udp_fragment", old.UDPFragment, inbound.UDPFragment)
+		field(prefix+"udp_timeout", old.UDPTimeout, inbound.UDPTimeout)
+		field(prefix+"detour", old.Detour, inbound.Detour)
+		field(prefix+"sniff", old.Sniff, inbound.Sniff)
+		field(prefix+"sniff_override_destination", old.SniffOverrideDestination, inbound.SniffOverrideDestination)
+		field(prefix+"sniff_timeout", old.SniffTimeout, inbound.SniffTimeout)
+		field(prefix+"domain_strategy", old.DomainStrategy, inbound.DomainStrategy)
+		field(prefix+"udp_disable_domain_unmapping", old.UDPDisableDomainUnmapping, inbound.UDPDisableDomainUnmapping)
+		field(prefix+"method", old.Method, inbound.Method)
+		secret(prefix+"password", old.Password, inbound.Password)
+		field(prefix+"flow", old.Flow, inbound.Flow)
+		field(prefix+"transport.type", old.Transport.Type, inbound.Transport.Type)
+		field(prefix+"transport.path", old.Transport.Path, inbound.Transport.Path)
+		field(prefix+"transport.service_name", old.Transport.ServiceName, inbound.Transport.ServiceName)
+		diffTLS(field, secret, prefix+"tls.", old.TLS, inbound.TLS)
+
+		for _, name := range missingUsers(old.Users, inbound.Users) {
+			change("- inbound %s: user %s", inbound.Tag, name)
+		}
+		for _, name := range missingUsers(inbound.Users, old.Users) {
+			change("+ inbound %s: user %s", inbound.Tag, name)
+		}
+
+		// Anything else that changes the written inbound, such as the flow
+		// of a single user, still counts as a change
+		if len(changes) == listed && jsonString(old) != jsonString(inbound) {
+			change("~ inbound %s", inbound.Tag)
+		}
+	}
+
+	for _, inbound := range current.Inbounds {
+		if !nextInbounds[inbound.Tag] {
+			change("- inbound %s (%s, port %d)", inbound.Tag, inbound.Type, inbound.ListenPort)
+		}
+	}
+
+	currentOutbounds := make(map[string]Outbound)
+	for _, outbound := range current.Outbounds {
+		currentOutbounds[outbound.Tag] = outbound
+	}
+	nextOutbounds := make(map[string]bool)
+
+	for _, outbound := range next.Outbounds {
+		nextOutbounds[outbound.Tag] = true
+		old, ok := currentOutbounds[outbound.Tag]
+		switch {
+		case !ok:
+			change("+ outbound %s (%s)", outbound.Tag, outbound.Type)
+		case old.Type != outbound.Type:
+			field("outbound "+outbound.Tag+".type", old.Type, outbound.Type)
+		case jsonString(old) != jsonString(outbound):
+			change("~ outbound %s", outbound.Tag)
+		}
+	}
+
+	for _, outbound := range current.Outbounds {
+		if !nextOutbounds[outbound.Tag] {
+			change("- outbound %s (%s)", outbound.Tag, outbound.Type)
+		}
+	}
+
+	field("route.final", current.Route.Final, next.Route.Final)
+	field("route.auto_detect_interface", current.Route.AutoDetectInterface, next.Route.AutoDetectInterface)
+	if jsonString(current.Route.Rules) != jsonString(next.Route.Rules) {
+		change("~ route.rules (%d -> %d rule(s))", len(current.Route.Rules), len(next.Route.Rules))
+	}
+	if jsonString(current.Route.RuleSet) != jsonString(next.Route.RuleSet) {
+		change("~ route.rule_set (%d -> %d rule set(s))", len(current.Route.RuleSet), len(next.Route.RuleSet))
+	}
+
+	field("dns.final", current.DNS.Final, next.DNS.Final)
+	field("dns.strategy", current.DNS.Strategy, next.DNS.Strategy)
+	field("dns.disable_cache", current.DNS.DisableCache, next.DNS.DisableCache)
+	field("dns.independent_cache", current.DNS.IndependentCache, next.DNS.IndependentCache)
+	if jsonString(current.DNS.Fakeip) != jsonString(next.DNS.Fakeip) {
+		change("~ dns.fakeip: %s -> %s", jsonString(current.DNS.Fakeip), jsonString(next.DNS.Fakeip))
+	}
+	if jsonString(current.DNS.Servers) != jsonString(next.DNS.Servers) {
+		change("~ dns.servers (%d -> %d server(s))", len(current.DNS.Servers), len(next.DNS.Servers))
+	}
+	if jsonString(current.DNS.Rules) != jsonString(next.DNS.Rules) {
+		change("~ dns.rules (%d -> %d rule(s))", len(current.DNS.Rules), len(next.DNS.Rules))
+	}
+
+	field("experimental.v2ray_api", describeV2rayAPI(current.Experimental.V2rayAPI), describeV2rayAPI(next.Experimental.V2rayAPI))
+	currentStats, nextStats := statsUsers(current.Experimental.V2rayAPI), statsUsers(next.Experimental.V2rayAPI)
+	for _, name := range missingNames(currentStats, nextStats) {
+		change("- experimental.v2ray_api.stats user %s", name)
+	}
+	for _, name := range missingNames(nextStats, currentStats) {
+		change("+ experimental.v2ray_api.stats user %s", name)
+	}
+
+	return changes
+}
+
+// describeV2rayAPI sums up a v2ray_api block without its stats users,
+// which are compared by name
+func describeV2rayAPI(api *V2rayAPI) string {
+	if api == nil {
+		return "off"
+	}
+	if api.Stats == nil || !api.Stats.Enabled {
+		return api.Listen + " without stats"
+	}
+	return api.Listen
+}
+
+// statsUsers returns the users whose traffic a v2ray_api block counts
+func statsUsers(api *V2rayAPI) []string {
+	if api == nil || api.Stats == nil {
+		return nil
+	}
+	return api.Stats.Users
+}
+
+// missingNames returns the names in from that are not in to
+func missingNames(from, to []string) []string {
+	present := make(map[string]bool)
+	for _, name := range to {
+		present[name] = true
+	}
+
+	var missing []string
+	for _, name := range from {
+		if !present[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// jsonString is the config form of a block, used to compare blocks without
+// listing each of their many fields
+func jsonString(block interface{}) string {
+	data, _ := json.Marshal(block)
+	return string(data)
+}
+
+// diffTLS compares the TLS and Reality fields of an inbound
+func diffTLS(field func(string, interface{}, interface{}), secret func(string, string, string), prefix string, old, new TLS) {
+	field(prefix+"enabled", old.Enabled, new.Enabled)
+	field(prefix+"server_name", old.ServerName, new.ServerName)
+	field(prefix+"min_version", old.MinVersion, new.MinVersion)
+	field(prefix+"max_version", old.MaxVersion, new.MaxVersion)
+	field(prefix+"certificate_path", old.CertificatePath, new.CertificatePath)
+	field(prefix+"key_path", old.KeyPath, new.KeyPath)
+	field(prefix+"reality.enabled", old.Reality.Enabled, new.Reality.Enabled)
+	secret(prefix+"reality.private_key", old.Reality.PrivateKey, new.Reality.PrivateKey)
+	field(prefix+"reality.short_id", strings.Join(old.Reality.ShortID, ","), strings.Join(new.Reality.ShortID, ","))
+	field(prefix+"reality.handshake.server", old.Reality.Handshake.Server, new.Reality.Handshake.Server)
+	field(prefix+"reality.handshake.server_port", old.Reality.Handshake.ServerPort, new.Reality.Handshake.ServerPort)
+}
+
+// missingUsers returns the names of the users in from that are not in to.
+// Users are matched by name and credentials, uuid and password, so a renamed
+// user counts as removed and added.
+func missingUsers(from, to []User) []string {
+	users := make(map[string]bool)
+	for _, user := range to {
+		users[user.Name+":"+user.UUID+":"+user.Password] = true
+	}
+
+	var missing []string
+	for _, user := range from {
+		if !users[user.Name+":"+user.UUID+":"+user.Password] {
+			missing = append(missing, user.Name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,128 @@
+package jsonhandler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// diffBase returns a config with one reality vless inbound, two users and
+// stats counted for both
+func diffBase() Config {
+	return Config{
+		Inbounds: []Inbound{{
+			Type:       "vless",
+			Tag:        "vless-in",
+			Listen:     "::",
+			ListenPort: 443,
+			Flow:       FlowVision,
+			Users: []User{
+				{Name: "alice", UUID: "0b6ea4a8-3c42-4c54-9d5c-1d2f8d5a6a01", Flow: FlowVision},
+				{Name: "bob", UUID: "0b6ea4a8-3c42-4c54-9d5c-1d2f8d5a6a02", Flow: FlowVision},
+			},
+			TLS: TLS{
+				Enabled:    true,
+				ServerName: "www.example.com",
+				Reality: Reality{
+					Enabled:    true,
+					Handshake:  Handshake{Server: "www.example.com", ServerPort: 443},
+					PrivateKey: "PH7uqe8UEqAyafcSwrvl3SaCL-DVkHuheJmkKs8w1Dg",
+					ShortID:    []string{"6ba85179"},
+				},
+			},
+		}},
+		Experimental: Experimental{V2rayAPI: &V2rayAPI{
+			Listen: "127.0.0.1:10085",
+			Stats:  &V2rayStats{Enabled: true, Users: []string{"alice", "bob"}},
+		}},
+	}
+}
+
+func TestDiffConfigs(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(config *Config)
+		want   []string
+	}{
+		{
+			name:   "unchanged",
+			change: func(config *Config) {},
+			want:   nil,
+		},
+		{
+			name:   "sniff",
+			change: func(config *Config) { config.Inbounds[0].Sniff = true },
+			want:   []string{"~ inbound vless-in.sniff: false -> true"},
+		},
+		{
+			name: "sniff override and timeout",
+			change: func(config *Config) {
+				config.Inbounds[0].SniffOverrideDestination = true
+				config.Inbounds[0].SniffTimeout = "300ms"
+			},
+			want: []string{
+				"~ inbound vless-in.sniff_override_destination: false -> true",
+				"~ inbound vless-in.sniff_timeout:  -> 300ms",
+			},
+		},
+		{
+			name:   "detour",
+			change: func(config *Config) { config.Inbounds[0].Detour = "other-in" },
+			want:   []string{"~ inbound vless-in.detour:  -> other-in"},
+		},
+		{
+			name:   "domain strategy",
+			change: func(config *Config) { config.Inbounds[0].DomainStrategy = "prefer_ipv4" },
+			want:   []string{"~ inbound vless-in.domain_strategy:  -> prefer_ipv4"},
+		},
+		{
+			name: "renamed user",
+			change: func(config *Config) {
+				config.Inbounds[0].Users[1].Name = "carol"
+				config.Experimental.V2rayAPI.Stats.Users[1] = "carol"
+			},
+			want: []string{
+				"- inbound vless-in: user bob",
+				"+ inbound vless-in: user carol",
+				"- experimental.v2ray_api.stats user bob",
+				"+ experimental.v2ray_api.stats user carol",
+			},
+		},
+		{
+			name: "private key",
+			change: func(config *Config) {
+				config.Inbounds[0].TLS.Reality.PrivateKey = "yD3oYdW3EJ7o5sDuE0eYoTN2Oo2dhTXGRPaWOr6NS2M"
+			},
+			want: []string{"~ inbound vless-in.tls.reality.private_key: changed"},
+		},
+		{
+			name:   "stats off",
+			change: func(config *Config) { config.Experimental.V2rayAPI = nil },
+			want: []string{
+				"~ experimental.v2ray_api: 127.0.0.1:10085 -> off",
+				"- experimental.v2ray_api.stats user alice",
+				"- experimental.v2ray_api.stats user bob",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			current, err := writtenConfig(diffBase())
+			if err != nil {
+				t.Fatalf("writtenConfig: %v", err)
+			}
+			changed := diffBase()
+			test.change(&changed)
+			next, err := writtenConfig(changed)
+			if err != nil {
+				t.Fatalf("writtenConfig: %v", err)
+			}
+
+			got := DiffConfigs(current, next)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("DiffConfigs =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package jsonhandler
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// PopulateDNS populates the dns block: the servers in the order they were
+// added, the DNS rules by priority with the sub-rules of logical rules
+// nested inside them, and the global DNS and fakeip settings.
+func PopulateDNS(db *sql.DB, settings Settings, config *Config) error {
+	rows, err := db.Query(`
+    SELECT tag, address, address_resolver, strategy, detour
+    FROM dns_servers
+    ORDER BY id
+`)
+	if err != nil {
+		return fmt.Errorf("error querying dns_servers table: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var server Servers
+		err := rows.Scan(&server.Tag, &server.Address, &server.AddressResolver, &server.Strategy, &server.Detour)
+		if err != nil {
+			return fmt.Errorf("error scanning DNS server row: %v", err)
+		}
+		config.DNS.Servers = append(config.DNS.Servers, server)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading DNS servers: %v", err)
+	}
+
+	config.DNS.Rules, err = populateRules(db, "dns_rules", "server")
+	if err != nil {
+		return err
+	}
+
+	config.DNS.Final = settings.DNSFinal
+	config.DNS.Strategy = settings.DNSStrategy
+	config.DNS.DisableCache = settings.DNSDisableCache
+	config.DNS.IndependentCache = settings.DNSIndependentCache
+	if settings.DNSFakeIPInet4Range != "" || settings.DNSFakeIPInet6Range != "" {
+		config.DNS.Fakeip = &FakeIP{
+			Enabled:    true,
+			Inet4Range: settings.DNSFakeIPInet4Range,
+			Inet6Range: settings.DNSFakeIPInet6Range,
+		}
+	}
+	return nil
+}
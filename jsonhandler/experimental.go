@@ -0,0 +1,36 @@
+package jsonhandler
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// PopulateExperimental populates the experimental block. The v2ray_api block
+// is only set when settings.StatsAPIListen is, and counts the traffic of
+// every active user.
+func PopulateExperimental(db *sql.DB, settings Settings, config *Config) error {
+	if settings.StatsAPIListen == "" {
+		return nil
+	}
+
+	rows, err := db.Query("SELECT name FROM users WHERE active = TRUE ORDER BY id")
+	if err != nil {
+		return fmt.Errorf("error querying users table: %v", err)
+	}
+	defer rows.Close()
+
+	stats := &V2rayStats{Enabled: true}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("error scanning user row: %v", err)
+		}
+		stats.Users = append(stats.Users, name)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading users: %v", err)
+	}
+
+	config.Experimental.V2rayAPI = &V2rayAPI{Listen: settings.StatsAPIListen, Stats: stats}
+	return nil
+}
@@ -4,8 +4,12 @@ package jsonhandler
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+
+	"winder.website/sbfm/staging"
 
 	// go-sqlite3 is the SQL driver for SQLite in Go
 	_ "github.com/mattn/go-sqlite3"
@@ -13,7 +17,7 @@ import (
 
 // Config is the structure of the config.json file.
 type Config struct {
-	Log          Log          `json:"log,omitempty"`
+	Log          *Log         `json:"log,omitempty"`
 	DNS          DNS          `json:"dns,omitempty"`
 	NTP          NTP          `json:"ntp,omitempty"`
 	Inbounds     []Inbound    `json:"inbounds,omitempty"`
@@ -42,7 +46,7 @@ type DNS struct {
 	IndependentCache bool      `json:"independent_cache,omitempty"`
 	ReverseMapping   bool      `json:"reverse_mapping,omitempty"`
 	ClientSubnet     string    `json:"client_subnet,omitempty"`
-	Fakeip           FakeIP    `json:"fakeip,omitempty"`
+	Fakeip           *FakeIP   `json:"fakeip,omitempty"`
 }
 
 // Servers is the structure of the DNS servers block.
@@ -57,9 +61,25 @@ type Servers struct {
 	ClientSubnet    string `json:"client_subnet,omitempty"`
 }
 
-// Rules is the structure of the DNS rules block.
+// Rules is the structure of a rule in the route and DNS rules blocks.
+// Logical rules have Type "logical" and combine their Rules by Mode. Route
+// rules lead to an Outbound, DNS rules to a Server.
 // Add Rules fields as needed.
-type Rules struct{}
+type Rules struct {
+	Type         string   `json:"type,omitempty"`
+	Mode         string   `json:"mode,omitempty"`
+	Rules        []Rules  `json:"rules,omitempty"`
+	Inbound      []string `json:"inbound,omitempty"`
+	Protocol     []string `json:"protocol,omitempty"`
+	Domain       []string `json:"domain,omitempty"`
+	DomainSuffix []string `json:"domain_suffix,omitempty"`
+	IPCIDR       []string `json:"ip_cidr,omitempty"`
+	Port         []int    `json:"port,omitempty"`
+	RuleSet      []string `json:"rule_set,omitempty"`
+	Invert       bool     `json:"invert,omitempty"`
+	Outbound     string   `json:"outbound,omitempty"`
+	Server       string   `json:"server,omitempty"`
+}
 
 // FakeIP is the structure of the DNS fakeip block.
 // Add Rules fields as needed.
@@ -79,7 +99,8 @@ type NTP struct {
 	// Dial Fields
 }
 
-// Inbound is the structure of the Inbound block.
+// Inbound is the structure of the Inbound block. Flow is not written on the
+// inbound: sing-box reads the vless flow from each user.
 type Inbound struct {
 	Type                      string    `json:"type,omitempty"`
 	Tag                       string    `json:"tag,omitempty"`
@@ -95,17 +116,31 @@ type Inbound struct {
 	SniffTimeout              string    `json:"sniff_timeout,omitempty"`
 	DomainStrategy            string    `json:"domain_strategy,omitempty"`
 	UDPDisableDomainUnmapping bool      `json:"udp_disable_domain_unmapping,omitempty"`
+	Method                    string    `json:"method,omitempty"`
+	Password                  string    `json:"password,omitempty"`
+	Flow                      string    `json:"-"`
 	Users                     []User    `json:"users,omitempty"`
 	TLS                       TLS       `json:"tls,omitempty"`
 	Transport                 Transport `json:"transport,omitempty"`
 }
 
+// FlowVision is the vless flow of XTLS Vision, used with Reality or TLS
+// over plain TCP.
+const FlowVision = "xtls-rprx-vision"
+
 // User is the structure of the user block in the inbound block.
+// Inbounds keep every credential of their users; ServerUser picks the ones
+// each inbound type takes.
 type User struct {
-	Name   string `json:"name,omitempty"`
-	UUID   string `json:"uuid,omitempty"`
-	SUB    string `json:"sub,omitempty"`
-	Active bool   `json:"active,omitempty"`
+	Name     string `json:"name,omitempty"`
+	UUID     string `json:"uuid,omitempty"`
+	Password string `json:"password,omitempty"`
+	Flow     string `json:"flow,omitempty"`
+	SUB      string `json:"sub,omitempty"`
+	Active   bool   `json:"active,omitempty"`
+	// Key is the base64 shadowsocks 2022 key of the user, cut to the key
+	// length of each inbound's method.
+	Key string `json:"-"`
 }
 
 // TLS is the structure of the TLS block in the inbound block.
@@ -126,7 +161,15 @@ type Reality struct {
 	Enabled    bool      `json:"enabled,omitempty"`
 	Handshake  Handshake `json:"handshake,omitempty"`
 	PrivateKey string    `json:"private_key,omitempty"`
-	ShortID    string    `json:"short_id,omitempty"`
+	ShortID    []string  `json:"short_id,omitempty"`
+	// PublicKey is only used for client exports; sing-box servers reject it.
+	PublicKey string `json:"-"`
+	// ServerNames lists the names clients may send. sing-box servers take a
+	// single tls.server_name, which is set to the first one.
+	ServerNames []string `json:"-"`
+	// UserShortIDs maps user uuids to the short_id issued to that user.
+	// Those short_ids are part of ShortID as well.
+	UserShortIDs map[string]string `json:"-"`
 }
 
 // Handshake is the structure of the Handshake block in the inbound block.
@@ -136,15 +179,66 @@ type Handshake struct {
 	ServerPort int    `json:"server_port,omitempty"`
 }
 
-// Transport is the structure of the transport block in the inbound block.
+// Transport is the structure of the transport block in the inbound and outbound blocks.
 type Transport struct {
-	Type string `json:"type,omitempty"`
-	Path string `json:"path,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Path        string `json:"path,omitempty"`
+	ServiceName string `json:"service_name,omitempty"`
 }
 
 // Outbound is the structure of the Outbound block.
 // Add Outbound fields as needed.
-type Outbound struct{}
+type Outbound struct {
+	Type          string       `json:"type"`
+	Tag           string       `json:"tag,omitempty"`
+	Server        string       `json:"server,omitempty"`
+	ServerPort    int          `json:"server_port,omitempty"`
+	Version       string       `json:"version,omitempty"`
+	Username      string       `json:"username,omitempty"`
+	Password      string       `json:"password,omitempty"`
+	Method        string       `json:"method,omitempty"`
+	UUID          string       `json:"uuid,omitempty"`
+	Flow          string       `json:"flow,omitempty"`
+	LocalAddress  []string     `json:"local_address,omitempty"`
+	PrivateKey    string       `json:"private_key,omitempty"`
+	PeerPublicKey string       `json:"peer_public_key,omitempty"`
+	PreSharedKey  string       `json:"pre_shared_key,omitempty"`
+	Reserved      []int        `json:"reserved,omitempty"`
+	MTU           int          `json:"mtu,omitempty"`
+	TLS           *OutboundTLS `json:"tls,omitempty"`
+	Transport     *Transport   `json:"transport,omitempty"`
+	Outbounds     []string     `json:"outbounds,omitempty"`
+	Default       string       `json:"default,omitempty"`
+	URL           string       `json:"url,omitempty"`
+	Interval      string       `json:"interval,omitempty"`
+	// Dial Fields
+	Detour         string `json:"detour,omitempty"`
+	BindInterface  string `json:"bind_interface,omitempty"`
+	RoutingMark    int    `json:"routing_mark,omitempty"`
+	DomainStrategy string `json:"domain_strategy,omitempty"`
+}
+
+// OutboundTLS is the structure of the TLS block in the outbound block.
+type OutboundTLS struct {
+	Enabled    bool             `json:"enabled"`
+	ServerName string           `json:"server_name,omitempty"`
+	Insecure   bool             `json:"insecure,omitempty"`
+	UTLS       *UTLS            `json:"utls,omitempty"`
+	Reality    *OutboundReality `json:"reality,omitempty"`
+}
+
+// UTLS is the structure of the uTLS block in the outbound TLS block.
+type UTLS struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// OutboundReality is the structure of the Reality block in the outbound TLS block.
+type OutboundReality struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key,omitempty"`
+	ShortID   string `json:"short_id,omitempty"`
+}
 
 // Route is the structure of the Route block.
 // Add Route fields as needed.
@@ -160,14 +254,22 @@ type Route struct {
 
 // RuleSet is the structure of the RuleSet block.
 // Add RuleSet fields as needed.
-type RuleSet struct{}
+type RuleSet struct {
+	Type           string `json:"type"`
+	Tag            string `json:"tag"`
+	Format         string `json:"format"`
+	Path           string `json:"path,omitempty"`
+	URL            string `json:"url,omitempty"`
+	DownloadDetour string `json:"download_detour,omitempty"`
+	UpdateInterval string `json:"update_interval,omitempty"`
+}
 
 // Experimental is the structure of the Experimental block.
 // Add Experimental fields as needed.
 type Experimental struct {
 	CacheFile CacheFile `json:"cache_file,omitempty"`
 	ClashAPI  ClashAPI  `json:"clash_api,omitempty"`
-	V2rayAPI  V2rayAPI  `json:"v2ray_api,omitempty"`
+	V2rayAPI  *V2rayAPI `json:"v2ray_api,omitempty"`
 }
 
 // CacheFile is the structure of the CacheFile block.
@@ -179,28 +281,99 @@ type CacheFile struct{}
 type ClashAPI struct{}
 
 // V2rayAPI is the structure of the V2rayAPI block.
-// Add V2rayAPI fields as needed.
-type V2rayAPI struct{}
+type V2rayAPI struct {
+	Listen string      `json:"listen,omitempty"`
+	Stats  *V2rayStats `json:"stats,omitempty"`
+}
+
+// V2rayStats selects the counters of the V2rayAPI block.
+type V2rayStats struct {
+	Enabled bool     `json:"enabled,omitempty"`
+	Users   []string `json:"users,omitempty"`
+}
+
+// ConfigFilePath is where the server config is written.
+const ConfigFilePath = "./sing-box/config.json"
+
+// ErrInvalidConfig is returned by GenerateConfigFile when validation finds
+// errors and the write was not forced.
+var ErrInvalidConfig = errors.New("config has validation errors")
+
+// GenerateOptions changes how GenerateConfigFile writes the config.
+type GenerateOptions struct {
+	// Force writes the config even when validation reports errors.
+	Force bool
+	// DryRun writes nothing and prints how the config would change instead.
+	DryRun bool
+	// Check, if set, gets the path of the new config before it replaces the
+	// current one and can veto it by returning an error.
+	Check func(path string) error
+}
+
+// Settings are the values of the settings table that go into config.json,
+// read by the db package with their defaults.
+type Settings struct {
+	RouteFinal               string
+	RouteAutoDetectInterface bool
+
+	DNSFinal            string
+	DNSStrategy         string
+	DNSDisableCache     bool
+	DNSIndependentCache bool
+	DNSFakeIPInet4Range string
+	DNSFakeIPInet6Range string
+
+	StatsAPIListen string
+}
 
 // GenerateConfigFile generates the config.json file from the data in the database.
-func GenerateConfigFile(db *sql.DB) error {
+// The config is validated first, and is not written if it has errors unless
+// options.Force is set.
+func GenerateConfigFile(db *sql.DB, settings Settings, options GenerateOptions) error {
 	// Create a Config instance.
 	config := Config{}
 
 	// Populate the Config instance from the database.
-	err := PopulateConfig(db, &config)
+	err := PopulateConfig(db, settings, &config)
 	if err != nil {
 		return fmt.Errorf("error populating config: %v", err)
 	}
 
+	// Validate before anything is written.
+	report := ValidateConfig(config)
+	if len(report.Issues) > 0 {
+		report.Print(os.Stdout)
+	}
+
+	// A dry run compares against the current file instead of writing.
+	var changesErr error
+	if options.DryRun {
+		current, err := ReadConfigFile(ConfigFilePath)
+		if err != nil {
+			return err
+		}
+		next, err := writtenConfig(config)
+		if err != nil {
+			return err
+		}
+		changesErr = staging.ReportChanges(DiffConfigs(current, next))
+	}
+
+	if report.ErrorCount() > 0 && !options.Force {
+		return fmt.Errorf("%w: %d error(s), not writing config.json", ErrInvalidConfig, report.ErrorCount())
+	}
+	if options.DryRun {
+		return changesErr
+	}
+
 	// Generate JSON.
 	jsonData, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
 		return fmt.Errorf("error marshaling JSON: %v", err)
 	}
 
-	// Write JSON to file.
-	err = os.WriteFile("./sing-box/config.json", jsonData, 0o644)
+	// Swap the new file in, keeping the previous one as a backup.
+	err = staging.WriteFile(ConfigFilePath, jsonData, options.Check)
 	if err != nil {
 		return fmt.Errorf("error writing JSON to file: %v", err)
 	}
@@ -209,42 +382,78 @@ func GenerateConfigFile(db *sql.DB) error {
 	return nil
 }
 
-// PopulateConfig populates the config.json file from the data in the database.
-func PopulateConfig(db *sql.DB, config *Config) error {
-	// Populate Log
-	err := db.QueryRow("SELECT disabled, level, output, timestamp FROM log").Scan(
-		&config.Log.Disabled, &config.Log.Level, &config.Log.Output, &config.Log.Timestamp)
-	if err != nil {
+// PopulateConfig populates the config.json file from the data in the database
+// and settings.
+func PopulateConfig(db *sql.DB, settings Settings, config *Config) error {
+	// Populate Log, which is left out when the log settings are unset
+	var logBlock Log
+	err := db.QueryRow("SELECT disabled, level, output, timestamp FROM log WHERE id = 1").Scan(
+		&logBlock.Disabled, &logBlock.Level, &logBlock.Output, &logBlock.Timestamp)
+	switch {
+	case err == nil:
+		config.Log = &logBlock
+	case err != sql.ErrNoRows:
 		return fmt.Errorf("error querying log table: %v", err)
 	}
 
-	// Fetch all users once
-	var allUsers []User
-	userRows, err := db.Query("SELECT name, uuid FROM users WHERE active = TRUE")
+	if err := PopulateInbounds(db, config); err != nil {
+		return err
+	}
+	if err := PopulateOutbounds(db, config); err != nil {
+		return err
+	}
+	if err := PopulateRoute(db, settings, config); err != nil {
+		return err
+	}
+	if err := PopulateDNS(db, settings, config); err != nil {
+		return err
+	}
+	return PopulateExperimental(db, settings, config)
+}
+
+// PopulateInbounds populates the inbounds of the config, each with the active
+// users granted to it, from the data in the database.
+func PopulateInbounds(db *sql.DB, config *Config) error {
+	// Fetch the active users granted to each inbound once
+	usersByInbound := make(map[int][]User)
+	userRows, err := db.Query(`
+    SELECT ui.inbound_id, u.name, u.uuid, u.password, u.ss_key
+    FROM user_inbounds ui
+    JOIN users u ON u.id = ui.user_id
+    WHERE u.active = TRUE
+    ORDER BY u.id
+`)
 	if err != nil {
 		return fmt.Errorf("error querying users table: %v", err)
 	}
 	defer userRows.Close()
 
 	for userRows.Next() {
+		var inboundID int
 		var user User
-		err := userRows.Scan(&user.Name, &user.UUID)
+		err := userRows.Scan(&inboundID, &user.Name, &user.UUID, &user.Password, &user.Key)
 		if err != nil {
 			return fmt.Errorf("error scanning user row: %v", err)
 		}
-		allUsers = append(allUsers, user)
+		usersByInbound[inboundID] = append(usersByInbound[inboundID], user)
+	}
+
+	realityLists, err := populateRealityLists(db)
+	if err != nil {
+		return err
 	}
 
 	// Query to fetch inbounds, transports, tls, reality, and handshake data
 	rows, err := db.Query(`
     SELECT 
-        i.type, i.tag, i.listen, i.listen_port, i.tcp_fast_open, i.tcp_multi_path, 
+        i.id, i.type, i.tag, i.listen, i.listen_port, i.tcp_fast_open, i.tcp_multi_path, 
         i.udp_fragment, i.udp_timeout, i.detour, i.sniff, i.sniff_override_destination, 
         i.sniff_timeout, i.domain_strategy, i.udp_disable_domain_unmapping, 
+        i.method, i.password, i.flow,
         t.type AS transport_type, t.path,
         tls.enabled, tls.server_name, tls.min_version, tls.max_version, 
         tls.certificate_path, tls.key_path,
-        r.enabled AS reality_enabled, r.private_key, r.short_id,
+        r.id AS reality_id, r.enabled AS reality_enabled, r.private_key, r.public_key,
         h.server, h.server_port
     FROM inbounds i
     LEFT JOIN transports t ON i.transport_id = t.id
@@ -262,20 +471,22 @@ func PopulateConfig(db *sql.DB, config *Config) error {
 
 	for rows.Next() {
 		var inbound Inbound
+		var inboundID int
 
 		// Using sql.Null* types for optional fields
 		var udpDisableDomainUnmapping, tcpFastOpen, tcpMultiPath, udpFragment, tlsEnabled, realityEnabled sql.NullBool
-		var serverName, minVersion, maxVersion, certPath, keyPath, privateKey, shortID, handshakeServer, udpTimeout, detour, domainStrategy, transportType, transportPath sql.NullString
-		var handshakeServerPort sql.NullInt64
+		var serverName, minVersion, maxVersion, certPath, keyPath, privateKey, publicKey, handshakeServer, udpTimeout, detour, domainStrategy, transportType, transportPath sql.NullString
+		var handshakeServerPort, realityID sql.NullInt64
 
 		err := rows.Scan(
-			&inbound.Type, &inbound.Tag, &inbound.Listen, &inbound.ListenPort,
+			&inboundID, &inbound.Type, &inbound.Tag, &inbound.Listen, &inbound.ListenPort,
 			&tcpFastOpen, &tcpMultiPath, &udpFragment, &udpTimeout, &detour,
 			&inbound.Sniff, &inbound.SniffOverrideDestination, &inbound.SniffTimeout,
 			&domainStrategy, &udpDisableDomainUnmapping,
+			&inbound.Method, &inbound.Password, &inbound.Flow,
 			&transportType, &transportPath,
 			&tlsEnabled, &serverName, &minVersion, &maxVersion, &certPath, &keyPath,
-			&realityEnabled, &privateKey, &shortID,
+			&realityID, &realityEnabled, &privateKey, &publicKey,
 			&handshakeServer, &handshakeServerPort,
 		)
 		if err != nil {
@@ -305,8 +516,17 @@ func PopulateConfig(db *sql.DB, config *Config) error {
 		if privateKey.Valid {
 			inbound.TLS.Reality.PrivateKey = privateKey.String
 		}
-		if shortID.Valid {
-			inbound.TLS.Reality.ShortID = shortID.String
+		if publicKey.Valid {
+			inbound.TLS.Reality.PublicKey = publicKey.String
+		}
+		// A profile without short_ids or server names has no lists
+		if lists, ok := realityLists[int(realityID.Int64)]; realityID.Valid && ok {
+			inbound.TLS.Reality.ShortID = lists.shortIDs
+			inbound.TLS.Reality.ServerNames = lists.serverNames
+			inbound.TLS.Reality.UserShortIDs = lists.userShortIDs
+			if inbound.TLS.ServerName == "" && len(lists.serverNames) > 0 {
+				inbound.TLS.ServerName = lists.serverNames[0]
+			}
 		}
 
 		// Populate Handshake block in Reality
@@ -324,12 +544,82 @@ func PopulateConfig(db *sql.DB, config *Config) error {
 		if transportPath.Valid {
 			inbound.Transport.Path = transportPath.String
 		}
+		// gRPC has no path, the path column holds its service name
+		if inbound.Transport.Type == "grpc" {
+			inbound.Transport.ServiceName = strings.TrimPrefix(inbound.Transport.Path, "/")
+			inbound.Transport.Path = ""
+		}
 
-		// Assign all users to the inbound entry.
-		inbound.Users = allUsers
+		// Assign the users granted this inbound.
+		inbound.Users = usersByInbound[inboundID]
 
 		config.Inbounds = append(config.Inbounds, inbound)
 	}
 
 	return nil
 }
+
+// realityList holds the short_ids and server names of one reality profile
+type realityList struct {
+	shortIDs     []string
+	serverNames  []string
+	userShortIDs map[string]string
+}
+
+// populateRealityLists reads the short_ids and server names of every reality
+// profile. Short_ids issued to inactive users are left out so that those
+// users can no longer connect.
+func populateRealityLists(db *sql.DB) (map[int]*realityList, error) {
+	lists := make(map[int]*realityList)
+	list := func(realityID int) *realityList {
+		if lists[realityID] == nil {
+			lists[realityID] = &realityList{userShortIDs: make(map[string]string)}
+		}
+		return lists[realityID]
+	}
+
+	shortIDRows, err := db.Query(`
+    SELECT rs.reality_id, rs.short_id, COALESCE(u.uuid, '')
+    FROM reality_short_ids rs
+    LEFT JOIN users u ON rs.user_id = u.id
+    WHERE rs.user_id IS NULL OR u.active = TRUE
+    ORDER BY rs.id
+`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying reality_short_ids table: %v", err)
+	}
+	defer shortIDRows.Close()
+
+	for shortIDRows.Next() {
+		var realityID int
+		var shortID, userUUID string
+		if err := shortIDRows.Scan(&realityID, &shortID, &userUUID); err != nil {
+			return nil, fmt.Errorf("error scanning short_id row: %v", err)
+		}
+		l := list(realityID)
+		l.shortIDs = append(l.shortIDs, shortID)
+		if userUUID != "" {
+			l.userShortIDs[userUUID] = shortID
+		}
+	}
+	if err := shortIDRows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading short_id rows: %v", err)
+	}
+
+	serverNameRows, err := db.Query(`SELECT reality_id, server_name FROM reality_server_names ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying reality_server_names table: %v", err)
+	}
+	defer serverNameRows.Close()
+
+	for serverNameRows.Next() {
+		var realityID int
+		var serverName string
+		if err := serverNameRows.Scan(&realityID, &serverName); err != nil {
+			return nil, fmt.Errorf("error scanning server name row: %v", err)
+		}
+		l := list(realityID)
+		l.serverNames = append(l.serverNames, serverName)
+	}
+	return lists, serverNameRows.Err()
+}
@@ -0,0 +1,150 @@
+package jsonhandler
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PopulateOutbounds adds the outbounds stored in the database to the config
+// in the order they were added. Only the fields that apply to the type of
+// each outbound are set.
+func PopulateOutbounds(db *sql.DB, config *Config) error {
+	rows, err := db.Query(`
+    SELECT
+        o.type, o.tag, o.server, o.server_port, o.version, o.username, o.password,
+        o.method, o.uuid, o.flow,
+        o.tls_enabled, o.tls_server_name, o.tls_insecure, o.utls_fingerprint,
+        o.reality_public_key, o.reality_short_id,
+        t.type AS transport_type, t.path,
+        o.local_address, o.private_key, o.peer_public_key, o.pre_shared_key,
+        o.reserved, o.mtu,
+        o.detour, o.bind_interface, o.routing_mark, o.domain_strategy
+    FROM outbounds o
+    LEFT JOIN transports t ON o.transport_id = t.id
+    ORDER BY o.id
+`)
+	if err != nil {
+		return fmt.Errorf("error querying outbounds table: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var outbound Outbound
+		var tls OutboundTLS
+		var utlsFingerprint, realityPublicKey, realityShortID, localAddress, reserved string
+		var transportType, transportPath sql.NullString
+
+		err := rows.Scan(
+			&outbound.Type, &outbound.Tag, &outbound.Server, &outbound.ServerPort,
+			&outbound.Version, &outbound.Username, &outbound.Password,
+			&outbound.Method, &outbound.UUID, &outbound.Flow,
+			&tls.Enabled, &tls.ServerName, &tls.Insecure, &utlsFingerprint,
+			&realityPublicKey, &realityShortID,
+			&transportType, &transportPath,
+			&localAddress, &outbound.PrivateKey, &outbound.PeerPublicKey, &outbound.PreSharedKey,
+			&reserved, &outbound.MTU,
+			&outbound.Detour, &outbound.BindInterface, &outbound.RoutingMark, &outbound.DomainStrategy,
+		)
+		if err != nil {
+			return fmt.Errorf("error scanning outbound row: %v", err)
+		}
+
+		if tls.Enabled {
+			if utlsFingerprint != "" {
+				tls.UTLS = &UTLS{Enabled: true, Fingerprint: utlsFingerprint}
+			}
+			if realityPublicKey != "" {
+				tls.Reality = &OutboundReality{Enabled: true, PublicKey: realityPublicKey, ShortID: realityShortID}
+			}
+			outbound.TLS = &tls
+		}
+
+		if transportType.Valid {
+			outbound.Transport = &Transport{Type: transportType.String, Path: transportPath.String}
+			// gRPC has no path, the path column holds its service name
+			if outbound.Transport.Type == "grpc" {
+				outbound.Transport.ServiceName = strings.TrimPrefix(outbound.Transport.Path, "/")
+				outbound.Transport.Path = ""
+			}
+		}
+
+		outbound.LocalAddress = SplitList(localAddress)
+		outbound.Reserved, err = parseReserved(reserved)
+		if err != nil {
+			return fmt.Errorf("outbound %s: %v", outbound.Tag, err)
+		}
+
+		config.Outbounds = append(config.Outbounds, trimOutbound(outbound))
+	}
+
+	return rows.Err()
+}
+
+// trimOutbound clears the fields that the type of outbound does not take,
+// so that a row whose type was changed leaves no stale fields behind.
+func trimOutbound(outbound Outbound) Outbound {
+	trimmed := Outbound{
+		Type:           outbound.Type,
+		Tag:            outbound.Tag,
+		Detour:         outbound.Detour,
+		BindInterface:  outbound.BindInterface,
+		RoutingMark:    outbound.RoutingMark,
+		DomainStrategy: outbound.DomainStrategy,
+	}
+
+	switch outbound.Type {
+	case "block", "dns":
+		// block and dns outbounds take no dial fields either
+		return Outbound{Type: outbound.Type, Tag: outbound.Tag}
+	case "direct":
+		return trimmed
+	}
+
+	trimmed.Server = outbound.Server
+	trimmed.ServerPort = outbound.ServerPort
+	switch outbound.Type {
+	case "socks":
+		trimmed.Version = outbound.Version
+		trimmed.Username = outbound.Username
+		trimmed.Password = outbound.Password
+	case "http":
+		trimmed.Username = outbound.Username
+		trimmed.Password = outbound.Password
+		trimmed.TLS = outbound.TLS
+	case "shadowsocks":
+		trimmed.Method = outbound.Method
+		trimmed.Password = outbound.Password
+	case "vless":
+		trimmed.UUID = outbound.UUID
+		trimmed.Flow = outbound.Flow
+		trimmed.TLS = outbound.TLS
+		trimmed.Transport = outbound.Transport
+	case "wireguard":
+		trimmed.LocalAddress = outbound.LocalAddress
+		trimmed.PrivateKey = outbound.PrivateKey
+		trimmed.PeerPublicKey = outbound.PeerPublicKey
+		trimmed.PreSharedKey = outbound.PreSharedKey
+		trimmed.Reserved = outbound.Reserved
+		trimmed.MTU = outbound.MTU
+	}
+	return trimmed
+}
+
+// parseReserved parses the "0,0,0" form the reserved column is stored in
+func parseReserved(reserved string) ([]int, error) {
+	if strings.TrimSpace(reserved) == "" {
+		return nil, nil
+	}
+
+	var bytes []int
+	for _, item := range strings.Split(reserved, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil {
+			return nil, fmt.Errorf("invalid reserved %q", reserved)
+		}
+		bytes = append(bytes, value)
+	}
+	return bytes, nil
+}
@@ -0,0 +1,144 @@
+package jsonhandler
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PopulateRoute populates the route block: the rule sets, the route rules by
+// priority with the sub-rules of logical rules nested inside them, and the
+// final outbound and auto_detect_interface settings.
+func PopulateRoute(db *sql.DB, settings Settings, config *Config) error {
+	ruleSets, err := db.Query(`
+    SELECT type, tag, format, path, url, download_detour, update_interval
+    FROM rule_sets
+    ORDER BY id
+`)
+	if err != nil {
+		return fmt.Errorf("error querying rule_sets table: %v", err)
+	}
+	defer ruleSets.Close()
+
+	for ruleSets.Next() {
+		var ruleSet RuleSet
+		err := ruleSets.Scan(
+			&ruleSet.Type, &ruleSet.Tag, &ruleSet.Format, &ruleSet.Path,
+			&ruleSet.URL, &ruleSet.DownloadDetour, &ruleSet.UpdateInterval,
+		)
+		if err != nil {
+			return fmt.Errorf("error scanning rule set row: %v", err)
+		}
+		// Local rule sets have no download settings
+		if ruleSet.Type == "local" {
+			ruleSet.URL, ruleSet.DownloadDetour, ruleSet.UpdateInterval = "", "", ""
+		}
+		config.Route.RuleSet = append(config.Route.RuleSet, ruleSet)
+	}
+	if err := ruleSets.Err(); err != nil {
+		return fmt.Errorf("error reading rule sets: %v", err)
+	}
+
+	config.Route.Rules, err = populateRules(db, "route_rules", "outbound")
+	if err != nil {
+		return err
+	}
+
+	config.Route.Final = settings.RouteFinal
+	config.Route.AutoDetectInterface = settings.RouteAutoDetectInterface
+	return nil
+}
+
+// ruleRow is a rule read from a rules table, before it is nested
+type ruleRow struct {
+	id     int
+	parent int
+	rule   Rules
+}
+
+// populateRules reads the rules of table, whose target column is outbound
+// or server, and nests the sub-rules of logical rules inside them. Rules
+// with the same parent are ordered by priority.
+func populateRules(db *sql.DB, table, target string) ([]Rules, error) {
+	rows, err := db.Query(`
+    SELECT id, COALESCE(parent_id, 0), type, mode,
+        inbound, protocol, domain, domain_suffix, ip_cidr, port, rule_set,
+        invert, ` + target + `
+    FROM ` + table + `
+    ORDER BY priority, id
+`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying %s table: %v", table, err)
+	}
+	defer rows.Close()
+
+	var ruleRows []ruleRow
+	for rows.Next() {
+		var row ruleRow
+		var ruleType, inbound, protocol, domain, domainSuffix, ipCIDR, port, ruleSet, targetTag string
+		err := rows.Scan(
+			&row.id, &row.parent, &ruleType, &row.rule.Mode,
+			&inbound, &protocol, &domain, &domainSuffix, &ipCIDR, &port, &ruleSet,
+			&row.rule.Invert, &targetTag,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning %s row: %v", table, err)
+		}
+
+		// sing-box leaves the type out of default rules
+		if ruleType == "logical" {
+			row.rule.Type = ruleType
+		}
+		row.rule.Inbound = SplitList(inbound)
+		row.rule.Protocol = SplitList(protocol)
+		row.rule.Domain = SplitList(domain)
+		row.rule.DomainSuffix = SplitList(domainSuffix)
+		row.rule.IPCIDR = SplitList(ipCIDR)
+		row.rule.RuleSet = SplitList(ruleSet)
+		for _, item := range SplitList(port) {
+			value, err := strconv.Atoi(item)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid port %q", row.id, item)
+			}
+			row.rule.Port = append(row.rule.Port, value)
+		}
+		if target == "outbound" {
+			row.rule.Outbound = targetTag
+		} else {
+			row.rule.Server = targetTag
+		}
+
+		ruleRows = append(ruleRows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", table, err)
+	}
+
+	return nestRules(ruleRows, 0), nil
+}
+
+// nestRules returns the rules under parent with their own sub-rules filled in
+func nestRules(rows []ruleRow, parent int) []Rules {
+	var rules []Rules
+	for _, row := range rows {
+		if row.parent != parent {
+			continue
+		}
+		rule := row.rule
+		rule.Rules = nestRules(rows, row.id)
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// SplitList splits a comma separated list, dropping empty items
+func SplitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,170 @@
+package jsonhandler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrNoRealityPublicKey is returned by the client exports of a Reality
+// inbound without a public key. Clients cannot connect without one, so
+// generation fails on it instead of leaving the inbound out.
+var ErrNoRealityPublicKey = errors.New("reality has no public key, run `sbfm reality derive-keys`")
+
+// checkRealityPublicKey returns ErrNoRealityPublicKey for a Reality inbound
+// whose public key is missing
+func checkRealityPublicKey(inbound Inbound) error {
+	if inbound.TLS.Reality.Enabled && inbound.TLS.Reality.PublicKey == "" {
+		return fmt.Errorf("inbound %s: %w", inbound.Tag, ErrNoRealityPublicKey)
+	}
+	return nil
+}
+
+// ShareLink builds the share link (vless://uuid@host:port?...#tag and its
+// trojan, ss, hysteria2 and tuic counterparts) that v2rayNG-style clients
+// use to reach inbound as user. host is the public address of the server,
+// since inbounds usually listen on a wildcard.
+func ShareLink(inbound Inbound, user User, host string) (string, error) {
+	if err := checkRealityPublicKey(inbound); err != nil {
+		return "", err
+	}
+
+	link := url.URL{
+		Scheme:   inbound.Type,
+		Host:     net.JoinHostPort(host, strconv.Itoa(inbound.ListenPort)),
+		Fragment: inbound.Tag,
+	}
+	query := url.Values{}
+
+	switch inbound.Type {
+	case "vless":
+		link.User = url.User(user.UUID)
+		query.Set("encryption", "none")
+		if inbound.Flow != "" {
+			query.Set("flow", inbound.Flow)
+		}
+		setShareLinkSecurity(query, inbound, user)
+		setShareLinkTransport(query, inbound)
+	case "trojan":
+		link.User = url.User(user.Password)
+		setShareLinkSecurity(query, inbound, user)
+		setShareLinkTransport(query, inbound)
+	case "shadowsocks":
+		// SIP002 base64 encodes the older methods; SIP022 percent-encodes
+		// the 2022 ones, whose keys are base64 already
+		link.Scheme = "ss"
+		password := ClientShadowsocksPassword(inbound, user)
+		if IsShadowsocks2022(inbound.Method) {
+			link.User = url.UserPassword(inbound.Method, password)
+		} else {
+			link.User = url.User(base64.RawURLEncoding.EncodeToString([]byte(inbound.Method + ":" + password)))
+		}
+	case "hysteria2":
+		link.User = url.User(user.Password)
+		if serverName := ClientServerName(inbound); serverName != "" {
+			query.Set("sni", serverName)
+		}
+	case "tuic":
+		link.User = url.UserPassword(user.UUID, user.Password)
+		if serverName := ClientServerName(inbound); serverName != "" {
+			query.Set("sni", serverName)
+		}
+		query.Set("alpn", "h3")
+		query.Set("congestion_control", "cubic")
+	default:
+		return "", fmt.Errorf("share links are not supported for %s inbounds", inbound.Type)
+	}
+
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// setShareLinkSecurity sets the query parameters of the security layer of
+// a vless or trojan share link
+func setShareLinkSecurity(query url.Values, inbound Inbound, user User) {
+	switch {
+	case inbound.TLS.Reality.Enabled:
+		query.Set("security", "reality")
+		query.Set("sni", ClientServerName(inbound))
+		query.Set("fp", "chrome")
+		query.Set("pbk", inbound.TLS.Reality.PublicKey)
+		if shortID := ClientShortID(inbound, user); shortID != "" {
+			query.Set("sid", shortID)
+		}
+	case inbound.TLS.Enabled:
+		query.Set("security", "tls")
+		if serverName := ClientServerName(inbound); serverName != "" {
+			query.Set("sni", serverName)
+		}
+	default:
+		query.Set("security", "none")
+	}
+}
+
+// setShareLinkTransport sets the query parameters of the transport layer of
+// a vless or trojan share link
+func setShareLinkTransport(query url.Values, inbound Inbound) {
+	switch inbound.Transport.Type {
+	case "":
+		query.Set("type", "tcp")
+	case "grpc":
+		query.Set("type", "grpc")
+		query.Set("serviceName", inbound.Transport.ServiceName)
+	default:
+		query.Set("type", inbound.Transport.Type)
+		if inbound.Transport.Path != "" {
+			query.Set("path", inbound.Transport.Path)
+		}
+	}
+}
+
+// ClientServerName returns the SNI a client should send to inbound. Reality
+// inbounds fall back to their first server name, then to the name of their
+// handshake server.
+func ClientServerName(inbound Inbound) string {
+	if inbound.TLS.ServerName != "" {
+		return inbound.TLS.ServerName
+	}
+	if inbound.TLS.Reality.Enabled {
+		if len(inbound.TLS.Reality.ServerNames) > 0 {
+			return inbound.TLS.Reality.ServerNames[0]
+		}
+		return inbound.TLS.Reality.Handshake.Server
+	}
+	return ""
+}
+
+// ClientShortID returns the Reality short_id user should send to inbound:
+// the one issued to the user if there is one, otherwise the first shared one.
+func ClientShortID(inbound Inbound, user User) string {
+	reality := inbound.TLS.Reality
+	if shortID, ok := reality.UserShortIDs[user.UUID]; ok {
+		return shortID
+	}
+	for _, shortID := range reality.ShortID {
+		if !isUserShortID(reality, shortID) {
+			return shortID
+		}
+	}
+	return ""
+}
+
+// isUserShortID reports whether shortID was issued to a single user
+func isUserShortID(reality Reality, shortID string) bool {
+	for _, userShortID := range reality.UserShortIDs {
+		if userShortID == shortID {
+			return true
+		}
+	}
+	return false
+}
+
+// ShareLinkBundle joins links with newlines and base64 encodes them, which is
+// the subscription body v2rayNG, Streisand and Hiddify expect.
+func ShareLinkBundle(links []string) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n")))
+}
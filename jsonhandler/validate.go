@@ -0,0 +1,557 @@
+package jsonhandler
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Severity tells whether a validation issue blocks writing the config.
+type Severity string
+
+// Validation severities
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Issue is a single problem found in a config, with the tag of the inbound
+// or outbound it was found in, if any.
+type Issue struct {
+	Severity Severity
+	Tag      string
+	Message  string
+}
+
+// String formats the issue as "error: [tag] message".
+func (issue Issue) String() string {
+	if issue.Tag == "" {
+		return fmt.Sprintf("%s: %s", issue.Severity, issue.Message)
+	}
+	return fmt.Sprintf("%s: [%s] %s", issue.Severity, issue.Tag, issue.Message)
+}
+
+// ValidationReport holds every issue found by ValidateConfig.
+type ValidationReport struct {
+	Issues []Issue
+}
+
+// ErrorCount returns the number of issues with SeverityError.
+func (report ValidationReport) ErrorCount() int {
+	return report.count(SeverityError)
+}
+
+// WarningCount returns the number of issues with SeverityWarning.
+func (report ValidationReport) WarningCount() int {
+	return report.count(SeverityWarning)
+}
+
+func (report ValidationReport) count(severity Severity) int {
+	count := 0
+	for _, issue := range report.Issues {
+		if issue.Severity == severity {
+			count++
+		}
+	}
+	return count
+}
+
+// Print writes every issue followed by a summary line.
+func (report ValidationReport) Print(w io.Writer) {
+	for _, issue := range report.Issues {
+		fmt.Fprintln(w, issue)
+	}
+	fmt.Fprintf(w, "%d error(s), %d warning(s)\n", report.ErrorCount(), report.WarningCount())
+}
+
+func (report *ValidationReport) add(severity Severity, tag, format string, args ...interface{}) {
+	report.Issues = append(report.Issues, Issue{
+		Severity: severity,
+		Tag:      tag,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// tlsVersions are the values sing-box accepts for min_version and max_version.
+var tlsVersions = map[string]bool{"1.0": true, "1.1": true, "1.2": true, "1.3": true}
+
+// vlessFlows are the vless flows sing-box supports.
+var vlessFlows = map[string]bool{FlowVision: true}
+
+// transportTypes are the V2Ray transports sing-box supports.
+var transportTypes = map[string]bool{
+	"http":        true,
+	"ws":          true,
+	"quic":        true,
+	"grpc":        true,
+	"httpupgrade": true,
+}
+
+// ValidateConfig walks config and reports everything sing-box would reject
+// as an error, and everything that is likely a mistake as a warning.
+func ValidateConfig(config Config) ValidationReport {
+	var report ValidationReport
+
+	if len(config.Inbounds) == 0 {
+		report.add(SeverityWarning, "", "config has no inbounds")
+	}
+
+	tags := make(map[string]bool)
+	// Inbounds by network and port, with their listen addresses
+	listeners := make(map[string][]Inbound)
+	for _, inbound := range config.Inbounds {
+		tag := inbound.Tag
+		if tag == "" {
+			report.add(SeverityError, "", "%s inbound on port %d has no tag", inbound.Type, inbound.ListenPort)
+		} else if tags[tag] {
+			report.add(SeverityError, tag, "duplicate inbound tag")
+		}
+		tags[tag] = true
+
+		if inbound.Type == "" {
+			report.add(SeverityError, tag, "inbound has no type")
+		}
+
+		if inbound.ListenPort <= 0 || inbound.ListenPort > 65535 {
+			report.add(SeverityError, tag, "invalid listen_port %d", inbound.ListenPort)
+		} else {
+			network := listenNetwork(inbound.Type)
+			port := fmt.Sprintf("%s/%d", network, inbound.ListenPort)
+			for _, other := range listeners[port] {
+				if listenOverlaps(other.Listen, inbound.Listen) {
+					report.add(SeverityError, tag, "%s listen port %d is already used by inbound %s", network, inbound.ListenPort, other.Tag)
+					break
+				}
+			}
+			listeners[port] = append(listeners[port], inbound)
+		}
+
+		validateUsers(&report, inbound)
+		validateProtocol(&report, inbound)
+		validateFlow(&report, inbound)
+		validateTLS(&report, inbound)
+		validateTransport(&report, inbound)
+	}
+
+	outboundTags := validateOutbounds(&report, config.Outbounds)
+	for _, inbound := range config.Inbounds {
+		if inbound.Detour != "" && !tags[inbound.Detour] && !outboundTags[inbound.Detour] {
+			report.add(SeverityError, inbound.Tag, "detour %q matches no inbound or outbound tag", inbound.Detour)
+		}
+	}
+
+	ruleSetTags := make(map[string]bool)
+	usedRuleSets := make(map[string]bool)
+	validateRoute(&report, config.Route, tags, outboundTags, ruleSetTags, usedRuleSets)
+	validateDNS(&report, config.DNS, tags, outboundTags, ruleSetTags, usedRuleSets)
+	for _, ruleSet := range config.Route.RuleSet {
+		if !usedRuleSets[ruleSet.Tag] {
+			report.add(SeverityWarning, "", "route.rule_set %s is not used by any rule", ruleSet.Tag)
+		}
+	}
+
+	return report
+}
+
+// validateRoute checks that the rules, rule sets and final outbound of the
+// route block only name tags that exist. It fills ruleSetTags with the tags
+// of the rule sets, and usedRuleSets with those the route rules use.
+func validateRoute(report *ValidationReport, route Route, inboundTags, outboundTags, ruleSetTags, usedRuleSets map[string]bool) {
+	for _, ruleSet := range route.RuleSet {
+		if ruleSet.Tag == "" {
+			report.add(SeverityError, "", "route.rule_set: %s rule set has no tag", ruleSet.Type)
+		} else if ruleSetTags[ruleSet.Tag] {
+			report.add(SeverityError, "", "route.rule_set: duplicate rule set tag %s", ruleSet.Tag)
+		}
+		ruleSetTags[ruleSet.Tag] = true
+
+		if ruleSet.DownloadDetour != "" && !outboundTags[ruleSet.DownloadDetour] {
+			report.add(SeverityError, "", "route.rule_set %s: download_detour %q matches no outbound tag", ruleSet.Tag, ruleSet.DownloadDetour)
+		}
+	}
+
+	for i, rule := range route.Rules {
+		name := fmt.Sprintf("route.rules[%d]", i)
+		if !outboundTags[rule.Outbound] {
+			report.add(SeverityError, "", "%s: outbound %q matches no outbound tag", name, rule.Outbound)
+		}
+		validateRule(report, name, rule, inboundTags, ruleSetTags, usedRuleSets)
+	}
+
+	if route.Final != "" && !outboundTags[route.Final] {
+		report.add(SeverityError, "", "route.final %q matches no outbound tag", route.Final)
+	}
+}
+
+// validateDNS checks the servers, rules and final server of the dns block,
+// and records the rule sets the DNS rules use in usedRuleSets
+func validateDNS(report *ValidationReport, dns DNS, inboundTags, outboundTags, ruleSetTags, usedRuleSets map[string]bool) {
+	serverTags := make(map[string]bool)
+	for _, server := range dns.Servers {
+		if server.Tag == "" {
+			report.add(SeverityError, "", "dns.servers: server %s has no tag", server.Address)
+		} else if serverTags[server.Tag] {
+			report.add(SeverityError, "", "dns.servers: duplicate server tag %s", server.Tag)
+		}
+		serverTags[server.Tag] = true
+	}
+
+	for _, server := range dns.Servers {
+		name := "dns.servers " + server.Tag
+		host := DNSAddressHost(server.Address)
+		switch {
+		case server.Address == "":
+			report.add(SeverityError, "", "%s: server has no address", name)
+		case server.Address == "fakeip" && dns.Fakeip == nil:
+			report.add(SeverityError, "", "%s: fakeip server without a dns.fakeip range", name)
+		case host != "" && server.AddressResolver == "":
+			report.add(SeverityError, "", "%s: address_resolver is needed to look up %s", name, host)
+		}
+		if server.AddressResolver != "" && !serverTags[server.AddressResolver] {
+			report.add(SeverityError, "", "%s: address_resolver %q matches no DNS server tag", name, server.AddressResolver)
+		}
+		if server.Detour != "" && !outboundTags[server.Detour] {
+			report.add(SeverityError, "", "%s: detour %q matches no outbound tag", name, server.Detour)
+		}
+	}
+
+	for i, rule := range dns.Rules {
+		name := fmt.Sprintf("dns.rules[%d]", i)
+		if !serverTags[rule.Server] {
+			report.add(SeverityError, "", "%s: server %q matches no DNS server tag", name, rule.Server)
+		}
+		validateRule(report, name, rule, inboundTags, ruleSetTags, usedRuleSets)
+	}
+
+	if dns.Final != "" && !serverTags[dns.Final] {
+		report.add(SeverityError, "", "dns.final %q matches no DNS server tag", dns.Final)
+	}
+}
+
+// DNSAddressHost returns the host of a DNS server address when it is a
+// domain name, which sing-box needs an address_resolver to look up
+func DNSAddressHost(address string) string {
+	if address == "local" || address == "fakeip" {
+		return ""
+	}
+	host := address
+	if scheme, rest, ok := strings.Cut(address, "://"); ok {
+		if scheme == "rcode" || scheme == "dhcp" {
+			return ""
+		}
+		host, _, _ = strings.Cut(rest, "/")
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if host == "" || net.ParseIP(host) != nil {
+		return ""
+	}
+	return host
+}
+
+// validateRule checks the matchers of a rule and its sub-rules, and records
+// the rule sets it uses in usedRuleSets
+func validateRule(report *ValidationReport, name string, rule Rules, inboundTags, ruleSetTags, usedRuleSets map[string]bool) {
+	if rule.Type == "logical" && len(rule.Rules) == 0 {
+		report.add(SeverityError, "", "%s: logical rule has no sub-rules", name)
+	}
+	for _, inbound := range rule.Inbound {
+		if !inboundTags[inbound] {
+			report.add(SeverityError, "", "%s: inbound %q matches no inbound tag", name, inbound)
+		}
+	}
+	for _, ruleSet := range rule.RuleSet {
+		if !ruleSetTags[ruleSet] {
+			report.add(SeverityError, "", "%s: rule_set %q matches no rule set tag", name, ruleSet)
+		}
+		usedRuleSets[ruleSet] = true
+	}
+	for i, subRule := range rule.Rules {
+		validateRule(report, fmt.Sprintf("%s.rules[%d]", name, i), subRule, inboundTags, ruleSetTags, usedRuleSets)
+	}
+}
+
+// outboundTypes are the outbound types sbfm generates
+var outboundTypes = map[string]bool{
+	"direct":      true,
+	"block":       true,
+	"dns":         true,
+	"socks":       true,
+	"http":        true,
+	"shadowsocks": true,
+	"vless":       true,
+	"wireguard":   true,
+}
+
+// validateOutbounds checks every outbound and the detours between them and
+// returns the set of outbound tags
+func validateOutbounds(report *ValidationReport, outbounds []Outbound) map[string]bool {
+	tags := make(map[string]bool)
+	detours := make(map[string]string)
+	for _, outbound := range outbounds {
+		tag := outbound.Tag
+		if tag == "" {
+			report.add(SeverityError, "", "%s outbound has no tag", outbound.Type)
+		} else if tags[tag] {
+			report.add(SeverityError, tag, "duplicate outbound tag")
+		}
+		tags[tag] = true
+		if outbound.Detour != "" {
+			detours[tag] = outbound.Detour
+		}
+
+		if !outboundTypes[outbound.Type] {
+			report.add(SeverityError, tag, "unknown outbound type %q", outbound.Type)
+			continue
+		}
+
+		if outbound.Type != "direct" && outbound.Type != "block" && outbound.Type != "dns" {
+			if outbound.Server == "" {
+				report.add(SeverityError, tag, "%s outbound has no server", outbound.Type)
+			}
+			if outbound.ServerPort <= 0 || outbound.ServerPort > 65535 {
+				report.add(SeverityError, tag, "invalid server_port %d", outbound.ServerPort)
+			}
+		}
+
+		switch outbound.Type {
+		case "shadowsocks":
+			if outbound.Method == "" || outbound.Password == "" {
+				report.add(SeverityError, tag, "shadowsocks outbound needs a method and a password")
+			}
+		case "vless":
+			if outbound.UUID == "" {
+				report.add(SeverityError, tag, "vless outbound has no uuid")
+			}
+		case "wireguard":
+			if len(outbound.LocalAddress) == 0 {
+				report.add(SeverityError, tag, "wireguard outbound has no local_address")
+			}
+			if outbound.PrivateKey == "" || outbound.PeerPublicKey == "" {
+				report.add(SeverityError, tag, "wireguard outbound needs a private_key and a peer_public_key")
+			}
+			if len(outbound.Reserved) != 0 && len(outbound.Reserved) != 3 {
+				report.add(SeverityError, tag, "wireguard reserved needs three bytes")
+			}
+		}
+
+		if outbound.Transport != nil && !transportTypes[outbound.Transport.Type] {
+			report.add(SeverityError, tag, "unknown transport type %q", outbound.Transport.Type)
+		}
+	}
+
+	for _, outbound := range outbounds {
+		tag, detour := outbound.Tag, outbound.Detour
+		if detour == "" {
+			continue
+		}
+		if !tags[detour] {
+			report.add(SeverityError, tag, "detour %q matches no outbound tag", detour)
+			continue
+		}
+		// Follow the chain; coming back to tag means the detours loop
+		seen := map[string]bool{tag: true}
+		for next := detour; next != ""; next = detours[next] {
+			if seen[next] {
+				if next == tag {
+					report.add(SeverityError, tag, "detour chain loops back to %s", tag)
+				}
+				break
+			}
+			seen[next] = true
+		}
+	}
+
+	return tags
+}
+
+func validateUsers(report *ValidationReport, inbound Inbound) {
+	tag := inbound.Tag
+	if len(inbound.Users) == 0 {
+		report.add(SeverityWarning, tag, "inbound has no users")
+		return
+	}
+
+	needsUUID := inbound.Type != "trojan" && inbound.Type != "hysteria2" && inbound.Type != "shadowsocks"
+	needsPassword := !needsUUID || inbound.Type == "tuic"
+	keyLength := 0
+	if inbound.Type == "shadowsocks" {
+		keyLength = ShadowsocksMethods[inbound.Method]
+	}
+
+	names := make(map[string]bool)
+	uuids := make(map[string]bool)
+	passwords := make(map[string]bool)
+	for _, user := range ServerUsers(inbound) {
+		if needsUUID {
+			if user.UUID == "" {
+				report.add(SeverityError, tag, "user %q has no uuid", user.Name)
+			} else if uuids[user.UUID] {
+				report.add(SeverityError, tag, "duplicate user uuid %s", user.UUID)
+			}
+			uuids[user.UUID] = true
+		}
+
+		if needsPassword {
+			switch {
+			case user.Password == "":
+				report.add(SeverityError, tag, "user %q has no password", user.Name)
+			case keyLength > 0 && !ValidShadowsocksKey(user.Password, keyLength):
+				report.add(SeverityError, tag, "user %q has no valid %d byte key for %s", user.Name, keyLength, inbound.Method)
+			case passwords[user.Password]:
+				report.add(SeverityError, tag, "user %q shares a password with another user", user.Name)
+			}
+			passwords[user.Password] = true
+		}
+
+		if names[user.Name] {
+			report.add(SeverityWarning, tag, "duplicate user name %q", user.Name)
+		}
+		names[user.Name] = true
+	}
+}
+
+// validateProtocol checks the fields that depend on the inbound type: the
+// method and server key of shadowsocks, and the QUIC based hysteria2 and
+// tuic, which need TLS and take no transport.
+func validateProtocol(report *ValidationReport, inbound Inbound) {
+	tag := inbound.Tag
+	switch inbound.Type {
+	case "shadowsocks":
+		keyLength, known := ShadowsocksMethods[inbound.Method]
+		switch {
+		case inbound.Method == "":
+			report.add(SeverityError, tag, "shadowsocks inbound has no method")
+		case !known:
+			report.add(SeverityError, tag, "unknown shadowsocks method %q", inbound.Method)
+		case keyLength > 0 && !ValidShadowsocksKey(inbound.Password, keyLength):
+			report.add(SeverityError, tag, "%s needs a base64 %d byte server password", inbound.Method, keyLength)
+		case keyLength == 0 && inbound.Password != "":
+			report.add(SeverityWarning, tag, "the server password is ignored with %s", inbound.Method)
+		}
+		if inbound.TLS.Enabled {
+			report.add(SeverityError, tag, "shadowsocks inbounds take no tls")
+		}
+		if inbound.Transport.Type != "" {
+			report.add(SeverityError, tag, "shadowsocks inbounds take no transport")
+		}
+	case "hysteria2", "tuic":
+		if !inbound.TLS.Enabled || inbound.TLS.Reality.Enabled {
+			report.add(SeverityError, tag, "%s inbound needs tls with a certificate", inbound.Type)
+		}
+		if inbound.Transport.Type != "" {
+			report.add(SeverityError, tag, "%s inbounds take no transport", inbound.Type)
+		}
+	default:
+		if inbound.Method != "" || inbound.Password != "" {
+			report.add(SeverityError, tag, "%s inbounds take no method or password", inbound.Type)
+		}
+	}
+}
+
+// listenNetwork returns the network an inbound type listens on. hysteria2
+// and tuic run over QUIC and can share a port with a TCP inbound.
+func listenNetwork(inboundType string) string {
+	switch inboundType {
+	case "hysteria2", "tuic":
+		return "udp"
+	default:
+		return "tcp"
+	}
+}
+
+// listenOverlaps reports whether two inbounds listening on a and b cannot
+// share a port: the addresses are the same, or either is a wildcard, which
+// takes the port on every address
+func listenOverlaps(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if a == "" || b == "" || (ipA != nil && ipA.IsUnspecified()) || (ipB != nil && ipB.IsUnspecified()) {
+		return true
+	}
+	if ipA != nil && ipB != nil {
+		return ipA.Equal(ipB)
+	}
+	return a == b
+}
+
+// validateFlow checks the vless flow of an inbound. Vision works on the TLS
+// stream itself, so it cannot run over a transport such as ws or grpc.
+func validateFlow(report *ValidationReport, inbound Inbound) {
+	tag := inbound.Tag
+	switch {
+	case inbound.Flow == "":
+	case inbound.Type != "vless":
+		report.add(SeverityError, tag, "flow is only used by vless inbounds")
+	case !vlessFlows[inbound.Flow]:
+		report.add(SeverityError, tag, "unknown vless flow %q", inbound.Flow)
+	case inbound.Transport.Type != "":
+		report.add(SeverityError, tag, "flow %s does not work over the %s transport", inbound.Flow, inbound.Transport.Type)
+	case !inbound.TLS.Enabled:
+		report.add(SeverityWarning, tag, "flow %s needs tls or reality", inbound.Flow)
+	}
+}
+
+func validateTLS(report *ValidationReport, inbound Inbound) {
+	tag := inbound.Tag
+	tls := inbound.TLS
+	reality := tls.Reality
+
+	if tls.MinVersion != "" && !tlsVersions[tls.MinVersion] {
+		report.add(SeverityError, tag, "invalid tls min_version %q", tls.MinVersion)
+	}
+	if tls.MaxVersion != "" && !tlsVersions[tls.MaxVersion] {
+		report.add(SeverityError, tag, "invalid tls max_version %q", tls.MaxVersion)
+	}
+	if tlsVersions[tls.MinVersion] && tlsVersions[tls.MaxVersion] && tls.MinVersion > tls.MaxVersion {
+		report.add(SeverityError, tag, "tls min_version %s is above max_version %s", tls.MinVersion, tls.MaxVersion)
+	}
+
+	if !reality.Enabled {
+		if tls.Enabled && (tls.CertificatePath == "" || tls.KeyPath == "") {
+			report.add(SeverityError, tag, "tls is enabled without certificate_path and key_path")
+		}
+		if !tls.Enabled && (inbound.Type == "vless" || inbound.Type == "trojan") {
+			report.add(SeverityWarning, tag, "%s inbound without tls sends traffic in the clear", inbound.Type)
+		}
+		return
+	}
+
+	if !tls.Enabled {
+		report.add(SeverityError, tag, "reality is enabled but tls is not")
+	}
+	if reality.Handshake.Server == "" || reality.Handshake.ServerPort == 0 {
+		report.add(SeverityError, tag, "reality is enabled without a handshake server")
+	}
+	if reality.PrivateKey == "" {
+		report.add(SeverityError, tag, "reality is enabled without a private_key")
+	}
+	if reality.PublicKey == "" {
+		report.add(SeverityWarning, tag, "reality has no public key, generating client exports will fail")
+	}
+	if len(reality.ShortID) == 0 {
+		report.add(SeverityWarning, tag, "reality has no short_id")
+	}
+	if tls.CertificatePath != "" || tls.KeyPath != "" {
+		report.add(SeverityWarning, tag, "certificate_path and key_path are ignored with reality")
+	}
+}
+
+func validateTransport(report *ValidationReport, inbound Inbound) {
+	tag := inbound.Tag
+	transport := inbound.Transport
+
+	switch {
+	case transport.Type == "":
+	case !transportTypes[transport.Type]:
+		report.add(SeverityError, tag, "unknown transport type %q", transport.Type)
+	case transport.Type == "grpc":
+		if transport.ServiceName == "" {
+			report.add(SeverityWarning, tag, "grpc transport has no service name")
+		}
+	case transport.Path != "" && !strings.HasPrefix(transport.Path, "/"):
+		report.add(SeverityError, tag, "%s transport path %q must start with /", transport.Type, transport.Path)
+	}
+}
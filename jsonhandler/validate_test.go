@@ -0,0 +1,131 @@
+package jsonhandler
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// validateBase returns a config that validates cleanly: the reality inbound
+// of diffBase and a trojan inbound with a certificate on another port
+func validateBase() Config {
+	config := diffBase()
+	config.Inbounds[0].TLS.Reality.PublicKey = "9ulHSHqFQu1JPyDeOtUgVSudL36bsDN0ILfzvSLWjAo"
+	config.Inbounds = append(config.Inbounds, Inbound{
+		Type:       "trojan",
+		Tag:        "trojan-in",
+		Listen:     "::",
+		ListenPort: 8443,
+		Users:      []User{{Name: "alice", Password: "alice-password"}},
+		TLS: TLS{
+			Enabled:         true,
+			ServerName:      "vpn.example.com",
+			CertificatePath: "/etc/ssl/vpn.crt",
+			KeyPath:         "/etc/ssl/vpn.key",
+		},
+	})
+	return config
+}
+
+func TestValidateConfig(t *testing.T) {
+	portTaken := Issue{SeverityError, "trojan-in", "tcp listen port 443 is already used by inbound vless-in"}
+	tests := []struct {
+		name   string
+		change func(config *Config)
+		want   []Issue
+	}{
+		{
+			name:   "valid",
+			change: func(config *Config) {},
+			want:   nil,
+		},
+		{
+			name:   "duplicate listen port",
+			change: func(config *Config) { config.Inbounds[1].ListenPort = 443 },
+			want:   []Issue{portTaken},
+		},
+		{
+			name: "ipv4 and ipv6 wildcards",
+			change: func(config *Config) {
+				config.Inbounds[0].Listen = "0.0.0.0"
+				config.Inbounds[1].ListenPort = 443
+			},
+			want: []Issue{portTaken},
+		},
+		{
+			name: "wildcard and one address",
+			change: func(config *Config) {
+				config.Inbounds[0].Listen = "0.0.0.0"
+				config.Inbounds[1].Listen = "127.0.0.1"
+				config.Inbounds[1].ListenPort = 443
+			},
+			want: []Issue{portTaken},
+		},
+		{
+			name: "no listen address and one address",
+			change: func(config *Config) {
+				config.Inbounds[0].Listen = "192.0.2.1"
+				config.Inbounds[1].Listen = ""
+				config.Inbounds[1].ListenPort = 443
+			},
+			want: []Issue{portTaken},
+		},
+		{
+			name: "same address written differently",
+			change: func(config *Config) {
+				config.Inbounds[0].Listen = "2001:db8::1"
+				config.Inbounds[1].Listen = "2001:db8:0::1"
+				config.Inbounds[1].ListenPort = 443
+			},
+			want: []Issue{portTaken},
+		},
+		{
+			name: "different addresses",
+			change: func(config *Config) {
+				config.Inbounds[0].Listen = "192.0.2.1"
+				config.Inbounds[1].Listen = "192.0.2.2"
+				config.Inbounds[1].ListenPort = 443
+			},
+			want: nil,
+		},
+		{
+			name: "tcp and udp on one port",
+			change: func(config *Config) {
+				config.Inbounds[1].Type = "hysteria2"
+				config.Inbounds[1].ListenPort = 443
+			},
+			want: nil,
+		},
+		{
+			name:   "reality without a handshake",
+			change: func(config *Config) { config.Inbounds[0].TLS.Reality.Handshake = Handshake{} },
+			want:   []Issue{{SeverityError, "vless-in", "reality is enabled without a handshake server"}},
+		},
+		{
+			name: "tls without certificate paths",
+			change: func(config *Config) {
+				config.Inbounds[1].TLS.CertificatePath = ""
+				config.Inbounds[1].TLS.KeyPath = ""
+			},
+			want: []Issue{{SeverityError, "trojan-in", "tls is enabled without certificate_path and key_path"}},
+		},
+		{
+			name:   "empty users list",
+			change: func(config *Config) { config.Inbounds[1].Users = nil },
+			want:   []Issue{{SeverityWarning, "trojan-in", "inbound has no users"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := validateBase()
+			test.change(&config)
+			report := ValidateConfig(config)
+			if !reflect.DeepEqual(report.Issues, test.want) {
+				var got strings.Builder
+				report.Print(&got)
+				t.Errorf("ValidateConfig =\n%s\nwant %v", got.String(), test.want)
+			}
+		})
+	}
+}
@@ -2,32 +2,42 @@
 package main
 
 import (
-	"bufio"
 	"database/sql"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 
 	_ "github.com/mattn/go-sqlite3"
+	"winder.website/sbfm/cli"
 	"winder.website/sbfm/db"
-	"winder.website/sbfm/prompt"
 )
 
 func main() {
-	dbConnection, err := sql.Open("sqlite3", "./config.db")
-	if err != nil {
-		log.Fatal("Error connecting to the database:", err)
+	dbPath := flag.String("db", "./config.db", "path to the sqlite database")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sbfm [-db path] [command] [flags]")
+		fmt.Fprintln(os.Stderr, "Without a command the interactive menu is started.")
+		flag.PrintDefaults()
+		cli.PrintUsage()
 	}
-	defer dbConnection.Close()
+	flag.Parse()
 
-	// Create tables if they don't exist
-	err = db.CreateTables(dbConnection)
+	dbConnection, err := sql.Open("sqlite3", *dbPath)
 	if err != nil {
-		log.Fatal("Error creating tables:", err)
+		log.Fatal("Error connecting to the database:", err)
 	}
 
-	// Create a scanner for reading input
-	scanner := bufio.NewScanner(os.Stdin)
+	// Bring the schema up to date unless the migrate command handles it
+	args := flag.Args()
+	if !cli.SkipsAutoMigrate(args) {
+		if _, err := db.Migrate(dbConnection); err != nil {
+			dbConnection.Close()
+			log.Fatal("Error migrating database:", err)
+		}
+	}
 
-	// Main application loop
-	prompt.HandleMenu(scanner, dbConnection)
+	code := cli.Run(dbConnection, args)
+	dbConnection.Close()
+	os.Exit(code)
 }
@@ -0,0 +1,198 @@
+// Package prompt is for printing the prompt
+package prompt
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+
+	"winder.website/sbfm/db"
+)
+
+// DisplayDNSManagementMenu displays the menu for managing the dns block
+func DisplayDNSManagementMenu() int {
+	fmt.Println("\nDNS Management Menu:")
+	fmt.Println("1. Add DNS server")
+	fmt.Println("2. List DNS servers")
+	fmt.Println("3. Edit DNS server by ID")
+	fmt.Println("4. Delete DNS server by ID")
+	fmt.Println("5. Add DNS rule")
+	fmt.Println("6. List DNS rules")
+	fmt.Println("7. Edit DNS rule by ID")
+	fmt.Println("8. Delete DNS rule by ID")
+	fmt.Println("9. Set final server, strategy, cache and fakeip")
+	fmt.Println("0. Return to main menu")
+	fmt.Print("Choose an option: ")
+
+	var choice int
+	fmt.Scanln(&choice)
+	return choice
+}
+
+// HandleDNSManagementMenu handles user input for the DNS options
+func HandleDNSManagementMenu(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	for {
+		choice := DisplayDNSManagementMenu()
+		switch choice {
+		case 1:
+			AddDNSServerPrompt(scanner, dbConnection)
+		case 2:
+			if err := db.PrintDNSServers(dbConnection); err != nil {
+				log.Println("Error displaying DNS servers:", err)
+			}
+		case 3:
+			EditDNSServerPrompt(scanner, dbConnection)
+		case 4:
+			deleteByIDPrompt(scanner, dbConnection, "DNS server", db.DeleteDNSServer)
+		case 5:
+			AddDNSRulePrompt(scanner, dbConnection)
+		case 6:
+			if err := db.PrintDNSRules(dbConnection); err != nil {
+				log.Println("Error displaying DNS rules:", err)
+			}
+		case 7:
+			EditDNSRulePrompt(scanner, dbConnection)
+		case 8:
+			deleteByIDPrompt(scanner, dbConnection, "DNS rule", db.DeleteDNSRule)
+		case 9:
+			DNSSettingsPrompt(scanner, dbConnection)
+		case 0:
+			return // Return to main menu
+		default:
+			fmt.Println("Invalid option. Please try again.")
+		}
+	}
+}
+
+// AddDNSServerPrompt asks for a new DNS server
+func AddDNSServerPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	var record db.DNSServerRecord
+	readDNSServer(scanner, &record)
+
+	if err := db.AddDNSServer(dbConnection, record); err != nil {
+		log.Println(err)
+	}
+}
+
+// EditDNSServerPrompt edits a DNS server by its ID, offering the current values as defaults
+func EditDNSServerPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	serverID, err := readID(scanner, "Enter the ID of the DNS server you want to edit: ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record, err := db.GetDNSServer(dbConnection, serverID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	readDNSServer(scanner, &record)
+
+	if err := db.UpdateDNSServer(dbConnection, record); err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("DNS server updated successfully.")
+	}
+}
+
+// readDNSServer asks for the fields of a DNS server, offering the current
+// values as defaults
+func readDNSServer(scanner *bufio.Scanner, record *db.DNSServerRecord) {
+	record.Tag = readString(scanner, "Enter DNS server tag (e.g., google)", record.Tag)
+	record.Address = readString(scanner, "Enter address (e.g., tls://8.8.8.8, https://dns.google/dns-query, local, fakeip)", record.Address)
+	record.AddressResolver = readOptionalString(scanner, "Enter the tag of the DNS server resolving the address", record.AddressResolver)
+	record.Strategy = readOptionalString(scanner, "Enter strategy (prefer_ipv4, prefer_ipv6, ipv4_only, ipv6_only)", record.Strategy)
+	record.Detour = readOptionalString(scanner, "Enter detour outbound tag", record.Detour)
+}
+
+// AddDNSRulePrompt asks for a new DNS rule
+func AddDNSRulePrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	var record db.DNSRuleRecord
+	record.Type = db.RuleTypeDefault
+	if err := db.PrintDNSRules(dbConnection); err != nil {
+		log.Println(err)
+	}
+
+	readDNSRule(scanner, &record)
+
+	if err := db.AddDNSRule(dbConnection, record); err != nil {
+		log.Println(err)
+	}
+}
+
+// EditDNSRulePrompt edits a DNS rule by its ID, offering the current values as defaults
+func EditDNSRulePrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	ruleID, err := readID(scanner, "Enter the ID of the DNS rule you want to edit: ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record, err := db.GetDNSRule(dbConnection, ruleID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	readDNSRule(scanner, &record)
+
+	if err := db.UpdateDNSRule(dbConnection, record); err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("DNS rule updated successfully.")
+	}
+}
+
+// readDNSRule asks for the fields of a DNS rule, offering the current
+// values as defaults
+func readDNSRule(scanner *bufio.Scanner, record *db.DNSRuleRecord) {
+	readRule(scanner, &record.RuleRecord)
+	if record.ParentID == nil {
+		record.Server = readString(scanner, "Enter the DNS server tag for matching queries", record.Server)
+	} else {
+		record.Server = ""
+	}
+}
+
+// DNSSettingsPrompt changes the global DNS settings
+func DNSSettingsPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	current := make(map[string]string)
+	for _, key := range []string{
+		db.SettingDNSFinal, db.SettingDNSStrategy, db.SettingDNSDisableCache,
+		db.SettingDNSIndependentCache, db.SettingDNSFakeIPInet4Range, db.SettingDNSFakeIPInet6Range,
+	} {
+		value, err := db.GetSetting(dbConnection, key)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		current[key] = value
+	}
+
+	final := readOptionalString(scanner, "Enter the final DNS server tag", current[db.SettingDNSFinal])
+	if err := db.SetDNSFinal(dbConnection, final); err != nil {
+		log.Println(err)
+		return
+	}
+
+	values := []struct {
+		key, value string
+	}{
+		{db.SettingDNSStrategy, readOptionalString(scanner, "Enter strategy (prefer_ipv4, prefer_ipv6, ipv4_only, ipv6_only)", current[db.SettingDNSStrategy])},
+		{db.SettingDNSDisableCache, strconv.FormatBool(readBool(scanner, "Disable the DNS cache", current[db.SettingDNSDisableCache] == "true"))},
+		{db.SettingDNSIndependentCache, strconv.FormatBool(readBool(scanner, "Keep a separate cache per server", current[db.SettingDNSIndependentCache] == "true"))},
+		{db.SettingDNSFakeIPInet4Range, readOptionalString(scanner, "Enter fakeip IPv4 range (e.g., 198.18.0.0/15)", current[db.SettingDNSFakeIPInet4Range])},
+		{db.SettingDNSFakeIPInet6Range, readOptionalString(scanner, "Enter fakeip IPv6 range (e.g., fc00::/18)", current[db.SettingDNSFakeIPInet6Range])},
+	}
+	for _, v := range values {
+		if err := db.SetSetting(dbConnection, v.key, v.value); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	fmt.Println("DNS settings saved.")
+}
@@ -67,9 +67,35 @@ func AddHandshakePrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
 		serverPort = defaultserverPort
 	}
 
-	db.AddHandshake(
+	if err := db.AddHandshake(
 		dbConnection,
 		server,
 		serverPort,
-	)
+	); err != nil {
+		log.Println(err)
+	}
+}
+
+// EditHandshakePrompt edits a Handshake configuration by its ID, offering the current values as defaults
+func EditHandshakePrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	handshakeID, err := readID(scanner, "Enter the ID of the Handshake configuration you want to edit: ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record, err := db.GetHandshake(dbConnection, handshakeID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record.Server = readString(scanner, "Enter handshakes server address", record.Server)
+	record.ServerPort = readInt(scanner, "Enter handshakes server Port", record.ServerPort)
+
+	if err := db.UpdateHandshake(dbConnection, record); err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("Handshake configuration updated successfully.")
+	}
 }
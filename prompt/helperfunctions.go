@@ -2,7 +2,10 @@
 package prompt
 
 import (
+	"bufio"
 	"fmt"
+	"log"
+	"strconv"
 	"strings"
 )
 
@@ -27,3 +30,76 @@ func GetBoolInput(prompt string) (bool, error) {
 		return false, fmt.Errorf("invalid input: %s, please enter 'true' or 'false'", input)
 	}
 }
+
+// readString prompts with the default value shown and returns the entered
+// line, or defaultValue when the line is empty.
+func readString(scanner *bufio.Scanner, prompt, defaultValue string) string {
+	fmt.Printf("%s [default: %s]: ", prompt, defaultValue)
+	if scanner.Scan() {
+		input := strings.TrimSpace(scanner.Text())
+		if input == "" {
+			return defaultValue
+		}
+		return input
+	}
+	return defaultValue
+}
+
+// readInt is readString for integers; invalid input keeps the default.
+func readInt(scanner *bufio.Scanner, prompt string, defaultValue int) int {
+	input := readString(scanner, prompt, strconv.Itoa(defaultValue))
+	value, err := strconv.Atoi(input)
+	if err != nil {
+		log.Printf("Invalid number %q, keeping %d.", input, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// readBool is readString for true/false answers; invalid input keeps the default.
+func readBool(scanner *bufio.Scanner, prompt string, defaultValue bool) bool {
+	input := readString(scanner, prompt+" (true/false)", strconv.FormatBool(defaultValue))
+	value, err := strconv.ParseBool(input)
+	if err != nil {
+		log.Printf("Invalid input %q, keeping %t.", input, defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+// readOptionalString is readString for optional values, where "-" clears
+// the current value.
+func readOptionalString(scanner *bufio.Scanner, prompt, current string) string {
+	value := readString(scanner, prompt+" (- for none)", current)
+	if value == "-" {
+		return ""
+	}
+	return value
+}
+
+// readOptionalID is readInt for optional foreign keys, where 0 means none.
+func readOptionalID(scanner *bufio.Scanner, prompt string, current *int) *int {
+	defaultValue := 0
+	if current != nil {
+		defaultValue = *current
+	}
+
+	value := readInt(scanner, prompt+" (0 for none)", defaultValue)
+	if value <= 0 {
+		return nil
+	}
+	return &value
+}
+
+// readID asks for the ID of the row to work on.
+func readID(scanner *bufio.Scanner, prompt string) (int, error) {
+	fmt.Print(prompt)
+	if !scanner.Scan() {
+		return 0, fmt.Errorf("no input")
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil {
+		return 0, fmt.Errorf("invalid ID: %v", err)
+	}
+	return id, nil
+}
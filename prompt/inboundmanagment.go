@@ -38,7 +38,7 @@ func DeleteInboundByID(dbConnection *sql.DB) {
 
 // AddInboundPrompt Function to handle inbound input
 func AddInboundPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
-	var inboundType, tag, listen, sniffTimeout string
+	var inboundType, tag, listen, sniffTimeout, detour, method, password, flow string
 	var sniff, sniffOverrideDestination bool
 	var listenPort int
 	var transportID, tlsID, realityID, handshakeID *int
@@ -51,6 +51,7 @@ func AddInboundPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
 	const defaultListenPort = 8080
 	const defaultSniff = true
 	const defaultSniffOverrideDestination = false
+	const defaultShadowsocksMethod = "2022-blake3-aes-128-gcm"
 
 	// Helper function to scan input with default fallback
 	readInput := func(prompt string, defaultValue string) string {
@@ -67,9 +68,22 @@ func AddInboundPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
 
 	// Read inputs with default fallback
 	inboundType = readInput(
-		"Enter inbounds type (e.g., vless, vmess, etc.) [default: vless]: ",
+		"Enter inbounds type (e.g., vless, vmess, trojan, shadowsocks, hysteria2, tuic) [default: vless]: ",
 		defaultInboundType,
 	)
+	if inboundType == "shadowsocks" {
+		method = readInput(
+			"Enter shadowsocks method [default: "+defaultShadowsocksMethod+"]: ",
+			defaultShadowsocksMethod,
+		)
+		password = readInput("Enter the server key of 2022 methods [default: generate]: ", "")
+	}
+	if inboundType == "vless" {
+		flow = readInput(
+			"Enter the vless flow, xtls-rprx-vision for Reality or TLS over TCP [default: none]: ",
+			"",
+		)
+	}
 	tag = readInput("Enter inbounds tag (e.g., vless-ws) [default: vless-ws]: ", defaultTag)
 	listen = readInput("Enter inbounds listenIP (e.g., ::) [default: ::]: ", defaultListen)
 
@@ -84,6 +98,11 @@ func AddInboundPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
 		defaultSniffTimeout,
 	)
 
+	detour = readInput(
+		"Enter inbounds detour, an inbound or outbound tag [default: none]: ",
+		"",
+	)
+
 	sniff, err = GetBoolInput("Enter inbounds sniff (true/false) [default: true]: ")
 	if err != nil {
 		log.Println(err)
@@ -188,18 +207,77 @@ func AddInboundPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
 		}
 	}
 
-	db.AddInbound(
-		dbConnection,
-		inboundType,
-		tag,
-		listen,
-		sniffTimeout,
-		listenPort,
-		transportID,
-		tlsID,
-		realityID,
-		handshakeID,
-		sniff,
-		sniffOverrideDestination,
+	if err := db.AddInbound(dbConnection, db.InboundRecord{
+		Type:                     inboundType,
+		Tag:                      tag,
+		Listen:                   listen,
+		ListenPort:               listenPort,
+		Sniff:                    sniff,
+		SniffOverrideDestination: sniffOverrideDestination,
+		SniffTimeout:             sniffTimeout,
+		Detour:                   detour,
+		Method:                   method,
+		Password:                 password,
+		Flow:                     flow,
+		TransportID:              transportID,
+		TLSID:                    tlsID,
+		RealityID:                realityID,
+		HandshakeID:              handshakeID,
+	}); err != nil {
+		log.Println(err)
+	}
+}
+
+// EditInboundPrompt edits an inbound by its ID, offering the current values as defaults
+func EditInboundPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	inboundID, err := readID(scanner, "Enter the ID of the inbound you want to edit: ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record, err := db.GetInbound(dbConnection, inboundID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record.Type = readString(scanner, "Enter inbounds type", record.Type)
+	record.Tag = readString(scanner, "Enter inbounds tag", record.Tag)
+	record.Listen = readString(scanner, "Enter inbounds listenIP", record.Listen)
+	record.ListenPort = readInt(scanner, "Enter inbounds listenPort", record.ListenPort)
+	record.SniffTimeout = readString(scanner, "Enter inbounds sniffTimeout", record.SniffTimeout)
+	record.Detour = readOptionalString(scanner, "Enter inbounds detour", record.Detour)
+	if record.Type == "shadowsocks" {
+		method := readString(scanner, "Enter shadowsocks method", record.Method)
+		// A new method needs a server key of its own length
+		if method != record.Method {
+			record.Password = ""
+		}
+		record.Method = method
+		record.Password = readOptionalString(scanner, "Enter the server key of 2022 methods, generated if none", record.Password)
+	} else {
+		record.Method, record.Password = "", ""
+	}
+	if record.Type == "vless" {
+		record.Flow = readOptionalString(scanner, "Enter the vless flow", record.Flow)
+	} else {
+		record.Flow = ""
+	}
+	record.Sniff = readBool(scanner, "Enter inbounds sniff", record.Sniff)
+	record.SniffOverrideDestination = readBool(
+		scanner,
+		"Enter inbounds sniffOverrideDestination",
+		record.SniffOverrideDestination,
 	)
+	record.TransportID = readOptionalID(scanner, "Enter the transport ID", record.TransportID)
+	record.TLSID = readOptionalID(scanner, "Enter the tls ID", record.TLSID)
+	record.RealityID = readOptionalID(scanner, "Enter the reality ID", record.RealityID)
+	record.HandshakeID = readOptionalID(scanner, "Enter the handshake ID", record.HandshakeID)
+
+	if err := db.UpdateInbound(dbConnection, record); err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("Inbound updated successfully.")
+	}
 }
@@ -25,6 +25,13 @@ func DisplayInboundManagementMenu() int {
 	fmt.Println("13. Add Handshake configurations")
 	fmt.Println("14. List all Handshake configurations")
 	fmt.Println("15. Delete Handshake by ID")
+	fmt.Println("16. Edit inbound by ID")
+	fmt.Println("17. Edit transport by ID")
+	fmt.Println("18. Edit TLS by ID")
+	fmt.Println("19. Edit Reality by ID")
+	fmt.Println("20. Edit Handshake by ID")
+	fmt.Println("21. Derive missing Reality public keys")
+	fmt.Println("22. Rotate a user's Reality short ID")
 	fmt.Println("0. Return to main menu")
 	fmt.Print("Choose an option: ")
 
@@ -68,6 +75,20 @@ func HandleInboundManagementMenu(scanner *bufio.Scanner, dbConnection *sql.DB) {
 			DisplayHandshakeList(dbConnection)
 		case 15:
 			DeleteHandshakeByID(dbConnection)
+		case 16:
+			EditInboundPrompt(scanner, dbConnection)
+		case 17:
+			EditTransportPrompt(scanner, dbConnection)
+		case 18:
+			EditTLSPrompt(scanner, dbConnection)
+		case 19:
+			EditRealityPrompt(scanner, dbConnection)
+		case 20:
+			EditHandshakePrompt(scanner, dbConnection)
+		case 21:
+			DeriveRealityPublicKeysPrompt(dbConnection)
+		case 22:
+			RotateUserShortIDPrompt(scanner, dbConnection)
 		case 0:
 			return // Return to main menu
 		default:
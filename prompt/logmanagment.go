@@ -0,0 +1,43 @@
+// Package prompt is for printing the prompt
+package prompt
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"winder.website/sbfm/db"
+)
+
+// EditLogSettingsPrompt edits the log settings, offering the current values
+// as defaults. Answering no to the first question removes the log block
+// from config.json.
+func EditLogSettingsPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	settings, ok, err := db.GetLogSettings(dbConnection)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if !readBool(scanner, "Write a log block to config.json", ok) {
+		if err := db.UnsetLogSettings(dbConnection); err != nil {
+			log.Println(err)
+		} else {
+			fmt.Println("Log settings removed.")
+		}
+		return
+	}
+
+	settings.Disabled = readBool(scanner, "Disable logging", settings.Disabled)
+	settings.Level = readString(scanner, fmt.Sprintf("Enter log level (%s)", strings.Join(db.LogLevels, ", ")), settings.Level)
+	settings.Output = readOptionalString(scanner, "Enter log output file, empty for the console", settings.Output)
+	settings.Timestamp = readBool(scanner, "Add timestamps to log lines", settings.Timestamp)
+
+	if err := db.SetLogSettings(dbConnection, settings); err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("Log settings saved.")
+	}
+}
@@ -4,21 +4,32 @@ package prompt
 import (
 	"bufio"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 
 	"winder.website/sbfm/db"
 	"winder.website/sbfm/jsonhandler"
+	"winder.website/sbfm/staging"
 )
 
 // DisplayMenu displays the main menu and returns the user's choice
 func DisplayMenu() int {
 	fmt.Println("\n1. User Management")
 	fmt.Println("2. Generate config.json")
-	fmt.Println("3. Add log block to the database")
+	fmt.Println("3. Edit log settings")
 	fmt.Println("4. Manage Inbounds, Transports, TLS, Reality, Handshake")
 	fmt.Println("5. make users client files")
 	fmt.Println("6. make users sub files")
+	fmt.Println("7. make users share links")
+	fmt.Println("8. make users clash profiles")
+	fmt.Println("9. Roll back the last generation")
+	fmt.Println("10. Preview config.json changes (dry run)")
+	fmt.Println("11. Import a sing-box config.json")
+	fmt.Println("12. Import from an x-ui / 3x-ui database")
+	fmt.Println("13. Manage Outbounds")
+	fmt.Println("14. Manage Route rules and rule sets")
+	fmt.Println("15. Manage DNS servers and rules")
 	fmt.Println("0. Exit")
 	fmt.Print("Choose an option: ")
 
@@ -33,24 +44,40 @@ func HandleMenu(scanner *bufio.Scanner, dbConnection *sql.DB) {
 		choice := DisplayMenu()
 		switch choice {
 		case 1:
-			HandleUserManagementMenu(dbConnection)
+			HandleUserManagementMenu(scanner, dbConnection)
 		case 2:
-			jsonhandler.GenerateConfigFile(dbConnection)
+			GenerateConfigPrompt(dbConnection)
 		case 3:
-			// Add log data
-			err := db.AddLogData(dbConnection, false, "info", "/var/log/app.log", true)
-			if err != nil {
-				log.Fatalf("Failed to add log data: %v", err)
-			}
+			EditLogSettingsPrompt(scanner, dbConnection)
 		case 4:
 			HandleInboundManagementMenu(scanner, dbConnection)
 		case 5:
-			templateFilePath := "./template.json"
-			if err := db.GenerateUserJSONFiles(dbConnection, templateFilePath); err != nil {
-				log.Fatalf("failed to generate user JSON files: %v", err)
-			}
+			GenerateClientFilesPrompt(scanner, dbConnection)
 		case 6:
-			db.GenerateUserConfigFiles(dbConnection)
+			db.GenerateUserConfigFiles(dbConnection, false)
+		case 7:
+			GenerateShareLinksPrompt(scanner, dbConnection)
+		case 8:
+			if err := db.GenerateUserClashProfiles(dbConnection, false); err != nil {
+				log.Println("Error generating clash profiles:", err)
+			}
+		case 9:
+			RollbackPrompt()
+		case 10:
+			err := db.GenerateServerConfig(dbConnection, jsonhandler.GenerateOptions{DryRun: true}, true)
+			if err != nil && !errors.Is(err, staging.ErrPendingChanges) {
+				log.Println("Error previewing config:", err)
+			}
+		case 11:
+			ImportConfigPrompt(scanner, dbConnection)
+		case 12:
+			ImportXUIPrompt(scanner, dbConnection)
+		case 13:
+			HandleOutboundManagementMenu(scanner, dbConnection)
+		case 14:
+			HandleRouteManagementMenu(scanner, dbConnection)
+		case 15:
+			HandleDNSManagementMenu(scanner, dbConnection)
 		case 0:
 			fmt.Println("Exiting...")
 			return
@@ -59,3 +86,106 @@ func HandleMenu(scanner *bufio.Scanner, dbConnection *sql.DB) {
 		}
 	}
 }
+
+// GenerateConfigPrompt generates config.json and reloads sing-box, asking
+// whether to write it anyway when validation finds errors
+func GenerateConfigPrompt(dbConnection *sql.DB) {
+	err := db.GenerateServerConfig(dbConnection, jsonhandler.GenerateOptions{}, false)
+	if errors.Is(err, jsonhandler.ErrInvalidConfig) {
+		log.Println(err)
+		force, inputErr := GetBoolInput("Write config.json anyway (true/false) [default: false]: ")
+		if inputErr != nil || !force {
+			return
+		}
+		err = db.GenerateServerConfig(dbConnection, jsonhandler.GenerateOptions{Force: true}, false)
+	}
+	if err != nil {
+		log.Println("Error generating config:", err)
+	}
+}
+
+// promptPublicHost asks for the public host if it has not been set yet. It
+// reports whether generating can go on.
+func promptPublicHost(scanner *bufio.Scanner, dbConnection *sql.DB) bool {
+	publicHost, err := db.GetSetting(dbConnection, db.SettingPublicHost)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	if publicHost == "" {
+		publicHost = readString(scanner, "Enter the public host clients connect to (e.g., example.com)", "")
+		if publicHost != "" {
+			if err := db.SetSetting(dbConnection, db.SettingPublicHost, publicHost); err != nil {
+				log.Println(err)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// GenerateClientFilesPrompt generates the client JSON files. Generated
+// profiles need the public host, which is asked for first if it has not
+// been set yet.
+func GenerateClientFilesPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	mode, err := db.GetSetting(dbConnection, db.SettingClientProfileMode)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if mode == db.ClientProfileGenerated && !promptPublicHost(scanner, dbConnection) {
+		return
+	}
+
+	templateFilePath := "./template.json"
+	if err := db.GenerateUserClientFiles(dbConnection, templateFilePath, false); err != nil {
+		log.Println("Error generating user JSON files:", err)
+	}
+}
+
+// GenerateShareLinksPrompt generates the share link bundles, asking for the
+// public host first if it has not been set yet
+func GenerateShareLinksPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	if !promptPublicHost(scanner, dbConnection) {
+		return
+	}
+
+	if err := db.GenerateUserShareLinks(dbConnection, false); err != nil {
+		log.Println("Error generating share links:", err)
+	}
+}
+
+// RollbackPrompt restores the files replaced by the last generation
+func RollbackPrompt() {
+	restored, err := staging.Rollback("")
+	if err != nil {
+		log.Println("Error rolling back:", err)
+		return
+	}
+	fmt.Printf("Restored backup %s.\n", restored)
+}
+
+// ImportConfigPrompt imports the inbounds and users of a sing-box config.json
+func ImportConfigPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	filename := readString(scanner, "Enter the path of the config.json to import", "./sing-box/config.json")
+
+	report, err := db.ImportConfigFile(dbConnection, filename)
+	if err != nil {
+		log.Println("Error importing config:", err)
+		return
+	}
+	report.Print()
+}
+
+// ImportXUIPrompt imports the inbounds and clients of an x-ui database
+func ImportXUIPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	filename := readString(scanner, "Enter the path of the x-ui database", "/etc/x-ui/x-ui.db")
+
+	report, err := db.ImportXUIDatabase(dbConnection, filename)
+	if err != nil {
+		log.Println("Error importing x-ui database:", err)
+		return
+	}
+	report.Print()
+}
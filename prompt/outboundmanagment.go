@@ -0,0 +1,198 @@
+// Package prompt is for printing the prompt
+package prompt
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"winder.website/sbfm/db"
+	"winder.website/sbfm/jsonhandler"
+)
+
+// DisplayOutboundManagementMenu displays the menu for managing outbounds
+func DisplayOutboundManagementMenu() int {
+	fmt.Println("\nOutbound Management Menu:")
+	fmt.Println("1. Add outbound")
+	fmt.Println("2. List all outbounds")
+	fmt.Println("3. Edit outbound by ID")
+	fmt.Println("4. Delete outbound by ID")
+	fmt.Println("0. Return to main menu")
+	fmt.Print("Choose an option: ")
+
+	var choice int
+	fmt.Scanln(&choice)
+	return choice
+}
+
+// HandleOutboundManagementMenu handles user input for the outbound options
+func HandleOutboundManagementMenu(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	for {
+		choice := DisplayOutboundManagementMenu()
+		switch choice {
+		case 1:
+			AddOutboundPrompt(scanner, dbConnection)
+		case 2:
+			DisplayOutboundList(dbConnection)
+		case 3:
+			EditOutboundPrompt(scanner, dbConnection)
+		case 4:
+			DeleteOutboundByID(dbConnection)
+		case 0:
+			return // Return to main menu
+		default:
+			fmt.Println("Invalid option. Please try again.")
+		}
+	}
+}
+
+// DisplayOutboundList lists all available outbounds in the database
+func DisplayOutboundList(dbConnection *sql.DB) {
+	if err := db.PrintOutbounds(dbConnection); err != nil {
+		log.Println("Error displaying outbounds:", err)
+	}
+}
+
+// DeleteOutboundByID deletes an outbound by its ID from the database
+func DeleteOutboundByID(dbConnection *sql.DB) {
+	fmt.Print("Enter the ID of the outbound you want to delete: ")
+	var outboundID int
+	_, err := fmt.Scanf("%d\n", &outboundID)
+	if err != nil {
+		log.Println("Invalid input:", err)
+		return
+	}
+
+	err = db.DeleteOutbound(dbConnection, outboundID)
+	if err != nil {
+		log.Println("Error deleting outbound:", err)
+	} else {
+		fmt.Println("Outbound deleted successfully.")
+	}
+}
+
+// AddOutboundPrompt asks for the type of a new outbound and then for the
+// fields that type takes
+func AddOutboundPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	record := db.OutboundRecord{Type: "direct"}
+	record.Type = readString(
+		scanner,
+		"Enter outbound type ("+strings.Join(db.OutboundTypes, ", ")+")",
+		record.Type,
+	)
+	record.Tag = record.Type
+	if record.Type == "dns" {
+		record.Tag = "dns-out"
+	}
+	if record.Type == "socks" {
+		record.Version = "5"
+	}
+	if record.Type == "wireguard" {
+		record.MTU = 1408
+	}
+
+	readOutbound(scanner, &record)
+
+	if err := db.AddOutbound(dbConnection, record); err != nil {
+		log.Println(err)
+	}
+}
+
+// EditOutboundPrompt edits an outbound by its ID, offering the current values as defaults
+func EditOutboundPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	outboundID, err := readID(scanner, "Enter the ID of the outbound you want to edit: ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record, err := db.GetOutbound(dbConnection, outboundID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record.Type = readString(
+		scanner,
+		"Enter outbound type ("+strings.Join(db.OutboundTypes, ", ")+")",
+		record.Type,
+	)
+	readOutbound(scanner, &record)
+
+	if err := db.UpdateOutbound(dbConnection, record); err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("Outbound updated successfully.")
+	}
+}
+
+// readOutbound asks for the fields that the type of record takes, offering
+// the current values as defaults
+func readOutbound(scanner *bufio.Scanner, record *db.OutboundRecord) {
+	record.Tag = readString(scanner, "Enter outbound tag", record.Tag)
+	if record.Type == "block" || record.Type == "dns" {
+		return
+	}
+
+	if record.Type != "direct" {
+		record.Server = readString(scanner, "Enter server address", record.Server)
+		record.ServerPort = readInt(scanner, "Enter server port", record.ServerPort)
+	}
+
+	switch record.Type {
+	case "socks":
+		record.Version = readString(scanner, "Enter socks version (4, 4a, 5)", record.Version)
+		record.Username = readOptionalString(scanner, "Enter username", record.Username)
+		record.Password = readOptionalString(scanner, "Enter password", record.Password)
+	case "http":
+		record.Username = readOptionalString(scanner, "Enter username", record.Username)
+		record.Password = readOptionalString(scanner, "Enter password", record.Password)
+		readOutboundTLS(scanner, record)
+	case "shadowsocks":
+		record.Method = readString(scanner, "Enter method (e.g., 2022-blake3-aes-128-gcm)", record.Method)
+		record.Password = readString(scanner, "Enter password", record.Password)
+	case "vless":
+		record.UUID = readString(scanner, "Enter uuid", record.UUID)
+		record.Flow = readOptionalString(scanner, "Enter flow (e.g., xtls-rprx-vision)", record.Flow)
+		readOutboundTLS(scanner, record)
+		record.TransportID = readOptionalID(scanner, "Enter the transport ID", record.TransportID)
+	case "wireguard":
+		record.LocalAddress = jsonhandler.SplitList(readString(
+			scanner,
+			"Enter local addresses, comma separated (e.g., 10.0.0.2/32)",
+			strings.Join(record.LocalAddress, ","),
+		))
+		record.PrivateKey = readString(scanner, "Enter private key", record.PrivateKey)
+		record.PeerPublicKey = readString(scanner, "Enter peer public key", record.PeerPublicKey)
+		record.PreSharedKey = readOptionalString(scanner, "Enter pre-shared key", record.PreSharedKey)
+		record.Reserved = readOptionalString(scanner, "Enter reserved bytes (e.g., 0,0,0)", record.Reserved)
+		record.MTU = readInt(scanner, "Enter MTU", record.MTU)
+	}
+
+	// Dial fields
+	record.Detour = readOptionalString(scanner, "Enter detour outbound tag", record.Detour)
+	record.BindInterface = readOptionalString(scanner, "Enter bind interface", record.BindInterface)
+	record.RoutingMark = readInt(scanner, "Enter routing mark (0 for none)", record.RoutingMark)
+	record.DomainStrategy = readOptionalString(
+		scanner,
+		"Enter domain strategy (prefer_ipv4, prefer_ipv6, ipv4_only, ipv6_only)",
+		record.DomainStrategy,
+	)
+}
+
+// readOutboundTLS asks for the client TLS fields of an outbound
+func readOutboundTLS(scanner *bufio.Scanner, record *db.OutboundRecord) {
+	record.TLSEnabled = readBool(scanner, "Enable TLS", record.TLSEnabled)
+	if !record.TLSEnabled {
+		return
+	}
+	record.TLSServerName = readOptionalString(scanner, "Enter TLS server name", record.TLSServerName)
+	record.TLSInsecure = readBool(scanner, "Accept any server certificate", record.TLSInsecure)
+	record.UTLSFingerprint = readOptionalString(scanner, "Enter uTLS fingerprint (e.g., chrome)", record.UTLSFingerprint)
+	record.RealityPublicKey = readOptionalString(scanner, "Enter Reality public key", record.RealityPublicKey)
+	if record.RealityPublicKey != "" {
+		record.RealityShortID = readOptionalString(scanner, "Enter Reality short ID", record.RealityShortID)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 
 	"winder.website/sbfm/db"
+	"winder.website/sbfm/jsonhandler"
 )
 
 // DisplayRealityList lists all available Reality configurations in the database
@@ -37,10 +39,9 @@ func DeleteRealityByID(dbConnection *sql.DB) {
 
 // AddRealityPrompt to handle transport input
 func AddRealityPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
-	var privateKey, shortID string
+	var privateKey string
 
 	const defaultprivetkey = "wKKkpH2-ccPqK3JUfrGiCcd62uZSsLBOScNRBd_BMUk"
-	const defaultshortid = "3a630a0a"
 
 	// Helper function to scan input with default fallback
 	readInput := func(prompt string, defaultValue string) string {
@@ -63,20 +64,129 @@ func AddRealityPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
 		return
 	}
 
-	privateKey = readInput(
-		"Enter reality's privetkey (e.g., jdasflkjdsfj) [default= wKKkpH2-ccPqK3JUfrGiCcd62uZSsLBOScNRBd_BMUk]: ",
-		defaultprivetkey,
+	generateKeys, err := GetBoolInput(
+		"Generate a new x25519 key pair (true/false) [default: true]: ",
 	)
+	if err != nil {
+		generateKeys = true
+	}
 
-	shortID = readInput(
-		"Enter reality's shortID (e.g., 3a630a0a) [ default= 3a630a0a]: ",
-		defaultshortid,
+	if generateKeys {
+		privateKey, _, err = db.GenerateRealityKeyPair()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	} else {
+		privateKey = readInput(
+			"Enter reality's privetkey (e.g., jdasflkjdsfj) [default= wKKkpH2-ccPqK3JUfrGiCcd62uZSsLBOScNRBd_BMUk]: ",
+			defaultprivetkey,
+		)
+	}
+
+	shortIDs := jsonhandler.SplitList(readInput(
+		"Enter reality's shortIDs, comma separated (e.g., 3a630a0a,0123) [default= one random]: ",
+		"",
+	))
+	if len(shortIDs) == 0 {
+		shortIDs, err = db.GenerateShortIDs(1, 8)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	serverNames := jsonhandler.SplitList(readInput(
+		"Enter reality's server names, comma separated (e.g., www.yahoo.com) [default= none]: ",
+		"",
+	))
+
+	perUserShortIDs, err := GetBoolInput(
+		"Give every user a shortID of their own (true/false) [default: false]: ",
 	)
+	if err != nil {
+		perUserShortIDs = false
+	}
 
-	db.AddReality(
+	if err := db.AddReality(
 		dbConnection,
 		enabledValue,
 		privateKey,
-		shortID,
+		shortIDs,
+		serverNames,
+		perUserShortIDs,
+	); err != nil {
+		log.Println(err)
+	}
+}
+
+// EditRealityPrompt edits a Reality configuration by its ID, offering the current values as defaults
+func EditRealityPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	realityID, err := readID(scanner, "Enter the ID of the Reality configuration you want to edit: ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record, err := db.GetReality(dbConnection, realityID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record.Enabled = readBool(scanner, "Enter if you want reality to be enabled", record.Enabled)
+	record.PrivateKey = readString(scanner, "Enter reality's privetkey", record.PrivateKey)
+	record.ShortIDs = jsonhandler.SplitList(readString(
+		scanner,
+		"Enter reality's shortIDs, comma separated",
+		strings.Join(record.ShortIDs, ","),
+	))
+	record.ServerNames = jsonhandler.SplitList(readString(
+		scanner,
+		"Enter reality's server names, comma separated",
+		strings.Join(record.ServerNames, ","),
+	))
+	record.PerUserShortIDs = readBool(
+		scanner,
+		"Give every user a shortID of their own",
+		record.PerUserShortIDs,
 	)
+
+	if err := db.UpdateReality(dbConnection, record); err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("Reality configuration updated successfully.")
+	}
+}
+
+// DeriveRealityPublicKeysPrompt derives the public key of every Reality
+// configuration that does not have one yet
+func DeriveRealityPublicKeysPrompt(dbConnection *sql.DB) {
+	derived, err := db.DeriveRealityPublicKeys(dbConnection)
+	if err != nil {
+		log.Println("Error deriving Reality public keys:", err)
+		return
+	}
+	fmt.Printf("Derived %d Reality public key(s).\n", derived)
+}
+
+// RotateUserShortIDPrompt issues a user a new shortID on a Reality
+// configuration with per-user shortIDs
+func RotateUserShortIDPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	realityID, err := readID(scanner, "Enter the ID of the Reality configuration: ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	userID, err := readID(scanner, "Enter the ID of the user: ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := db.RotateUserShortID(dbConnection, realityID, userID); err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("Short ID rotated successfully.")
+	}
 }
@@ -0,0 +1,257 @@
+// Package prompt is for printing the prompt
+package prompt
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"winder.website/sbfm/db"
+	"winder.website/sbfm/jsonhandler"
+)
+
+// DisplayRouteManagementMenu displays the menu for managing the route block
+func DisplayRouteManagementMenu() int {
+	fmt.Println("\nRoute Management Menu:")
+	fmt.Println("1. Add route rule")
+	fmt.Println("2. List route rules")
+	fmt.Println("3. Edit route rule by ID")
+	fmt.Println("4. Delete route rule by ID")
+	fmt.Println("5. Add rule set")
+	fmt.Println("6. List rule sets")
+	fmt.Println("7. Edit rule set by ID")
+	fmt.Println("8. Delete rule set by ID")
+	fmt.Println("9. Set final outbound and auto_detect_interface")
+	fmt.Println("0. Return to main menu")
+	fmt.Print("Choose an option: ")
+
+	var choice int
+	fmt.Scanln(&choice)
+	return choice
+}
+
+// HandleRouteManagementMenu handles user input for the route options
+func HandleRouteManagementMenu(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	for {
+		choice := DisplayRouteManagementMenu()
+		switch choice {
+		case 1:
+			AddRouteRulePrompt(scanner, dbConnection)
+		case 2:
+			if err := db.PrintRouteRules(dbConnection); err != nil {
+				log.Println("Error displaying route rules:", err)
+			}
+		case 3:
+			EditRouteRulePrompt(scanner, dbConnection)
+		case 4:
+			deleteByIDPrompt(scanner, dbConnection, "route rule", db.DeleteRouteRule)
+		case 5:
+			AddRuleSetPrompt(scanner, dbConnection)
+		case 6:
+			if err := db.PrintRuleSets(dbConnection); err != nil {
+				log.Println("Error displaying rule sets:", err)
+			}
+		case 7:
+			EditRuleSetPrompt(scanner, dbConnection)
+		case 8:
+			deleteByIDPrompt(scanner, dbConnection, "rule set", db.DeleteRuleSet)
+		case 9:
+			RouteSettingsPrompt(scanner, dbConnection)
+		case 0:
+			return // Return to main menu
+		default:
+			fmt.Println("Invalid option. Please try again.")
+		}
+	}
+}
+
+// deleteByIDPrompt asks for the ID of a row and deletes it with remove
+func deleteByIDPrompt(scanner *bufio.Scanner, dbConnection *sql.DB, what string, remove func(*sql.DB, int) error) {
+	id, err := readID(scanner, fmt.Sprintf("Enter the ID of the %s you want to delete: ", what))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := remove(dbConnection, id); err != nil {
+		log.Printf("Error deleting %s: %v", what, err)
+	} else {
+		fmt.Printf("Deleted %s %d.\n", what, id)
+	}
+}
+
+// AddRouteRulePrompt asks for a new route rule
+func AddRouteRulePrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	var record db.RouteRuleRecord
+	record.Type = db.RuleTypeDefault
+	if err := db.PrintRouteRules(dbConnection); err != nil {
+		log.Println(err)
+	}
+
+	readRouteRule(scanner, &record)
+
+	if err := db.AddRouteRule(dbConnection, record); err != nil {
+		log.Println(err)
+	}
+}
+
+// EditRouteRulePrompt edits a route rule by its ID, offering the current values as defaults
+func EditRouteRulePrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	ruleID, err := readID(scanner, "Enter the ID of the route rule you want to edit: ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record, err := db.GetRouteRule(dbConnection, ruleID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	readRouteRule(scanner, &record)
+
+	if err := db.UpdateRouteRule(dbConnection, record); err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("Route rule updated successfully.")
+	}
+}
+
+// readRouteRule asks for the fields of a route rule, offering the current
+// values as defaults
+func readRouteRule(scanner *bufio.Scanner, record *db.RouteRuleRecord) {
+	readRule(scanner, &record.RuleRecord)
+	if record.ParentID == nil {
+		record.Outbound = readString(scanner, "Enter the outbound tag for matching connections", record.Outbound)
+	} else {
+		record.Outbound = ""
+	}
+}
+
+// readRule asks for the fields shared by route and DNS rules
+func readRule(scanner *bufio.Scanner, record *db.RuleRecord) {
+	record.ParentID = readOptionalID(scanner, "Enter the ID of the logical rule this is a sub-rule of", record.ParentID)
+	record.Priority = readInt(scanner, "Enter priority, lowest applies first (0 to go after the others)", record.Priority)
+	record.Type = readString(scanner, "Enter rule type (default, logical)", record.Type)
+
+	if record.Type == db.RuleTypeLogical {
+		if record.Mode == "" {
+			record.Mode = "or"
+		}
+		record.Mode = readString(scanner, "Enter mode (and, or)", record.Mode)
+		record.RuleMatchers = db.RuleMatchers{}
+		fmt.Println("Add the sub-rules of this rule with it as their parent.")
+	} else {
+		record.Mode = ""
+		readRuleMatchers(scanner, &record.RuleMatchers)
+	}
+
+	record.Invert = readBool(scanner, "Invert the match", record.Invert)
+}
+
+// readRuleMatchers asks for every matcher of a rule as a comma separated list
+func readRuleMatchers(scanner *bufio.Scanner, matchers *db.RuleMatchers) {
+	readList := func(prompt string, current []string) []string {
+		return jsonhandler.SplitList(readOptionalString(scanner, prompt+", comma separated", strings.Join(current, ",")))
+	}
+
+	matchers.Inbound = readList("Enter inbound tags", matchers.Inbound)
+	matchers.Protocol = readList("Enter sniffed protocols (e.g., tls, quic, bittorrent)", matchers.Protocol)
+	matchers.Domain = readList("Enter domains", matchers.Domain)
+	matchers.DomainSuffix = readList("Enter domain suffixes (e.g., cn, ads.example.com)", matchers.DomainSuffix)
+	matchers.IPCIDR = readList("Enter IP ranges (e.g., 10.0.0.0/8)", matchers.IPCIDR)
+
+	ports := make([]string, len(matchers.Port))
+	for i, port := range matchers.Port {
+		ports[i] = strconv.Itoa(port)
+	}
+	parsed, err := db.ParsePorts(strings.Join(readList("Enter ports", ports), ","))
+	if err != nil {
+		log.Printf("%v, keeping the current ports.", err)
+	} else {
+		matchers.Port = parsed
+	}
+
+	matchers.RuleSet = readList("Enter rule set tags", matchers.RuleSet)
+}
+
+// AddRuleSetPrompt asks for a new rule set
+func AddRuleSetPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	record := db.RuleSetRecord{Type: "remote", Format: "binary"}
+	readRuleSet(scanner, &record)
+
+	if err := db.AddRuleSet(dbConnection, record); err != nil {
+		log.Println(err)
+	}
+}
+
+// EditRuleSetPrompt edits a rule set by its ID, offering the current values as defaults
+func EditRuleSetPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	ruleSetID, err := readID(scanner, "Enter the ID of the rule set you want to edit: ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record, err := db.GetRuleSet(dbConnection, ruleSetID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	readRuleSet(scanner, &record)
+
+	if err := db.UpdateRuleSet(dbConnection, record); err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("Rule set updated successfully.")
+	}
+}
+
+// readRuleSet asks for the fields of a rule set, offering the current
+// values as defaults
+func readRuleSet(scanner *bufio.Scanner, record *db.RuleSetRecord) {
+	record.Tag = readString(scanner, "Enter rule set tag (e.g., geosite-cn)", record.Tag)
+	record.Type = readString(scanner, "Enter rule set type (local, remote)", record.Type)
+	record.Format = readString(scanner, "Enter rule set format (source, binary)", record.Format)
+
+	if record.Type == "local" {
+		record.Path = readString(scanner, "Enter rule set path", record.Path)
+		return
+	}
+	record.URL = readString(scanner, "Enter rule set URL", record.URL)
+	record.DownloadDetour = readOptionalString(scanner, "Enter download detour outbound tag", record.DownloadDetour)
+	record.UpdateInterval = readOptionalString(scanner, "Enter update interval (e.g., 1d)", record.UpdateInterval)
+}
+
+// RouteSettingsPrompt changes route.final and route.auto_detect_interface
+func RouteSettingsPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	final, err := db.GetSetting(dbConnection, db.SettingRouteFinal)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	autoDetect, err := db.GetSetting(dbConnection, db.SettingRouteAutoDetectInterface)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	final = readOptionalString(scanner, "Enter the final outbound tag", final)
+	if err := db.SetRouteFinal(dbConnection, final); err != nil {
+		log.Println(err)
+		return
+	}
+
+	enabled := readBool(scanner, "Enable auto_detect_interface", autoDetect == "true")
+	err = db.SetSetting(dbConnection, db.SettingRouteAutoDetectInterface, strconv.FormatBool(enabled))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	fmt.Println("Route settings saved.")
+}
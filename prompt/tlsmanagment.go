@@ -92,7 +92,7 @@ func AddTLSPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
 		defaultkeyPath,
 	)
 
-	db.AddTLS(
+	if err := db.AddTLS(
 		dbConnection,
 		enabledValue,
 		serverName,
@@ -100,5 +100,35 @@ func AddTLSPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
 		maxVersion,
 		certificatePath,
 		keyPath,
-	)
+	); err != nil {
+		log.Println(err)
+	}
+}
+
+// EditTLSPrompt edits a TLS configuration by its ID, offering the current values as defaults
+func EditTLSPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	tlsID, err := readID(scanner, "Enter the ID of the TLS configuration you want to edit: ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record, err := db.GetTLS(dbConnection, tlsID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record.Enabled = readBool(scanner, "Enter if you want tls to be enabled", record.Enabled)
+	record.ServerName = readString(scanner, "Enter server-name", record.ServerName)
+	record.MinVersion = readString(scanner, "Enter tls minVersion", record.MinVersion)
+	record.MaxVersion = readString(scanner, "Enter tls maxVersion", record.MaxVersion)
+	record.CertificatePath = readString(scanner, "Enter tls certificatePath", record.CertificatePath)
+	record.KeyPath = readString(scanner, "Enter tls keyPath", record.KeyPath)
+
+	if err := db.UpdateTLS(dbConnection, record); err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("TLS configuration updated successfully.")
+	}
 }
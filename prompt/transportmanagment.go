@@ -73,3 +73,27 @@ func AddTransportPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
 		fmt.Println("Transport configuration saved.")
 	}
 }
+
+// EditTransportPrompt edits a transport by its ID, offering the current values as defaults
+func EditTransportPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	transportID, err := readID(scanner, "Enter the ID of the transport you want to edit: ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record, err := db.GetTransport(dbConnection, transportID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record.Type = readString(scanner, "Enter transport type", record.Type)
+	record.Path = readString(scanner, "Enter transport path", record.Path)
+
+	if err := db.UpdateTransport(dbConnection, record); err != nil {
+		log.Println(err)
+	} else {
+		fmt.Println("Transport updated successfully.")
+	}
+}
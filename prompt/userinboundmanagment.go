@@ -0,0 +1,97 @@
+// Package prompt is for printing the prompt
+package prompt
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"winder.website/sbfm/db"
+)
+
+// readUserAndTag asks for a user ID and an inbound tag
+func readUserAndTag() (int, string, error) {
+	var userID int
+	var tag string
+
+	fmt.Print("Enter the ID of the user: ")
+	if _, err := fmt.Scanln(&userID); err != nil {
+		return 0, "", fmt.Errorf("invalid input: %v", err)
+	}
+
+	fmt.Print("Enter the tag of the inbound: ")
+	if _, err := fmt.Scanln(&tag); err != nil {
+		return 0, "", fmt.Errorf("invalid input: %v", err)
+	}
+
+	return userID, tag, nil
+}
+
+// GrantUserInboundPrompt gives a user access to an inbound
+func GrantUserInboundPrompt(dbConnection *sql.DB) {
+	DisplayInboundList(dbConnection)
+
+	userID, tag, err := readUserAndTag()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := db.GrantUserInbound(dbConnection, userID, tag); err != nil {
+		log.Println("Error granting inbound:", err)
+	} else {
+		fmt.Printf("User %d can now use inbound %s.\n", userID, tag)
+	}
+}
+
+// RevokeUserInboundPrompt removes a user's access to an inbound
+func RevokeUserInboundPrompt(dbConnection *sql.DB) {
+	userID, tag, err := readUserAndTag()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := db.RevokeUserInbound(dbConnection, userID, tag); err != nil {
+		log.Println("Error revoking inbound:", err)
+	} else {
+		fmt.Printf("User %d can no longer use inbound %s.\n", userID, tag)
+	}
+}
+
+// DisplayUserInbounds lists the inbounds a user has been granted
+func DisplayUserInbounds(dbConnection *sql.DB) {
+	var userID int
+	fmt.Print("Enter the ID of the user: ")
+	if _, err := fmt.Scanln(&userID); err != nil {
+		log.Println("Invalid input:", err)
+		return
+	}
+
+	if err := db.PrintUserInbounds(dbConnection, userID); err != nil {
+		log.Println("Error displaying user inbounds:", err)
+	}
+}
+
+// SetNewUserInboundsPrompt sets which inbounds new users get by default
+func SetNewUserInboundsPrompt(dbConnection *sql.DB) {
+	current, err := db.GetSetting(dbConnection, db.SettingNewUserInbounds)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	fmt.Printf("New users are granted %s inbounds.\n", current)
+	fmt.Printf("Enter the default for new users (all/none) [default: %s]: ", current)
+	var mode string
+	fmt.Scanln(&mode)
+	if mode == "" {
+		mode = current
+	}
+
+	if err := db.SetNewUserInbounds(dbConnection, mode); err != nil {
+		log.Println(err)
+	} else {
+		fmt.Printf("New users will be granted %s inbounds.\n", mode)
+	}
+}
@@ -2,8 +2,11 @@
 package prompt
 
 import (
+	"bufio"
 	"database/sql"
 	"fmt"
+	"log"
+	"time"
 
 	"winder.website/sbfm/db"
 )
@@ -16,6 +19,15 @@ func DisplayUserManagementMenu() int {
 	fmt.Println("3. Print all users")
 	fmt.Println("4. Delete user by ID")
 	fmt.Println("5. Activate/Deactivate user by ID")
+	fmt.Println("6. Grant user access to an inbound")
+	fmt.Println("7. Revoke user access to an inbound")
+	fmt.Println("8. List inbounds of a user")
+	fmt.Println("9. Set default inbound access for new users")
+	fmt.Println("10. Extend user by N days")
+	fmt.Println("11. Deactivate expired users now")
+	fmt.Println("12. Show traffic of the last 30 days")
+	fmt.Println("13. Set user quota")
+	fmt.Println("14. Reset user usage")
 	fmt.Println("0. Return to main menu")
 	fmt.Print("Choose an option: ")
 
@@ -25,7 +37,7 @@ func DisplayUserManagementMenu() int {
 }
 
 // HandleUserManagementMenu handles user input for management options
-func HandleUserManagementMenu(dbConnection *sql.DB) {
+func HandleUserManagementMenu(scanner *bufio.Scanner, dbConnection *sql.DB) {
 	for {
 		choice := DisplayUserManagementMenu()
 		switch choice {
@@ -34,11 +46,34 @@ func HandleUserManagementMenu(dbConnection *sql.DB) {
 		case 2:
 			db.AddUsersFromJSON(dbConnection)
 		case 3:
-			db.PrintAllUsers(dbConnection)
+			if err := db.PrintAllUsers(dbConnection); err != nil {
+				log.Println(err)
+			}
 		case 4:
 			db.DeleteUserByID(dbConnection)
 		case 5:
 			db.ToggleUserActiveStatus(dbConnection)
+		case 6:
+			GrantUserInboundPrompt(dbConnection)
+		case 7:
+			RevokeUserInboundPrompt(dbConnection)
+		case 8:
+			DisplayUserInbounds(dbConnection)
+		case 9:
+			SetNewUserInboundsPrompt(dbConnection)
+		case 10:
+			ExtendUserPrompt(dbConnection)
+		case 11:
+			ExpireUsersPrompt(dbConnection)
+		case 12:
+			now := time.Now()
+			if err := db.PrintTrafficUsage(dbConnection, now.AddDate(0, 0, -29), now); err != nil {
+				log.Println(err)
+			}
+		case 13:
+			SetUserQuotaPrompt(scanner, dbConnection)
+		case 14:
+			ResetUserUsagePrompt(dbConnection)
 		case 0:
 			// Return to the main menu
 			return
@@ -47,3 +82,89 @@ func HandleUserManagementMenu(dbConnection *sql.DB) {
 		}
 	}
 }
+
+// ExtendUserPrompt extends a user by a number of days
+func ExtendUserPrompt(dbConnection *sql.DB) {
+	var id, days int
+	fmt.Print("Enter the ID of the user to extend: ")
+	if _, err := fmt.Scanln(&id); err != nil {
+		log.Printf("Error reading input: %v", err)
+		return
+	}
+	fmt.Print("Enter the number of days to add: ")
+	if _, err := fmt.Scanln(&days); err != nil {
+		log.Printf("Error reading input: %v", err)
+		return
+	}
+
+	expiresAt, err := db.ExtendUser(dbConnection, id, days, time.Now())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	fmt.Printf("User with ID %d is active until %s\n", id, expiresAt.Format("2006-01-02 15:04"))
+}
+
+// ExpireUsersPrompt deactivates the expired users and regenerates the
+// affected files
+func ExpireUsersPrompt(dbConnection *sql.DB) {
+	expired, err := db.SweepExpiredUsers(dbConnection, "./template.json", false)
+	if err != nil {
+		log.Println(err)
+	}
+	fmt.Printf("%d user(s) expired.\n", len(expired))
+}
+
+// SetUserQuotaPrompt sets or removes the quota of a user
+func SetUserQuotaPrompt(scanner *bufio.Scanner, dbConnection *sql.DB) {
+	id, err := readID(scanner, "Enter the ID of the user: ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	gib := readInt(scanner, "Enter the quota in GiB per period (0 for unlimited)", 0)
+	if gib <= 0 {
+		if err := db.SetUserQuota(dbConnection, id, nil); err != nil {
+			log.Println(err)
+			return
+		}
+		fmt.Printf("User with ID %d has no quota\n", id)
+		return
+	}
+
+	quota := db.Quota{Bytes: int64(gib) << 30}
+	quota.Period = readString(scanner, "Enter the period (monthly, rolling)", db.QuotaMonthly)
+	if quota.Period == db.QuotaRolling {
+		anchor := readString(scanner, "Enter the first day of the period (YYYY-MM-DD, empty for today)", "")
+		quota.Anchor = time.Now()
+		if anchor != "" {
+			if quota.Anchor, err = time.ParseInLocation("2006-01-02", anchor, time.Local); err != nil {
+				log.Printf("Invalid date %q", anchor)
+				return
+			}
+		}
+	}
+
+	if err := db.SetUserQuota(dbConnection, id, &quota); err != nil {
+		log.Println(err)
+		return
+	}
+	fmt.Printf("User with ID %d has a %s quota of %d GiB\n", id, quota.Period, gib)
+}
+
+// ResetUserUsagePrompt starts the quota usage of a user over
+func ResetUserUsagePrompt(dbConnection *sql.DB) {
+	var id int
+	fmt.Print("Enter the ID of the user to reset: ")
+	if _, err := fmt.Scanln(&id); err != nil {
+		log.Printf("Error reading input: %v", err)
+		return
+	}
+
+	if err := db.ResetUserUsage(dbConnection, id, time.Now()); err != nil {
+		log.Println(err)
+		return
+	}
+	fmt.Printf("Usage of user with ID %d reset, the user is active\n", id)
+}
@@ -0,0 +1,101 @@
+// Package reload checks a freshly generated sing-box config and makes the
+// running sing-box pick it up.
+package reload
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Values of Hooks.CheckMode.
+const (
+	// CheckAuto checks configs when the sing-box binary is found on PATH.
+	CheckAuto = "auto"
+	// CheckOn refuses every config that could not be checked.
+	CheckOn = "on"
+	// CheckOff never checks configs.
+	CheckOff = "off"
+)
+
+// Hooks describes what runs around a config generation.
+type Hooks struct {
+	// CheckMode decides whether `sing-box check` vetoes new configs.
+	CheckMode string
+	// SingBox is the sing-box binary, looked up on PATH if it has no slash.
+	SingBox string
+	// PidFile holds the pid of the sing-box process that gets SIGHUP.
+	PidFile string
+	// Command is run through sh after a new config is in place, e.g.
+	// "systemctl reload sing-box".
+	Command string
+}
+
+// Check runs `sing-box check -c path` and returns an error holding the
+// output of sing-box if the config is rejected.
+func (hooks Hooks) Check(path string) error {
+	if hooks.CheckMode == CheckOff {
+		return nil
+	}
+
+	binary, err := exec.LookPath(hooks.SingBox)
+	if err != nil {
+		if hooks.CheckMode == CheckOn {
+			return fmt.Errorf("error checking config: %v", err)
+		}
+		fmt.Printf("%s not found, skipping config check\n", hooks.SingBox)
+		return nil
+	}
+
+	output, err := exec.Command(binary, "check", "-c", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("config rejected by %s check: %v\n%s", hooks.SingBox, err, strings.TrimSpace(string(output)))
+	}
+	fmt.Println("Config passed sing-box check.")
+	return nil
+}
+
+// Reload sends SIGHUP to the process in the pidfile and then runs the
+// reload command, reporting the output of each. Both are optional.
+func (hooks Hooks) Reload() error {
+	if hooks.PidFile != "" {
+		pid, err := readPidFile(hooks.PidFile)
+		if err != nil {
+			return err
+		}
+		if err := syscall.Kill(pid, syscall.SIGHUP); err != nil {
+			return fmt.Errorf("error sending SIGHUP to %d: %v", pid, err)
+		}
+		fmt.Printf("Sent SIGHUP to sing-box (pid %d).\n", pid)
+	}
+
+	if hooks.Command != "" {
+		output, err := exec.Command("sh", "-c", hooks.Command).CombinedOutput()
+		if len(output) > 0 {
+			fmt.Printf("Output of %q:\n%s\n", hooks.Command, strings.TrimSpace(string(output)))
+		}
+		if err != nil {
+			return fmt.Errorf("error running reload command %q: %v", hooks.Command, err)
+		}
+		fmt.Printf("Ran reload command %q.\n", hooks.Command)
+	}
+
+	return nil
+}
+
+// readPidFile reads the pid of a running process from path
+func readPidFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("error reading pidfile: %v", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid in %s: %q", path, strings.TrimSpace(string(data)))
+	}
+	return pid, nil
+}
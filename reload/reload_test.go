@@ -0,0 +1,166 @@
+package reload
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"winder.website/sbfm/staging"
+)
+
+// fakeSingBox puts a sing-box script first on PATH. It appends its
+// arguments to the returned log file and exits with status, printing an
+// error the way sing-box does when it rejects a config.
+func fakeSingBox(t *testing.T, status int) string {
+	t.Helper()
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$@" >> '%s'
+if [ %d -ne 0 ]; then
+	echo 'FATAL[0000] decode config: unknown field "bogus"'
+fi
+exit %d
+`, calls, status, status)
+	if err := os.WriteFile(filepath.Join(dir, "sing-box"), []byte(script), 0o755); err != nil {
+		t.Fatalf("error writing fake sing-box: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return calls
+}
+
+// configDir returns a sing-box directory holding config.json with the
+// given content and points the staging backups into it
+func configDir(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	previous := staging.BackupDir
+	staging.BackupDir = filepath.Join(dir, "backups")
+	t.Cleanup(func() { staging.BackupDir = previous })
+
+	target := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(target, []byte(content), 0o644); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+	return target
+}
+
+// generate writes a config the way config generation does: sing-box checks
+// the staged file, which only then replaces the target, and sing-box is
+// reloaded once the new config is in place
+func generate(hooks Hooks, target, content string) error {
+	if err := staging.WriteFile(target, []byte(content), hooks.Check); err != nil {
+		return err
+	}
+	return hooks.Reload()
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", path, err)
+	}
+	return string(data)
+}
+
+// checkedPath returns the path the fake sing-box was asked to check, which
+// has to be a staged file next to target
+func checkedPath(t *testing.T, calls, target string) string {
+	t.Helper()
+	call := strings.TrimSuffix(readFile(t, calls), "\n")
+	path, ok := strings.CutPrefix(call, "check -c ")
+	if !ok || filepath.Dir(path) != filepath.Dir(target) || !strings.HasPrefix(filepath.Base(path), ".config.json.staging-") {
+		t.Fatalf("sing-box called with %q, want a check of the staged config", call)
+	}
+	return path
+}
+
+func TestRejectedConfigIsNotCommitted(t *testing.T) {
+	calls := fakeSingBox(t, 1)
+	target := configDir(t, "old")
+	reloaded := filepath.Join(t.TempDir(), "reloaded")
+	hooks := Hooks{CheckMode: CheckOn, SingBox: "sing-box", Command: "touch '" + reloaded + "'"}
+
+	err := generate(hooks, target, "new")
+	if err == nil || !strings.Contains(err.Error(), `unknown field "bogus"`) {
+		t.Fatalf("generate = %v, want the sing-box check output", err)
+	}
+
+	staged := checkedPath(t, calls, target)
+	if got := readFile(t, target); got != "old" {
+		t.Errorf("config.json = %q, want the old config", got)
+	}
+	if _, err := os.Stat(staged); !os.IsNotExist(err) {
+		t.Errorf("staged config left behind: %v", err)
+	}
+	if _, err := os.Stat(reloaded); !os.IsNotExist(err) {
+		t.Errorf("reload command ran after a failed check")
+	}
+	if backups, _ := staging.Backups(); len(backups) != 0 {
+		t.Errorf("backups = %q, want none", backups)
+	}
+}
+
+func TestCheckedConfigIsCommittedAndReloaded(t *testing.T) {
+	calls := fakeSingBox(t, 0)
+	target := configDir(t, "old")
+	reloaded := filepath.Join(t.TempDir(), "reloaded")
+	hooks := Hooks{CheckMode: CheckAuto, SingBox: "sing-box", Command: "touch '" + reloaded + "'"}
+
+	if err := generate(hooks, target, "new"); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	staged := checkedPath(t, calls, target)
+	if got := readFile(t, target); got != "new" {
+		t.Errorf("config.json = %q, want the new config", got)
+	}
+	if _, err := os.Stat(staged); !os.IsNotExist(err) {
+		t.Errorf("staged config left behind: %v", err)
+	}
+	if _, err := os.Stat(reloaded); err != nil {
+		t.Errorf("reload command did not run: %v", err)
+	}
+
+	backups, err := staging.Backups()
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("backups = %q, %v, want one", backups, err)
+	}
+	if got := readFile(t, filepath.Join(staging.BackupDir, backups[0], "config.json")); got != "old" {
+		t.Errorf("backed up config.json = %q, want the old config", got)
+	}
+}
+
+func TestCheckWithoutSingBox(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	if err := (Hooks{CheckMode: CheckAuto, SingBox: "sing-box"}).Check(path); err != nil {
+		t.Errorf("auto check without sing-box = %v, want it skipped", err)
+	}
+	if err := (Hooks{CheckMode: CheckOn, SingBox: "sing-box"}).Check(path); err == nil {
+		t.Errorf("check without sing-box passed, want an error")
+	}
+}
+
+func TestCheckOff(t *testing.T) {
+	calls := fakeSingBox(t, 1)
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	if err := (Hooks{CheckMode: CheckOff, SingBox: "sing-box"}).Check(path); err != nil {
+		t.Errorf("check off = %v, want no check", err)
+	}
+	if _, err := os.Stat(calls); !os.IsNotExist(err) {
+		t.Errorf("sing-box ran with checks off")
+	}
+}
+
+func TestReloadCommandFailure(t *testing.T) {
+	err := Hooks{Command: "echo not running; exit 3"}.Reload()
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("Reload = %v, want the exit status of the command", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package staging
+
+import "errors"
+
+// exchange atomically swaps the paths a and b, which only Linux can do
+func exchange(a, b string) error {
+	return errors.New("exchanging paths is not supported")
+}
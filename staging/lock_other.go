@@ -0,0 +1,17 @@
+//go:build !unix
+
+package staging
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile opens path without locking it, which only Unix systems do
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file: %v", err)
+	}
+	return file, nil
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package staging
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an exclusive lock on path, waiting for the process that
+// holds it. Closing the file releases the lock.
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file: %v", err)
+	}
+
+	err = unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		fmt.Println("Waiting for another sbfm to finish its generation...")
+		for err = unix.EINTR; err == unix.EINTR; {
+			err = unix.Flock(int(file.Fd()), unix.LOCK_EX)
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error locking %s: %v", path, err)
+	}
+	return file, nil
+}
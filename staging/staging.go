@@ -0,0 +1,663 @@
+// Package staging writes generated files next to their destination and swaps
+// them in with renames, so a crash never leaves a half written directory.
+// The files one generation replaces are kept together as a timestamped
+// backup which Rollback can restore. A generation holds a file lock, so
+// generations of separate sbfm processes never interleave.
+package staging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BackupDir holds one directory per generation, named by the time it was
+// replaced. Every target must be a sibling of BackupDir.
+var BackupDir = "./sing-box/backups"
+
+// KeepBackups is how many generations are kept before the oldest are removed.
+const KeepBackups = 10
+
+// backupTimeFormat sorts lexically in time order.
+const backupTimeFormat = "20060102-150405.000000"
+
+// ErrNoBackup is returned by Rollback when there is nothing to restore.
+var ErrNoBackup = errors.New("no backup to roll back to")
+
+// lockName is the file next to BackupDir that generations lock, so a
+// generation never overlaps one running in another sbfm process
+const lockName = ".sbfm.lock"
+
+// createdName lists, in a backup, the targets its generation created.
+// Rollback removes them.
+const createdName = ".created"
+
+// ErrPendingChanges is returned by dry runs whose output differs from the
+// files on disk.
+var ErrPendingChanges = errors.New("generated files differ from the files on disk")
+
+// Dir is a new generation of a target directory. Its files are kept in
+// memory until Commit, so a dry run never touches the disk.
+type Dir struct {
+	target    string
+	extension string
+	files     map[string][]byte
+}
+
+// NewDir starts a new generation of target. Only files ending in extension
+// belong to the generator, every other file in target is carried over into
+// the new generation unchanged. An empty extension claims the whole
+// directory.
+func NewDir(target, extension string) (*Dir, error) {
+	dir := &Dir{
+		target:    target,
+		extension: extension,
+		files:     make(map[string][]byte),
+	}
+	if err := dir.carryOver(); err != nil {
+		return nil, err
+	}
+	return dir, nil
+}
+
+// carryOver reads the files of target that the generator does not own
+func (dir *Dir) carryOver() error {
+	if dir.extension == "" {
+		return nil
+	}
+
+	current, err := readFiles(dir.target)
+	if err != nil {
+		return err
+	}
+	for name, data := range current {
+		if filepath.Ext(name) != dir.extension {
+			dir.files[name] = data
+		}
+	}
+	return nil
+}
+
+// WriteFile adds a file to the new generation
+func (dir *Dir) WriteFile(name string, data []byte) error {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	dir.files[name] = data
+	return nil
+}
+
+// Commit writes the new generation next to the target and swaps it in,
+// keeping the current target in the backup of the generation.
+func (dir *Dir) Commit() error {
+	if err := Begin(); err != nil {
+		return err
+	}
+	err := dir.commit()
+	if endErr := End(); err == nil {
+		err = endErr
+	}
+	return err
+}
+
+func (dir *Dir) commit() error {
+	if err := removeLeftovers(dir.target); err != nil {
+		return err
+	}
+	staged, err := os.MkdirTemp(filepath.Dir(dir.target), stagingPrefix(dir.target))
+	if err != nil {
+		return fmt.Errorf("error creating staging directory: %v", err)
+	}
+	if err := os.Chmod(staged, 0o755); err != nil {
+		os.RemoveAll(staged)
+		return fmt.Errorf("error creating staging directory: %v", err)
+	}
+	for name, data := range dir.files {
+		if err := writeSynced(filepath.Join(staged, name), data); err != nil {
+			os.RemoveAll(staged)
+			return fmt.Errorf("error writing %s: %v", name, err)
+		}
+	}
+
+	if err := replace(staged, dir.target); err != nil {
+		os.RemoveAll(staged)
+		return err
+	}
+	return nil
+}
+
+// Finish commits the new generation, or in a dry run prints how it differs
+// from the current one.
+func (dir *Dir) Finish(dryRun bool) error {
+	if !dryRun {
+		return dir.Commit()
+	}
+
+	changes, err := dir.Diff()
+	if err != nil {
+		return err
+	}
+	return ReportChanges(changes)
+}
+
+// Diff lists the files the new generation adds, removes or changes
+func (dir *Dir) Diff() ([]string, error) {
+	current, err := readFiles(dir.target)
+	if err != nil {
+		return nil, err
+	}
+	next := dir.files
+
+	base := filepath.Base(dir.target)
+	var changes []string
+	for _, name := range sortedKeys(next) {
+		data, ok := current[name]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("+ %s/%s", base, name))
+		case string(data) != string(next[name]):
+			changes = append(changes, fmt.Sprintf("~ %s/%s", base, name))
+		}
+	}
+	for _, name := range sortedKeys(current) {
+		if _, ok := next[name]; !ok {
+			changes = append(changes, fmt.Sprintf("- %s/%s", base, name))
+		}
+	}
+	return changes, nil
+}
+
+// ReportChanges prints the changes a dry run found and returns
+// ErrPendingChanges if there are any
+func ReportChanges(changes []string) error {
+	if len(changes) == 0 {
+		fmt.Println("No changes.")
+		return nil
+	}
+
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	return fmt.Errorf("%w: %d change(s)", ErrPendingChanges, len(changes))
+}
+
+// WriteFile replaces the target file with data in a single rename, keeping a
+// copy of the previous file in the backup of the generation. If check is
+// not nil it gets the path of the staged file first and can veto the swap
+// by returning an error.
+func WriteFile(target string, data []byte, check func(path string) error) error {
+	if err := Begin(); err != nil {
+		return err
+	}
+	err := writeFile(target, data, check)
+	if endErr := End(); err == nil {
+		err = endErr
+	}
+	return err
+}
+
+func writeFile(target string, data []byte, check func(path string) error) error {
+	if err := removeLeftovers(target); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(target), stagingPrefix(target))
+	if err != nil {
+		return fmt.Errorf("error creating staging file: %v", err)
+	}
+	temp := file.Name()
+	file.Close()
+	if err := writeSynced(temp, data); err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("error writing %s: %v", temp, err)
+	}
+	if err := os.Chmod(temp, 0o644); err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("error writing %s: %v", temp, err)
+	}
+
+	if check != nil {
+		if err := check(temp); err != nil {
+			os.Remove(temp)
+			return err
+		}
+	}
+
+	if err := replace(temp, target); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+// Begin starts a generation. Every file and directory replaced until the
+// matching End goes into one backup, which Rollback restores as a whole.
+// Calls nest and only the outermost pair counts, so a generator committing
+// on its own is a generation of its own. The outermost Begin waits for the
+// generation of any other sbfm process to end and holds a lock next to
+// BackupDir until End.
+func Begin() error {
+	generation.Lock()
+	defer generation.Unlock()
+	if generation.depth == 0 {
+		root := filepath.Dir(BackupDir)
+		if err := os.MkdirAll(root, os.ModePerm); err != nil {
+			return fmt.Errorf("error creating %s: %v", root, err)
+		}
+		lock, err := lockFile(filepath.Join(root, lockName))
+		if err != nil {
+			return err
+		}
+		generation.lock = lock
+		generation.created = make(map[string]bool)
+	}
+	generation.depth++
+	return nil
+}
+
+// End finishes the generation started by the matching Begin and removes
+// the generations past KeepBackups.
+func End() error {
+	generation.Lock()
+	defer generation.Unlock()
+	generation.depth--
+	if generation.depth > 0 {
+		return nil
+	}
+
+	if generation.backup != "" {
+		fmt.Printf("Previous files kept in %s\n", generation.backup)
+	}
+	generation.backup = ""
+	generation.created = nil
+	err := pruneBackups()
+	if closeErr := generation.lock.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("error unlocking generation: %v", closeErr)
+	}
+	generation.lock = nil
+	return err
+}
+
+// generation is the state of the generation in progress
+var generation struct {
+	sync.Mutex
+	depth int
+	// lock is held from the outermost Begin to its End
+	lock *os.File
+	// backup is created when the generation replaces its first file
+	backup string
+	// created holds the base names of the targets the generation created
+	created map[string]bool
+}
+
+// Backups returns the names of all backups, newest first
+func Backups() ([]string, error) {
+	entries, err := os.ReadDir(BackupDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading backups: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && IsBackupName(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	return names, nil
+}
+
+// BackupContents returns the names of the files and directories a backup
+// would restore, followed by those it would remove prefixed with "-"
+func BackupContents(name string) ([]string, error) {
+	restored, created, err := backupEntries(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range created {
+		restored = append(restored, "-"+target)
+	}
+	return restored, nil
+}
+
+// backupEntries returns the targets a backup keeps the previous version of
+// and the targets its generation created
+func backupEntries(name string) (restored, created []string, err error) {
+	backup := filepath.Join(BackupDir, name)
+	entries, err := os.ReadDir(backup)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading backup %s: %v", name, err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != createdName {
+			restored = append(restored, entry.Name())
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(backup, createdName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("error reading backup %s: %v", name, err)
+	}
+	for _, target := range strings.Split(string(data), "\n") {
+		if target != "" {
+			created = append(created, target)
+		}
+	}
+	return restored, created, nil
+}
+
+// Rollback restores every file and directory of the backup with the given
+// name, or of the newest one if name is empty, over the current ones, and
+// removes the ones its generation created. The backup is used up, so
+// calling Rollback again steps a generation further back. It returns the
+// name of the backup.
+func Rollback(name string) (string, error) {
+	if err := Begin(); err != nil {
+		return "", err
+	}
+	restored, err := rollback(name)
+	if endErr := End(); err == nil {
+		err = endErr
+	}
+	return restored, err
+}
+
+func rollback(name string) (string, error) {
+	if name == "" {
+		backups, err := Backups()
+		if err != nil {
+			return "", err
+		}
+		if len(backups) == 0 {
+			return "", ErrNoBackup
+		}
+		name = backups[0]
+	}
+	if !IsBackupName(name) {
+		return "", fmt.Errorf("invalid backup name %q", name)
+	}
+
+	backup := filepath.Join(BackupDir, name)
+	contents, created, err := backupEntries(name)
+	if err != nil {
+		return "", err
+	}
+
+	root := filepath.Dir(BackupDir)
+	for _, entry := range created {
+		target := filepath.Join(root, entry)
+		if err := os.RemoveAll(target); err != nil {
+			return "", fmt.Errorf("error removing %s: %v", target, err)
+		}
+	}
+	for _, entry := range contents {
+		target := filepath.Join(root, entry)
+		old, err := swap(filepath.Join(backup, entry), target)
+		if err != nil {
+			return "", fmt.Errorf("error restoring %s: %v", target, err)
+		}
+		if err := os.RemoveAll(old); err != nil {
+			return "", fmt.Errorf("error removing the replaced %s: %v", target, err)
+		}
+	}
+
+	if err := os.RemoveAll(backup); err != nil {
+		return "", fmt.Errorf("error removing backup %s: %v", name, err)
+	}
+	return name, nil
+}
+
+// replace swaps staged in for target and keeps the previous target in the
+// backup of the current generation, or records that the generation created
+// it. A target replaced twice in the same generation keeps the version from
+// before the generation.
+func replace(staged, target string) error {
+	old, err := swap(staged, target)
+	if err != nil {
+		return fmt.Errorf("error swapping in %s: %v", target, err)
+	}
+
+	backup, err := generationBackup()
+	if err != nil {
+		if old != "" {
+			os.RemoveAll(old)
+		}
+		return err
+	}
+	base := filepath.Base(target)
+	if old == "" {
+		return recordCreated(backup, base)
+	}
+
+	kept := filepath.Join(backup, base)
+	if _, err := os.Lstat(kept); err == nil || createdInGeneration(base) {
+		return os.RemoveAll(old)
+	}
+	if err := os.Rename(old, kept); err != nil {
+		return fmt.Errorf("error backing up %s: %v", target, err)
+	}
+	return nil
+}
+
+// swap puts staged in place of target without target ever going missing
+// and returns where the previous target is now, or an empty path if there
+// was none. Files are renamed over the target. Directories are exchanged
+// with it, or where the system cannot exchange them, the target is moved
+// aside right before staged is renamed into its place.
+func swap(staged, target string) (string, error) {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return "", os.Rename(staged, target)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !info.IsDir() {
+		// Keep the previous file under another name, the rename drops it
+		old := staged + ".old"
+		os.Remove(old)
+		if err := os.Link(target, old); err != nil {
+			if err := copyFile(target, old); err != nil {
+				return "", err
+			}
+		}
+		if err := os.Rename(staged, target); err != nil {
+			os.Remove(old)
+			return "", err
+		}
+		return old, nil
+	}
+
+	if err := exchange(staged, target); err == nil {
+		return staged, nil
+	}
+	old := staged + ".old"
+	if err := os.RemoveAll(old); err != nil {
+		return "", err
+	}
+	if err := os.Rename(target, old); err != nil {
+		return "", err
+	}
+	if err := os.Rename(staged, target); err != nil {
+		os.Rename(old, target)
+		return "", err
+	}
+	return old, nil
+}
+
+// stagingPrefix starts the name of the temporary file or directory the new
+// version of target is written to before it is swapped in
+func stagingPrefix(target string) string {
+	return "." + filepath.Base(target) + ".staging-"
+}
+
+// removeLeftovers removes what interrupted runs left staged for target.
+// It is only called inside a generation, so no other run is staging.
+func removeLeftovers(target string) error {
+	leftovers, err := filepath.Glob(filepath.Join(filepath.Dir(target), stagingPrefix(target)+"*"))
+	if err != nil {
+		return err
+	}
+	for _, leftover := range leftovers {
+		if err := os.RemoveAll(leftover); err != nil {
+			return fmt.Errorf("error removing %s: %v", leftover, err)
+		}
+	}
+	return nil
+}
+
+// generationBackup returns the backup directory of the current generation,
+// creating it named after the current time
+func generationBackup() (string, error) {
+	generation.Lock()
+	defer generation.Unlock()
+	if generation.backup != "" {
+		return generation.backup, nil
+	}
+
+	if err := os.MkdirAll(BackupDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("error creating backup directory: %v", err)
+	}
+	// A backup name taken by an earlier generation is never shared
+	for {
+		backup := filepath.Join(BackupDir, time.Now().Format(backupTimeFormat))
+		err := os.Mkdir(backup, os.ModePerm)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("error creating backup directory: %v", err)
+		}
+		generation.backup = backup
+		return backup, nil
+	}
+}
+
+// recordCreated notes in backup that the current generation created the
+// target named base
+func recordCreated(backup, base string) error {
+	generation.Lock()
+	generation.created[base] = true
+	generation.Unlock()
+
+	file, err := os.OpenFile(filepath.Join(backup, createdName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error recording new %s: %v", base, err)
+	}
+	if _, err := file.WriteString(base + "\n"); err != nil {
+		file.Close()
+		return fmt.Errorf("error recording new %s: %v", base, err)
+	}
+	return file.Close()
+}
+
+// createdInGeneration reports whether the current generation created the
+// target named base
+func createdInGeneration(base string) bool {
+	generation.Lock()
+	defer generation.Unlock()
+	return generation.created[base]
+}
+
+// pruneBackups removes all but the newest KeepBackups backups, one per
+// generation
+func pruneBackups() error {
+	backups, err := Backups()
+	if err != nil {
+		return err
+	}
+
+	for len(backups) > KeepBackups {
+		oldest := backups[len(backups)-1]
+		if err := os.RemoveAll(filepath.Join(BackupDir, oldest)); err != nil {
+			return fmt.Errorf("error removing backup %s: %v", oldest, err)
+		}
+		backups = backups[:len(backups)-1]
+	}
+	return nil
+}
+
+// readFiles reads every regular file in dir by name. A missing directory
+// has no files.
+func readFiles(dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", dir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", entry.Name(), err)
+		}
+		files[entry.Name()] = data
+	}
+	return files, nil
+}
+
+func sortedKeys(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeSynced writes data to path and flushes it to disk before returning
+func writeSynced(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// copyFile copies the regular file src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("error copying %s: %v", src, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("error copying %s: %v", src, err)
+	}
+	return out.Close()
+}
+
+// IsBackupName reports whether name looks like a backup created by this package
+func IsBackupName(name string) bool {
+	_, err := time.Parse(backupTimeFormat, name)
+	return err == nil && !strings.ContainsAny(name, `/\`)
+}
@@ -0,0 +1,349 @@
+package staging
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testRoot returns a directory standing in for ./sing-box, with BackupDir
+// pointed into it
+func testRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	previous := BackupDir
+	BackupDir = filepath.Join(root, "backups")
+	t.Cleanup(func() { BackupDir = previous })
+	return root
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tree returns every file under dir with its content, by slash separated
+// path
+func tree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// rootEntries lists the names in root besides the backups and the lock
+func rootEntries(t *testing.T, root string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Name() != "backups" && entry.Name() != lockName {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func commitUsers(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	dir, err := NewDir(filepath.Join(root, "users"), ".json")
+	if err != nil {
+		t.Fatalf("NewDir: %v", err)
+	}
+	for name, content := range files {
+		if err := dir.WriteFile(name, []byte(content)); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	if err := dir.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+}
+
+func TestCommitReplacesOwnFilesAndKeepsOthers(t *testing.T) {
+	root := testRoot(t)
+	writeTestFile(t, filepath.Join(root, "users", "alice.json"), "alice")
+	writeTestFile(t, filepath.Join(root, "users", "alice.txt"), "links")
+
+	commitUsers(t, root, map[string]string{"bob.json": "bob"})
+
+	want := map[string]string{"alice.txt": "links", "bob.json": "bob"}
+	if got := tree(t, filepath.Join(root, "users")); !reflect.DeepEqual(got, want) {
+		t.Errorf("users = %v, want %v", got, want)
+	}
+	if got := rootEntries(t, root); !reflect.DeepEqual(got, []string{"users"}) {
+		t.Errorf("root holds %q, want only users", got)
+	}
+
+	backups, err := Backups()
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("backups = %q, %v, want one", backups, err)
+	}
+	want = map[string]string{"users/alice.json": "alice", "users/alice.txt": "links"}
+	if got := tree(t, filepath.Join(BackupDir, backups[0])); !reflect.DeepEqual(got, want) {
+		t.Errorf("backup = %v, want %v", got, want)
+	}
+}
+
+func TestDryRunTouchesNothing(t *testing.T) {
+	root := testRoot(t)
+	writeTestFile(t, filepath.Join(root, "users", "alice.json"), "alice")
+
+	dir, err := NewDir(filepath.Join(root, "users"), ".json")
+	if err != nil {
+		t.Fatalf("NewDir: %v", err)
+	}
+	dir.WriteFile("bob.json", []byte("bob"))
+	changes, err := dir.Diff()
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if want := []string{"+ users/bob.json", "- users/alice.json"}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("Diff = %q, want %q", changes, want)
+	}
+	if got := rootEntries(t, root); !reflect.DeepEqual(got, []string{"users"}) {
+		t.Errorf("root holds %q, want only users", got)
+	}
+}
+
+func TestWriteFileCheckVetoes(t *testing.T) {
+	root := testRoot(t)
+	target := filepath.Join(root, "config.json")
+	writeTestFile(t, target, "old")
+
+	veto := os.ErrInvalid
+	err := WriteFile(target, []byte("new"), func(path string) error {
+		if data, _ := os.ReadFile(path); string(data) != "new" {
+			t.Errorf("checked %q, want the new config", data)
+		}
+		return veto
+	})
+	if err != veto {
+		t.Fatalf("WriteFile = %v, want the veto", err)
+	}
+	if got := tree(t, root); !reflect.DeepEqual(got, map[string]string{"config.json": "old", lockName: ""}) {
+		t.Errorf("root = %v, want only the old config", got)
+	}
+}
+
+func TestLeftoversAreRemoved(t *testing.T) {
+	root := testRoot(t)
+	writeTestFile(t, filepath.Join(root, ".users.staging-123", "alice.json"), "stale")
+	writeTestFile(t, filepath.Join(root, ".config.json.staging-456"), "stale")
+
+	commitUsers(t, root, map[string]string{"bob.json": "bob"})
+	if err := WriteFile(filepath.Join(root, "config.json"), []byte("new"), nil); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if got := rootEntries(t, root); !reflect.DeepEqual(got, []string{"config.json", "users"}) {
+		t.Errorf("root holds %q, want config.json and users", got)
+	}
+}
+
+func TestGenerationIsOneBackup(t *testing.T) {
+	root := testRoot(t)
+	config := filepath.Join(root, "config.json")
+	writeTestFile(t, config, "old")
+	writeTestFile(t, filepath.Join(root, "users", "alice.json"), "alice")
+
+	if err := Begin(); err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if err := WriteFile(config, []byte("new"), nil); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	// A target replaced twice keeps the version from before the generation
+	if err := WriteFile(config, []byte("newer"), nil); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	commitUsers(t, root, map[string]string{"bob.json": "bob"})
+	if err := End(); err != nil {
+		t.Fatalf("End: %v", err)
+	}
+
+	backups, err := Backups()
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("backups = %q, %v, want one", backups, err)
+	}
+	want := map[string]string{"config.json": "old", "users/alice.json": "alice"}
+	if got := tree(t, filepath.Join(BackupDir, backups[0])); !reflect.DeepEqual(got, want) {
+		t.Errorf("backup = %v, want %v", got, want)
+	}
+}
+
+func TestRollbackRestoresGeneration(t *testing.T) {
+	root := testRoot(t)
+	config := filepath.Join(root, "config.json")
+	writeTestFile(t, config, "old")
+	writeTestFile(t, filepath.Join(root, "users", "alice.json"), "alice")
+
+	if err := Begin(); err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if err := WriteFile(config, []byte("new"), nil); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	commitUsers(t, root, map[string]string{"bob.json": "bob"})
+	// sub is new in this generation, and replaced again within it
+	for _, content := range []string{"sub", "sub again"} {
+		sub, err := NewDir(filepath.Join(root, "sub"), "")
+		if err != nil {
+			t.Fatalf("NewDir: %v", err)
+		}
+		sub.WriteFile("token", []byte(content))
+		if err := sub.Commit(); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+	}
+	if err := End(); err != nil {
+		t.Fatalf("End: %v", err)
+	}
+
+	backups, _ := Backups()
+	contents, err := BackupContents(backups[0])
+	if err != nil {
+		t.Fatalf("BackupContents: %v", err)
+	}
+	if want := []string{"config.json", "users", "-sub"}; !reflect.DeepEqual(contents, want) {
+		t.Errorf("BackupContents = %q, want %q", contents, want)
+	}
+
+	if _, err := Rollback(""); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	want := map[string]string{"config.json": "old", "users/alice.json": "alice", lockName: ""}
+	if got := tree(t, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("after rollback root = %v, want %v", got, want)
+	}
+	if _, err := Rollback(""); err != ErrNoBackup {
+		t.Errorf("second Rollback = %v, want ErrNoBackup", err)
+	}
+}
+
+func TestBackupsArePrunedByGeneration(t *testing.T) {
+	root := testRoot(t)
+	config := filepath.Join(root, "config.json")
+	writeTestFile(t, config, "0")
+
+	for i := 1; i <= KeepBackups+2; i++ {
+		if err := Begin(); err != nil {
+			t.Fatalf("Begin: %v", err)
+		}
+		if err := WriteFile(config, []byte{byte('0' + i)}, nil); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		commitUsers(t, root, map[string]string{"alice.json": string(rune('0' + i))})
+		if err := End(); err != nil {
+			t.Fatalf("End: %v", err)
+		}
+	}
+
+	backups, err := Backups()
+	if err != nil || len(backups) != KeepBackups {
+		t.Fatalf("%d backups, %v, want %d", len(backups), err, KeepBackups)
+	}
+	// The newest backup holds what the last generation replaced
+	newest := tree(t, filepath.Join(BackupDir, backups[0]))
+	want := map[string]string{"config.json": string(rune('0' + KeepBackups + 1)), "users/alice.json": string(rune('0' + KeepBackups + 1))}
+	if !reflect.DeepEqual(newest, want) {
+		t.Errorf("newest backup = %v, want %v", newest, want)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	root := testRoot(t)
+
+	file := filepath.Join(root, "config.json")
+	staged := filepath.Join(root, "staged.json")
+	writeTestFile(t, file, "old")
+	writeTestFile(t, staged, "new")
+	old, err := swap(staged, file)
+	if err != nil {
+		t.Fatalf("swap file: %v", err)
+	}
+	if got := tree(t, root); got["config.json"] != "new" || got[filepath.Base(old)] != "old" {
+		t.Errorf("after swapping a file root = %v, old at %s", got, old)
+	}
+
+	dir := filepath.Join(root, "users")
+	stagedDir := filepath.Join(root, "staged")
+	writeTestFile(t, filepath.Join(dir, "a.json"), "old")
+	writeTestFile(t, filepath.Join(stagedDir, "a.json"), "new")
+	old, err = swap(stagedDir, dir)
+	if err != nil {
+		t.Fatalf("swap directory: %v", err)
+	}
+	if got := tree(t, dir); got["a.json"] != "new" {
+		t.Errorf("swapped directory = %v, want the new file", got)
+	}
+	if got := tree(t, old); got["a.json"] != "old" {
+		t.Errorf("previous directory = %v, want the old file", got)
+	}
+
+	missing := filepath.Join(root, "sub")
+	writeTestFile(t, filepath.Join(stagedDir, "token"), "sub")
+	os.Remove(filepath.Join(stagedDir, "a.json"))
+	if old, err := swap(stagedDir, missing); err != nil || old != "" {
+		t.Errorf("swap into a missing target = %q, %v, want no previous version", old, err)
+	}
+}
+
+func TestGenerationLocksOutOtherProcesses(t *testing.T) {
+	root := testRoot(t)
+	if err := Begin(); err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+
+	// Another process opens the lock file on its own, as this does
+	locked := make(chan *os.File)
+	go func() {
+		lock, err := lockFile(filepath.Join(root, lockName))
+		if err != nil {
+			t.Errorf("lockFile: %v", err)
+		}
+		locked <- lock
+	}()
+
+	select {
+	case lock := <-locked:
+		lock.Close()
+		t.Fatalf("lock taken during a generation")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := End(); err != nil {
+		t.Fatalf("End: %v", err)
+	}
+	select {
+	case lock := <-locked:
+		lock.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("lock not released by End")
+	}
+}
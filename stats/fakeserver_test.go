@@ -0,0 +1,131 @@
+package stats
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeServer serves QueryStats on a local port the way sing-box does, from
+// counters set by the test. It lets the client be exercised without a
+// sing-box.
+type fakeServer struct {
+	listener net.Listener
+	server   *grpc.Server
+
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+// newFakeServer starts a fake stats API on a free loopback port, which is
+// stopped when the test ends
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+
+	fake := &fakeServer{
+		listener: listener,
+		server:   grpc.NewServer(grpc.ForceServerCodec(rawCodec{})),
+		counters: make(map[string]int64),
+	}
+	fake.server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "v2ray.core.app.stats.command.StatsService",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "QueryStats",
+			Handler: func(_ interface{}, _ context.Context, decode func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				var request []byte
+				if err := decode(&request); err != nil {
+					return nil, err
+				}
+				return fake.queryStats(request)
+			},
+		}},
+	}, fake)
+
+	go fake.server.Serve(listener)
+	t.Cleanup(fake.server.Stop)
+	return fake
+}
+
+// Addr returns the address the fake server listens on
+func (fake *fakeServer) Addr() string {
+	return fake.listener.Addr().String()
+}
+
+// AddTraffic adds to the counters of the named user
+func (fake *fakeServer) AddTraffic(user string, uplink, downlink int64) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.counters[userPrefix+user+trafficSeparator+"uplink"] += uplink
+	fake.counters[userPrefix+user+trafficSeparator+"downlink"] += downlink
+}
+
+// Restart drops every counter, as a sing-box restart does
+func (fake *fakeServer) Restart() {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.counters = make(map[string]int64)
+}
+
+// queryStats answers a QueryStatsRequest with the counters whose name
+// contains its pattern, resetting them if asked to
+func (fake *fakeServer) queryStats(request []byte) ([]byte, error) {
+	pattern, reset, err := decodeQueryStatsRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	matched := make(map[string]int64)
+	for name, value := range fake.counters {
+		if strings.Contains(name, pattern) {
+			matched[name] = value
+			if reset {
+				fake.counters[name] = 0
+			}
+		}
+	}
+	return encodeQueryStatsResponse(matched), nil
+}
+
+// decodeQueryStatsRequest is the reverse of encodeQueryStatsRequest
+func decodeQueryStatsRequest(message []byte) (pattern string, reset bool, err error) {
+	err = decodeFields(message, func(number protowire.Number, value []byte, varint uint64) {
+		switch number {
+		case 1:
+			pattern = string(value)
+		case 2:
+			reset = varint != 0
+		}
+	})
+	return pattern, reset, err
+}
+
+// encodeQueryStatsResponse encodes a QueryStatsResponse:
+//
+//	message QueryStatsResponse { repeated Stat stat = 1; }
+//	message Stat { string name = 1; int64 value = 2; }
+func encodeQueryStatsResponse(counters map[string]int64) []byte {
+	var message []byte
+	for name, value := range counters {
+		var stat []byte
+		stat = protowire.AppendTag(stat, 1, protowire.BytesType)
+		stat = protowire.AppendString(stat, name)
+		stat = protowire.AppendTag(stat, 2, protowire.VarintType)
+		stat = protowire.AppendVarint(stat, uint64(value))
+
+		message = protowire.AppendTag(message, 1, protowire.BytesType)
+		message = protowire.AppendBytes(message, stat)
+	}
+	return message
+}
@@ -0,0 +1,231 @@
+// Package stats reads the per-user traffic counters of a running sing-box
+// through the V2Ray stats API, which config.json turns on with the
+// experimental.v2ray_api block.
+package stats
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// queryStatsMethod is the StatsService method sing-box serves, under the
+// package name V2Ray uses so that the v2ray tools work against it.
+const queryStatsMethod = "/v2ray.core.app.stats.command.StatsService/QueryStats"
+
+// Counter names look like user>>>NAME>>>traffic>>>uplink.
+const (
+	userPrefix       = "user>>>"
+	trafficSeparator = ">>>traffic>>>"
+)
+
+// Traffic holds the byte counters of a user. Uplink is what the user sent,
+// Downlink what the user received. sing-box starts them at zero whenever it
+// starts or reloads its config.
+type Traffic struct {
+	Uplink   int64
+	Downlink int64
+}
+
+// Querier returns the traffic counters of every user sing-box tracks, by
+// user name, and sets them back to zero if reset is set. Client implements
+// it against a running sing-box.
+type Querier interface {
+	QueryUserTraffic(ctx context.Context, reset bool) (map[string]Traffic, error)
+}
+
+// Client talks to the stats API of a sing-box.
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// DialAddress turns the listen address of the stats API into the address
+// to connect to. A listen address without a host, or with an unspecified
+// one such as 0.0.0.0, is reached on the loopback address.
+func DialAddress(listen string) (string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("invalid stats API address %q: %v", listen, err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Dial returns a client of the stats API at address. The connection is
+// made on the first query.
+func Dial(address string) (*Client, error) {
+	conn, err := grpc.NewClient(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the stats API: %v", err)
+	}
+	return &Client{conn: conn}, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// QueryUserTraffic returns the traffic counters of every user. With reset
+// sing-box sets them back to zero in the same step, so that traffic is
+// neither missed nor counted twice by the next query.
+func (c *Client) QueryUserTraffic(ctx context.Context, reset bool) (map[string]Traffic, error) {
+	request := encodeQueryStatsRequest(userPrefix, reset)
+	var response []byte
+	if err := c.conn.Invoke(ctx, queryStatsMethod, &request, &response); err != nil {
+		return nil, fmt.Errorf("error querying the stats API: %v", err)
+	}
+
+	counters, err := decodeQueryStatsResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	return userTraffic(counters), nil
+}
+
+// userTraffic picks the user traffic counters out of counters
+func userTraffic(counters map[string]int64) map[string]Traffic {
+	traffic := make(map[string]Traffic)
+	for name, value := range counters {
+		rest, ok := strings.CutPrefix(name, userPrefix)
+		if !ok {
+			continue
+		}
+		user, direction, ok := strings.Cut(rest, trafficSeparator)
+		if !ok {
+			continue
+		}
+
+		t := traffic[user]
+		switch direction {
+		case "uplink":
+			t.Uplink = value
+		case "downlink":
+			t.Downlink = value
+		default:
+			continue
+		}
+		traffic[user] = t
+	}
+	return traffic
+}
+
+// rawCodec passes already encoded protobuf messages through, so that the
+// three small messages of QueryStats need no generated code
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch message := v.(type) {
+	case *[]byte:
+		return *message, nil
+	case []byte:
+		return message, nil
+	}
+	return nil, fmt.Errorf("unexpected message type %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	message, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	*message = append((*message)[:0], data...)
+	return nil
+}
+
+// Name is the content subtype, which has to be proto for sing-box to
+// accept the messages
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// encodeQueryStatsRequest encodes a QueryStatsRequest:
+//
+//	message QueryStatsRequest { string pattern = 1; bool reset = 2; }
+func encodeQueryStatsRequest(pattern string, reset bool) []byte {
+	var message []byte
+	message = protowire.AppendTag(message, 1, protowire.BytesType)
+	message = protowire.AppendString(message, pattern)
+	if reset {
+		message = protowire.AppendTag(message, 2, protowire.VarintType)
+		message = protowire.AppendVarint(message, 1)
+	}
+	return message
+}
+
+// decodeQueryStatsResponse returns the counters of a QueryStatsResponse by
+// name
+func decodeQueryStatsResponse(message []byte) (map[string]int64, error) {
+	counters := make(map[string]int64)
+	var statErr error
+	err := decodeFields(message, func(number protowire.Number, value []byte, _ uint64) {
+		if number != 1 || statErr != nil {
+			return
+		}
+		var name string
+		var counter int64
+		statErr = decodeFields(value, func(number protowire.Number, value []byte, varint uint64) {
+			switch number {
+			case 1:
+				name = string(value)
+			case 2:
+				counter = int64(varint)
+			}
+		})
+		counters[name] = counter
+	})
+	if err == nil {
+		err = statErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return counters, nil
+}
+
+// decodeFields calls field for each field of message with its bytes, for
+// length delimited fields, or its value, for varints. Other wire types are
+// skipped.
+func decodeFields(message []byte, field func(number protowire.Number, value []byte, varint uint64)) error {
+	for len(message) > 0 {
+		number, wireType, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return fmt.Errorf("error decoding stats message: %v", protowire.ParseError(n))
+		}
+		message = message[n:]
+
+		switch wireType {
+		case protowire.BytesType:
+			value, n := protowire.ConsumeBytes(message)
+			if n < 0 {
+				return fmt.Errorf("error decoding stats message: %v", protowire.ParseError(n))
+			}
+			field(number, value, 0)
+			message = message[n:]
+		case protowire.VarintType:
+			value, n := protowire.ConsumeVarint(message)
+			if n < 0 {
+				return fmt.Errorf("error decoding stats message: %v", protowire.ParseError(n))
+			}
+			field(number, nil, value)
+			message = message[n:]
+		default:
+			n := protowire.ConsumeFieldValue(number, wireType, message)
+			if n < 0 {
+				return fmt.Errorf("error decoding stats message: %v", protowire.ParseError(n))
+			}
+			message = message[n:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// query reads and resets the counters of the fake server through a Client
+func query(t *testing.T, client *Client) map[string]Traffic {
+	t.Helper()
+	traffic, err := client.QueryUserTraffic(context.Background(), true)
+	if err != nil {
+		t.Fatalf("QueryUserTraffic: %v", err)
+	}
+	return traffic
+}
+
+func dial(t *testing.T, fake *fakeServer) *Client {
+	t.Helper()
+	client, err := Dial(fake.Addr())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestQueryUserTrafficReturnsDeltas(t *testing.T) {
+	fake := newFakeServer(t)
+	client := dial(t, fake)
+
+	fake.AddTraffic("alice", 100, 1000)
+	fake.AddTraffic("bob", 5, 50)
+	want := map[string]Traffic{
+		"alice": {Uplink: 100, Downlink: 1000},
+		"bob":   {Uplink: 5, Downlink: 50},
+	}
+	if got := query(t, client); !reflect.DeepEqual(got, want) {
+		t.Errorf("first query = %v, want %v", got, want)
+	}
+
+	// Reset on read: the next query only holds what came after the first
+	fake.AddTraffic("alice", 1, 2)
+	want = map[string]Traffic{
+		"alice": {Uplink: 1, Downlink: 2},
+		"bob":   {},
+	}
+	if got := query(t, client); !reflect.DeepEqual(got, want) {
+		t.Errorf("second query = %v, want %v", got, want)
+	}
+}
+
+func TestQueryUserTrafficAfterRestart(t *testing.T) {
+	fake := newFakeServer(t)
+	client := dial(t, fake)
+
+	fake.AddTraffic("alice", 100, 1000)
+	query(t, client)
+
+	// sing-box starts its counters over, users it has not seen yet are gone
+	fake.Restart()
+	if got := query(t, client); len(got) != 0 {
+		t.Errorf("query after restart = %v, want no counters", got)
+	}
+
+	fake.AddTraffic("alice", 7, 70)
+	want := map[string]Traffic{"alice": {Uplink: 7, Downlink: 70}}
+	if got := query(t, client); !reflect.DeepEqual(got, want) {
+		t.Errorf("query = %v, want %v", got, want)
+	}
+}
+
+func TestUserTrafficIgnoresOtherCounters(t *testing.T) {
+	counters := map[string]int64{
+		"user>>>alice>>>traffic>>>uplink":      3,
+		"user>>>alice>>>traffic>>>downlink":    4,
+		"inbound>>>vless>>>traffic>>>uplink":   9,
+		"user>>>alice>>>traffic>>>unknown":     9,
+		"user>>>bob>>>something>>>uplink":      9,
+		"user>>>a>>>b>>>traffic>>>uplink":      5,
+		"outbound>>>direct>>>traffic>>>uplink": 9,
+	}
+	want := map[string]Traffic{
+		"alice": {Uplink: 3, Downlink: 4},
+		"a>>>b": {Uplink: 5},
+	}
+	if got := userTraffic(counters); !reflect.DeepEqual(got, want) {
+		t.Errorf("userTraffic = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,125 @@
+// Package subscription serves user client configs over HTTP, replacing the
+// nginx snippets from db.GenerateUserConfigFiles for those who prefer it.
+package subscription
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"winder.website/sbfm/db"
+	"winder.website/sbfm/jsonhandler"
+)
+
+// Options configures the subscription server.
+type Options struct {
+	// Listen is the address the server listens on, e.g. ":8080".
+	Listen string
+	// Prefix is the URL path in front of the sub token, e.g. "/sub/".
+	Prefix string
+	// TemplateFilePath is the client template filled in for each user when
+	// the client_profile_mode setting is template. It is read on every
+	// request so edits apply without a restart.
+	TemplateFilePath string
+}
+
+// NormalizePrefix makes sure prefix starts and ends with a slash.
+func NormalizePrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return "/"
+	}
+	return "/" + prefix + "/"
+}
+
+// NewHandler returns the handler answering GET <prefix><sub> with the client
+// config of the active user owning that sub token, and 404 otherwise. The
+// format query parameter selects the output: singbox (default), links or
+// clash.
+func NewHandler(dbConnection *sql.DB, opts Options) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+NormalizePrefix(opts.Prefix)+"{sub}", func(w http.ResponseWriter, r *http.Request) {
+		user, err := db.GetActiveUserBySub(dbConnection, r.PathValue("sub"))
+		if errors.Is(err, db.ErrUserNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("error looking up subscription: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		var body []byte
+		var contentType string
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "singbox":
+			body, err = db.UserClientJSON(dbConnection, user, opts.TemplateFilePath)
+			contentType = "application/json; charset=utf-8"
+		case "links":
+			body, err = linksBody(dbConnection, user)
+			contentType = "text/plain; charset=utf-8"
+		case "clash":
+			body, err = db.UserClashProfile(dbConnection, user)
+			contentType = "text/yaml; charset=utf-8"
+		default:
+			http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("error building subscription for user %s: %v", user.Name, err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(body)
+		log.Printf("Served subscription for user %s", user.Name)
+	})
+	return mux
+}
+
+// linksBody returns the base64 share link bundle of user.
+func linksBody(dbConnection *sql.DB, user jsonhandler.User) ([]byte, error) {
+	links, err := db.UserShareLinks(dbConnection, user)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(jsonhandler.ShareLinkBundle(links)), nil
+}
+
+// Serve runs the subscription server until ctx is cancelled.
+func Serve(ctx context.Context, dbConnection *sql.DB, opts Options) error {
+	server := &http.Server{
+		Addr:              opts.Listen,
+		Handler:           NewHandler(dbConnection, opts),
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.ListenAndServe()
+	}()
+	log.Printf("Serving subscriptions on %s%s", opts.Listen, NormalizePrefix(opts.Prefix))
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("subscription server failed: %v", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("error shutting down subscription server: %v", err)
+	}
+	return nil
+}